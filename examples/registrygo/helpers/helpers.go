@@ -2,11 +2,13 @@ package helpers
 
 import (
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -15,6 +17,24 @@ var (
 	dockerContainerName = "dockerdaemon"
 )
 
+// SeedEnv is the environment variable set by golem
+// to the random seed given with -seed.
+const SeedEnv = "GOLEM_SEED"
+
+// Seed returns the random seed for generating the named
+// fixture. When GOLEM_SEED is set the seed is derived from
+// it and the name, making fixtures reproducible across runs,
+// otherwise the current time is used.
+func Seed(name string) int64 {
+	base, err := strconv.ParseInt(os.Getenv(SeedEnv), 10, 64)
+	if err != nil {
+		return time.Now().UnixNano()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return base ^ int64(h.Sum64())
+}
+
 func readRand(r *rand.Rand, p []byte) {
 	for i := 0; i < len(p); i += 7 {
 		val := r.Int63()
@@ -25,7 +45,7 @@ func readRand(r *rand.Rand, p []byte) {
 	}
 }
 
-func randomFile(name string, blockSize, blocks int) error {
+func randomFile(name string, seed int64, blockSize, blocks int) error {
 	rf, err := os.Create(name)
 	if err != nil {
 		return err
@@ -33,7 +53,7 @@ func randomFile(name string, blockSize, blocks int) error {
 	defer rf.Close()
 
 	buf := make([]byte, blockSize)
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	r := rand.New(rand.NewSource(seed))
 	for i := 0; i < blocks; i++ {
 		readRand(r, buf)
 		if _, err := rf.Write(buf); err != nil {
@@ -44,14 +64,16 @@ func randomFile(name string, blockSize, blocks int) error {
 	return nil
 }
 
-func TempImage(name string) error {
+// TempImage builds an image with a random file generated
+// from the seed, the same seed builds identical content.
+func TempImage(name string, seed int64) error {
 	td, err := ioutil.TempDir("", "")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(td)
 
-	if err := randomFile(filepath.Join(td, "f"), 1024, 512); err != nil {
+	if err := randomFile(filepath.Join(td, "f"), seed, 1024, 512); err != nil {
 		return err
 	}
 
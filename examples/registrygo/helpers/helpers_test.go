@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRandomFileSeed(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-helpers-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	generate := func(name string, seed int64) []byte {
+		fp := filepath.Join(td, name)
+		if err := randomFile(fp, seed, 1024, 4); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	a := generate("a", 42)
+	b := generate("b", 42)
+	c := generate("c", 43)
+	if len(a) != 4096 {
+		t.Fatalf("Unexpected random file size %d", len(a))
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatal("Expected same seed to generate identical files")
+	}
+	if bytes.Equal(a, c) {
+		t.Fatal("Expected different seeds to generate different files")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	old, set := os.LookupEnv(SeedEnv)
+	defer func() {
+		if set {
+			os.Setenv(SeedEnv, old)
+		} else {
+			os.Unsetenv(SeedEnv)
+		}
+	}()
+
+	os.Setenv(SeedEnv, "42")
+	if Seed("image") != Seed("image") {
+		t.Fatal("Expected seed to be reproducible")
+	}
+	if Seed("image") == Seed("other") {
+		t.Fatal("Expected fixtures to use different seeds")
+	}
+	first := Seed("image")
+	os.Setenv(SeedEnv, "43")
+	if Seed("image") == first {
+		t.Fatal("Expected seed to change with GOLEM_SEED")
+	}
+}
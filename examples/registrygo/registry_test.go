@@ -8,7 +8,7 @@ import (
 
 func TestPush(t *testing.T) {
 	imageName := "localregistry:5000/testpush"
-	if err := helpers.TempImage(imageName); err != nil {
+	if err := helpers.TempImage(imageName, helpers.Seed(imageName)); err != nil {
 		t.Fatal(err)
 	}
 
@@ -3,11 +3,16 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -19,24 +24,60 @@ import (
 func main() {
 	name := filepath.Base(os.Args[0])
 	if name == "golem_runner" {
-		runnerMain()
+		if err := runnerMain(); err != nil {
+			logrus.Error(err)
+			os.Exit(stageExitCode(err))
+		}
 		return
 	}
 	if name == "golem_tapper" {
 		tapperMain()
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		listMain(name, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		cacheMain(name, os.Args[2:])
+		return
+	}
 	var (
-		cacheDir    string
-		startDaemon bool
-		debug       bool
+		cacheFlags   runner.CacheSettings
+		golemConfig  string
+		tmpDir       string
+		keepInstance bool
+		eventLog     string
+		metricsFile  string
+		bundle       bool
+		deadline     time.Duration
+		startDaemon  bool
+		prune        bool
+		resume       bool
+		dockerBinary string
+		pidFile      string
+		debug        bool
+		serverRange  versionutil.VersionConstraint
 	)
 
 	cm := runner.NewConfigurationManager(name)
 
-	cm.FlagSet.StringVar(&cacheDir, "cache", "", "Cache directory")
+	cm.FlagSet.StringVar(&golemConfig, "golem-config", os.Getenv("GOLEM_CONFIG"), "Golem configuration file for cache locations")
+	cm.FlagSet.StringVar(&cacheFlags.Root, "cache", "", "Cache directory")
+	cm.FlagSet.StringVar(&cacheFlags.Images, "image-cache", "", "Image cache directory, defaults to images in the cache directory")
+	cm.FlagSet.StringVar(&tmpDir, "tmpdir", "", "Directory to create temporary build and cache directories in, defaults to TMPDIR")
+	cm.FlagSet.BoolVar(&keepInstance, "keep-instance-json", false, "Save the instance.json of each instance to instances in the cache directory")
+	cm.FlagSet.StringVar(&eventLog, "event-log", "", "File to append run events to as JSON lines")
+	cm.FlagSet.StringVar(&metricsFile, "metrics-file", "", "File to write run metrics to in the Prometheus text format")
+	cm.FlagSet.BoolVar(&bundle, "bundle", false, "Write the logs and outputs of the run to a gzipped tar archive named by run ID in the cache directory")
+	cm.FlagSet.DurationVar(&deadline, "deadline", 0, "Maximum time for building and running all tests, an image build in progress is abandoned and keeps running on the daemon")
 	cm.FlagSet.BoolVar(&startDaemon, "rundaemon", false, "Start daemon")
+	cm.FlagSet.StringVar(&dockerBinary, "docker-binary", runner.DefaultDockerBinary(), "Docker binary used to start the daemon")
+	cm.FlagSet.StringVar(&pidFile, "daemon-pidfile", "", "Pid file of the daemon started with -rundaemon, defaults to the daemon config pidfile or "+runner.DefaultDaemonPidFile)
+	cm.FlagSet.BoolVar(&resume, "resume", false, "Skip instances which passed in a previous run using the same cache directory")
+	cm.FlagSet.BoolVar(&prune, "prune", false, "Remove volumes left by previous golem runs and exit")
 	cm.FlagSet.BoolVar(&debug, "debug", false, "Whether to output debug logs")
+	cm.FlagSet.Var(&serverRange, "server-version", "Constraint on the docker server version, such as \">=1.10.0,<1.13.0\"")
 
 	if err := cm.ParseFlags(os.Args[1:]); err != nil {
 		logrus.Fatalf("Invalid options: %v", err)
@@ -51,23 +92,68 @@ func main() {
 		logrus.Fatalf("Error creating run configuration: %v", err)
 	}
 
-	if cacheDir == "" {
-		td, err := ioutil.TempDir("", "golem-cache-")
+	if metricsFile != "" {
+		runConfig.Metrics = runner.NewMetrics()
+	}
+
+	settings, err := cacheSettings(golemConfig, cacheFlags)
+	if err != nil {
+		logrus.Fatalf("Error loading golem configuration: %v", err)
+	}
+
+	tempDirs := runner.NewTempDirs(tmpDir)
+	tempDirs.RemoveOnSignal(os.Interrupt, syscall.SIGTERM)
+	logrus.AddHook(tempDirs.FatalHook())
+	runConfig.TempDirs = tempDirs
+
+	bundleDir := settings.Root
+	if settings.Root == "" {
+		td, err := tempDirs.Create("golem-cache-")
 		if err != nil {
 			logrus.Fatalf("Error creating tempdir: %v", err)
 		}
-		cacheDir = td
-		defer os.RemoveAll(td)
+		settings.Root = td
+		defer tempDirs.Remove(td)
+		// Temporary cache directory is removed after the run
+		bundleDir = "."
 	}
 
-	cacheConfig := runner.CacheConfiguration{
-		ImageCache: runner.NewImageCache(filepath.Join(cacheDir, "images")),
+	if eventLog != "" {
+		el, err := runner.OpenEventLog(eventLog)
+		if err != nil {
+			logrus.Fatalf("Error opening event log: %v", err)
+		}
+		defer el.Close()
+		runConfig.EventLog = el
+
+		var instances []string
+		for _, suite := range runConfig.Suites {
+			for _, instance := range suite.Instances {
+				instances = append(instances, instance.Name)
+			}
+		}
+		if err := el.Log(runner.Event{Type: runner.EventConfigResolved, Instances: instances}); err != nil {
+			logrus.Errorf("Error writing to event log: %v", err)
+		}
+	}
+
+	if keepInstance {
+		runConfig.InstanceConfigDir = filepath.Join(settings.Root, "instances")
 	}
 
+	rf, err := runner.OpenResumeFile(settings.ResumeFile())
+	if err != nil {
+		logrus.Fatalf("Error opening resume file: %v", err)
+	}
+	runConfig.ResumeFile = rf
+	runConfig.Resume = resume
+
+	cacheConfig := settings.CacheConfiguration()
+
 	var client runner.DockerClient
 	if startDaemon {
 		logger := runner.NewConsoleLogCapturer()
-		c, shutdown, err := runner.StartDaemon(context.Background(), "docker", logger)
+		c, shutdown, err := runner.StartDaemon(context.Background(), runner.DaemonConfiguration{Binary: dockerBinary, PidFile: pidFile}, logger)
 		if err != nil {
 			logrus.Fatalf("Error starting deamon: %v", err)
 		}
@@ -83,22 +169,271 @@ func main() {
 
 	// require running on docker 1.10 to ensure content addressable
 	// image identifiers are used
-	if err := client.CheckServerVersion(versionutil.StaticVersion(1, 10, 0)); err != nil {
+	if err := client.CheckServerVersion(versionutil.AtLeast(versionutil.StaticVersion(1, 10, 0)).And(serverRange)); err != nil {
 		logrus.Fatal(err)
 	}
 
+	if prune {
+		removed, err := runner.PruneVolumes(context.Background(), client, "")
+		if err != nil {
+			logrus.Fatalf("Error pruning volumes: %v", err)
+		}
+		logrus.Infof("Removed %d volumes", len(removed))
+		return
+	}
+
+	ctx := context.Background()
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	router := runner.NewLogRouter(filepath.Join(settings.Root, "logs"), "")
+	defer router.Shutdown()
+	pullCapturer, err := router.RouteLogCapturer("pull")
+	if err != nil {
+		logrus.Fatalf("Error creating log capturer: %v", err)
+	}
+	defer pullCapturer.Close()
+	if err := router.AddCapturer("pull", runner.NewConsoleLogCapturer()); err != nil {
+		logrus.Fatalf("Error creating pull capturer: %v", err)
+	}
+	runConfig.PullCapturer = pullCapturer
+
+	buildCapturer, err := router.RouteLogCapturer("build")
+	if err != nil {
+		logrus.Fatalf("Error creating log capturer: %v", err)
+	}
+	defer buildCapturer.Close()
+	if err := router.AddCapturer("build", runner.NewConsoleLogCapturer()); err != nil {
+		logrus.Fatalf("Error creating build capturer: %v", err)
+	}
+	runConfig.BuildCapturer = buildCapturer
+
+	if len(runConfig.Hooks.BeforeAll) > 0 || len(runConfig.Hooks.AfterAll) > 0 {
+		hookCapturer, err := router.RouteLogCapturer("hooks")
+		if err != nil {
+			logrus.Fatalf("Error creating log capturer: %v", err)
+		}
+		defer hookCapturer.Close()
+		if err := router.AddCapturer("hooks", runner.NewConsoleLogCapturer()); err != nil {
+			logrus.Fatalf("Error creating hook capturer: %v", err)
+		}
+		runConfig.HookCapturer = hookCapturer
+	}
+
+	if runConfig.RunID == "" {
+		runConfig.RunID = runner.NewRunID()
+	}
 	r := runner.NewRunner(runConfig, cacheConfig, debug)
 
-	if err := r.Build(client); err != nil {
+	writeOutputs := func() {
+		writeMetrics(metricsFile, runConfig.Metrics)
+		if !bundle {
+			return
+		}
+		files := []runner.BundleFile{
+			{Name: "logs", Path: filepath.Join(settings.Root, "logs")},
+			{Name: "instances", Path: runConfig.InstanceConfigDir},
+			{Name: "coverage", Path: runConfig.CoverageDir},
+			{Name: filepath.Base(eventLog), Path: eventLog},
+			{Name: filepath.Base(metricsFile), Path: metricsFile},
+		}
+		writeBundle(filepath.Join(bundleDir, runner.BundleName(runConfig.RunID)), files)
+	}
+
+	if err := r.Build(ctx, client); err != nil {
+		writeOutputs()
 		logrus.Fatalf("Error building test images: %v", err)
 	}
 
-	if err := r.Run(client); err != nil {
+	err = r.Run(ctx, client)
+	writeOutputs()
+	if err != nil {
 		logrus.Fatalf("Error running tests: %v", err)
 	}
 }
 
-func runnerMain() {
+// writeMetrics writes the collected run metrics to the metrics
+// file, if any. Errors are logged so they do not fail the run.
+func writeMetrics(filename string, m *runner.Metrics) {
+	if filename == "" {
+		return
+	}
+	if err := m.WriteFile(filename); err != nil {
+		logrus.Errorf("Error writing metrics: %v", err)
+	}
+}
+
+// writeBundle writes the run bundle, skipping unset paths.
+// Errors are logged so they do not fail the run.
+func writeBundle(filename string, files []runner.BundleFile) {
+	var set []runner.BundleFile
+	for _, bf := range files {
+		if bf.Path != "" {
+			set = append(set, bf)
+		}
+	}
+	if err := runner.WriteBundle(filename, set); err != nil {
+		logrus.Errorf("Error writing bundle: %v", err)
+		return
+	}
+	logrus.Infof("Wrote run bundle %s", filename)
+}
+
+// cacheSettings returns the cache settings from the golem configuration
+// file, if any, with the cache flags taking precedence.
+func cacheSettings(golemConfig string, flags runner.CacheSettings) (runner.CacheSettings, error) {
+	if golemConfig == "" {
+		return flags, nil
+	}
+	settings, err := runner.LoadCacheSettings(golemConfig)
+	if err != nil {
+		return runner.CacheSettings{}, err
+	}
+	return settings.Merge(flags), nil
+}
+
+// listMain prints the suites and instances resolved from the
+// given suite paths as JSON without building or running them.
+func listMain(name string, args []string) {
+	var debug bool
+
+	cm := runner.NewConfigurationManager(name + " list")
+	cm.FlagSet.BoolVar(&debug, "debug", false, "Whether to output debug logs")
+
+	if err := cm.ParseFlags(args); err != nil {
+		logrus.Fatalf("Invalid options: %v", err)
+	}
+
+	if debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	runConfig, err := cm.RunnerConfiguration()
+	if err != nil {
+		logrus.Fatalf("Error creating run configuration: %v", err)
+	}
+
+	if err := runner.WriteSuiteListing(os.Stdout, runConfig); err != nil {
+		logrus.Fatalf("Error writing suite listing: %v", err)
+	}
+}
+
+// cacheMain exports the image cache to an archive or imports an
+// archive into the image cache. Exported entries are limited to
+// images which exist on the docker server.
+func cacheMain(name string, args []string) {
+	var (
+		cacheFlags  runner.CacheSettings
+		golemConfig string
+		debug       bool
+	)
+
+	cm := runner.NewConfigurationManager(name + " cache")
+	cm.FlagSet.StringVar(&golemConfig, "golem-config", os.Getenv("GOLEM_CONFIG"), "Golem configuration file for cache locations")
+	cm.FlagSet.StringVar(&cacheFlags.Root, "cache", "", "Cache directory")
+	cm.FlagSet.StringVar(&cacheFlags.Images, "image-cache", "", "Image cache directory, defaults to images in the cache directory")
+	cm.FlagSet.BoolVar(&debug, "debug", false, "Whether to output debug logs")
+
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		logrus.Fatalf("Usage: %s cache export|import [flags] <file>", name)
+	}
+	command := args[0]
+
+	if err := cm.ParseFlags(args[1:]); err != nil {
+		logrus.Fatalf("Invalid options: %v", err)
+	}
+	if cm.FlagSet.NArg() != 1 {
+		logrus.Fatalf("Usage: %s cache %s [flags] <file>", name, command)
+	}
+	archive := cm.FlagSet.Arg(0)
+
+	if debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	settings, err := cacheSettings(golemConfig, cacheFlags)
+	if err != nil {
+		logrus.Fatalf("Error loading golem configuration: %v", err)
+	}
+	if settings.Root == "" && settings.Images == "" {
+		logrus.Fatalf("No cache directory configured, set with -cache or a golem configuration file")
+	}
+	imageCache := settings.CacheConfiguration().ImageCache
+
+	client, err := cm.DockerClient()
+	if err != nil {
+		logrus.Fatalf("Failed to create client: %v", err)
+	}
+
+	if command == "import" {
+		f, err := os.Open(archive)
+		if err != nil {
+			logrus.Fatalf("Error opening cache archive: %v", err)
+		}
+		defer f.Close()
+		n, err := imageCache.Import(context.Background(), f, client)
+		if err != nil {
+			logrus.Fatalf("Error importing cache archive: %v", err)
+		}
+		logrus.Infof("Imported %d cache entries from %s", n, archive)
+		return
+	}
+
+	f, err := os.Create(archive)
+	if err != nil {
+		logrus.Fatalf("Error creating cache archive: %v", err)
+	}
+	defer f.Close()
+	n, err := imageCache.Export(context.Background(), f, client)
+	if err != nil {
+		logrus.Fatalf("Error exporting cache archive: %v", err)
+	}
+	logrus.Infof("Exported %d cache entries to %s", n, archive)
+}
+
+// optionalBool is a boolean flag which
+// records whether it has been set.
+type optionalBool struct {
+	set   bool
+	value bool
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.set = true
+	b.value = v
+	return nil
+}
+
+func (b *optionalBool) String() string {
+	if !b.set {
+		return ""
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// consoleEnabled returns whether test output should be dumped to the
+// console, by default only when logs are not being forwarded.
+func consoleEnabled(console optionalBool, forwardAddress string) bool {
+	if console.set {
+		return console.value
+	}
+	return forwardAddress == ""
+}
+
+// runnerMain runs the suite in a test container, returning a
+// stage error once the deferred capturers have been closed.
+func runnerMain() error {
 	var (
 		command        string
 		forwardAddress string
@@ -106,14 +441,60 @@ func runnerMain() {
 		dind           bool
 		clean          bool
 		debug          bool
+		stopTimeout    time.Duration
+		daemonConfig   runner.DaemonConfiguration
+		console        optionalBool
+		cleanup        runner.CleanupPolicy
+		instance       string
+		maxTaps        int
+		logPersist     runner.LogPersistence
+		logStreams     runner.LogStreams
+		mergeStreams   runner.LogStreams
+		printEnv       bool
+		envMask        runner.EnvMask
+		combinedLog    bool
+		tracer         runner.Tracer
+		traceTests     bool
+		loadProgress   bool
+		statusAddr     string
+		logFilter      string
+		daemonEvents   bool
+		refPolicy      runner.ReferencePolicy
 	)
 
-	flag.StringVar(&command, "command", "bats", "Command to run")
+	flag.StringVar(&command, "command", runner.DefaultTestCommand, "Default test command run when the instance has no test runner commands")
 	flag.StringVar(&forwardAddress, "forward", "", "Address to forward logs to")
+	flag.StringVar(&instance, "instance", "", "Name of the test instance, used to namespace logs")
+	flag.Var(&console, "console", "Whether to dump test output to console, defaults to true when logs are not forwarded")
 	flag.StringVar(&tapSocket, "tap-socket", "/var/run/golem-logs", "Socket to spawn log tapper")
+	flag.Var(&logPersist, "log-persist", "When to save log streams: always, on-failure or never")
+	flag.StringVar(&logFilter, "log-filter", "", "Shell command log streams are piped through before being saved or forwarded")
+	flag.Var(&logStreams, "log-streams", "Comma separated log streams to save, all streams when unset")
+	flag.Var(&mergeStreams, "merge-streams", "Comma separated log streams to save with stdout and stderr merged in write order")
+	flag.BoolVar(&printEnv, "print-env", false, "Print the environment of setup and test commands before they run")
+	flag.Var(&envMask, "env-mask", "Comma separated key patterns of environment values to mask when printing")
+	flag.BoolVar(&combinedLog, "combined-log", false, "Write the lines of all log streams to a combined log")
+	flag.Var(&tracer, "tracer", "Command to run the daemon under for debugging, output written to file descriptor 3 goes to the trace log")
+	flag.BoolVar(&traceTests, "trace-tests", false, "Whether to also run test runner commands under the tracer")
+	flag.BoolVar(&daemonEvents, "daemon-events", false, "Whether to capture the events of the docker daemon to the events log")
+	flag.Var(&refPolicy, "reference-policy", "How the tags of synced images are normalized: strict or docker")
+	flag.BoolVar(&loadProgress, "load-progress", false, "Whether to show image load progress in the load log")
+	flag.StringVar(&statusAddr, "status-addr", "", "Address to serve the run status and log tails over HTTP, disabled when empty")
+	flag.IntVar(&maxTaps, "max-taps", runner.DefaultMaxTaps, "Maximum number of simultaneous taps per log stream, 0 for no limit")
 	flag.BoolVar(&dind, "docker", false, "Whether to run docker")
 	flag.BoolVar(&clean, "clean", false, "Whether to ensure /var/lib/docker is empty")
 	flag.BoolVar(&debug, "debug", false, "Whether to output debug logs")
+	flag.DurationVar(&stopTimeout, "stop-timeout", 0, "Time to wait for containers to stop before killing them")
+	flag.Var(&cleanup, "cleanup", "Policy for removing compose containers on teardown: never, always, on-success or on-failure")
+	flag.StringVar(&daemonConfig.Binary, "docker-binary", runner.DefaultDockerBinary(), "Docker binary used to start the daemon")
+	flag.Var((*runner.RegistryMirrors)(&daemonConfig.RegistryMirrors), "registry-mirror", "Registry mirror for the docker daemon, may be set multiple times")
+	flag.Var((*runner.DaemonRuntimes)(&daemonConfig.Runtimes), "runtime", "Additional runtime for the docker daemon as name=path, may be set multiple times")
+	flag.StringVar(&daemonConfig.DefaultRuntime, "default-runtime", "", "Default runtime for the docker daemon")
+	flag.StringVar(&daemonConfig.ConfigFile, "daemon-config", "", "Daemon configuration file combined with /etc/docker/daemon.json")
+	flag.Var(&daemonConfig.ConfigMerge, "daemon-config-merge", "Whether the daemon configuration file is merged into or replaces /etc/docker/daemon.json: merge or replace")
+	flag.BoolVar(&daemonConfig.CheckWarnings, "daemon-warnings", false, "Whether to check daemon startup output for warnings")
+	flag.BoolVar(&daemonConfig.FailOnWarning, "daemon-warnings-fatal", false, "Whether daemon startup warnings fail the setup")
+	flag.StringVar(&daemonConfig.PidFile, "daemon-pidfile", "", "Pid file of the docker daemon, passed to the daemon with --pidfile, defaults to the daemon config pidfile or "+runner.DefaultDaemonPidFile)
 
 	flag.Parse()
 
@@ -121,7 +502,26 @@ func runnerMain() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	router := runner.NewLogRouter("/var/log/docker")
+	router := runner.NewLogRouter("/var/log/docker", instance)
+	router.SetMaxTaps(maxTaps)
+	if logPersist != "" || len(logStreams) > 0 {
+		router.SetPersistence(logPersist, logStreams)
+	}
+	if len(mergeStreams) > 0 {
+		router.SetMergedStreams(mergeStreams)
+	}
+	if logFilter != "" {
+		router.SetFilter(logFilter)
+	}
+
+	var combined *runner.CombinedLog
+	if combinedLog {
+		var err error
+		combined, err = router.AddCombinedLog("combined.log")
+		if err != nil {
+			logrus.Fatalf("Error creating combined log: %v", err)
+		}
+	}
 
 	if tapSocket != "" {
 		l, err := net.Listen("unix", tapSocket)
@@ -132,6 +532,24 @@ func runnerMain() {
 		go runner.TapServer(l, router)
 	}
 
+	var status *runner.StatusTracker
+	if statusAddr != "" {
+		tails, err := router.AddLogTails(runner.StatusTailLines)
+		if err != nil {
+			logrus.Fatalf("Error adding log tails: %v", err)
+		}
+		l, err := net.Listen("tcp", statusAddr)
+		if err != nil {
+			logrus.Fatalf("Error creating status listener for %s: %v", statusAddr, err)
+		}
+		status = runner.NewStatusTracker()
+		go func() {
+			if err := http.Serve(l, runner.NewStatusHandler(status, tails)); err != nil {
+				logrus.Errorf("Error serving status: %v", err)
+			}
+		}()
+	}
+
 	if forwardAddress != "" {
 		logrus.Debugf("Forwarding logs to %s, not yet supported", forwardAddress)
 		// TODO: Create forwarder with address
@@ -176,8 +594,28 @@ func runnerMain() {
 	}
 	defer testCapturer.Close()
 
-	if forwardAddress == "" {
-		logrus.Debugf("Logs not forwarded, dumping test output to console")
+	var traceCapturer runner.LogCapturer
+	if len(tracer) > 0 {
+		traceCapturer, err = router.RouteLogCapturer("trace")
+		if err != nil {
+			logrus.Fatalf("Error creating log capturer: %v", err)
+		}
+		defer traceCapturer.Close()
+		daemonConfig.Tracer = tracer
+		daemonConfig.TraceCapturer = traceCapturer
+	}
+
+	var eventsCapturer runner.LogCapturer
+	if daemonEvents && dind {
+		eventsCapturer, err = router.RouteLogCapturer("events")
+		if err != nil {
+			logrus.Fatalf("Error creating log capturer: %v", err)
+		}
+		defer eventsCapturer.Close()
+	}
+
+	if consoleEnabled(console, forwardAddress) {
+		logrus.Debugf("Dumping test output to console")
 		if err := router.AddCapturer("test", runner.NewConsoleLogCapturer()); err != nil {
 			logrus.Fatalf("Error creating test capturer")
 		}
@@ -196,13 +634,22 @@ func runnerMain() {
 	suiteConfig := runner.SuiteRunnerConfiguration{
 		DockerLoadLogCapturer: loadCapturer,
 		DockerLogCapturer:     daemonCapturer,
+		LoadProgress:          loadProgress,
+		ReferencePolicy:       refPolicy,
 
 		RunConfiguration: instanceConfig,
 		SetupLogCapturer: scriptCapturer,
 		TestCapturer:     testCapturer,
+		DefaultCommand:   strings.Fields(command),
 
 		CleanDockerGraph: clean,
 		DockerInDocker:   dind,
+		StopTimeout:      stopTimeout,
+		Cleanup:          cleanup,
+		Daemon:           daemonConfig,
+		LogRouter:        router,
+		Status:           status,
+		EventsCapturer:   eventsCapturer,
 	}
 
 	if composeCapturer != nil {
@@ -210,25 +657,103 @@ func runnerMain() {
 		suiteConfig.ComposeFile = composeFile
 
 	}
+	if printEnv {
+		suiteConfig.EnvPrinter = runner.NewEnvPrinter(envMask)
+	}
+	if traceCapturer != nil && traceTests {
+		suiteConfig.Tracer = tracer
+		suiteConfig.TraceTests = true
+		suiteConfig.TraceCapturer = traceCapturer
+	}
 
 	r := runner.NewSuiteRunner(suiteConfig)
 
 	if err := r.Setup(); err != nil {
-		logrus.Fatalf("Setup error: %v", err)
+		status.Finish(err)
+		flushLogs(router, combined, true)
+		return stageFailed(runner.ExitSetupFailed, "Setup error: %v", err)
 	}
 
 	runErr := r.RunTests()
 
-	if err := r.TearDown(); err != nil {
-		logrus.Errorf("TearDown error: %v", err)
+	resultCounts := map[runner.TestStatus]int{}
+	for _, result := range r.Results() {
+		resultCounts[result.Status]++
+	}
+	logrus.WithFields(logrus.Fields{
+		"passed":  resultCounts[runner.TestPassed],
+		"failed":  resultCounts[runner.TestFailed],
+		"skipped": resultCounts[runner.TestSkipped],
+		"allowed": resultCounts[runner.TestAllowedFailure],
+		"xfail":   resultCounts[runner.TestExpectedFailure],
+		"xpass":   resultCounts[runner.TestUnexpectedPass],
+	}).Info("test results")
+
+	teardownErr := r.TearDown()
+	if teardownErr != nil {
+		logrus.Errorf("TearDown error: %v", teardownErr)
 	}
 
 	if runErr != nil {
-		logrus.Fatalf("Test errored: %v", runErr)
+		status.Finish(runErr)
+	} else {
+		status.Finish(teardownErr)
+	}
+	flushLogs(router, combined, runErr != nil || teardownErr != nil)
+
+	if runErr != nil {
+		return stageFailed(runner.ExitTestFailed, "Test errored: %v", runErr)
+	}
+	if teardownErr != nil {
+		return stageFailed(runner.ExitTeardownFailed, "Teardown failed after tests passed: %v", teardownErr)
 	}
 
 	logrus.Debugf("Shutting down log router")
 	router.Shutdown()
+	return nil
+}
+
+// stageError is an error from a stage of the runner, with
+// the exit code identifying the failed stage to the outer runner.
+type stageError struct {
+	code int
+	msg  string
+}
+
+func (e stageError) Error() string {
+	return e.msg
+}
+
+// stageFailed returns a stage error with the exit code
+// and a formatted message.
+func stageFailed(code int, format string, args ...interface{}) error {
+	return stageError{
+		code: code,
+		msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+// stageExitCode returns the exit code for an error returned
+// by the runner, 1 when the error is not from a stage.
+func stageExitCode(err error) int {
+	if se, ok := err.(stageError); ok {
+		return se.code
+	}
+	return 1
+}
+
+// flushLogs writes any buffered log streams when the
+// instance failed and discards them otherwise, closing
+// the combined log.
+func flushLogs(router *runner.LogRouter, combined *runner.CombinedLog, failed bool) {
+	if combined != nil {
+		if err := combined.Close(); err != nil {
+			logrus.Errorf("Error closing combined log: %v", err)
+		}
+	}
+	if err := router.Flush(failed); err != nil {
+		logrus.Errorf("Error flushing logs: %v", err)
+	}
 }
 
 func tapperMain() {
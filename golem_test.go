@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/golem/runner"
+)
+
+func TestConsoleEnabled(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{}, true},
+		{[]string{"-forward=localhost:5000"}, false},
+		{[]string{"-console=false"}, false},
+		{[]string{"-console"}, true},
+		{[]string{"-console=true", "-forward=localhost:5000"}, true},
+		{[]string{"-console=false", "-forward=localhost:5000"}, false},
+	}
+	for _, c := range cases {
+		var (
+			console        optionalBool
+			forwardAddress string
+		)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&console, "console", "")
+		fs.StringVar(&forwardAddress, "forward", "", "")
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatalf("Error parsing %v: %v", c.args, err)
+		}
+		if enabled := consoleEnabled(console, forwardAddress); enabled != c.expected {
+			t.Fatalf("Unexpected console enabled for %v: %t, expected %t", c.args, enabled, c.expected)
+		}
+	}
+}
+
+func TestStageExitCode(t *testing.T) {
+	err := stageFailed(runner.ExitTeardownFailed, "Teardown failed after tests passed: %v", errors.New("daemon stuck"))
+	if code := stageExitCode(err); code != runner.ExitTeardownFailed {
+		t.Fatalf("Unexpected exit code %d, expected %d", code, runner.ExitTeardownFailed)
+	}
+	if msg := err.Error(); msg != "Teardown failed after tests passed: daemon stuck" {
+		t.Fatalf("Unexpected message %q", msg)
+	}
+	if code := stageExitCode(errors.New("unexpected")); code != 1 {
+		t.Fatalf("Unexpected exit code %d for an error without a stage", code)
+	}
+}
+
+func TestCacheSettings(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	config := filepath.Join(td, "golem.toml")
+	content := "[cache]\n  root=\"/var/cache/golem\"\n  images=\"images-cache\"\n"
+	if err := ioutil.WriteFile(config, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := cacheSettings(config, runner.CacheSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Root != "/var/cache/golem" {
+		t.Fatalf("Unexpected cache root %q", settings.Root)
+	}
+	if dir := settings.ImageDir(); dir != filepath.Join(td, "images-cache") {
+		t.Fatalf("Unexpected image cache %q", dir)
+	}
+	if f := settings.ResumeFile(); f != "/var/cache/golem/resume.json" {
+		t.Fatalf("Unexpected resume file %q", f)
+	}
+
+	// Flags take precedence over the file
+	settings, err = cacheSettings(config, runner.CacheSettings{Root: "/tmp/golem", Images: "/tmp/golem-images"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Root != "/tmp/golem" {
+		t.Fatalf("Unexpected cache root %q", settings.Root)
+	}
+	if settings.ImageDir() != "/tmp/golem-images" {
+		t.Fatalf("Unexpected image cache %q", settings.ImageDir())
+	}
+
+	// Without a file the flags are used
+	settings, err = cacheSettings("", runner.CacheSettings{Root: "/tmp/golem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ImageDir() != "/tmp/golem/images" {
+		t.Fatalf("Unexpected image cache %q", settings.ImageDir())
+	}
+
+	if _, err := cacheSettings(filepath.Join(td, "missing.toml"), runner.CacheSettings{}); err == nil {
+		t.Fatal("Expected error with missing configuration file")
+	}
+}
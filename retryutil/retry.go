@@ -0,0 +1,111 @@
+// Package retryutil provides a utility for retrying
+// operations with a configurable backoff policy.
+package retryutil
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Policy configures the number of attempts and the
+// backoff between attempts when retrying an operation.
+type Policy struct {
+	// MaxAttempts is the maximum number of times the operation
+	// is attempted, zero or less only attempts once.
+	MaxAttempts int
+
+	// InitialBackoff is the time to wait after the first
+	// failed attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff limits the time to wait between attempts,
+	// zero does not limit the backoff.
+	MaxBackoff time.Duration
+
+	// Multiplier is the growth of the backoff after each
+	// failed attempt, values less than 1 keep the backoff
+	// constant.
+	Multiplier float64
+
+	// Retryable classifies whether an error may be retried,
+	// when nil all errors not marked permanent are retried.
+	Retryable func(error) bool
+}
+
+// DefaultPolicy is a policy suitable for most network operations.
+var DefaultPolicy = Policy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+}
+
+// Backoff returns the time to wait after the given
+// failed attempt, starting from attempt 1.
+func (p Policy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && p.Multiplier > 1; i++ {
+		backoff = time.Duration(float64(backoff) * p.Multiplier)
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+func (p Policy) retryable(err error) bool {
+	if _, ok := err.(permanentError); ok {
+		return false
+	}
+	if p.Retryable == nil {
+		return true
+	}
+	return p.Retryable(err)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (pe permanentError) Error() string {
+	return pe.err.Error()
+}
+
+// Permanent marks an error as not retryable, Do will
+// return the original error without further attempts.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error,
+// the maximum attempts is reached or the context is done. The
+// last error returned by fn is returned.
+func Do(ctx context.Context, p Policy, fn func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if pe, ok := err.(permanentError); ok {
+			return pe.err
+		}
+		if attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		t := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
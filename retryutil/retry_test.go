@@ -0,0 +1,136 @@
+package retryutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var errFailed = errors.New("failed")
+
+func TestMaxAttempts(t *testing.T) {
+	var attempts int
+	err := Do(context.Background(), Policy{MaxAttempts: 3}, func(context.Context) error {
+		attempts++
+		return errFailed
+	})
+	if err != errFailed {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Unexpected attempts %d, expected 3", attempts)
+	}
+
+	attempts = 0
+	err = Do(context.Background(), Policy{MaxAttempts: 3}, func(context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return errFailed
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("Unexpected attempts %d, expected 2", attempts)
+	}
+
+	attempts = 0
+	Do(context.Background(), Policy{}, func(context.Context) error {
+		attempts++
+		return errFailed
+	})
+	if attempts != 1 {
+		t.Fatalf("Unexpected attempts %d with empty policy, expected 1", attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, e := range expected {
+		if b := p.Backoff(i + 1); b != e {
+			t.Fatalf("Unexpected backoff for attempt %d: %s, expected %s", i+1, b, e)
+		}
+	}
+
+	constant := Policy{InitialBackoff: time.Second}
+	if b := constant.Backoff(5); b != time.Second {
+		t.Fatalf("Unexpected constant backoff: %s", b)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var attempts int
+	errC := make(chan error, 1)
+	go func() {
+		errC <- Do(ctx, Policy{MaxAttempts: 10, InitialBackoff: time.Hour}, func(context.Context) error {
+			attempts++
+			return errFailed
+		})
+	}()
+	cancel()
+
+	select {
+	case err := <-errC:
+		if err != errFailed {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if attempts != 1 {
+			t.Fatalf("Unexpected attempts %d, expected 1", attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Retry not stopped after context cancelled")
+	}
+}
+
+func TestNonRetryable(t *testing.T) {
+	var attempts int
+	err := Do(context.Background(), Policy{MaxAttempts: 5}, func(context.Context) error {
+		attempts++
+		return Permanent(errFailed)
+	})
+	if err != errFailed {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("Unexpected attempts %d, expected 1", attempts)
+	}
+
+	errOther := errors.New("other")
+	p := Policy{
+		MaxAttempts: 5,
+		Retryable: func(err error) bool {
+			return err == errFailed
+		},
+	}
+	attempts = 0
+	err = Do(context.Background(), p, func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errFailed
+		}
+		return errOther
+	})
+	if err != errOther {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Unexpected attempts %d, expected 3", attempts)
+	}
+}
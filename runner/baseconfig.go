@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"golang.org/x/net/context"
+)
+
+// cacheConfigsDir is the image cache directory storing the
+// base image last built for each base image configuration.
+const cacheConfigsDir = "configs"
+
+// baseConfigKey returns the key of a base image configuration
+// itself, computed without resolving any of its references, so
+// that an unchanged configuration is recognized before any
+// images are inspected or pulled.
+func baseConfigKey(conf BaseImageConfiguration, pinned bool) digest.Digest {
+	lines := []string{
+		"version " + hashVersion,
+		"base " + conf.Base.String(),
+	}
+	if !conf.Platform.IsZero() {
+		lines = append(lines, "platform "+conf.Platform.String())
+	}
+	if pinned {
+		lines = append(lines, "pinned true")
+	}
+
+	var refs []string
+	for arch, ref := range conf.ArchBase {
+		refs = append(refs, fmt.Sprintf("arch %s %s", arch, ref))
+	}
+	for _, ref := range conf.ExtraImages {
+		refs = append(refs, "image "+ref.String())
+	}
+	for _, ci := range conf.CustomImages {
+		env := append([]string{}, ci.Env...)
+		sort.Strings(env)
+		refs = append(refs, fmt.Sprintf("custom %s %s %s %s", ci.Target, ci.Source, ci.Version, strings.Join(env, " ")))
+	}
+	sort.Strings(refs)
+
+	return digest.FromBytes([]byte(strings.Join(append(lines, refs...), "\n")))
+}
+
+func (ic *ImageCache) configFile(key digest.Digest) string {
+	return filepath.Join(ic.root, cacheConfigsDir, key.Algorithm().String(), key.Hex())
+}
+
+// GetConfigImage gets the id of the base image last built for
+// the configuration key, returning an error satisfying
+// os.IsNotExist if none is saved.
+func (ic *ImageCache) GetConfigImage(key digest.Digest) (string, error) {
+	b, err := ioutil.ReadFile(ic.configFile(key))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// SaveConfigImage saves the id of the base image built for
+// the configuration key.
+func (ic *ImageCache) SaveConfigImage(key digest.Digest, id string) error {
+	fp := ic.configFile(key)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fp, []byte(id), 0644)
+}
+
+// baseImage returns the base image for the configuration,
+// skipping the base image build entirely when an image was
+// built from the same configuration and is still present.
+func (r *runner) baseImage(ctx context.Context, cli DockerClient, conf BaseImageConfiguration) (string, error) {
+	return r.configBaseImage(ctx, cli, conf, func() (string, error) {
+		return r.buildBaseImage(ctx, cli, conf)
+	})
+}
+
+// configBaseImage returns the image last built for the base
+// image configuration if present, otherwise calls build and
+// saves the built image for the configuration. The saved image
+// is ignored when refreshing base images so that images behind
+// unchanged references are resolved again.
+func (r *runner) configBaseImage(ctx context.Context, cli imageInspector, conf BaseImageConfiguration, build func() (string, error)) (string, error) {
+	if id := r.reusableBaseImage(ctx, cli, conf); id != "" {
+		return id, nil
+	}
+
+	id, err := build()
+	if err != nil {
+		return "", err
+	}
+	key := baseConfigKey(conf, r.config.PinDigests)
+	if err := r.cache.ImageCache.SaveConfigImage(key, id); err != nil {
+		logrus.Errorf("Unable to save base image for configuration %s: %v", key, err)
+	}
+	return id, nil
+}
+
+// reusableBaseImage returns the present image last built for
+// the base image configuration, empty if there is none or base
+// images are being refreshed.
+func (r *runner) reusableBaseImage(ctx context.Context, cli imageInspector, conf BaseImageConfiguration) string {
+	if r.config.RefreshBase {
+		return ""
+	}
+	key := baseConfigKey(conf, r.config.PinDigests)
+	id, err := r.cache.ImageCache.GetConfigImage(key)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("Unable to read base image for configuration %s: %v", key, err)
+		}
+		return ""
+	}
+	if _, _, err := cli.ImageInspectWithRaw(ctx, id, false); err != nil {
+		logrus.Debugf("Base image %s for configuration %s not found", id, key)
+		return ""
+	}
+	if changed := r.changedCustomSources(ctx, cli, conf); len(changed) > 0 {
+		logrus.Warnf("Custom image sources of base image %s now resolve to different images, rebuilding: %s", id, strings.Join(changed, ", "))
+		return ""
+	}
+	logrus.Debugf("Base image configuration %s unchanged, using %s", key, id)
+	return id
+}
+
+// changedCustomSources returns the custom image sources of the
+// configuration whose local images differ from the images the
+// base image was last built with. A source rebuilt locally under
+// the same tag leaves the configuration key unchanged, only the
+// local image is inspected so nothing is pulled.
+func (r *runner) changedCustomSources(ctx context.Context, cli imageInspector, conf BaseImageConfiguration) []string {
+	if len(conf.CustomImages) == 0 {
+		return nil
+	}
+	inputs, err := r.cache.ImageCache.GetInputs(baseImageInputsKey(conf))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("Unable to read build inputs: %v", err)
+		}
+		return nil
+	}
+	built := map[string]string{}
+	for _, line := range inputs {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "image" {
+			built[fields[1]] = fields[2]
+		}
+	}
+
+	var changed []string
+	for _, ci := range conf.CustomImages {
+		id, ok := built[ci.Target.String()]
+		if !ok {
+			continue
+		}
+		info, _, err := cli.ImageInspectWithRaw(ctx, ci.Source, false)
+		if err != nil {
+			logrus.Debugf("Custom image source %s not found: %v", ci.Source, err)
+			continue
+		}
+		if info.ID != id {
+			changed = append(changed, ci.Source)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/engine-api/types"
+	"golang.org/x/net/context"
+)
+
+func TestConfigBaseImage(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	r := &runner{
+		cache: CacheConfiguration{ImageCache: NewImageCache(td)},
+	}
+	cli := fakeImageInspector{}
+	conf := BaseImageConfiguration{
+		Base:        assertTagged("golang:1.7"),
+		ExtraImages: []reference.NamedTagged{assertTagged("nginx:1.9")},
+	}
+
+	var builds int
+	build := func(id string) func() (string, error) {
+		return func() (string, error) {
+			builds++
+			cli[id] = types.ImageInspect{ID: id}
+			return id, nil
+		}
+	}
+	checkBuild := func(conf BaseImageConfiguration, id, expectedID string, expectedBuilds int) {
+		actual, err := r.configBaseImage(context.Background(), cli, conf, build(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expectedID {
+			t.Fatalf("Unexpected base image %s, expected %s", actual, expectedID)
+		}
+		if builds != expectedBuilds {
+			t.Fatalf("Unexpected builds %d, expected %d", builds, expectedBuilds)
+		}
+	}
+
+	checkBuild(conf, "sha256:first", "sha256:first", 1)
+
+	// Unchanged configuration skips the build
+	checkBuild(conf, "sha256:second", "sha256:first", 1)
+
+	// Changed configuration is built
+	changed := conf
+	changed.ExtraImages = []reference.NamedTagged{assertTagged("nginx:1.10")}
+	checkBuild(changed, "sha256:second", "sha256:second", 2)
+	checkBuild(changed, "sha256:third", "sha256:second", 2)
+
+	// Removed image is built again
+	delete(cli, "sha256:first")
+	checkBuild(conf, "sha256:fourth", "sha256:fourth", 3)
+
+	// Refreshing always builds
+	r.config.RefreshBase = true
+	checkBuild(conf, "sha256:fifth", "sha256:fifth", 4)
+}
+
+func TestBaseConfigKey(t *testing.T) {
+	conf := BaseImageConfiguration{
+		Base: assertTagged("golang:1.7"),
+		CustomImages: []CustomImage{
+			{Source: "dockerd:1.12", Target: assertTagged("docker:latest"), Version: "1.12"},
+		},
+	}
+	key := baseConfigKey(conf, false)
+	if baseConfigKey(conf, false) != key {
+		t.Fatal("Expected same key for unchanged configuration")
+	}
+	if baseConfigKey(conf, true) == key {
+		t.Fatal("Expected different key when pinning digests")
+	}
+
+	changed := conf
+	changed.CustomImages = []CustomImage{
+		{Source: "dockerd:1.13", Target: assertTagged("docker:latest"), Version: "1.13"},
+	}
+	if baseConfigKey(changed, false) == key {
+		t.Fatal("Expected different key for changed custom image")
+	}
+
+	changed = conf
+	changed.Platform = Platform{OS: "linux", Architecture: "arm64"}
+	if baseConfigKey(changed, false) == key {
+		t.Fatal("Expected different key for changed platform")
+	}
+}
+
+func TestConfigBaseImageChangedSource(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	r := &runner{
+		cache: CacheConfiguration{ImageCache: NewImageCache(td)},
+	}
+	cli := fakeImageInspector{
+		"dockerd:dev": {ID: "sha256:dockerd-1"},
+	}
+	conf := BaseImageConfiguration{
+		Base: assertTagged("golang:1.7"),
+		CustomImages: []CustomImage{
+			{Source: "dockerd:dev", Target: assertTagged("docker:latest"), Version: "dev"},
+		},
+	}
+
+	var builds int
+	build := func(id string) func() (string, error) {
+		return func() (string, error) {
+			builds++
+			cli[id] = types.ImageInspect{ID: id}
+			// Record the source image as the base image build does
+			inputs := []string{"image docker:latest " + cli["dockerd:dev"].ID}
+			if err := r.cache.ImageCache.SaveInputs(baseImageInputsKey(conf), inputs); err != nil {
+				t.Fatal(err)
+			}
+			return id, nil
+		}
+	}
+	checkBuild := func(id, expectedID string, expectedBuilds int) {
+		actual, err := r.configBaseImage(context.Background(), cli, conf, build(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expectedID {
+			t.Fatalf("Unexpected base image %s, expected %s", actual, expectedID)
+		}
+		if builds != expectedBuilds {
+			t.Fatalf("Unexpected builds %d, expected %d", builds, expectedBuilds)
+		}
+	}
+
+	checkBuild("sha256:first", "sha256:first", 1)
+	checkBuild("sha256:second", "sha256:first", 1)
+
+	// Source rebuilt under the same tag is built again
+	cli["dockerd:dev"] = types.ImageInspect{ID: "sha256:dockerd-2"}
+	checkBuild("sha256:third", "sha256:third", 2)
+	checkBuild("sha256:fourth", "sha256:third", 2)
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// BundleFile is a file or directory included in a run bundle,
+// stored under Name in the archive.
+type BundleFile struct {
+	Name string
+	Path string
+}
+
+// BundleName returns the file name of the bundle for a run.
+func BundleName(runID string) string {
+	return "golem-" + runID + ".tar.gz"
+}
+
+// WriteBundle writes the files to a gzipped tar archive at
+// filename, recursing into directories. Paths which do not
+// exist are skipped. File contents are streamed into the
+// archive so large logs are never held in memory. The archive
+// is written to a temporary file and renamed when complete.
+func WriteBundle(filename string, files []BundleFile) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".bundle-")
+	if err != nil {
+		return err
+	}
+	if err := writeBundle(f, files); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+func writeBundle(w io.Writer, files []BundleFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, bf := range files {
+		if _, err := os.Stat(bf.Path); err != nil {
+			if os.IsNotExist(err) {
+				logrus.Debugf("Skipping missing bundle file %s", bf.Path)
+				continue
+			}
+			return err
+		}
+		if err := addBundlePath(tw, bf.Name, bf.Path); err != nil {
+			return fmt.Errorf("error adding %s to bundle: %v", bf.Path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// addBundlePath adds the file or directory tree at p to the
+// archive under name. Only regular files and directories are
+// added.
+func addBundlePath(tw *tar.Writer, name, p string) error {
+	return filepath.Walk(p, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(p, fp)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(hdr)
+		}
+
+		f, err := os.Open(fp)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// Logs may still be appended to while bundling, only
+		// copy the size recorded in the header
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, f, hdr.Size)
+		return err
+	})
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteBundle(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	logs := filepath.Join(td, "logs")
+	if err := os.MkdirAll(filepath.Join(logs, "suite-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTempFile(t, logs, "build.log", "building\n")
+	writeTempFile(t, filepath.Join(logs, "suite-1"), "test.log", "ok 1 first\n")
+	events := writeTempFile(t, td, "events.json", `{"type":"run-complete"}`+"\n")
+
+	filename := filepath.Join(td, BundleName("abc123"))
+	err = WriteBundle(filename, []BundleFile{
+		{Name: "logs", Path: logs},
+		{Name: "coverage", Path: filepath.Join(td, "missing")},
+		{Name: "events.json", Path: events},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(filename) != "golem-abc123.tar.gz" {
+		t.Fatalf("Unexpected bundle name %s", filepath.Base(filename))
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	var dirs []string
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr.Name)
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(b)
+	}
+
+	expected := map[string]string{
+		"logs/build.log":        "building\n",
+		"logs/suite-1/test.log": "ok 1 first\n",
+		"events.json":           `{"type":"run-complete"}` + "\n",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("Unexpected bundle files %v, expected %v", files, expected)
+	}
+	if !reflect.DeepEqual(dirs, []string{"logs/", "logs/suite-1/"}) {
+		t.Fatalf("Unexpected bundle directories %v", dirs)
+	}
+
+	// No temporary files left behind
+	entries, err := ioutil.ReadDir(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name()[0] == '.' {
+			t.Fatalf("Unexpected temporary file %s", e.Name())
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package runner
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+	"golang.org/x/net/context"
+)
+
+const (
+	// cacheArchiveManifest is the name of the archive entry
+	// listing the digest of every other archive entry.
+	cacheArchiveManifest = "manifest.json"
+
+	// cacheArchiveImages is the archive directory of the
+	// image cache entries, using the image cache layout.
+	cacheArchiveImages = "images"
+
+	// cacheArchiveSaved is the archive directory of the saved
+	// image tars, by image id.
+	cacheArchiveSaved = "saved"
+
+	// maxCacheEntrySize is the maximum size of an archive
+	// entry, image cache entries only contain an image id.
+	maxCacheEntrySize = 1 << 20
+)
+
+type cacheArchiveManifestFile struct {
+	Files map[string]digest.Digest `json:"files"`
+}
+
+// entries returns the image ids in the cache by digest.
+func (ic *ImageCache) entries() (map[digest.Digest]string, error) {
+	entries := map[digest.Digest]string{}
+	algs, err := ioutil.ReadDir(ic.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	for _, alg := range algs {
+		if !alg.IsDir() || alg.Name() == cacheInputsDir || alg.Name() == cacheConfigsDir {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(ic.root, alg.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			dgst := digest.NewDigestFromHex(alg.Name(), f.Name())
+			if err := dgst.Validate(); err != nil {
+				logrus.Debugf("Skipping invalid cache entry %s: %v", dgst, err)
+				continue
+			}
+			id, err := ic.GetImage(dgst)
+			if err != nil {
+				return nil, err
+			}
+			entries[dgst] = id
+		}
+	}
+	return entries, nil
+}
+
+// cacheArchiveClient is the subset of the docker client used
+// to export and import cache archives
+type cacheArchiveClient interface {
+	imageInspector
+	imageSaver
+	imageLoader
+}
+
+// savedImageName returns the archive entry of the saved
+// image tar for the image id.
+func savedImageName(imageID string) (string, error) {
+	dgst, err := digest.ParseDigest(imageID)
+	if err != nil {
+		return "", fmt.Errorf("invalid image id %s: %v", imageID, err)
+	}
+	return path.Join(cacheArchiveSaved, dgst.Algorithm().String(), dgst.Hex()+".tar"), nil
+}
+
+// Export writes the image cache to a tar archive along with
+// the saved images, so the archive can seed the cache of a
+// docker server without the images. Entries referencing
+// images which no longer exist are skipped. Returns the number
+// of exported entries.
+func (ic *ImageCache) Export(ctx context.Context, w io.Writer, cli cacheArchiveClient) (int, error) {
+	entries, err := ic.entries()
+	if err != nil {
+		return 0, fmt.Errorf("error reading image cache: %v", err)
+	}
+
+	dgsts := make([]string, 0, len(entries))
+	images := map[string]string{}
+	for dgst, id := range entries {
+		if _, _, err := cli.ImageInspectWithRaw(ctx, id, false); err != nil {
+			logrus.Debugf("Skipping cache entry %s, image %s does not exist", dgst, id)
+			continue
+		}
+		name, err := savedImageName(id)
+		if err != nil {
+			logrus.Debugf("Skipping cache entry %s: %v", dgst, err)
+			continue
+		}
+		dgsts = append(dgsts, dgst.String())
+		images[id] = name
+	}
+	sort.Strings(dgsts)
+
+	td, err := ioutil.TempDir("", "golem-cache-export-")
+	if err != nil {
+		return 0, fmt.Errorf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	tw := tar.NewWriter(w)
+	manifest := cacheArchiveManifestFile{
+		Files: map[string]digest.Digest{},
+	}
+	writeEntry := func(name string, content []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	for _, s := range dgsts {
+		dgst := digest.Digest(s)
+		name := path.Join(cacheArchiveImages, dgst.Algorithm().String(), dgst.Hex())
+		content := []byte(entries[dgst])
+		if err := writeEntry(name, content); err != nil {
+			return 0, err
+		}
+		manifest.Files[name] = digest.FromBytes(content)
+	}
+
+	ids := make([]string, 0, len(images))
+	for id := range images {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		dgst, err := writeSavedImage(tw, cli, filepath.Join(td, "image.tar"), images[id], id)
+		if err != nil {
+			return 0, fmt.Errorf("error exporting image %s: %v", id, err)
+		}
+		manifest.Files[images[id]] = dgst
+	}
+
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeEntry(cacheArchiveManifest, b); err != nil {
+		return 0, err
+	}
+
+	return len(dgsts), tw.Close()
+}
+
+// writeSavedImage saves the image to filename and copies it to
+// the archive entry name, returning the digest of the entry.
+func writeSavedImage(tw *tar.Writer, cli imageSaver, filename, name, imageID string) (digest.Digest, error) {
+	if err := saveImage(cli, filename, imageID); err != nil {
+		return "", err
+	}
+	defer os.Remove(filename)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	digester := digest.Canonical.New()
+	if err := writeTarFile(tw, name, fi.Size(), io.TeeReader(f, digester.Hash())); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// Import restores image cache entries from an archive written
+// by Export, loading the saved images which do not exist on
+// the docker server. The archive is validated against its
+// manifest before any images are loaded or entries are saved.
+// Returns the number of imported entries.
+func (ic *ImageCache) Import(ctx context.Context, r io.Reader, cli cacheArchiveClient) (int, error) {
+	var manifest *cacheArchiveManifestFile
+	files := map[string][]byte{}
+	digests := map[string]digest.Digest{}
+
+	td, err := ioutil.TempDir("", "golem-cache-import-")
+	if err != nil {
+		return 0, fmt.Errorf("unable to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	saved := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading cache archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if strings.HasPrefix(hdr.Name, cacheArchiveSaved+"/") {
+			// Saved images are kept on disk until validated
+			filename := filepath.Join(td, fmt.Sprintf("image-%d.tar", len(saved)))
+			dgst, err := copySavedImage(filename, tr)
+			if err != nil {
+				return 0, fmt.Errorf("error reading cache archive entry %s: %v", hdr.Name, err)
+			}
+			saved[hdr.Name] = filename
+			digests[hdr.Name] = dgst
+			continue
+		}
+		if hdr.Size > maxCacheEntrySize {
+			return 0, fmt.Errorf("cache archive entry %s too large", hdr.Name)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return 0, fmt.Errorf("error reading cache archive entry %s: %v", hdr.Name, err)
+		}
+		if hdr.Name == cacheArchiveManifest {
+			manifest = &cacheArchiveManifestFile{}
+			if err := json.Unmarshal(b, manifest); err != nil {
+				return 0, fmt.Errorf("invalid cache archive manifest: %v", err)
+			}
+			continue
+		}
+		files[hdr.Name] = b
+		digests[hdr.Name] = digest.FromBytes(b)
+	}
+
+	if manifest == nil {
+		return 0, errors.New("cache archive missing manifest")
+	}
+
+	for name, expected := range manifest.Files {
+		actual, ok := digests[name]
+		if !ok {
+			return 0, fmt.Errorf("cache archive missing %s", name)
+		}
+		if actual != expected {
+			return 0, fmt.Errorf("cache archive entry %s has digest %s, expected %s", name, actual, expected)
+		}
+	}
+	for name := range digests {
+		if _, ok := manifest.Files[name]; !ok {
+			return 0, fmt.Errorf("cache archive entry %s not in manifest", name)
+		}
+	}
+
+	entries := map[digest.Digest]string{}
+	for name, content := range files {
+		parts := strings.Split(name, "/")
+		if len(parts) != 3 || parts[0] != cacheArchiveImages {
+			return 0, fmt.Errorf("unexpected cache archive entry %s", name)
+		}
+		dgst := digest.NewDigestFromHex(parts[1], parts[2])
+		if err := dgst.Validate(); err != nil {
+			return 0, fmt.Errorf("invalid cache archive entry %s: %v", name, err)
+		}
+		id := string(content)
+		savedName, err := savedImageName(id)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cache archive entry %s: %v", name, err)
+		}
+		if _, ok := saved[savedName]; !ok {
+			return 0, fmt.Errorf("cache archive missing saved image %s for %s", id, name)
+		}
+		entries[dgst] = id
+	}
+
+	loaded := map[string]struct{}{}
+	for _, id := range entries {
+		if _, ok := loaded[id]; ok {
+			continue
+		}
+		savedName, _ := savedImageName(id)
+		if err := loadSavedImage(ctx, cli, saved[savedName], id); err != nil {
+			return 0, err
+		}
+		loaded[id] = struct{}{}
+	}
+
+	for dgst, id := range entries {
+		if err := ic.SaveImage(dgst, id); err != nil {
+			return 0, fmt.Errorf("error saving cache entry %s: %v", dgst, err)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// copySavedImage copies a saved image archive entry to
+// filename, returning the digest of the entry.
+func copySavedImage(filename string, r io.Reader) (digest.Digest, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	digester := digest.Canonical.New()
+	if _, err := io.Copy(f, io.TeeReader(r, digester.Hash())); err != nil {
+		return "", err
+	}
+	return digester.Digest(), nil
+}
+
+// loadSavedImage loads the saved image tar unless the image
+// already exists, checking the loaded image id when reported.
+func loadSavedImage(ctx context.Context, cli cacheArchiveClient, filename, imageID string) error {
+	if _, _, err := cli.ImageInspectWithRaw(ctx, imageID, false); err == nil {
+		logrus.Debugf("Image %s exists, skipping load", imageID)
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := verifySavedImage(f, imageID); err != nil {
+		return fmt.Errorf("invalid saved image %s: %v", imageID, err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	logrus.Debugf("Loading image %s", imageID)
+	resp, err := cli.ImageLoad(ctx, f, true)
+	if err != nil {
+		return fmt.Errorf("error loading image %s: %v", imageID, err)
+	}
+	if resp.Body == nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	loadedID, err := displayLoad(resp, ioutil.Discard)
+	if err != nil {
+		return fmt.Errorf("error loading image %s: %v", imageID, err)
+	}
+	if loadedID != "" && loadedID != imageID {
+		return fmt.Errorf("loaded image %s, expected %s", loadedID, imageID)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/engine-api/types"
+	"golang.org/x/net/context"
+)
+
+// archiveDaemon is a fake docker server holding saved image
+// tars by image id, adding the images it loads.
+type archiveDaemon struct {
+	images map[string][]byte
+	loads  int
+}
+
+func (d *archiveDaemon) ImageInspectWithRaw(ctx context.Context, imageID string, getSize bool) (types.ImageInspect, []byte, error) {
+	if _, ok := d.images[imageID]; !ok {
+		return types.ImageInspect{}, nil, fmt.Errorf("no such image: %s", imageID)
+	}
+	return types.ImageInspect{ID: imageID}, nil, nil
+}
+
+func (d *archiveDaemon) ImageSave(ctx context.Context, imageIDs []string) (io.ReadCloser, error) {
+	b, ok := d.images[imageIDs[0]]
+	if !ok {
+		return nil, fmt.Errorf("no such image: %s", imageIDs[0])
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (d *archiveDaemon) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	b, err := ioutil.ReadAll(input)
+	if err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	var manifest []savedManifest
+	err = walkTar(bytes.NewReader(b), func(name string, hdr *tar.Header, r io.Reader) error {
+		if name != "manifest.json" {
+			return nil
+		}
+		return json.NewDecoder(r).Decode(&manifest)
+	})
+	if err != nil || len(manifest) == 0 {
+		return types.ImageLoadResponse{}, fmt.Errorf("invalid image tar: %v", err)
+	}
+	id := "sha256:" + strings.TrimSuffix(manifest[0].Config, ".json")
+	d.images[id] = b
+	d.loads++
+	body := loadedImagePrefix + id + "\n"
+	return types.ImageLoadResponse{Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+}
+
+// savedImageTar returns a docker save tar for the image id.
+func savedImageTar(t *testing.T, id string) []byte {
+	hex := digest.Digest(id).Hex()
+	manifest, err := json.Marshal([]savedManifest{{Config: hex + ".json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buildTar(t, map[string][]byte{
+		"manifest.json": manifest,
+		hex + ".json":   []byte(`{"os":"linux"}`),
+	})
+}
+
+func TestImageCacheExportImport(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir("", "golem-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	keptID := digest.FromBytes([]byte("kept image")).String()
+	removedID := digest.FromBytes([]byte("removed image")).String()
+
+	source := NewImageCache(filepath.Join(td, "source"))
+	kept := digest.FromBytes([]byte("kept"))
+	shared := digest.FromBytes([]byte("shared"))
+	removed := digest.FromBytes([]byte("removed"))
+	if err := source.SaveImage(kept, keptID); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.SaveImage(shared, keptID); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.SaveImage(removed, removedID); err != nil {
+		t.Fatal(err)
+	}
+	sourceDaemon := &archiveDaemon{images: map[string][]byte{keptID: savedImageTar(t, keptID)}}
+
+	var buf bytes.Buffer
+	n, err := source.Export(ctx, &buf, sourceDaemon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Unexpected exported entries %d, expected 2", n)
+	}
+	archive := buf.Bytes()
+
+	// Import on a docker server without the images
+	dest := NewImageCache(filepath.Join(td, "dest"))
+	destDaemon := &archiveDaemon{images: map[string][]byte{}}
+	n, err = dest.Import(ctx, bytes.NewReader(archive), destDaemon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Unexpected imported entries %d, expected 2", n)
+	}
+	if destDaemon.loads != 1 {
+		t.Fatalf("Unexpected image loads %d, expected 1", destDaemon.loads)
+	}
+	if !bytes.Equal(destDaemon.images[keptID], sourceDaemon.images[keptID]) {
+		t.Fatalf("Expected image %s loaded from archive", keptID)
+	}
+
+	// Cached base images are found on the fresh server
+	r := &runner{cache: CacheConfiguration{ImageCache: dest}}
+	for _, dgst := range []digest.Digest{kept, shared} {
+		id, err := r.cachedBaseImage(ctx, destDaemon, dgst, digest.FromBytes([]byte("inputs")), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != keptID {
+			t.Fatalf("Unexpected cached image %q for %s, expected %s", id, dgst, keptID)
+		}
+	}
+	if _, err := dest.GetImage(removed); err == nil {
+		t.Fatal("Expected entry for removed image to be skipped")
+	}
+
+	// Existing images are not loaded again
+	n, err = NewImageCache(filepath.Join(td, "again")).Import(ctx, bytes.NewReader(archive), destDaemon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || destDaemon.loads != 1 {
+		t.Fatalf("Unexpected import of %d entries with %d loads", n, destDaemon.loads)
+	}
+
+	// Tampered entries are rejected
+	var tampered bytes.Buffer
+	tw := tar.NewWriter(&tampered)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != cacheArchiveManifest {
+			content = []byte("sha256:cccc")
+		}
+		hdr.Size = int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewImageCache(filepath.Join(td, "tampered")).Import(ctx, &tampered, &archiveDaemon{images: map[string][]byte{}}); err == nil {
+		t.Fatal("Expected error importing tampered archive")
+	}
+	if _, err := os.Stat(filepath.Join(td, "tampered")); !os.IsNotExist(err) {
+		t.Fatalf("Expected no entries saved from tampered archive: %v", err)
+	}
+}
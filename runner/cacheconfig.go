@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CacheSettings are the locations used for caching between
+// runs. Empty locations default to a subdirectory of Root.
+type CacheSettings struct {
+	// Root is the cache directory
+	Root string `toml:"root"`
+
+	// Images is the directory of the image cache
+	Images string `toml:"images"`
+
+	// Resume is the file recording instance results
+	Resume string `toml:"resume"`
+}
+
+type golemConfiguration struct {
+	Cache CacheSettings `toml:"cache"`
+}
+
+// LoadCacheSettings loads the cache settings from a golem
+// configuration file. Relative paths are resolved from the
+// directory containing the configuration file.
+func LoadCacheSettings(path string) (CacheSettings, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return CacheSettings{}, err
+	}
+
+	var conf golemConfiguration
+	if err := toml.Unmarshal(b, &conf); err != nil {
+		return CacheSettings{}, fmt.Errorf("error unmarshalling %s: %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	return CacheSettings{
+		Root:   resolve(conf.Cache.Root),
+		Images: resolve(conf.Cache.Images),
+		Resume: resolve(conf.Cache.Resume),
+	}, nil
+}
+
+// Merge returns the settings with any non-empty
+// locations in override taking precedence.
+func (s CacheSettings) Merge(override CacheSettings) CacheSettings {
+	if override.Root != "" {
+		s.Root = override.Root
+	}
+	if override.Images != "" {
+		s.Images = override.Images
+	}
+	if override.Resume != "" {
+		s.Resume = override.Resume
+	}
+	return s
+}
+
+// ImageDir returns the directory of the image cache.
+func (s CacheSettings) ImageDir() string {
+	if s.Images != "" {
+		return s.Images
+	}
+	return filepath.Join(s.Root, "images")
+}
+
+// ResumeFile returns the path of the resume file.
+func (s CacheSettings) ResumeFile() string {
+	if s.Resume != "" {
+		return s.Resume
+	}
+	return filepath.Join(s.Root, "resume.json")
+}
+
+// CacheConfiguration returns the cache configuration
+// using the configured locations.
+func (s CacheSettings) CacheConfiguration() CacheConfiguration {
+	return CacheConfiguration{
+		ImageCache: NewImageCache(s.ImageDir()),
+	}
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+)
+
+// cacheInputsDir is the image cache directory storing the
+// inputs of the last build for each base image configuration.
+const cacheInputsDir = "inputs"
+
+// baseImageInputs returns the inputs of the base image build
+// cache digest as labelled lines, one per input, used to
+// explain why a cached image could not be used.
+func baseImageInputs(baseImageID string, tags []tag, envs []string, platform Platform, pinned bool) []string {
+	inputs := []string{
+		"version " + hashVersion,
+		"base " + baseImageID,
+	}
+	if !platform.IsZero() {
+		inputs = append(inputs, "platform "+platform.String())
+	}
+	if pinned {
+		inputs = append(inputs, "pinned true")
+	}
+
+	var images []string
+	for _, t := range tags {
+		images = append(images, fmt.Sprintf("image %s %s", t.Tag, t.Image))
+	}
+	sort.Strings(images)
+	inputs = append(inputs, images...)
+
+	var envInputs []string
+	for _, e := range envs {
+		envInputs = append(envInputs, "env "+e)
+	}
+	sort.Strings(envInputs)
+
+	return append(inputs, envInputs...)
+}
+
+// baseImageInputsKey returns the key the build inputs of a base
+// image configuration are stored under. The key only depends on
+// the image references so that changes to the images behind the
+// references can be reported.
+func baseImageInputsKey(conf BaseImageConfiguration) digest.Digest {
+	refs := []string{"base " + conf.Base.String()}
+	for _, ref := range conf.ExtraImages {
+		refs = append(refs, "image "+ref.String())
+	}
+	for _, ci := range conf.CustomImages {
+		refs = append(refs, fmt.Sprintf("custom %s %s", ci.Target, ci.Source))
+	}
+	sort.Strings(refs)
+	return digest.FromBytes([]byte(strings.Join(refs, "\n")))
+}
+
+// diffInputs returns the inputs removed from old, prefixed by
+// "-", followed by the inputs added in new, prefixed by "+".
+func diffInputs(old, new []string) []string {
+	oldSet := map[string]struct{}{}
+	for _, line := range old {
+		oldSet[line] = struct{}{}
+	}
+	newSet := map[string]struct{}{}
+	for _, line := range new {
+		newSet[line] = struct{}{}
+	}
+
+	var diff []string
+	for _, line := range old {
+		if _, ok := newSet[line]; !ok {
+			diff = append(diff, "- "+line)
+		}
+	}
+	for _, line := range new {
+		if _, ok := oldSet[line]; !ok {
+			diff = append(diff, "+ "+line)
+		}
+	}
+	return diff
+}
+
+// logInputChanges logs the build inputs which differ from the
+// inputs last saved for the key, explaining a cache miss.
+func logInputChanges(ic *ImageCache, key digest.Digest, inputs []string) {
+	last, err := ic.GetInputs(key)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Errorf("Unable to read last build inputs: %v", err)
+		}
+		logrus.Debugf("No previous build inputs for %s", key)
+		return
+	}
+	diff := diffInputs(last, inputs)
+	if len(diff) == 0 {
+		logrus.Infof("Build inputs unchanged since last build, cached image is missing")
+		return
+	}
+	for _, line := range diff {
+		logrus.Infof("Build input changed since last build: %s", line)
+	}
+}
+
+func (ic *ImageCache) inputsFile(key digest.Digest) string {
+	return filepath.Join(ic.root, cacheInputsDir, key.Algorithm().String(), key.Hex())
+}
+
+// GetInputs gets the build inputs last saved for the key,
+// returning an error satisfying os.IsNotExist if none are saved.
+func (ic *ImageCache) GetInputs(key digest.Digest) ([]string, error) {
+	b, err := ioutil.ReadFile(ic.inputsFile(key))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n"), nil
+}
+
+// SaveInputs saves the build inputs for the key, replacing
+// any previously saved inputs.
+func (ic *ImageCache) SaveInputs(key digest.Digest, inputs []string) error {
+	fp := ic.inputsFile(key)
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fp, []byte(strings.Join(inputs, "\n")+"\n"), 0644)
+}
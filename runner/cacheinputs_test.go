@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/distribution/digest"
+)
+
+func TestDiffBaseImageInputs(t *testing.T) {
+	tags := []tag{
+		{Tag: assertTagged("golem-registry:latest"), Image: "sha256:registry"},
+		{Tag: assertTagged("nginx:1.9"), Image: "sha256:nginx"},
+	}
+	envs := []string{"GOLEM_REGISTRY_VERSION 2.3.0"}
+	last := baseImageInputs("sha256:base", tags, envs, Platform{}, false)
+
+	if diff := diffInputs(last, baseImageInputs("sha256:base", tags, envs, Platform{}, false)); len(diff) != 0 {
+		t.Fatalf("Unexpected diff for unchanged inputs %v", diff)
+	}
+
+	// Changed environment variable
+	diff := diffInputs(last, baseImageInputs("sha256:base", tags, []string{"GOLEM_REGISTRY_VERSION 2.4.0"}, Platform{}, false))
+	expected := []string{
+		"- env GOLEM_REGISTRY_VERSION 2.3.0",
+		"+ env GOLEM_REGISTRY_VERSION 2.4.0",
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("Unexpected env diff\n\tExpected: %v\n\tActual: %v", expected, diff)
+	}
+
+	// Changed image behind the same tag
+	changed := []tag{tags[0], {Tag: assertTagged("nginx:1.9"), Image: "sha256:nginx2"}}
+	diff = diffInputs(last, baseImageInputs("sha256:base", changed, envs, Platform{}, false))
+	expected = []string{
+		"- image nginx:1.9 sha256:nginx",
+		"+ image nginx:1.9 sha256:nginx2",
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("Unexpected image diff\n\tExpected: %v\n\tActual: %v", expected, diff)
+	}
+}
+
+func TestImageCacheInputs(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	conf := BaseImageConfiguration{
+		Base:         assertTagged("distribution/golem-runner:0.1-bats"),
+		CustomImages: []CustomImage{mustImage("registry:2.3.0", "golem-registry:latest", "2.3.0")},
+	}
+	key := baseImageInputsKey(conf)
+
+	// The key does not depend on versions or platform
+	other := conf
+	other.Platform = Platform{OS: "linux", Architecture: "arm64"}
+	if baseImageInputsKey(other) != key {
+		t.Fatal("Expected inputs key to only depend on image references")
+	}
+
+	ic := NewImageCache(td)
+	if _, err := ic.GetInputs(key); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist error, got %v", err)
+	}
+
+	inputs := []string{"version " + hashVersion, "base sha256:base", "env GOLEM_REGISTRY_VERSION 2.3.0"}
+	if err := ic.SaveInputs(key, inputs); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ic.GetInputs(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, inputs) {
+		t.Fatalf("Unexpected saved inputs %v", saved)
+	}
+
+	// Saved inputs are not image cache entries
+	entries, err := ic.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Unexpected cache entries %v", entries)
+	}
+}
+
+func TestRequireCache(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	ic := NewImageCache(td)
+	hit := baseImageDigest(baseImageInputs("sha256:base", nil, []string{"GOLEM_REGISTRY_VERSION 2.3.0"}, Platform{}, false))
+	miss := baseImageDigest(baseImageInputs("sha256:base", nil, []string{"GOLEM_REGISTRY_VERSION 2.4.0"}, Platform{}, false))
+	removed := baseImageDigest(baseImageInputs("sha256:removed", nil, nil, Platform{}, false))
+	if err := ic.SaveImage(hit, "sha256:cached"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ic.SaveImage(removed, "sha256:removed"); err != nil {
+		t.Fatal(err)
+	}
+	f := fakeImageInspector{
+		"sha256:cached": {ID: "sha256:cached"},
+	}
+	key := baseImageInputsKey(BaseImageConfiguration{Base: assertTagged("golang:1.6")})
+
+	r := &runner{
+		config: RunnerConfiguration{RequireCache: true},
+		cache:  CacheConfiguration{ImageCache: ic},
+	}
+	id, err := r.cachedBaseImage(context.Background(), f, hit, key, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error on cache hit: %v", err)
+	}
+	if id != "sha256:cached" {
+		t.Fatalf("Unexpected cached image %q", id)
+	}
+
+	for _, dgst := range []digest.Digest{miss, removed} {
+		_, err := r.cachedBaseImage(context.Background(), f, dgst, key, nil)
+		if err == nil {
+			t.Fatalf("Expected error for required cache miss %s", dgst)
+		}
+		if !strings.Contains(err.Error(), dgst.String()) {
+			t.Fatalf("Expected error to name cache key %s: %v", dgst, err)
+		}
+	}
+
+	// Without requiring the cache a miss builds the image
+	r.config.RequireCache = false
+	id, err = r.cachedBaseImage(context.Background(), f, miss, key, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error on cache miss: %v", err)
+	}
+	if id != "" {
+		t.Fatalf("Unexpected image %q for cache miss", id)
+	}
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+)
+
+// CleanupPolicy determines whether the containers and
+// volumes of a test instance are removed after it runs.
+type CleanupPolicy string
+
+const (
+	// CleanupNever keeps all containers and volumes
+	CleanupNever CleanupPolicy = "never"
+
+	// CleanupAlways removes containers and volumes
+	// whether the tests passed or failed
+	CleanupAlways CleanupPolicy = "always"
+
+	// CleanupOnSuccess removes containers and volumes
+	// only when the tests passed, keeping them for
+	// debugging failures
+	CleanupOnSuccess CleanupPolicy = "on-success"
+
+	// CleanupOnFailure removes containers and volumes
+	// only when the tests failed
+	CleanupOnFailure CleanupPolicy = "on-failure"
+)
+
+func (p *CleanupPolicy) String() string {
+	return string(*p)
+}
+
+// Set sets the cleanup policy from a string, allowing
+// the policy to be used as a flag value.
+func (p *CleanupPolicy) Set(s string) error {
+	switch policy := CleanupPolicy(s); policy {
+	case CleanupNever, CleanupAlways, CleanupOnSuccess, CleanupOnFailure:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("invalid cleanup policy %q, must be one of never, always, on-success or on-failure", s)
+}
+
+// shouldRemove returns whether resources should be removed
+// for a run with the given result. An empty policy is
+// treated as never.
+func (p CleanupPolicy) shouldRemove(passed bool) bool {
+	switch p {
+	case CleanupAlways:
+		return true
+	case CleanupOnSuccess:
+		return passed
+	case CleanupOnFailure:
+		return !passed
+	}
+	return false
+}
+
+// instanceCleaner is the subset of the docker client used
+// to remove the container and volume of a test instance.
+type instanceCleaner interface {
+	containerRemover
+	VolumeRemove(ctx context.Context, volumeID string) error
+}
+
+// cleanupInstance removes the container and graph volume of a
+// test instance when required by the cleanup policy. An empty
+// volume name only removes the container.
+func cleanupInstance(ctx context.Context, cli instanceCleaner, policy CleanupPolicy, containerID, volumeName string, timeout time.Duration, passed bool) error {
+	if !policy.shouldRemove(passed) {
+		logrus.Debugf("Keeping container %s with cleanup policy %s", containerID, policy)
+		return nil
+	}
+
+	removeOptions := types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+	}
+	if err := removeContainer(ctx, cli, containerID, timeout, removeOptions); err != nil {
+		return fmt.Errorf("error removing container %s: %v", containerID, err)
+	}
+	if volumeName != "" {
+		if err := cli.VolumeRemove(ctx, volumeName); err != nil {
+			return fmt.Errorf("error removing volume %s: %v", volumeName, err)
+		}
+	}
+	return nil
+}
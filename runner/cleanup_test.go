@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCleanupPolicy(t *testing.T) {
+	cases := []struct {
+		policy  CleanupPolicy
+		passed  bool
+		removed bool
+	}{
+		{"", true, false},
+		{"", false, false},
+		{CleanupNever, true, false},
+		{CleanupNever, false, false},
+		{CleanupAlways, true, true},
+		{CleanupAlways, false, true},
+		{CleanupOnSuccess, true, true},
+		{CleanupOnSuccess, false, false},
+		{CleanupOnFailure, true, false},
+		{CleanupOnFailure, false, true},
+	}
+	for _, c := range cases {
+		f := &fakeOrphanRemover{}
+		if err := cleanupInstance(context.Background(), f, c.policy, "c1", "golem-c1-graph", 0, c.passed); err != nil {
+			t.Fatal(err)
+		}
+		if !c.removed {
+			if len(f.removedContainers) != 0 || len(f.removedVolumes) != 0 {
+				t.Fatalf("Unexpected removal with policy %q passed=%t: %v %v", c.policy, c.passed, f.removedContainers, f.removedVolumes)
+			}
+			continue
+		}
+		if len(f.removedContainers) != 1 || f.removedContainers[0] != "c1" {
+			t.Fatalf("Expected container removal with policy %q passed=%t: %v", c.policy, c.passed, f.removedContainers)
+		}
+		if len(f.removedVolumes) != 1 || f.removedVolumes[0] != "golem-c1-graph" {
+			t.Fatalf("Expected volume removal with policy %q passed=%t: %v", c.policy, c.passed, f.removedVolumes)
+		}
+	}
+
+	// No graph volume when not running docker in docker
+	f := &fakeOrphanRemover{}
+	if err := cleanupInstance(context.Background(), f, CleanupAlways, "c1", "", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.removedContainers) != 1 || len(f.removedVolumes) != 0 {
+		t.Fatalf("Unexpected removal without volume: %v %v", f.removedContainers, f.removedVolumes)
+	}
+
+	var p CleanupPolicy
+	if err := p.Set("on-success"); err != nil || p != CleanupOnSuccess {
+		t.Fatalf("Unexpected policy %q: %v", p, err)
+	}
+	if err := p.Set("sometimes"); err == nil {
+		t.Fatal("Expected error setting invalid policy")
+	}
+}
@@ -2,13 +2,18 @@ package runner
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"sync"
 
 	"golang.org/x/net/context"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/engine-api/client"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/versions"
 	"github.com/docker/golem/clientutil"
 	"github.com/docker/golem/versionutil"
 	"github.com/jlhawn/dockramp/build"
@@ -34,7 +39,7 @@ func newDockerClient(co *clientutil.ClientOptions) (DockerClient, error) {
 		}
 	}
 
-	apiClient, err := client.NewClient(host, os.Getenv("DOCKER_API_VERSION"), httpClient, nil)
+	apiClient, err := newAPIClient(host, os.Getenv("DOCKER_API_VERSION"), httpClient)
 	if err != nil {
 		return DockerClient{}, err
 	}
@@ -45,19 +50,170 @@ func newDockerClient(co *clientutil.ClientOptions) (DockerClient, error) {
 	}, nil
 }
 
-// NewBuilder creates a new docker builder using the given client
-func (dc DockerClient) NewBuilder(contextDirectory, dockerfilePath, repoTag string) (*build.Builder, error) {
+// newAPIClient creates an engine-api client for the host, negotiating
+// the API version with the daemon when no version is given.
+func newAPIClient(host, version string, httpClient *http.Client) (*client.Client, error) {
+	apiClient, err := client.NewClient(host, version, httpClient, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == "" {
+		if err := negotiateAPIVersion(context.Background(), apiClient); err != nil {
+			logrus.Warnf("Unable to negotiate API version, using daemon default: %v", err)
+		}
+	}
+
+	return apiClient, nil
+}
+
+// builderStdoutL serializes replacing os.Stdout while dockramp
+// builders are created, a builder writes build progress to the
+// os.Stdout set when it is created.
+var builderStdoutL sync.Mutex
+
+// Builder is a docker builder with its build progress
+// copied to an output writer.
+type Builder struct {
+	*build.Builder
+	w    *os.File
+	out  *detachableWriter
+	done chan struct{}
+}
+
+// Run runs the build, returning once the build progress
+// has been copied to the output.
+func (b *Builder) Run() error {
+	err := b.Builder.Run()
+	b.w.Close()
+	<-b.done
+	return err
+}
+
+// Detach discards any further build progress. An abandoned
+// build keeps running and must not write to the output after
+// the caller has moved on.
+func (b *Builder) Detach() {
+	b.out.detach()
+}
+
+// detachableWriter is a writer which discards all writes
+// once detached.
+type detachableWriter struct {
+	l sync.Mutex
+	w io.Writer
+}
+
+func (dw *detachableWriter) Write(p []byte) (int, error) {
+	dw.l.Lock()
+	defer dw.l.Unlock()
+	return dw.w.Write(p)
+}
+
+func (dw *detachableWriter) detach() {
+	dw.l.Lock()
+	dw.w = ioutil.Discard
+	dw.l.Unlock()
+}
+
+// NewBuilder creates a new docker builder using the given client,
+// writing build progress to out.
+func (dc DockerClient) NewBuilder(contextDirectory, dockerfilePath, repoTag string, out io.Writer) (*Builder, error) {
 	if dc.options == nil {
 		return nil, fmt.Errorf("missing client options, cannot create builder")
 	}
-	return build.NewBuilder(dc.options.DaemonURL(), dc.options.TLSConfig(), contextDirectory, dockerfilePath, repoTag)
+	return newOutputBuilder(out, func() (*build.Builder, error) {
+		return build.NewBuilder(dc.options.DaemonURL(), dc.options.TLSConfig(), contextDirectory, dockerfilePath, repoTag)
+	})
+}
+
+// newOutputBuilder creates a builder with os.Stdout replaced by
+// a pipe, copying the build progress written to the pipe to out.
+func newOutputBuilder(out io.Writer, newBuilder func() (*build.Builder, error)) (*Builder, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating build output pipe: %v", err)
+	}
+
+	builderStdoutL.Lock()
+	stdout := os.Stdout
+	os.Stdout = pw
+	builder, err := newBuilder()
+	os.Stdout = stdout
+	builderStdoutL.Unlock()
+	if err != nil {
+		pr.Close()
+		pw.Close()
+		return nil, err
+	}
+
+	dw := &detachableWriter{w: out}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer pr.Close()
+		if _, err := io.Copy(dw, pr); err != nil {
+			logrus.Errorf("Error copying build output: %v", err)
+			io.Copy(ioutil.Discard, pr)
+		}
+	}()
+
+	return &Builder{
+		Builder: builder,
+		w:       pw,
+		out:     dw,
+		done:    done,
+	}, nil
+}
+
+// serverVersioner is the subset of the docker client
+// used to get the server version.
+type serverVersioner interface {
+	ServerVersion(ctx context.Context) (types.Version, error)
+}
+
+// versionNegotiator is the subset of the docker client
+// used to negotiate the API version with the server.
+type versionNegotiator interface {
+	serverVersioner
+	UpdateClientVersion(v string)
+}
+
+// maxAPIVersion is the latest API version implemented by the
+// vendored engine-api client.
+const maxAPIVersion = "1.24"
+
+// negotiateAPIVersion pins the client to the API version reported
+// by the connected daemon, capped at the latest version the client
+// implements, so that later requests do not fail with a client too
+// new or too old error.
+func negotiateAPIVersion(ctx context.Context, cli versionNegotiator) error {
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting version: %v", err)
+	}
+	if v.APIVersion == "" {
+		return fmt.Errorf("server did not report an API version")
+	}
+
+	version := v.APIVersion
+	if versions.GreaterThan(version, maxAPIVersion) {
+		version = maxAPIVersion
+	}
+	cli.UpdateClientVersion(version)
+	logrus.Debugf("Negotiated API version %s with server API version %s", version, v.APIVersion)
+
+	return nil
+}
+
+// CheckServerVersion checks that the server version satisfies
+// the provided constraint, throws an error if not
+func (dc DockerClient) CheckServerVersion(constraint versionutil.VersionConstraint) error {
+	return checkServerVersion(context.Background(), dc, constraint)
 }
 
-// CheckServerVersion checks that the server version is atleast
-// the provided version, throws an error if not
-func (dc DockerClient) CheckServerVersion(version versionutil.Version) error {
-	ctx := context.Background()
-	v, err := dc.ServerVersion(ctx)
+func checkServerVersion(ctx context.Context, cli serverVersioner, constraint versionutil.VersionConstraint) error {
+	v, err := cli.ServerVersion(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting version: %v", err)
 	}
@@ -67,8 +223,8 @@ func (dc DockerClient) CheckServerVersion(version versionutil.Version) error {
 		return fmt.Errorf("error parsing version %s: %v", v.Version, err)
 	}
 
-	if serverVersion.LessThan(version) {
-		return fmt.Errorf("unsupported Docker version %s, golem requires running on at least %s", serverVersion, version)
+	if !constraint.Matches(serverVersion) {
+		return fmt.Errorf("unsupported Docker version %s, golem requires running on %s", serverVersion, constraint)
 	}
 
 	logrus.Debugf("Client connected to server with version %s", serverVersion)
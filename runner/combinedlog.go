@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// CombinedLog is a log forwarder writing the lines of every
+// forwarded stream to a single writer in the order they are
+// read, each line prefixed by a timestamp and the stream name.
+type CombinedLog struct {
+	// l guards writes to w
+	l   sync.Mutex
+	w   io.Writer
+	now func() time.Time
+
+	streamsL sync.Mutex
+	streams  map[string]io.ReadCloser
+	closed   bool
+	wg       sync.WaitGroup
+	closer   io.Closer
+}
+
+// NewCombinedLog creates a combined log writing to w
+func NewCombinedLog(w io.Writer) *CombinedLog {
+	return &CombinedLog{
+		w:       w,
+		now:     time.Now,
+		streams: map[string]io.ReadCloser{},
+	}
+}
+
+// StartForward starts copying the lines of the named stream
+// to the combined log.
+func (cl *CombinedLog) StartForward(name string, r io.ReadCloser) error {
+	cl.streamsL.Lock()
+	defer cl.streamsL.Unlock()
+	if cl.closed {
+		r.Close()
+		return fmt.Errorf("combined log closed, not forwarding %s", name)
+	}
+	if _, ok := cl.streams[name]; ok {
+		return fmt.Errorf("already forwarding %s", name)
+	}
+	cl.streams[name] = r
+
+	cl.wg.Add(1)
+	go func() {
+		defer cl.wg.Done()
+		cl.copyLines(name, r)
+	}()
+	return nil
+}
+
+// StopForward stops copying the named stream, lines already
+// written to the stream are still copied.
+func (cl *CombinedLog) StopForward(name string) error {
+	cl.streamsL.Lock()
+	r, ok := cl.streams[name]
+	delete(cl.streams, name)
+	cl.streamsL.Unlock()
+	if !ok {
+		return nil
+	}
+	return r.Close()
+}
+
+func (cl *CombinedLog) copyLines(name string, r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = line + "\n"
+			}
+			cl.writeLine(name, line)
+		}
+		if err != nil {
+			if err != io.EOF {
+				logrus.Errorf("Error reading %s for combined log: %v", name, err)
+			}
+			return
+		}
+	}
+}
+
+func (cl *CombinedLog) writeLine(name, line string) {
+	cl.l.Lock()
+	defer cl.l.Unlock()
+	if _, err := fmt.Fprintf(cl.w, "%s [%s] %s", cl.now().UTC().Format(time.RFC3339Nano), name, line); err != nil {
+		logrus.Debugf("Error writing combined log: %v", err)
+	}
+}
+
+// Close stops all forwarded streams, waiting for their lines
+// to be copied before closing the underlying file if any.
+// Closing a nil combined log does nothing.
+func (cl *CombinedLog) Close() error {
+	if cl == nil {
+		return nil
+	}
+	cl.streamsL.Lock()
+	cl.closed = true
+	names := make([]string, 0, len(cl.streams))
+	for name := range cl.streams {
+		names = append(names, name)
+	}
+	cl.streamsL.Unlock()
+	for _, name := range names {
+		if err := cl.StopForward(name); err != nil {
+			logrus.Debugf("Error stopping combined log stream %s: %v", name, err)
+		}
+	}
+	cl.wg.Wait()
+
+	if cl.closer != nil {
+		return cl.closer.Close()
+	}
+	return nil
+}
+
+// AddCombinedLog creates a combined log file with the given name
+// in the namespaced log directory, forwarding all log streams of
+// the router to it. The combined log should be closed after the
+// streams are done being written.
+func (lr *LogRouter) AddCombinedLog(name string) (*CombinedLog, error) {
+	if lr.logDir == "" {
+		return nil, errors.New("combined log requires a log directory")
+	}
+	fp := filepath.Join(lr.logDir, filepath.FromSlash(lr.streamName(name)))
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	cl := NewCombinedLog(f)
+	cl.closer = f
+	if err := lr.AddForwarder(cl); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cl, nil
+}
@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForContent polls the content until it contains s
+func waitForContent(t *testing.T, content func() string, s string) {
+	for i := 0; i < 500; i++ {
+		if strings.Contains(content(), s) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %q in %q", s, content())
+}
+
+func TestCombinedLogInterleaved(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	cl := NewCombinedLog(buf)
+	cl.now = func() time.Time {
+		return time.Date(2016, 8, 1, 12, 0, 0, 0, time.UTC)
+	}
+	content := func() string {
+		cl.l.Lock()
+		defer cl.l.Unlock()
+		return buf.String()
+	}
+
+	ra, wa := io.Pipe()
+	rb, wb := io.Pipe()
+	if err := cl.StartForward("daemon-stdout", ra); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.StartForward("test-stderr", rb); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.StartForward("test-stderr", rb); err == nil {
+		t.Fatal("Expected error forwarding stream twice")
+	}
+
+	assertWrite(t, wa, "daemon started")
+	waitForContent(t, content, "daemon started")
+	assertWrite(t, wb, "test failed")
+	waitForContent(t, content, "test failed")
+	if _, err := wa.Write([]byte("daemon stopped")); err != nil {
+		t.Fatal(err)
+	}
+	wa.Close()
+	waitForContent(t, content, "daemon stopped")
+
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "2016-08-01T12:00:00Z [daemon-stdout] daemon started\n" +
+		"2016-08-01T12:00:00Z [test-stderr] test failed\n" +
+		"2016-08-01T12:00:00Z [daemon-stdout] daemon stopped\n"
+	if out := buf.String(); out != expected {
+		t.Fatalf("Unexpected combined log\n\tExpected:\n%s\n\tActual:\n%s", expected, out)
+	}
+}
+
+func TestLogRouterCombinedLog(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "registry-1")
+	daemon, err := lr.RouteLogCapturer("daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test, err := lr.RouteLogCapturer("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := lr.AddCombinedLog("combined.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Creating a stream waits for the router to finish
+	// forwarding the existing streams
+	if _, err := lr.RouteLogCapturer("barrier"); err != nil {
+		t.Fatal(err)
+	}
+
+	fp := filepath.Join(td, "registry-1", "combined.log")
+	content := func() string {
+		b, _ := ioutil.ReadFile(fp)
+		return string(b)
+	}
+	assertWrite(t, daemon.Stdout(), "daemon started")
+	waitForContent(t, content, "daemon started")
+	assertWrite(t, test.Stderr(), "test failed")
+	waitForContent(t, content, "test failed")
+
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := content()
+	first := strings.Index(out, "[registry-1/daemon-stdout] daemon started\n")
+	second := strings.Index(out, "[registry-1/test-stderr] test failed\n")
+	if first < 0 || second < first {
+		t.Fatalf("Unexpected combined log %q", out)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(td, "registry-1", "daemon-stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "daemon started\n" {
+		t.Fatalf("Unexpected stream log %q", b)
+	}
+
+	if _, err := NewLogRouter("", "").AddCombinedLog("combined.log"); err == nil {
+		t.Fatal("Expected error without log directory")
+	}
+}
@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/golem/retryutil"
+	"golang.org/x/net/context"
+)
+
+// composeErrorLines is the number of output lines of a failed
+// compose command included in the returned error.
+const composeErrorLines = 20
+
+// tailWriter keeps the last lines written to it.
+type tailWriter struct {
+	l     sync.Mutex
+	lines int
+	buf   []byte
+}
+
+func newTailWriter(lines int) *tailWriter {
+	return &tailWriter{
+		lines: lines,
+	}
+}
+
+func (tw *tailWriter) Write(b []byte) (int, error) {
+	tw.l.Lock()
+	defer tw.l.Unlock()
+
+	tw.buf = append(tw.buf, b...)
+
+	// Drop complete lines beyond the limit, ignoring a trailing newline
+	content := bytes.TrimSuffix(tw.buf, []byte("\n"))
+	if n := bytes.Count(content, []byte("\n")); n >= tw.lines {
+		idx := 0
+		for i := 0; i <= n-tw.lines; i++ {
+			idx += bytes.IndexByte(tw.buf[idx:], '\n') + 1
+		}
+		tw.buf = append([]byte{}, tw.buf[idx:]...)
+	}
+
+	return len(b), nil
+}
+
+// Tail returns the last lines written.
+func (tw *tailWriter) Tail() string {
+	tw.l.Lock()
+	defer tw.l.Unlock()
+	return strings.TrimSuffix(string(tw.buf), "\n")
+}
+
+// tailCapturer is a log capturer which writes to the
+// wrapped capturer while keeping the combined tail of
+// stdout and stderr.
+type tailCapturer struct {
+	LogCapturer
+	tail *tailWriter
+}
+
+func (tc tailCapturer) Stdout() io.Writer {
+	return io.MultiWriter(tc.LogCapturer.Stdout(), tc.tail)
+}
+
+func (tc tailCapturer) Stderr() io.Writer {
+	return io.MultiWriter(tc.LogCapturer.Stderr(), tc.tail)
+}
+
+// runComposeScript runs a docker compose command capturing
+// output to the log capturer. When the command fails, the
+// tail of its combined output is included in the error.
+func runComposeScript(lc LogCapturer, script Script) error {
+	tail := newTailWriter(composeErrorLines)
+	if err := RunScript(tailCapturer{LogCapturer: lc, tail: tail}, script); err != nil {
+		if output := tail.Tail(); output != "" {
+			return fmt.Errorf("%v, output:\n%s", err, output)
+		}
+		return err
+	}
+	return nil
+}
+
+// composeRetryPolicy is the backoff between attempts of a
+// retried compose command, the attempts are configured
+// per suite.
+var composeRetryPolicy = retryutil.Policy{
+	InitialBackoff: 2 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+}
+
+// composeScript returns the script running docker compose
+// with the given arguments for the compose file.
+func composeScript(composeFile string, args ...string) Script {
+	return Script{
+		Command: append([]string{"docker-compose", "-f", composeFile}, args...),
+		Env:     os.Environ(),
+	}
+}
+
+// runComposeRetry runs a docker compose command, retrying a failed
+// command up to the given number of retries with the compose
+// services taken down between attempts. The compose file is
+// validated first when retrying so configuration errors fail
+// without being retried.
+func runComposeRetry(ctx context.Context, lc LogCapturer, composeFile string, retries int, script Script) error {
+	if retries <= 0 {
+		return runComposeScript(lc, script)
+	}
+	if err := runComposeScript(lc, composeScript(composeFile, "config", "-q")); err != nil {
+		return fmt.Errorf("invalid compose file: %v", err)
+	}
+
+	policy := composeRetryPolicy
+	policy.MaxAttempts = retries + 1
+	var attempt int
+	return retryutil.Do(ctx, policy, func(ctx context.Context) error {
+		attempt++
+		if attempt > 1 {
+			if err := runComposeScript(lc, composeScript(composeFile, "down", "-v")); err != nil {
+				logrus.Errorf("Error taking down compose services before retry: %v", err)
+			}
+		}
+		err := runComposeScript(lc, script)
+		if err != nil && attempt <= retries {
+			logrus.Warnf("Compose attempt %d of %d failed, retrying: %v", attempt, retries+1, err)
+		}
+		return err
+	})
+}
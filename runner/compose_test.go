@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/golem/retryutil"
+	"golang.org/x/net/context"
+)
+
+func TestTailWriter(t *testing.T) {
+	tw := newTailWriter(3)
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(tw, "line %d\n", i)
+	}
+	if tail := tw.Tail(); tail != "line 3\nline 4\nline 5" {
+		t.Fatalf("Unexpected tail %q", tail)
+	}
+
+	// Partial lines are kept until complete
+	fmt.Fprint(tw, "line")
+	fmt.Fprint(tw, " 6\n")
+	if tail := tw.Tail(); tail != "line 4\nline 5\nline 6" {
+		t.Fatalf("Unexpected tail %q", tail)
+	}
+}
+
+func TestRunComposeScriptError(t *testing.T) {
+	lc := newBufferLogger()
+	script := Script{
+		Command: []string{"/bin/sh", "-c", "echo building; echo 'service db failed to build' >&2; exit 1"},
+	}
+
+	err := runComposeScript(lc, script)
+	if err == nil {
+		t.Fatal("Expected error from failing compose command")
+	}
+	if !strings.Contains(err.Error(), "service db failed to build") {
+		t.Fatalf("Expected output tail in error, got %q", err)
+	}
+	if lc.stdout.String() != "building\n" {
+		t.Fatalf("Unexpected captured stdout %q", lc.stdout.String())
+	}
+	if lc.stderr.String() != "service db failed to build\n" {
+		t.Fatalf("Unexpected captured stderr %q", lc.stderr.String())
+	}
+
+	if err := runComposeScript(lc, Script{Command: []string{"true"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// fakeCompose installs a docker-compose script on the PATH which
+// records its arguments and fails "up" the given number of times.
+func fakeCompose(t *testing.T, dir string, upFailures int) (calls func() []string) {
+	callFile := filepath.Join(dir, "calls")
+	countFile := filepath.Join(dir, "up-count")
+	script := fmt.Sprintf(`#!/bin/sh
+shift 2
+echo "$*" >> %[1]s
+if [ "$1" = "up" ]; then
+	count=$(cat %[2]s 2>/dev/null || echo 0)
+	echo $((count + 1)) > %[2]s
+	if [ "$count" -lt %[3]d ]; then
+		echo "port is already allocated" >&2
+		exit 1
+	fi
+fi
+`, callFile, countFile, upFailures)
+	bin := writeTempFile(t, dir, "docker-compose", script)
+	if err := os.Chmod(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return func() []string {
+		b, err := ioutil.ReadFile(callFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+}
+
+func TestRunComposeRetry(t *testing.T) {
+	defer func(p retryutil.Policy) { composeRetryPolicy = p }(composeRetryPolicy)
+	composeRetryPolicy = retryutil.Policy{InitialBackoff: time.Millisecond}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+
+	td, err := ioutil.TempDir("", "golem-compose-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	transient := filepath.Join(td, "transient")
+	if err := os.Mkdir(transient, 0755); err != nil {
+		t.Fatal(err)
+	}
+	calls := fakeCompose(t, transient, 1)
+	os.Setenv("PATH", transient+":"+os.Getenv("PATH"))
+
+	lc := newBufferLogger()
+	if err := runComposeRetry(context.Background(), lc, "compose.yml", 2, composeScript("compose.yml", "up", "-d")); err != nil {
+		t.Fatalf("Unexpected error after retry: %v", err)
+	}
+	expected := []string{"config -q", "up -d", "down -v", "up -d"}
+	if c := calls(); !reflect.DeepEqual(c, expected) {
+		t.Fatalf("Unexpected compose calls %v, expected %v", c, expected)
+	}
+
+	persistent := filepath.Join(td, "persistent")
+	if err := os.Mkdir(persistent, 0755); err != nil {
+		t.Fatal(err)
+	}
+	calls = fakeCompose(t, persistent, 10)
+	os.Setenv("PATH", persistent+":"+os.Getenv("PATH"))
+
+	err = runComposeRetry(context.Background(), lc, "compose.yml", 2, composeScript("compose.yml", "up", "-d"))
+	if err == nil || !strings.Contains(err.Error(), "port is already allocated") {
+		t.Fatalf("Expected error with output tail after retries, got %v", err)
+	}
+	expected = []string{"config -q", "up -d", "down -v", "up -d", "down -v", "up -d"}
+	if c := calls(); !reflect.DeepEqual(c, expected) {
+		t.Fatalf("Unexpected compose calls %v, expected %v", c, expected)
+	}
+}
@@ -13,8 +13,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/Sirupsen/logrus"
@@ -53,38 +58,62 @@ func (m customImageMap) Set(value string) error {
 	if len(parts) < 2 || len(parts) > 3 {
 		return errors.New("invalid custom image format, expected \"name,reference[,version]\"")
 	}
-	ref, err := reference.Parse(parts[0])
+	if parts[1] == "" {
+		return errors.New("custom image reference must not be empty")
+	}
+
+	var version string
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+
+	ci, err := normalizeCustomImage(parts[0], parts[1], version)
 	if err != nil {
 		return err
 	}
+
+	key := fmt.Sprintf("%s,%s", parts[0], parts[1])
+	m[key] = ci
+
+	return nil
+}
+
+// normalizeCustomImage creates a custom image from the given target, source,
+// and version. The version is resolved from the explicit version if given,
+// then the source image tag, then the target image tag. The source may be
+// empty when the source is expected to be provided at runtime.
+func normalizeCustomImage(target, source, version string) (CustomImage, error) {
+	ref, err := reference.Parse(target)
+	if err != nil {
+		return CustomImage{}, fmt.Errorf("invalid custom image target %q: %v", target, err)
+	}
 	namedTagged, ok := ref.(reference.NamedTagged)
 	if !ok {
-		return fmt.Errorf("reference %s must contain name and tag", ref.String())
+		return CustomImage{}, fmt.Errorf("custom image target %s must contain name and tag", ref.String())
 	}
-	source, err := reference.ParseNamed(parts[1])
-	if err != nil {
-		return err
+
+	if source != "" {
+		sourceRef, err := reference.Parse(source)
+		if err != nil {
+			return CustomImage{}, fmt.Errorf("invalid custom image source %q: %v", source, err)
+		}
+		if version == "" {
+			if tagged, ok := sourceRef.(reference.Tagged); ok {
+				version = tagged.Tag()
+			}
+		}
+		source = sourceRef.String()
 	}
 
-	var version string
-	if len(parts) == 3 {
-		version = parts[2]
-	} else if refTag, ok := source.(reference.Tagged); ok {
-		version = refTag.Tag()
-	} else {
-		// TODO: In this case is it better to leave it blank and use the default
-		// from the configuration file?
+	if version == "" {
 		version = namedTagged.Tag()
 	}
 
-	key := fmt.Sprintf("%s,%s", parts[0], parts[1])
-	m[key] = CustomImage{
-		Source:  source.String(),
+	return CustomImage{
+		Source:  source,
 		Target:  namedTagged,
 		Version: version,
-	}
-
-	return nil
+	}, nil
 }
 
 type configurationVersion versionutil.Version
@@ -116,6 +145,52 @@ type ConfigurationManager struct {
 	clientOptions *clientutil.ClientOptions
 	parallel      bool
 	manager       string
+	stopTimeout   time.Duration
+	pullTimeout   time.Duration
+	pullVerbosity PullVerbosity
+	buildTimeout  time.Duration
+	shell         string
+	command       string
+	console       bool
+	dindBinary    string
+	dindPidFile   string
+	loadProgress  bool
+	daemonEvents  bool
+	daemonWarn    bool
+	daemonWarnErr bool
+	maxTaps       int
+	removeOrphans bool
+	checkPorts    bool
+	killPorts     bool
+	cleanup       CleanupPolicy
+	imageMismatch MismatchPolicy
+	nameTemplate  string
+	refPolicy     ReferencePolicy
+	mirrors       RegistryMirrors
+	coverageDir   string
+	pinDigests    bool
+	requireCache  bool
+	refreshBase   bool
+	prePull       bool
+	pullWorkers   int
+	imageFormat   ImageFormat
+	repeat        int
+	seed          int64
+	failFast      bool
+	maxSuiteBytes ByteSize
+	maxSuiteFiles int
+	logPersist    LogPersistence
+	logStreams    LogStreams
+	mergeStreams  LogStreams
+	printEnv      bool
+	statusAddr    string
+	logFilter     string
+	envMask       EnvMask
+	combinedLog   bool
+	tracer        Tracer
+	traceTests    bool
+	profile       string
+	profiles      string
 }
 
 // NewConfigurationManager creates a new configuration manager
@@ -127,8 +202,60 @@ func NewConfigurationManager(name string) *ConfigurationManager {
 		FlagSet:       flagSet,
 		flagResolver:  newFlagResolver(flagSet),
 		clientOptions: clientutil.NewClientOptions(flagSet),
+		maxSuiteBytes: DefaultMaxSuiteBytes,
+		imageFormat:   ImageFormatDocker,
+		pullVerbosity: PullNormal,
+		imageMismatch: MismatchError,
+		refPolicy:     ReferenceStrict,
 	}
 
+	flagSet.DurationVar(&m.stopTimeout, "stop-timeout", 0, "Time to wait for containers to stop before killing them")
+	flagSet.DurationVar(&m.pullTimeout, "pull-timeout", 0, "Maximum time to wait for an image pull")
+	flagSet.DurationVar(&m.buildTimeout, "build-timeout", 0, "Maximum time to wait for a base or test image build, a timed out build is abandoned and keeps running on the daemon")
+	flagSet.Var(&m.pullVerbosity, "pull-verbosity", "Image pull output: quiet for only a summary line, normal or verbose for progress and a summary line")
+	flagSet.Var(&m.refPolicy, "reference-policy", "How image references are normalized for tags and cache keys: strict to use references as written or docker to normalize as the docker client does")
+	flagSet.StringVar(&m.nameTemplate, "name-template", DefaultNameTemplate, "Template of instance container names and compose project names with {{.RunID}}, {{.Suite}} and {{.Instance}}, names must be valid container names")
+	flagSet.Var(&m.imageMismatch, "image-mismatch", "Action when an instance image was not built from the instance configuration: error or rebuild")
+	flagSet.Var(&m.cleanup, "cleanup", "Policy for removing test containers and volumes after running: never, always, on-success or on-failure")
+	flagSet.Var(&m.mirrors, "registry-mirror", "Registry mirror for the docker daemon in test containers, may be set multiple times")
+	flagSet.StringVar(&m.coverageDir, "coverage-dir", "", "Directory to collect and merge coverage profiles into")
+	flagSet.BoolVar(&m.pinDigests, "pin-digests", false, "Use digest references in base image Dockerfiles and record them in the image")
+	flagSet.Var(&m.maxSuiteBytes, "max-suite-size", "Maximum total size of the files in a suite directory, such as 500m, 0 for no limit")
+	flagSet.IntVar(&m.maxSuiteFiles, "max-suite-files", DefaultMaxSuiteFiles, "Maximum number of files in a suite directory, 0 for no limit")
+	flagSet.Int64Var(&m.seed, "seed", envSeed(), "Random seed set as GOLEM_SEED in test containers for reproducible fixtures, 0 for none")
+	flagSet.IntVar(&m.repeat, "repeat", 1, "Number of times to run each instance, instances not passing every run are reported as flaky")
+	flagSet.BoolVar(&m.failFast, "failfast", false, "Stop repeating an instance after its first failed run")
+	flagSet.BoolVar(&m.requireCache, "require-cache", false, "Fail instead of building base images missing from the image cache")
+	flagSet.BoolVar(&m.prePull, "prepull", false, "Pull the images of all suites concurrently before building any base images")
+	flagSet.IntVar(&m.pullWorkers, "pull-concurrency", 4, "Maximum number of images pulled at the same time when pre-pulling")
+	flagSet.BoolVar(&m.refreshBase, "refresh-base", false, "Resolve base image references again instead of reusing the base image built from an unchanged configuration")
+	flagSet.Var(&m.imageFormat, "image-format", "Format to export images into base images in: docker or oci")
+	flagSet.Var(&m.logPersist, "log-persist", "When to save instance log streams: always, on-failure or never")
+	flagSet.Var(&m.logStreams, "log-streams", "Comma separated instance log streams to save, all streams when unset")
+	flagSet.Var(&m.mergeStreams, "merge-streams", "Comma separated instance log streams saved to a single -output file with stdout and stderr in write order")
+	flagSet.StringVar(&m.logFilter, "log-filter", "", "Shell command instance log streams are piped through before being saved, such as a sed expression scrubbing secrets")
+	flagSet.BoolVar(&m.combinedLog, "combined-log", false, "Also write the lines of all instance log streams to a combined.log")
+	flagSet.BoolVar(&m.printEnv, "print-env", false, "Print the environment of setup and test commands to their log streams before they run")
+	flagSet.StringVar(&m.statusAddr, "status-addr", "", "Address the runner in test containers serves its status and log tails on over HTTP, such as :8080")
+	flagSet.Var(&m.envMask, "env-mask", "Comma separated key patterns of environment values to mask when printing, defaults to "+strings.Join(DefaultEnvMask, ","))
+	flagSet.Var(&m.tracer, "tracer", "Command to run the daemon in test containers under for debugging, such as \"strace -f -o /dev/fd/3\"")
+	flagSet.BoolVar(&m.traceTests, "trace-tests", false, "Also run test runner commands under the tracer")
+	flagSet.StringVar(&m.command, "command", DefaultTestCommand, "Default test command run in test containers for suites without testrunner entries, empty for none")
+	flagSet.BoolVar(&m.console, "console", true, "Dump the test output of test containers to the console")
+	flagSet.StringVar(&m.shell, "shell", "", "Shell to run in instance containers instead of the tests, such as /bin/sh, for debugging built images")
+	flagSet.BoolVar(&m.loadProgress, "load-progress", false, "Show image load progress in the load log stream of each instance")
+	flagSet.BoolVar(&m.daemonEvents, "daemon-events", false, "Capture the events of the docker daemon in dind instances to the events log stream")
+	flagSet.BoolVar(&m.daemonWarn, "daemon-warnings", false, "Check the startup output of the docker daemon in dind instances for warnings")
+	flagSet.BoolVar(&m.daemonWarnErr, "daemon-warnings-fatal", false, "Fail the setup of dind instances when the daemon logs startup warnings, implies -daemon-warnings")
+	flagSet.StringVar(&m.dindBinary, "dind-docker-binary", "", "Docker binary used to start the daemon in dind test containers, defaults to GOLEM_DOCKER_BINARY in the container or docker")
+	flagSet.StringVar(&m.dindPidFile, "dind-daemon-pidfile", "", "Pid file of the daemon in dind test containers, defaults to the daemon config pidfile or "+DefaultDaemonPidFile)
+	flagSet.IntVar(&m.maxTaps, "max-taps", DefaultMaxTaps, "Maximum number of simultaneous taps per log stream in test containers, 0 for no limit")
+	flagSet.BoolVar(&m.removeOrphans, "remove-orphans", false, "Remove containers and volumes left by previous runs")
+	flagSet.BoolVar(&m.checkPorts, "check-ports", false, "Report golem containers still holding host ports after the run")
+	flagSet.BoolVar(&m.killPorts, "kill-leaked-ports", false, "Remove golem containers still holding host ports after the run")
+	flagSet.StringVar(&m.profile, "profile", os.Getenv("GOLEM_PROFILE"), "Profile of flag defaults to apply from the profiles file, such as smoke or nightly")
+	flagSet.StringVar(&m.profiles, "profiles", os.Getenv("GOLEM_PROFILES"), "Profiles file mapping profile names to flag defaults")
+
 	// TODO: Support parallel mode
 	//flag.BoolVar(&m.parallel, "parallel", false, "Whether to run tests in parallel")
 	//flag.StringVar(&m.manager, "manager", "", "Image to use to manage test output")
@@ -136,13 +263,47 @@ func NewConfigurationManager(name string) *ConfigurationManager {
 	return m
 }
 
+// envSeed returns the random seed from GOLEM_SEED,
+// zero when unset or invalid.
+func envSeed() int64 {
+	v := os.Getenv("GOLEM_SEED")
+	if v == "" {
+		return 0
+	}
+	seed, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		logrus.Warnf("Ignoring invalid GOLEM_SEED %q: %v", v, err)
+		return 0
+	}
+	return seed
+}
+
 // ParseFlags parses the command line flags returning any error
-// encountered during parse.
+// encountered during parse. Flags of the selected profile are
+// applied unless set on the command line.
 func (c *ConfigurationManager) ParseFlags(args []string) error {
 	if err := c.FlagSet.Parse(args); err != nil {
 		return err
 	}
 
+	if c.profile != "" {
+		if c.profiles == "" {
+			return fmt.Errorf("profile %s requires a profiles file, set with -profiles or GOLEM_PROFILES", c.profile)
+		}
+		profiles, err := LoadProfiles(c.profiles)
+		if err != nil {
+			return fmt.Errorf("error loading profiles: %v", err)
+		}
+		values, ok := profiles[c.profile]
+		if !ok {
+			return fmt.Errorf("profile %s not found in %s", c.profile, c.profiles)
+		}
+		if err := applyProfile(c.FlagSet, values); err != nil {
+			return fmt.Errorf("error applying profile %s: %v", c.profile, err)
+		}
+		logrus.Debugf("Applied profile %s from %s", c.profile, c.profiles)
+	}
+
 	// TODO: Check for any invalid arguments
 
 	return nil
@@ -164,70 +325,299 @@ func (c *ConfigurationManager) RunnerConfiguration() (RunnerConfiguration, error
 		logrus.Debugf("No configuration given, trying current directory %s", conf)
 	}
 
-	suites, err := parseSuites(suitePaths)
+	suites, hooks, err := parseConfiguration(suitePaths)
 	if err != nil {
 		return RunnerConfiguration{}, err
 	}
 
-	runnerConfig := RunnerConfiguration{
-		ExecutableName: "golem_runner",
-		Parallel:       c.parallel,
-		ManagerImage:   c.manager,
+	if err := ValidateNameTemplate(c.nameTemplate); err != nil {
+		return RunnerConfiguration{}, fmt.Errorf("invalid -name-template: %v", err)
 	}
 
-	for _, suite := range suites {
-		resolver := newMultiResolver(c.flagResolver, suite, globalDefault)
-
-		registrySuite := SuiteConfiguration{
-			Name:           resolver.Name(),
-			Path:           resolver.Path(),
-			DockerInDocker: resolver.Dind(),
+	flagRes := c.refPolicy.resolver(c.flagResolver)
+	flagImages := flagRes.CustomImages()
+	names := suiteNames(suites)
+	var suiteImages []CustomImage
+	for _, name := range names {
+		suite := suites[name]
+		declared := c.refPolicy.customImages(suite.CustomImages())
+		if missing := missingCustomImages(flagImages, declared); len(missing) > 0 {
+			return RunnerConfiguration{}, fmt.Errorf("suite %s requires custom images with no default, set with -i: %s", name, strings.Join(missing, ", "))
 		}
+		suiteImages = append(suiteImages, declared...)
+	}
+	for _, ci := range unusedCustomImages(flagImages, suiteImages) {
+		logrus.Warnf("Custom image %s is not declared by any suite and will not be used", ci.Target)
+	}
 
-		baseConf := BaseImageConfiguration{
-			Base:        resolver.BaseImage(),
-			ExtraImages: resolver.Images(),
-		}
+	maxTaps := c.maxTaps
+	if maxTaps <= 0 {
+		maxTaps = -1
+	}
 
-		runConfig := resolver.RunConfiguration()
-		imageMatrix := expandCustomImageMatrix(resolver.CustomImages())
+	runnerConfig := RunnerConfiguration{
+		ExecutableName:      "golem_runner",
+		Parallel:            c.parallel,
+		ManagerImage:        c.manager,
+		StopTimeout:         c.stopTimeout,
+		PullTimeout:         c.pullTimeout,
+		PullVerbosity:       c.pullVerbosity,
+		BuildTimeout:        c.buildTimeout,
+		Shell:               c.shell,
+		DefaultCommand:      c.command,
+		DisableConsole:      !c.console,
+		LoadProgress:        c.loadProgress,
+		DaemonEvents:        c.daemonEvents,
+		DaemonWarnings:      c.daemonWarn || c.daemonWarnErr,
+		DaemonWarningsFatal: c.daemonWarnErr,
+		DockerBinary:        c.dindBinary,
+		DaemonPidFile:       c.dindPidFile,
+		MaxTaps:             maxTaps,
+		RemoveOrphans:       c.removeOrphans,
+		CheckPorts:          c.checkPorts,
+		KillLeakedPorts:     c.killPorts,
+		Cleanup:             c.cleanup,
+		ImageMismatch:       c.imageMismatch,
+		ReferencePolicy:     c.refPolicy,
+		RegistryMirrors:     c.mirrors,
+		CoverageDir:         c.coverageDir,
+		PinDigests:          c.pinDigests,
+		NameTemplate:        c.nameTemplate,
+		RequireCache:        c.requireCache,
+		RefreshBase:         c.refreshBase,
+		PrePull:             c.prePull,
+		PullConcurrency:     c.pullWorkers,
+		ImageFormat:         c.imageFormat,
+		Repeat:              c.repeat,
+		Seed:                c.seed,
+		FailFast:            c.failFast,
+		LogPersistence:      c.logPersist,
+		LogStreams:          c.logStreams,
+		MergeStreams:        c.mergeStreams,
+		CombinedLog:         c.combinedLog,
+		LogFilter:           c.logFilter,
+		PrintEnv:            c.printEnv,
+		EnvMask:             c.envMask,
+		StatusAddr:          c.statusAddr,
+		Tracer:              c.tracer,
+		TraceTests:          c.traceTests,
+		Hooks:               hooks,
+	}
+	runnerConfig.SuiteSizeLimit = SuiteSizeLimit{
+		MaxBytes: int64(c.maxSuiteBytes),
+		MaxFiles: c.maxSuiteFiles,
+	}
 
-		var multiInstance bool
-		if len(imageMatrix) > 1 {
-			logrus.Debugf("Running %d instance for suite %s", len(imageMatrix), registrySuite.Name)
-			multiInstance = true
+	for _, name := range names {
+		suite := suites[name]
+		suite.archBase = c.refPolicy.archBase(suite.archBase)
+		resolver := newMultiResolver(flagRes, c.refPolicy.resolver(suite), c.refPolicy.resolver(globalDefault))
+		registrySuite, err := resolveConfigurationSuite(resolver, suite, c.command)
+		if err != nil {
+			return RunnerConfiguration{}, err
 		}
+		runnerConfig.Suites = append(runnerConfig.Suites, registrySuite)
+	}
+
+	return runnerConfig, nil
+}
+
+// suiteNames returns the names of the parsed suites in order,
+// suites are resolved in name order so that runs and listings
+// do not depend on map iteration order.
+func suiteNames(suites map[string]*configurationSuite) []string {
+	names := make([]string, 0, len(suites))
+	for name := range suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveConfigurationSuite resolves the configuration of a suite
+// parsed from a configuration file, including the suite options
+// which are not resolved from flags.
+func resolveConfigurationSuite(resolver resolver, suite *configurationSuite, defaultCommand string) (SuiteConfiguration, error) {
+	registrySuite, err := resolveSuite(resolver, suite.config.StorageDriver, defaultCommand)
+	if err != nil {
+		return SuiteConfiguration{}, err
+	}
+	registrySuite.Runtimes = suite.runtimes
+	registrySuite.DefaultRuntime = suite.config.DefaultRuntime
+	registrySuite.DaemonConfig = suite.config.DaemonConfig
+	registrySuite.DaemonConfigMerge = suite.config.DaemonConfigMerge
+	registrySuite.Labels = suite.config.Labels
+	registrySuite.Unprivileged = suite.config.Privileged != nil && !*suite.config.Privileged
+	registrySuite.CapAdd = suite.config.CapAdd
+	registrySuite.CapDrop = suite.config.CapDrop
+	registrySuite.SecurityOpt = suite.config.SecurityOpt
+	registrySuite.Requires = suite.requires
+	for i := range registrySuite.Instances {
+		registrySuite.Instances[i].BaseImage.ArchBase = suite.archBase
+	}
+	if registrySuite.Unprivileged && registrySuite.DockerInDocker {
+		return SuiteConfiguration{}, fmt.Errorf("suite %s disables privileged with dind, which requires privileged", registrySuite.Name)
+	}
+	if (len(registrySuite.Runtimes) > 0 || registrySuite.DefaultRuntime != "") && !registrySuite.DockerInDocker {
+		return SuiteConfiguration{}, fmt.Errorf("suite %s configures runtimes without dind", registrySuite.Name)
+	}
+	if registrySuite.DaemonConfig != "" && !registrySuite.DockerInDocker {
+		return SuiteConfiguration{}, fmt.Errorf("suite %s configures daemon_config without dind", registrySuite.Name)
+	}
+	return registrySuite, nil
+}
+
+// resolveSuite resolves the configuration of a suite from the
+// resolver, expanding custom images into test instances. Suites
+// without testrunner entries run the default command.
+func resolveSuite(resolver resolver, storageDriver, defaultCommand string) (SuiteConfiguration, error) {
+	registrySuite := SuiteConfiguration{
+		Name:           resolver.Name(),
+		Path:           resolver.Path(),
+		DockerInDocker: resolver.Dind(),
+		Mounts:         resolver.Mounts(),
+		StorageDriver:  storageDriver,
+	}
+	if err := validateMounts(registrySuite.Mounts, registrySuite.DockerInDocker); err != nil {
+		return SuiteConfiguration{}, fmt.Errorf("invalid mounts for suite %s: %v", registrySuite.Name, err)
+	}
+
+	baseConf := BaseImageConfiguration{
+		Base:        resolver.BaseImage(),
+		ExtraImages: resolver.Images(),
+		Platform:    resolver.Platform(),
+	}
 
-		if len(imageMatrix) == 0 {
+	runConfig := resolver.RunConfiguration()
+	if err := validateRunConfiguration(registrySuite.Name, runConfig, defaultCommand); err != nil {
+		return SuiteConfiguration{}, err
+	}
+	customImages := resolver.CustomImages()
+	if err := checkCustomImageConflicts(customImages); err != nil {
+		return SuiteConfiguration{}, fmt.Errorf("invalid custom images for suite %s: %v", registrySuite.Name, err)
+	}
+	imageMatrix := expandCustomImageMatrix(customImages)
+
+	var multiInstance bool
+	if len(imageMatrix) > 1 {
+		logrus.Debugf("Running %d instance for suite %s", len(imageMatrix), registrySuite.Name)
+		multiInstance = true
+	}
+
+	// Every image combination is run with every param combination
+	paramMatrix := expandParamMatrix(resolver.Params())
+	if len(paramMatrix) == 0 {
+		paramMatrix = [][]ParamValue{nil}
+	}
+	names := map[string]struct{}{}
+	addInstances := func(name string, imageConf BaseImageConfiguration) error {
+		for _, values := range paramMatrix {
+			instanceName := paramInstanceName(name, values)
+			if _, ok := names[instanceName]; ok {
+				return fmt.Errorf("suite %s has multiple instances named %s, params must differ in letters or digits", registrySuite.Name, instanceName)
+			}
+			names[instanceName] = struct{}{}
 			conf := InstanceConfiguration{
-				Name:             registrySuite.Name,
-				BaseImage:        baseConf,
+				Name:             instanceName,
+				BaseImage:        imageConf,
 				RunConfiguration: runConfig,
 			}
+			conf.InstanceEnv = append(instanceEnv(instanceName, len(registrySuite.Instances)+1, imageConf.CustomImages), paramEnv(values)...)
 			registrySuite.Instances = append(registrySuite.Instances, conf)
-		} else {
-			for idx, customImages := range imageMatrix {
-				name := registrySuite.Name
-				if multiInstance {
-					logrus.Debugf("Instance %d: %v", idx+1, customImages)
-					name = fmt.Sprintf("%s-%d", name, idx+1)
-				}
-				imageConf := baseConf
-				imageConf.CustomImages = customImages
+		}
+		return nil
+	}
 
-				conf := InstanceConfiguration{
-					Name:             name,
-					BaseImage:        imageConf,
-					RunConfiguration: runConfig,
-				}
-				registrySuite.Instances = append(registrySuite.Instances, conf)
+	if len(imageMatrix) == 0 {
+		if err := addInstances(registrySuite.Name, baseConf); err != nil {
+			return SuiteConfiguration{}, err
+		}
+	} else {
+		for idx, customImages := range imageMatrix {
+			name := registrySuite.Name
+			if multiInstance {
+				logrus.Debugf("Instance %d: %v", idx+1, customImages)
+				name = fmt.Sprintf("%s-%d", name, idx+1)
+			}
+			imageConf := baseConf
+			imageConf.CustomImages = customImages
+
+			if err := addInstances(name, imageConf); err != nil {
+				return SuiteConfiguration{}, err
 			}
 		}
+	}
 
-		runnerConfig.Suites = append(runnerConfig.Suites, registrySuite)
+	return registrySuite, nil
+}
+
+// checkCustomImageConflicts returns an error if custom images for
+// the same target and version have different sources, which would
+// be tagged to the target in an unpredictable order. Images for the
+// same target with different versions form the version matrix.
+func checkCustomImageConflicts(images []CustomImage) error {
+	type targetVersion struct {
+		target  string
+		version string
+	}
+	sources := map[targetVersion]string{}
+	for _, img := range images {
+		key := targetVersion{target: img.Target.String(), version: img.Version}
+		source, ok := sources[key]
+		if !ok {
+			sources[key] = img.Source
+			continue
+		}
+		if source != img.Source {
+			return fmt.Errorf("custom image target %s has conflicting sources %s and %s for version %s, set distinct versions to run both", key.target, source, img.Source, key.version)
+		}
 	}
+	return nil
+}
 
-	return runnerConfig, nil
+// missingCustomImages returns the custom image targets declared
+// by a suite without a default image which are not supplied.
+func missingCustomImages(supplied, declared []CustomImage) []string {
+	targets := map[string]struct{}{}
+	for _, ci := range supplied {
+		targets[ci.Target.String()] = struct{}{}
+	}
+	var missing []string
+	for _, ci := range declared {
+		if ci.Source != "" {
+			continue
+		}
+		if _, ok := targets[ci.Target.String()]; !ok {
+			missing = append(missing, ci.Target.String())
+		}
+	}
+	return missing
+}
+
+// unusedCustomImages returns the supplied custom images
+// whose targets are not declared by any suite.
+func unusedCustomImages(supplied, declared []CustomImage) []CustomImage {
+	targets := map[string]struct{}{}
+	for _, ci := range declared {
+		targets[ci.Target.String()] = struct{}{}
+	}
+	var unused []CustomImage
+	for _, ci := range supplied {
+		if _, ok := targets[ci.Target.String()]; !ok {
+			unused = append(unused, ci)
+		}
+	}
+	return unused
+}
+
+// validateRunConfiguration validates the resolved run configuration
+// of a suite, rejecting suites without tests unless a default command
+// is run or running without tests is allowed.
+func validateRunConfiguration(name string, runConfig RunConfiguration, defaultCommand string) error {
+	if len(runConfig.TestRunner) == 0 && defaultCommand == "" && !runConfig.AllowNoTests {
+		return fmt.Errorf("suite %s has no testrunner entries and no default -command, set allow_no_tests to run without tests", name)
+	}
+	return nil
 }
 
 // DockerClient returns a new DockerClient using the parsed configuration
@@ -246,10 +636,14 @@ type resolver interface {
 	Images() []reference.NamedTagged
 	RunConfiguration() RunConfiguration
 	CustomImages() []CustomImage
+	Platform() Platform
+	Mounts() []Mount
+	Params() []MatrixParam
 }
 
 type flagResolver struct {
 	customImages customImageMap
+	platform     Platform
 }
 
 func newFlagResolver(fs *flag.FlagSet) *flagResolver {
@@ -258,6 +652,7 @@ func newFlagResolver(fs *flag.FlagSet) *flagResolver {
 	}
 
 	fs.Var(fr.customImages, "i", "Set a custom image for running tests")
+	fs.Var(&fr.platform, "platform", "Set the platform (os/arch[/variant]) required for test images")
 
 	return fr
 }
@@ -287,13 +682,31 @@ func (fr *flagResolver) RunConfiguration() RunConfiguration {
 }
 
 func (fr *flagResolver) CustomImages() []CustomImage {
+	// Sort by key to keep instance names stable between runs
+	keys := make([]string, 0, len(fr.customImages))
+	for key := range fr.customImages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 	customImages := make([]CustomImage, 0, len(fr.customImages))
-	for _, ci := range fr.customImages {
-		customImages = append(customImages, ci)
+	for _, key := range keys {
+		customImages = append(customImages, fr.customImages[key])
 	}
 	return customImages
 }
 
+func (fr *flagResolver) Platform() Platform {
+	return fr.platform
+}
+
+func (fr *flagResolver) Mounts() []Mount {
+	return nil
+}
+
+func (fr *flagResolver) Params() []MatrixParam {
+	return nil
+}
+
 // defaultResolver is used to inject defaults
 type defaultResolver struct {
 	base reference.NamedTagged
@@ -328,6 +741,18 @@ func (dr defaultResolver) CustomImages() []CustomImage {
 	return nil
 }
 
+func (dr defaultResolver) Platform() Platform {
+	return Platform{}
+}
+
+func (dr defaultResolver) Mounts() []Mount {
+	return nil
+}
+
+func (dr defaultResolver) Params() []MatrixParam {
+	return nil
+}
+
 type multiResolver struct {
 	resolvers []resolver
 }
@@ -398,6 +823,21 @@ func (mr multiResolver) RunConfiguration() RunConfiguration {
 		rc := r.RunConfiguration()
 		runConfig.Setup = append(runConfig.Setup, rc.Setup...)
 		runConfig.TestRunner = append(runConfig.TestRunner, rc.TestRunner...)
+		runConfig.AllowNoTests = runConfig.AllowNoTests || rc.AllowNoTests
+		runConfig.RunAll = runConfig.RunAll || rc.RunAll
+		if rc.Parallel > runConfig.Parallel {
+			runConfig.Parallel = rc.Parallel
+		}
+		if rc.MinTests > runConfig.MinTests {
+			runConfig.MinTests = rc.MinTests
+		}
+		if rc.ComposeRetries > runConfig.ComposeRetries {
+			runConfig.ComposeRetries = rc.ComposeRetries
+		}
+		runConfig.XFail = append(runConfig.XFail, rc.XFail...)
+		runConfig.XFailStrict = runConfig.XFailStrict || rc.XFailStrict
+		runConfig.WaitFor = append(runConfig.WaitFor, rc.WaitFor...)
+		runConfig.Probes = append(runConfig.Probes, rc.Probes...)
 	}
 	return runConfig
 }
@@ -405,10 +845,16 @@ func (mr multiResolver) RunConfiguration() RunConfiguration {
 func (mr multiResolver) CustomImages() []CustomImage {
 	var customImages []CustomImage
 	targets := map[string]struct{}{}
+	// Environment templates are configured with the default
+	// image and apply to all images for the same target
+	targetEnv := map[string][]string{}
 	for _, r := range mr.resolvers {
 		for _, customImage := range r.CustomImages() {
 			if customImage.DefaultOnly {
 				targets[customImage.Target.String()] = struct{}{}
+				if len(customImage.Env) > 0 {
+					targetEnv[customImage.Target.String()] = customImage.Env
+				}
 			}
 			var hasImage bool
 			for i, existingImage := range customImages {
@@ -432,6 +878,9 @@ func (mr multiResolver) CustomImages() []CustomImage {
 	}
 	filtered := make([]CustomImage, 0, len(customImages))
 	for _, customImage := range customImages {
+		if len(customImage.Env) == 0 {
+			customImage.Env = targetEnv[customImage.Target.String()]
+		}
 		if _, ok := targets[customImage.Target.String()]; ok {
 			filtered = append(filtered, customImage)
 		}
@@ -440,6 +889,32 @@ func (mr multiResolver) CustomImages() []CustomImage {
 
 }
 
+func (mr multiResolver) Platform() Platform {
+	// Return first non-empty value
+	for _, r := range mr.resolvers {
+		if platform := r.Platform(); !platform.IsZero() {
+			return platform
+		}
+	}
+	return Platform{}
+}
+
+func (mr multiResolver) Mounts() []Mount {
+	var mounts []Mount
+	for _, r := range mr.resolvers {
+		mounts = append(mounts, r.Mounts()...)
+	}
+	return mounts
+}
+
+func (mr multiResolver) Params() []MatrixParam {
+	var params []MatrixParam
+	for _, r := range mr.resolvers {
+		params = append(params, r.Params()...)
+	}
+	return params
+}
+
 // configurationSuite represents the configuration for
 // an entire test suite. The test suite may have multiple
 // instances
@@ -450,6 +925,14 @@ type configurationSuite struct {
 	base         reference.NamedTagged
 	images       []reference.NamedTagged
 	customImages []CustomImage
+	platform     Platform
+	mounts       []Mount
+	runtimes     []DaemonRuntime
+	waits        []LogWait
+	probes       []ReadinessProbe
+	requires     SuiteRequirements
+	params       []MatrixParam
+	archBase     map[string]reference.NamedTagged
 
 	resolvedName string
 }
@@ -486,58 +969,108 @@ func (cs *configurationSuite) RunConfiguration() RunConfiguration {
 		runConfig.Setup = append(runConfig.Setup, Script{
 			Command: command,
 			Env:     script.Env,
+			EnvFile: cs.envFiles(script.EnvFile),
 		})
 	}
 	for _, script := range cs.config.Runner {
 		// TODO: respect quoted values
 		command := strings.Split(script.Command, " ")
+		format := script.Format
+		if format == "" {
+			format = cs.config.Format
+		}
+		resultsFormat := script.ResultsFormat
+		if script.ResultsFile != "" && resultsFormat == "" {
+			resultsFormat = format
+			format = ""
+		}
+		wrapper := script.Wrapper
+		if wrapper == "" {
+			wrapper = cs.config.Wrapper
+		}
+		wrapperEnv := script.WrapperEnv
+		if wrapperEnv == nil {
+			wrapperEnv = cs.config.WrapperEnv
+		}
+		var wrapperCommand []string
+		if wrapper != "" {
+			// TODO: respect quoted values
+			wrapperCommand = strings.Split(wrapper, " ")
+		}
 		runConfig.TestRunner = append(runConfig.TestRunner, TestScript{
 			Script: Script{
 				Command: command,
 				Env:     script.Env,
+				EnvFile: cs.envFiles(script.EnvFile),
 			},
-			Format: script.Format,
+			Format:       format,
+			Coverage:     script.Coverage,
+			AllowFailure: script.AllowFailure,
+			Wrapper:      wrapperCommand,
+			WrapperEnv:   wrapperEnv,
+			Ordered:      script.Ordered,
+
+			ResultsFile:   script.ResultsFile,
+			ResultsFormat: resultsFormat,
 		})
 	}
 
+	runConfig.AllowNoTests = cs.config.AllowNoTests
+	runConfig.RunAll = cs.config.RunAll
+	runConfig.Parallel = cs.config.Parallel
+	runConfig.MinTests = cs.config.MinTests
+	runConfig.ComposeRetries = cs.config.ComposeRetries
+	runConfig.XFail = cs.config.XFail
+	runConfig.XFailStrict = cs.config.XFailStrict
+	runConfig.WaitFor = cs.waits
+	runConfig.Probes = cs.probes
+
 	return runConfig
 }
 
+// envFiles returns the env files for a script, including
+// the suite env file which applies to every script.
+func (cs *configurationSuite) envFiles(scriptEnvFile string) []string {
+	var envFiles []string
+	if cs.config.EnvFile != "" {
+		envFiles = append(envFiles, cs.config.EnvFile)
+	}
+	if scriptEnvFile != "" {
+		envFiles = append(envFiles, scriptEnvFile)
+	}
+	return envFiles
+}
+
 func (cs *configurationSuite) CustomImages() []CustomImage {
 	return cs.customImages
 }
 
+func (cs *configurationSuite) Platform() Platform {
+	return cs.platform
+}
+
+func (cs *configurationSuite) Mounts() []Mount {
+	return cs.mounts
+}
+
+func (cs *configurationSuite) Params() []MatrixParam {
+	return cs.params
+}
+
 func newSuiteConfiguration(path string, config suiteConfiguration) (*configurationSuite, error) {
 	customImages := make([]CustomImage, 0, len(config.CustomImages))
 	for _, value := range config.CustomImages {
-		ref, err := reference.Parse(value.Tag)
+		ci, err := normalizeCustomImage(value.Tag, value.Default, value.Version)
 		if err != nil {
 			return nil, err
 		}
-		target, ok := ref.(reference.NamedTagged)
-		if !ok {
-			return nil, fmt.Errorf("expecting name:tag for image target, got %s", value.Tag)
-		}
-
-		version := value.Version
-		if version == "" {
-			version = target.Tag()
-
-			ref, err := reference.Parse(value.Default)
-			if err == nil {
-				if tagged, ok := ref.(reference.Tagged); ok {
-					version = tagged.Tag()
-				}
-			}
-
+		ci.DefaultOnly = true
+		ci.Env = value.Env
+		if err := validateCustomImageEnv(ci); err != nil {
+			return nil, err
 		}
 
-		customImages = append(customImages, CustomImage{
-			Source:      value.Default,
-			Target:      target,
-			Version:     version,
-			DefaultOnly: true,
-		})
+		customImages = append(customImages, ci)
 	}
 	images := make([]reference.NamedTagged, 0, len(config.Images))
 	for _, image := range config.Images {
@@ -557,6 +1090,107 @@ func newSuiteConfiguration(path string, config suiteConfiguration) (*configurati
 		}
 	}
 
+	platform, err := ParsePlatform(config.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Parallel < 0 {
+		return nil, fmt.Errorf("invalid parallel %d, must not be negative", config.Parallel)
+	}
+	if config.MinTests < 0 {
+		return nil, fmt.Errorf("invalid min_tests %d, must not be negative", config.MinTests)
+	}
+	if config.ComposeRetries < 0 {
+		return nil, fmt.Errorf("invalid compose_retries %d, must not be negative", config.ComposeRetries)
+	}
+	if _, err := newXFailMatcher(config.XFail); err != nil {
+		return nil, err
+	}
+	if err := checkWrapper(config.Wrapper, path); err != nil {
+		return nil, err
+	}
+	for _, script := range config.Runner {
+		if err := checkResultsFile(script, config.Format); err != nil {
+			return nil, err
+		}
+		if err := checkWrapper(script.Wrapper, path); err != nil {
+			return nil, err
+		}
+	}
+
+	mounts := make([]Mount, 0, len(config.Mounts))
+	for _, spec := range config.Mounts {
+		m, err := ParseMount(spec, path)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+
+	runtimes := make([]DaemonRuntime, 0, len(config.Runtimes))
+	for _, spec := range config.Runtimes {
+		r, err := ParseDaemonRuntime(spec)
+		if err != nil {
+			return nil, err
+		}
+		runtimes = append(runtimes, r)
+	}
+	if err := validateRuntimes(runtimes, config.DefaultRuntime); err != nil {
+		return nil, err
+	}
+
+	if err := validateSuiteDaemonConfig(path, config, runtimes); err != nil {
+		return nil, err
+	}
+
+	if err := validateLabels(config.Labels); err != nil {
+		return nil, err
+	}
+
+	if err := validateCapabilities(config.CapAdd); err != nil {
+		return nil, err
+	}
+	if err := validateCapabilities(config.CapDrop); err != nil {
+		return nil, err
+	}
+	if err := validateSecurityOpts(config.SecurityOpt); err != nil {
+		return nil, err
+	}
+
+	requires, err := newSuiteRequirements(config.Requires)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := newMatrixParams(config.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	archBase, err := newArchBase(config.ArchBase)
+	if err != nil {
+		return nil, err
+	}
+
+	waits := make([]LogWait, 0, len(config.WaitFor))
+	for _, wc := range config.WaitFor {
+		wait, err := newLogWait(wc)
+		if err != nil {
+			return nil, err
+		}
+		waits = append(waits, wait)
+	}
+
+	probes := make([]ReadinessProbe, 0, len(config.Probe))
+	for _, pc := range config.Probe {
+		probe, err := newReadinessProbe(pc)
+		if err != nil {
+			return nil, err
+		}
+		probes = append(probes, probe)
+	}
+
 	name := config.Name
 	if name == "" {
 		name = filepath.Base(path)
@@ -568,6 +1202,14 @@ func newSuiteConfiguration(path string, config suiteConfiguration) (*configurati
 		base:         base,
 		customImages: customImages,
 		images:       images,
+		platform:     platform,
+		mounts:       mounts,
+		runtimes:     runtimes,
+		waits:        waits,
+		probes:       probes,
+		requires:     requires,
+		params:       params,
+		archBase:     archBase,
 
 		resolvedName: name,
 	}, nil
@@ -586,42 +1228,57 @@ func getNamedTagged(image string) (reference.NamedTagged, error) {
 }
 
 func parseSuites(suites []string) (map[string]*configurationSuite, error) {
+	configs, _, err := parseConfiguration(suites)
+	return configs, err
+}
+
+// parseConfiguration parses the suites and run hooks from the
+// configuration files of the given paths. Run hooks from each
+// configuration are run in the order the paths are given.
+func parseConfiguration(suites []string) (map[string]*configurationSuite, RunHooks, error) {
 	configs := map[string]*configurationSuite{}
+	var hooks RunHooks
 	for _, suite := range suites {
 		logrus.Debugf("Handling suite %s", suite)
 		absPath, err := filepath.Abs(suite)
 		if err != nil {
-			return nil, fmt.Errorf("could not resolve %s: %s", suite, err)
+			return nil, RunHooks{}, fmt.Errorf("could not resolve %s: %s", suite, err)
 		}
 
 		info, err := os.Stat(absPath)
 		if err != nil {
-			return nil, fmt.Errorf("error statting %s: %s", suite, err)
+			return nil, RunHooks{}, fmt.Errorf("error statting %s: %s", suite, err)
 		}
 		if info.IsDir() {
 			absPath = filepath.Join(absPath, "golem.conf")
 			if _, err := os.Stat(absPath); err != nil {
-				return nil, fmt.Errorf("error statting %s: %s", filepath.Join(suite, "golem.conf"), err)
+				return nil, RunHooks{}, fmt.Errorf("error statting %s: %s", filepath.Join(suite, "golem.conf"), err)
 			}
 		}
 
 		confBytes, err := ioutil.ReadFile(absPath)
 		if err != nil {
-			return nil, fmt.Errorf("unable to open configuration file %s: %s", absPath, err)
+			return nil, RunHooks{}, fmt.Errorf("unable to open configuration file %s: %s", absPath, err)
 		}
 
 		// Load
 		var conf suitesConfiguration
 		if err := toml.Unmarshal(confBytes, &conf); err != nil {
-			return nil, fmt.Errorf("error unmarshalling %s: %s", absPath, err)
+			return nil, RunHooks{}, fmt.Errorf("error unmarshalling %s: %s", absPath, err)
+		}
+		if err := expandSnippets(&conf); err != nil {
+			return nil, RunHooks{}, fmt.Errorf("error in %s: %v", absPath, err)
 		}
 
 		logrus.Debugf("Found %d test suites in %s", len(conf.Suites), suite)
+		confHooks := newRunHooks(filepath.Dir(absPath), conf)
+		hooks.BeforeAll = append(hooks.BeforeAll, confHooks.BeforeAll...)
+		hooks.AfterAll = append(hooks.AfterAll, confHooks.AfterAll...)
 		for _, sc := range conf.Suites {
 			p := filepath.Dir(absPath)
 			suiteConfig, err := newSuiteConfiguration(p, sc)
 			if err != nil {
-				return nil, err
+				return nil, RunHooks{}, err
 			}
 
 			name := suiteConfig.Name()
@@ -635,28 +1292,161 @@ func parseSuites(suites []string) (map[string]*configurationSuite, error) {
 		}
 	}
 
-	return configs, nil
+	return configs, hooks, nil
 }
 
 type customimageConfiguration struct {
 	Tag     string `toml:"tag"`
 	Default string `toml:"default"`
 	Version string `toml:"version"`
+
+	// Env are additional environment variables computed from the
+	// selected version, in the form KEY=template. Templates may use
+	// .Name, .Tag, .Version, .Major, .Minor and .Patch.
+	Env []string `toml:"env"`
 }
 
 type suitesConfiguration struct {
 	Suites []suiteConfiguration `toml:"suite"`
+
+	// Commands are named command snippets used by pretest,
+	// testrunner and hook entries, with ${name} parameters
+	// replaced by the args of the entry
+	Commands map[string]string `toml:"commands"`
+
+	// BeforeAll are commands run on the host once before
+	// any suite in the run, from the configuration directory
+	BeforeAll []pretestConfiguration `toml:"before_all"`
+
+	// AfterAll are commands run on the host once after all
+	// suites in the run, even when a suite fails
+	AfterAll []pretestConfiguration `toml:"after_all"`
 }
 
 type pretestConfiguration struct {
 	Command string   `toml:"command"`
 	Env     []string `toml:"env"`
+	EnvFile string   `toml:"env_file"`
+
+	// Use is the name of a command snippet used as the
+	// command, with Args replacing its parameters
+	Use  string            `toml:"use"`
+	Args map[string]string `toml:"args"`
+}
+
+// defaultWaitTimeout is the timeout for waiting for
+// a log pattern when none is configured.
+const defaultWaitTimeout = time.Minute
+
+// checkResultsFile checks a testrunner entry reading results
+// from a file has a supported results format.
+func checkResultsFile(script testRunConfiguration, defaultFormat string) error {
+	if script.ResultsFile == "" {
+		if script.ResultsFormat != "" {
+			return fmt.Errorf("results_format %q set without results_file", script.ResultsFormat)
+		}
+		return nil
+	}
+	format := script.ResultsFormat
+	if format == "" {
+		format = script.Format
+	}
+	if format == "" {
+		format = defaultFormat
+	}
+	if _, ok := resultParsers[format]; !ok {
+		return fmt.Errorf("unsupported format %q for results_file %s", format, script.ResultsFile)
+	}
+	return nil
+}
+
+// checkWrapper checks the command of a test runner wrapper is
+// found in the path, relative paths are found in the suite directory.
+func checkWrapper(wrapper, dir string) error {
+	if wrapper == "" {
+		return nil
+	}
+	// TODO: respect quoted values
+	name := strings.Split(wrapper, " ")[0]
+	if strings.Contains(name, "/") && !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("invalid wrapper %s: %v", wrapper, err)
+	}
+	return nil
+}
+
+type waitForConfiguration struct {
+	Stream  string `toml:"stream"`
+	Pattern string `toml:"pattern"`
+	Timeout string `toml:"timeout"`
+}
+
+// newLogWait validates the log wait configuration, defaulting
+// to waiting on the compose log stream.
+func newLogWait(wc waitForConfiguration) (LogWait, error) {
+	wait := LogWait{
+		Stream:  wc.Stream,
+		Pattern: wc.Pattern,
+		Timeout: defaultWaitTimeout,
+	}
+	if wait.Stream == "" {
+		wait.Stream = "compose"
+	}
+	if wait.Pattern == "" {
+		return LogWait{}, errors.New("waitfor pattern must not be empty")
+	}
+	if _, err := regexp.Compile(wait.Pattern); err != nil {
+		return LogWait{}, fmt.Errorf("invalid waitfor pattern %q: %v", wait.Pattern, err)
+	}
+	if wc.Timeout != "" {
+		timeout, err := time.ParseDuration(wc.Timeout)
+		if err != nil {
+			return LogWait{}, fmt.Errorf("invalid waitfor timeout %q: %v", wc.Timeout, err)
+		}
+		wait.Timeout = timeout
+	}
+	return wait, nil
 }
 
 type testRunConfiguration struct {
 	Command string   `toml:"command"`
 	Format  string   `toml:"format"`
 	Env     []string `toml:"env"`
+	EnvFile string   `toml:"env_file"`
+
+	// Use is the name of a command snippet used as the
+	// command, with Args replacing its parameters
+	Use  string            `toml:"use"`
+	Args map[string]string `toml:"args"`
+
+	// Coverage is the path of a go coverage profile written by
+	// the command, copied out of the test container after running
+	Coverage string `toml:"coverage"`
+
+	// AllowFailure records a non-zero exit of the command
+	// without failing the suite
+	AllowFailure bool `toml:"allow_failure"`
+
+	// Wrapper is a command prepended to the command, overriding
+	// the suite wrapper
+	Wrapper string `toml:"wrapper"`
+
+	// WrapperEnv are environment variables set for the wrapped
+	// command, overriding the suite wrapper environment
+	WrapperEnv []string `toml:"wrapper_env"`
+
+	// Ordered runs the command alone after all previous commands
+	// have finished when the suite runs commands in parallel
+	Ordered bool `toml:"ordered"`
+
+	// ResultsFile is a report written by the command, such as a
+	// JUnit XML file, parsed after the command exits instead of
+	// its output. The command output is also parsed when the
+	// results format is set separately from the format.
+	ResultsFile   string `toml:"results_file"`
+	ResultsFormat string `toml:"results_format"`
 }
 
 type suiteConfiguration struct {
@@ -671,14 +1461,115 @@ type suiteConfiguration struct {
 	// Base is the base image to build the test from
 	Base string `toml:"baseimage"`
 
+	// ArchBase are base images by architecture, such as arm64,
+	// used instead of the base image when building for it
+	ArchBase map[string]string `toml:"arch_baseimage"`
+
+	// StorageDriver is the storage driver for the docker daemon
+	// inside the test container, overrides DOCKER_GRAPHDRIVER
+	StorageDriver string `toml:"storage_driver"`
+
+	// Runtimes are additional runtimes for the docker daemon inside
+	// the test container in the form name=path, path being absolute
+	// inside the test container
+	Runtimes []string `toml:"runtimes"`
+
+	// DefaultRuntime is the default runtime for the docker daemon
+	// inside the test container
+	DefaultRuntime string `toml:"default_runtime"`
+
+	// DaemonConfig is a daemon.json file relative to the suite
+	// directory for the docker daemon inside the test container
+	DaemonConfig string `toml:"daemon_config"`
+
+	// DaemonConfigMerge is whether DaemonConfig is merged into
+	// or replaces a daemon.json in the base image, "merge" or
+	// "replace", defaulting to merge
+	DaemonConfigMerge DaemonConfigMerge `toml:"daemon_config_merge"`
+
+	// Privileged is whether the test container is privileged,
+	// defaulting to true. Must not be false with dind.
+	Privileged *bool `toml:"privileged"`
+
+	// CapAdd and CapDrop are kernel capabilities added to or
+	// dropped from the test container
+	CapAdd  []string `toml:"cap_add"`
+	CapDrop []string `toml:"cap_drop"`
+
+	// SecurityOpt are security options for the test container,
+	// such as seccomp=profile.json or apparmor=profile
+	SecurityOpt []string `toml:"security_opt"`
+
+	// Platform is the platform (os/arch[/variant]) required
+	// for the base image and all images in the test container
+	Platform string `toml:"platform"`
+
+	// EnvFile is an environment file loaded for every pretest and
+	// test runner command. The path is relative to the suite directory.
+	EnvFile string `toml:"env_file"`
+
 	// Pretest is the commands to run before the test starts
 	Pretest []pretestConfiguration `toml:"pretest"`
 
+	// Format is the default output format for test runner
+	// commands which do not specify a format
+	Format string `toml:"format"`
+
+	// Wrapper is a command prepended to every test runner
+	// command, such as a sanitizer wrapper
+	Wrapper string `toml:"wrapper"`
+
+	// WrapperEnv are environment variables set for every
+	// wrapped test runner command, such as GORACE
+	WrapperEnv []string `toml:"wrapper_env"`
+
 	// Runner are the commands to run for the test. Each command
 	// must run without error for the suite to be considered passed.
 	// Each command may have a different output format.
 	Runner []testRunConfiguration `toml:"testrunner"`
 
+	// AllowNoTests allows the suite to be run without any
+	// testrunner entries, otherwise the suite is rejected
+	AllowNoTests bool `toml:"allow_no_tests"`
+
+	// WaitFor are log patterns to wait for after starting compose
+	// services, before running any tests
+	WaitFor []waitForConfiguration `toml:"waitfor"`
+
+	// Probe are commands run in compose service containers after
+	// the log waits until they succeed, before any tests
+	Probe []probeConfiguration `toml:"probe"`
+
+	// RunAll runs every testrunner entry even after a failure,
+	// the suite fails if any entry failed
+	RunAll bool `toml:"run_all"`
+
+	// Parallel is the maximum number of testrunner entries run
+	// at the same time, entries are independent unless ordered
+	Parallel int `toml:"parallel"`
+
+	// MinTests is the minimum number of tests parsed from the
+	// testrunner output, not counting skipped tests, for the
+	// suite to pass. Catches test discovery running nothing.
+	MinTests int `toml:"min_tests"`
+
+	// ComposeRetries is the number of times a failed compose
+	// build or up is retried, taking the services down first
+	ComposeRetries int `toml:"compose_retries"`
+
+	// XFail are regular expressions matching the full names of
+	// tests expected to fail. Matching failures are recorded as
+	// expected and do not fail the suite.
+	XFail []string `toml:"xfail"`
+
+	// XFailStrict fails the suite when a test matching an xfail
+	// pattern passes, otherwise the pass is only reported
+	XFailStrict bool `toml:"xfail_strict"`
+
+	// Params are named parameters with the values to run the
+	// suite with, an instance is run for every combination
+	Params map[string][]string `toml:"params"`
+
 	// Images which should exist in the test container
 	// automatically set dind to true
 	Images []string `toml:"images"`
@@ -686,6 +1577,33 @@ type suiteConfiguration struct {
 	// CustomImages allow runtime selection of an image inside the container
 	// automatically set dind to true
 	CustomImages []customimageConfiguration `toml:"customimage"`
+
+	// Mounts are host paths to mount into the test container in the
+	// form host:container[:ro|rw], relative host paths are resolved
+	// from the suite directory
+	Mounts []string `toml:"mounts"`
+
+	// Labels are free-form metadata, such as the owning team, applied
+	// to the test images and containers and included in results
+	Labels map[string]string `toml:"labels"`
+
+	// Requires are the daemon capabilities required to run the
+	// suite, the suite is skipped when they are not met
+	Requires requiresConfiguration `toml:"requires"`
+}
+
+type requiresConfiguration struct {
+	// MinDockerVersion and MaxDockerVersion are the inclusive
+	// range of versions of the daemon golem runs against
+	MinDockerVersion string `toml:"min_docker_version"`
+	MaxDockerVersion string `toml:"max_docker_version"`
+
+	// StorageDriver is the required storage driver, of the daemon
+	// inside the test container for dind suites
+	StorageDriver string `toml:"storage_driver"`
+
+	// OS is the required operating system type of the daemon
+	OS string `toml:"os"`
 }
 
 func assertTagged(image string) reference.NamedTagged {
@@ -0,0 +1,375 @@
+package runner
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeCustomImage(t *testing.T) {
+	cases := []struct {
+		target  string
+		source  string
+		version string
+
+		expectedSource  string
+		expectedVersion string
+		expectErr       bool
+	}{
+		{
+			target:          "golem-registry:latest",
+			source:          "registry:2.2.1",
+			version:         "2.2.0",
+			expectedSource:  "registry:2.2.1",
+			expectedVersion: "2.2.0",
+		},
+		{
+			target:          "golem-registry:latest",
+			source:          "registry:2.2.1",
+			expectedSource:  "registry:2.2.1",
+			expectedVersion: "2.2.1",
+		},
+		{
+			target:          "golem-registry:2.1.0",
+			source:          "registry",
+			expectedSource:  "registry",
+			expectedVersion: "2.1.0",
+		},
+		{
+			target:    "golem-registry",
+			source:    "registry:2.2.1",
+			expectErr: true,
+		},
+		{
+			target:    "golem-registry:latest",
+			source:    "Registry:2.2.1",
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		// Check flag entry point
+		m := customImageMap{}
+		value := c.target + "," + c.source
+		if c.version != "" {
+			value = value + "," + c.version
+		}
+		err := m.Set(value)
+		if c.expectErr {
+			if err == nil {
+				t.Fatalf("Expected error setting %q", value)
+			}
+		} else if err != nil {
+			t.Fatalf("Error setting %q: %v", value, err)
+		} else {
+			for _, ci := range m {
+				if ci.Source != c.expectedSource {
+					t.Fatalf("Unexpected source %q for %q, expected %q", ci.Source, value, c.expectedSource)
+				}
+				if ci.Version != c.expectedVersion {
+					t.Fatalf("Unexpected version %q for %q, expected %q", ci.Version, value, c.expectedVersion)
+				}
+			}
+		}
+
+		// Check configuration file entry point
+		sc := suiteConfiguration{
+			CustomImages: []customimageConfiguration{
+				{
+					Tag:     c.target,
+					Default: c.source,
+					Version: c.version,
+				},
+			},
+		}
+		cs, err := newSuiteConfiguration("/suite", sc)
+		if c.expectErr {
+			if err == nil {
+				t.Fatalf("Expected error creating configuration with %#v", sc.CustomImages[0])
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("Error creating configuration with %#v: %v", sc.CustomImages[0], err)
+		}
+		customImages := cs.CustomImages()
+		if len(customImages) != 1 {
+			t.Fatalf("Unexpected number of custom images %d", len(customImages))
+		}
+		if customImages[0].Source != c.expectedSource {
+			t.Fatalf("Unexpected source %q, expected %q", customImages[0].Source, c.expectedSource)
+		}
+		if customImages[0].Version != c.expectedVersion {
+			t.Fatalf("Unexpected version %q, expected %q", customImages[0].Version, c.expectedVersion)
+		}
+		if !customImages[0].DefaultOnly {
+			t.Fatalf("Expected configuration custom image to be default only")
+		}
+	}
+}
+
+func TestDefaultFormat(t *testing.T) {
+	sc := suiteConfiguration{
+		Format: "tap",
+		Runner: []testRunConfiguration{
+			{
+				Command: "bats -t .",
+			},
+			{
+				Command: "go test -v .",
+				Format:  "go",
+			},
+		},
+	}
+	cs, err := newSuiteConfiguration("/suite", sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runConfig := cs.RunConfiguration()
+	if len(runConfig.TestRunner) != 2 {
+		t.Fatalf("Unexpected number of test runners %d", len(runConfig.TestRunner))
+	}
+	if runConfig.TestRunner[0].Format != "tap" {
+		t.Fatalf("Unexpected format %q, expected default %q", runConfig.TestRunner[0].Format, "tap")
+	}
+	if runConfig.TestRunner[1].Format != "go" {
+		t.Fatalf("Unexpected format %q, expected override %q", runConfig.TestRunner[1].Format, "go")
+	}
+}
+
+func TestNoTestRunners(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	cases := []struct {
+		config    string
+		command   string
+		expectErr bool
+	}{
+		{
+			config:    "[[suite]]\n  name=\"empty\"\n",
+			expectErr: true,
+		},
+		{
+			config:  "[[suite]]\n  name=\"empty\"\n",
+			command: DefaultTestCommand,
+		},
+		{
+			config: "[[suite]]\n  name=\"empty\"\n  allow_no_tests=true\n",
+		},
+		{
+			config: "[[suite]]\n  name=\"tests\"\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n",
+		},
+	}
+	for _, c := range cases {
+		writeTempFile(t, td, "golem.conf", c.config)
+		suites, err := parseSuites([]string{td})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, suite := range suites {
+			_, err := resolveConfigurationSuite(newMultiResolver(suite, globalDefault), suite, c.command)
+			if c.expectErr && err == nil {
+				t.Fatalf("Expected error resolving %q", c.config)
+			} else if !c.expectErr && err != nil {
+				t.Fatalf("Unexpected error resolving %q with command %q: %v", c.config, c.command, err)
+			}
+		}
+	}
+}
+
+func TestWrapperValidation(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	wrapper := writeTempFile(t, td, "wrap.sh", "#!/bin/sh\nexec \"$@\"\n")
+	if err := os.Chmod(wrapper, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		config    string
+		expectErr bool
+	}{
+		{
+			config: "[[suite]]\n  name=\"wrapped\"\n  wrapper=\"env WRAPPED=yes\"\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n",
+		},
+		{
+			config: "[[suite]]\n  name=\"wrapped\"\n  wrapper=\"./wrap.sh\"\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n",
+		},
+		{
+			config:    "[[suite]]\n  name=\"wrapped\"\n  wrapper=\"golem-missing-wrapper\"\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n",
+			expectErr: true,
+		},
+		{
+			config:    "[[suite]]\n  name=\"wrapped\"\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n    wrapper=\"./missing.sh\"\n",
+			expectErr: true,
+		},
+	}
+	for _, c := range cases {
+		writeTempFile(t, td, "golem.conf", c.config)
+		_, err := parseSuites([]string{td})
+		if c.expectErr && err == nil {
+			t.Fatalf("Expected error parsing %q", c.config)
+		} else if !c.expectErr && err != nil {
+			t.Fatalf("Unexpected error parsing %q: %v", c.config, err)
+		}
+	}
+}
+
+func TestCustomImagePreflight(t *testing.T) {
+	declared := []CustomImage{
+		mustImage("registry:2.2.1", "golem-distribution:latest", "2.2.1"),
+		mustImage("", "golem-registry:latest", "latest"),
+	}
+
+	// Declared without default and not supplied
+	missing := missingCustomImages(nil, declared)
+	if len(missing) != 1 || missing[0] != "golem-registry:latest" {
+		t.Fatalf("Unexpected missing images %v, expected [golem-registry:latest]", missing)
+	}
+
+	supplied := []CustomImage{
+		mustImage("registry:0.9.1", "golem-registry:latest", "0.9.1"),
+		mustImage("nginx:1.9", "golem-nginx:latest", "1.9"),
+	}
+	if missing := missingCustomImages(supplied, declared); len(missing) != 0 {
+		t.Fatalf("Unexpected missing images %v", missing)
+	}
+
+	unused := unusedCustomImages(supplied, declared)
+	if len(unused) != 1 || unused[0].Target.String() != "golem-nginx:latest" {
+		t.Fatalf("Unexpected unused images %v, expected golem-nginx:latest", unused)
+	}
+	if unused := unusedCustomImages(supplied[:1], declared); len(unused) != 0 {
+		t.Fatalf("Unexpected unused images %v", unused)
+	}
+}
+
+func TestCustomImageConflicts(t *testing.T) {
+	conflicting := []CustomImage{
+		mustImage("registry:2.2.1", "golem-registry:latest", "latest"),
+		mustImage("distribution:2.2.1", "golem-registry:latest", "latest"),
+	}
+	err := checkCustomImageConflicts(conflicting)
+	if err == nil || !strings.Contains(err.Error(), "conflicting sources registry:2.2.1 and distribution:2.2.1") {
+		t.Fatalf("Expected conflicting source error, got %v", err)
+	}
+
+	matrix := []CustomImage{
+		mustImage("registry:2.2.1", "golem-registry:latest", "2.2.1"),
+		mustImage("registry:2.3.0", "golem-registry:latest", "2.3.0"),
+		mustImage("registry:2.3.0", "golem-registry:latest", "2.3.0"),
+		mustImage("nginx:1.9", "golem-nginx:latest", "1.9"),
+	}
+	if err := checkCustomImageConflicts(matrix); err != nil {
+		t.Fatalf("Unexpected error for version matrix: %v", err)
+	}
+}
+
+func TestResolveSuiteInstanceEnv(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-instance-env-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "golem.conf", `[[suite]]
+  name="registry"
+  dind=true
+  [[suite.testrunner]]
+    command="bats -t ."
+  [[suite.customimage]]
+    tag="golem-registry:latest"
+    default="registry:2.2.1"
+  [[suite.customimage]]
+    tag="golem-nginx:latest"
+    default="nginx:1.9"
+`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fr := newFlagResolver(fs)
+	if err := fs.Parse([]string{"-i", "golem-registry:latest,registry:2.3.0", "-i", "golem-registry:latest,registry:2.4.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	suites, err := parseSuites([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := resolveSuite(newMultiResolver(fr, suites["registry"], globalDefault), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"GOLEM_INSTANCE_NAME=registry-1", "GOLEM_INSTANCE_INDEX=1", "GOLEM_REGISTRY_VERSION=2.3.0", "GOLEM_NGINX_VERSION=1.9"},
+		{"GOLEM_INSTANCE_NAME=registry-2", "GOLEM_INSTANCE_INDEX=2", "GOLEM_REGISTRY_VERSION=2.4.0", "GOLEM_NGINX_VERSION=1.9"},
+	}
+	if len(sc.Instances) != len(expected) {
+		t.Fatalf("Expected %d instances, got %d", len(expected), len(sc.Instances))
+	}
+	for i, instance := range sc.Instances {
+		if !reflect.DeepEqual(instance.InstanceEnv, expected[i]) {
+			t.Fatalf("Unexpected env for %s\n\tExpected: %v\n\tActual: %v", instance.Name, expected[i], instance.InstanceEnv)
+		}
+	}
+}
+
+func TestResultsFileConfiguration(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "golem.conf", `[[suite]]
+  name="reports"
+  format="tap"
+  [[suite.testrunner]]
+    command="go test"
+    format="junit"
+    results_file="report.xml"
+  [[suite.testrunner]]
+    command="bats -t ."
+    results_file="report.xml"
+    results_format="junit"
+  [[suite.testrunner]]
+    command="bats -t ."
+`)
+	suites, err := parseSuites([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runners := suites["reports"].RunConfiguration().TestRunner
+	expected := []struct{ format, resultsFormat string }{
+		{"", "junit"},
+		{"tap", "junit"},
+		{"tap", ""},
+	}
+	for i, e := range expected {
+		if runners[i].Format != e.format || runners[i].ResultsFormat != e.resultsFormat {
+			t.Fatalf("Unexpected formats %q and %q for runner %d, expected %q and %q", runners[i].Format, runners[i].ResultsFormat, i, e.format, e.resultsFormat)
+		}
+	}
+
+	for _, config := range []string{
+		"[[suite]]\n  [[suite.testrunner]]\n    command=\"go test\"\n    results_file=\"report.xml\"\n",
+		"[[suite]]\n  [[suite.testrunner]]\n    command=\"go test\"\n    results_file=\"report.xml\"\n    results_format=\"xml\"\n",
+		"[[suite]]\n  [[suite.testrunner]]\n    command=\"go test\"\n    results_format=\"junit\"\n",
+	} {
+		writeTempFile(t, td, "golem.conf", config)
+		if _, err := parseSuites([]string{td}); err == nil {
+			t.Fatalf("Expected error parsing %q", config)
+		}
+	}
+}
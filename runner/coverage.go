@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+)
+
+// coverageCopier is the subset of the docker client
+// used to copy coverage profiles out of a container.
+type coverageCopier interface {
+	CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error)
+}
+
+// coveragePath returns the absolute path of a coverage profile
+// inside the test container, relative paths are resolved from
+// the runner working directory.
+func coveragePath(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join("/runner", p)
+}
+
+// copyCoverage copies a coverage profile from the container
+// to the destination file on the host.
+func copyCoverage(ctx context.Context, cli coverageCopier, containerID, src, dst string) error {
+	rc, _, err := cli.CopyFromContainer(ctx, containerID, coveragePath(src))
+	if err != nil {
+		return fmt.Errorf("error copying coverage %s: %v", src, err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("coverage %s is not a file", src)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading coverage archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		f, err := os.Create(dst)
+		if err != nil {
+			return fmt.Errorf("error creating coverage file: %v", err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, tr); err != nil {
+			return fmt.Errorf("error writing coverage file: %v", err)
+		}
+		return nil
+	}
+}
+
+// coverProfile is a parsed go coverage profile, mapping each
+// block ("file:start,end numStmt") to its count.
+type coverProfile struct {
+	mode   string
+	blocks map[string]int
+}
+
+func parseCoverProfile(r io.Reader) (coverProfile, error) {
+	p := coverProfile{
+		blocks: map[string]int{},
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode: ") {
+			mode := strings.TrimPrefix(line, "mode: ")
+			if p.mode != "" && p.mode != mode {
+				return coverProfile{}, fmt.Errorf("mixed coverage modes %s and %s", p.mode, mode)
+			}
+			p.mode = mode
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			return coverProfile{}, fmt.Errorf("invalid coverage line %q", line)
+		}
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return coverProfile{}, fmt.Errorf("invalid coverage count %q: %v", line, err)
+		}
+		p.blocks[line[:i]] += count
+	}
+	if err := scanner.Err(); err != nil {
+		return coverProfile{}, err
+	}
+	if p.mode == "" {
+		return coverProfile{}, fmt.Errorf("missing coverage mode")
+	}
+	return p, nil
+}
+
+// mergeCoverProfiles merges go coverage profiles into a single
+// profile. Counts are summed in count and atomic mode, in set
+// mode a block is covered if covered in any profile.
+func mergeCoverProfiles(w io.Writer, profiles ...io.Reader) error {
+	merged := coverProfile{
+		blocks: map[string]int{},
+	}
+	for _, r := range profiles {
+		p, err := parseCoverProfile(r)
+		if err != nil {
+			return err
+		}
+		if merged.mode == "" {
+			merged.mode = p.mode
+		} else if merged.mode != p.mode {
+			return fmt.Errorf("cannot merge coverage modes %s and %s", merged.mode, p.mode)
+		}
+		for block, count := range p.blocks {
+			merged.blocks[block] += count
+		}
+	}
+
+	blocks := make([]string, 0, len(merged.blocks))
+	for block := range merged.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Strings(blocks)
+
+	if _, err := fmt.Fprintf(w, "mode: %s\n", merged.mode); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		count := merged.blocks[block]
+		if merged.mode == "set" && count > 1 {
+			count = 1
+		}
+		if _, err := fmt.Fprintf(w, "%s %d\n", block, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeCoverageFiles merges the coverage profile files
+// into a single profile file.
+func mergeCoverageFiles(dst string, files []string) error {
+	var readers []io.Reader
+	for _, fp := range files {
+		f, err := os.Open(fp)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return mergeCoverProfiles(out, readers...)
+}
@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+)
+
+type fakeCoverageCopier struct {
+	t     *testing.T
+	files map[string]string
+}
+
+func (f fakeCoverageCopier) CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	content, ok := f.files[srcPath]
+	if !ok {
+		return nil, types.ContainerPathStat{}, os.ErrNotExist
+	}
+	buf := imageTar(f.t, map[string]string{filepath.Base(srcPath): content})
+	return ioutil.NopCloser(buf), types.ContainerPathStat{Name: filepath.Base(srcPath)}, nil
+}
+
+func TestCollectCoverage(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-coverage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	profile := "mode: set\ngithub.com/docker/distribution/registry.go:10.2,12.3 2 1\n"
+	f := fakeCoverageCopier{
+		t: t,
+		files: map[string]string{
+			"/runner/cover.out": profile,
+		},
+	}
+	r := &runner{config: RunnerConfiguration{CoverageDir: filepath.Join(td, "coverage")}}
+	instance := InstanceConfiguration{
+		Name: "registry",
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{Coverage: "cover.out"},
+				{},
+				{Coverage: "/missing.out"},
+			},
+		},
+	}
+
+	files := r.collectCoverage(context.Background(), f, "c1", instance)
+	if len(files) != 1 {
+		t.Fatalf("Unexpected coverage files %v, expected 1", files)
+	}
+	if expected := filepath.Join(td, "coverage", "registry-1.cover"); files[0] != expected {
+		t.Fatalf("Unexpected coverage file %s, expected %s", files[0], expected)
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != profile {
+		t.Fatalf("Unexpected coverage content %q, expected %q", b, profile)
+	}
+}
+
+func TestMergeCoverProfiles(t *testing.T) {
+	p1 := `mode: count
+github.com/docker/distribution/registry.go:10.2,12.3 2 1
+github.com/docker/distribution/registry.go:14.2,16.3 1 0
+`
+	p2 := `mode: count
+github.com/docker/distribution/registry.go:14.2,16.3 1 3
+github.com/docker/distribution/registry.go:10.2,12.3 2 2
+github.com/docker/distribution/proxy.go:5.2,6.3 1 1
+`
+	expected := `mode: count
+github.com/docker/distribution/proxy.go:5.2,6.3 1 1
+github.com/docker/distribution/registry.go:10.2,12.3 2 3
+github.com/docker/distribution/registry.go:14.2,16.3 1 3
+`
+	buf := bytes.NewBuffer(nil)
+	if err := mergeCoverProfiles(buf, strings.NewReader(p1), strings.NewReader(p2)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Fatalf("Unexpected merged profile:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	set1 := "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 1 0\n"
+	set2 := "mode: set\na.go:1.1,2.2 1 1\n"
+	buf.Reset()
+	if err := mergeCoverProfiles(buf, strings.NewReader(set1), strings.NewReader(set2)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "mode: set\na.go:1.1,2.2 1 1\na.go:3.1,4.2 1 0\n"; buf.String() != expected {
+		t.Fatalf("Unexpected merged set profile %q, expected %q", buf.String(), expected)
+	}
+
+	if err := mergeCoverProfiles(ioutil.Discard, strings.NewReader(p1), strings.NewReader(set1)); err == nil {
+		t.Fatal("Expected error merging different modes")
+	}
+}
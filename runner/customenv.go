@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var (
+	envKeyRegexp       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	imageVersionRegexp = regexp.MustCompile(`^v?([0-9]+)(?:\.([0-9]+))?(?:\.([0-9]+))?`)
+)
+
+// customImageEnvData is the data available to custom
+// image environment templates.
+type customImageEnvData struct {
+	// Name is the name of the target image
+	Name string
+
+	// Tag is the tag of the target image
+	Tag string
+
+	// Version is the selected version of the custom image
+	Version string
+
+	// Major, Minor and Patch are the numeric components of
+	// the version, empty when not present in the version.
+	Major string
+	Minor string
+	Patch string
+}
+
+func newCustomImageEnvData(ci CustomImage) customImageEnvData {
+	data := customImageEnvData{
+		Version: ci.Version,
+	}
+	if ci.Target != nil {
+		data.Name = ci.Target.Name()
+		data.Tag = ci.Target.Tag()
+	}
+	if m := imageVersionRegexp.FindStringSubmatch(ci.Version); m != nil {
+		data.Major = m[1]
+		data.Minor = m[2]
+		data.Patch = m[3]
+	}
+	return data
+}
+
+// parseEnvTemplate splits an environment template in the form
+// KEY=template and parses the template.
+func parseEnvTemplate(env string) (string, *template.Template, error) {
+	parts := strings.SplitN(env, "=", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("invalid env template %q, expected KEY=template", env)
+	}
+	if !envKeyRegexp.MatchString(parts[0]) {
+		return "", nil, fmt.Errorf("invalid env template %q, bad variable name %q", env, parts[0])
+	}
+	tmpl, err := template.New(parts[0]).Option("missingkey=error").Parse(parts[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid env template %q: %v", env, err)
+	}
+	return parts[0], tmpl, nil
+}
+
+// validateCustomImageEnv validates the environment templates of a
+// custom image by parsing and executing each template.
+func validateCustomImageEnv(ci CustomImage) error {
+	_, err := renderCustomImageEnv(ci)
+	return err
+}
+
+// renderCustomImageEnv renders the environment templates of a
+// custom image for its selected version, returning the variables
+// in the form "KEY value" for use in a Dockerfile ENV instruction.
+func renderCustomImageEnv(ci CustomImage) ([]string, error) {
+	data := newCustomImageEnvData(ci)
+	envs := make([]string, 0, len(ci.Env))
+	for _, env := range ci.Env {
+		key, tmpl, err := parseEnvTemplate(env)
+		if err != nil {
+			return nil, err
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := tmpl.Execute(buf, data); err != nil {
+			return nil, fmt.Errorf("error executing env template %q: %v", env, err)
+		}
+		envs = append(envs, fmt.Sprintf("%s %s", key, buf.String()))
+	}
+	return envs, nil
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"testing"
+)
+
+func TestRenderCustomImageEnv(t *testing.T) {
+	templates := []string{
+		"DOCKER_MAJOR_MINOR={{.Major}}.{{.Minor}}",
+		"DOCKER_IMAGE={{.Name}}:{{.Tag}}",
+		"DOCKER_RELEASE={{.Version}}",
+	}
+	cases := []struct {
+		version  string
+		expected []string
+	}{
+		{
+			version: "1.10.3",
+			expected: []string{
+				"DOCKER_MAJOR_MINOR 1.10",
+				"DOCKER_IMAGE docker:latest",
+				"DOCKER_RELEASE 1.10.3",
+			},
+		},
+		{
+			version: "1.11.0-rc2",
+			expected: []string{
+				"DOCKER_MAJOR_MINOR 1.11",
+				"DOCKER_IMAGE docker:latest",
+				"DOCKER_RELEASE 1.11.0-rc2",
+			},
+		},
+	}
+	for _, c := range cases {
+		ci := mustImage("dockerswarm/dind:"+c.version, "docker:latest", c.version)
+		ci.Env = templates
+		envs, err := renderCustomImageEnv(ci)
+		if err != nil {
+			t.Fatalf("Error rendering env for %s: %v", c.version, err)
+		}
+		if len(envs) != len(c.expected) {
+			t.Fatalf("Unexpected env for %s: %v, expected %v", c.version, envs, c.expected)
+		}
+		for i := range c.expected {
+			if envs[i] != c.expected[i] {
+				t.Fatalf("Unexpected env %d for %s: %q, expected %q", i, c.version, envs[i], c.expected[i])
+			}
+		}
+	}
+}
+
+func TestValidateCustomImageEnv(t *testing.T) {
+	invalid := []string{
+		"NOVALUE",
+		"1BAD={{.Version}}",
+		"UNCLOSED={{.Version",
+		"UNKNOWN={{.Missing}}",
+	}
+	for _, env := range invalid {
+		ci := mustImage("dockerswarm/dind:1.10.3", "docker:latest", "1.10.3")
+		ci.Env = []string{env}
+		if err := validateCustomImageEnv(ci); err == nil {
+			t.Fatalf("Expected error validating %q", env)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/golem/versionutil"
+)
+
+// DaemonConfiguration is the configuration for
+// starting a docker daemon.
+type DaemonConfiguration struct {
+	// Binary is the docker binary used to run the daemon,
+	// defaults to DefaultDockerBinary when empty.
+	Binary string
+
+	// CheckWarnings enables checking the daemon output during
+	// startup for warnings which may indicate a misconfiguration,
+	// such as deprecated flags or storage driver fallbacks.
+	CheckWarnings bool
+
+	// FailOnWarning causes daemon startup to fail when any
+	// warnings are found. Only used when CheckWarnings is set.
+	FailOnWarning bool
+
+	// RegistryMirrors are the registry mirrors used by
+	// the daemon when pulling from Docker Hub.
+	RegistryMirrors []string
+
+	// StorageDriver is the storage driver for the daemon, when
+	// empty DOCKER_GRAPHDRIVER from the environment is used.
+	StorageDriver string
+
+	// Runtimes are additional OCI runtimes registered with
+	// the daemon, requires docker 1.12 or later.
+	Runtimes []DaemonRuntime
+
+	// DefaultRuntime is the runtime used for containers by
+	// default, the daemon default is used when empty.
+	DefaultRuntime string
+
+	// ConfigFile is a daemon configuration file combined with
+	// /etc/docker/daemon.json before starting the daemon.
+	ConfigFile string
+
+	// ConfigMerge determines whether ConfigFile is merged into
+	// or replaces /etc/docker/daemon.json, merged when empty.
+	ConfigMerge DaemonConfigMerge
+
+	// Tracer is a command the daemon is run under for debugging
+	// daemon startup, such as strace. Tracer output written to
+	// file descriptor 3 goes to TraceCapturer, or the daemon
+	// log stream when TraceCapturer is nil.
+	Tracer        []string
+	TraceCapturer LogCapturer
+
+	// PidFile is the file the daemon writes its pid to, passed
+	// to the daemon with --pidfile. When empty the pidfile set
+	// in the daemon configuration file is used, otherwise
+	// DefaultDaemonPidFile.
+	PidFile string
+}
+
+// DefaultDaemonPidFile is the daemon's default pid file.
+const DefaultDaemonPidFile = "/var/run/docker.pid"
+
+// runtimeVersion is the first daemon version supporting
+// additional runtimes.
+var runtimeVersion = versionutil.StaticVersion(1, 12, 0)
+
+// runtimeNameRegexp matches valid runtime names.
+var runtimeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// DaemonRuntime is an OCI runtime registered with the daemon.
+type DaemonRuntime struct {
+	Name string
+	Path string
+}
+
+func (r DaemonRuntime) String() string {
+	return r.Name + "=" + r.Path
+}
+
+// ParseDaemonRuntime parses a runtime in the form name=path, where
+// path is the absolute path of the runtime binary in the test container.
+func ParseDaemonRuntime(spec string) (DaemonRuntime, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return DaemonRuntime{}, fmt.Errorf("invalid runtime %q, expected name=path", spec)
+	}
+	r := DaemonRuntime{
+		Name: parts[0],
+		Path: parts[1],
+	}
+	if !runtimeNameRegexp.MatchString(r.Name) {
+		return DaemonRuntime{}, fmt.Errorf("invalid runtime name %q", r.Name)
+	}
+	if !path.IsAbs(r.Path) {
+		return DaemonRuntime{}, fmt.Errorf("invalid runtime path %q, must be absolute", r.Path)
+	}
+	return r, nil
+}
+
+// DaemonRuntimes is a list of runtimes which may be
+// set multiple times as a flag.
+type DaemonRuntimes []DaemonRuntime
+
+func (dr *DaemonRuntimes) String() string {
+	specs := make([]string, 0, len(*dr))
+	for _, r := range *dr {
+		specs = append(specs, r.String())
+	}
+	return strings.Join(specs, ",")
+}
+
+// Set parses and adds a runtime in the form name=path
+func (dr *DaemonRuntimes) Set(value string) error {
+	r, err := ParseDaemonRuntime(value)
+	if err != nil {
+		return err
+	}
+	*dr = append(*dr, r)
+	return nil
+}
+
+// validateRuntimes checks that runtime names are unique and
+// that the default runtime is either runc or a configured runtime.
+func validateRuntimes(runtimes []DaemonRuntime, defaultRuntime string) error {
+	names := map[string]struct{}{}
+	for _, r := range runtimes {
+		if r.Name == "runc" {
+			return errors.New("runtime name runc is reserved")
+		}
+		if _, ok := names[r.Name]; ok {
+			return fmt.Errorf("duplicate runtime %s", r.Name)
+		}
+		names[r.Name] = struct{}{}
+	}
+	if defaultRuntime != "" && defaultRuntime != "runc" {
+		if _, ok := names[defaultRuntime]; !ok {
+			return fmt.Errorf("default runtime %s is not configured", defaultRuntime)
+		}
+	}
+	return nil
+}
+
+// checkDaemonVersion checks that the daemon version
+// supports the options in the configuration.
+func checkDaemonVersion(config DaemonConfiguration, version versionutil.Version) error {
+	if (len(config.Runtimes) > 0 || config.DefaultRuntime != "") && version.LessThan(runtimeVersion) {
+		return fmt.Errorf("runtimes require docker %s or later, have %s", runtimeVersion, version)
+	}
+	return nil
+}
+
+// DefaultDockerBinary returns the docker binary to use when none
+// is configured, set by the GOLEM_DOCKER_BINARY environment variable
+// or "docker" to find the binary in the path.
+func DefaultDockerBinary() string {
+	if binary := os.Getenv("GOLEM_DOCKER_BINARY"); binary != "" {
+		return binary
+	}
+	return "docker"
+}
+
+// daemonCommand returns the command for starting a daemon with the
+// configured binary, using the binary version to determine arguments.
+func daemonCommand(config DaemonConfiguration) (*exec.Cmd, error) {
+	binary := config.Binary
+	if binary == "" {
+		binary = DefaultDockerBinary()
+	}
+
+	// Get Docker version of process
+	previousVersion, err := versionutil.BinaryVersion(binary)
+	if err != nil {
+		return nil, fmt.Errorf("could not get binary version for %s: %s", binary, err)
+	}
+
+	if err := validateRuntimes(config.Runtimes, config.DefaultRuntime); err != nil {
+		return nil, err
+	}
+	if err := checkDaemonVersion(config, previousVersion); err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("Starting daemon with %s", binary)
+	return traceCommand(config.Tracer, exec.Command(binary, daemonArgs(config, previousVersion)...))
+}
+
+// daemonArgs returns the arguments for starting a daemon
+// of the given version using the provided configuration.
+func daemonArgs(config DaemonConfiguration, version versionutil.Version) []string {
+	args := []string{}
+	if version.LessThan(versionutil.StaticVersion(1, 8, 0)) {
+		args = append(args, "--daemon")
+	} else {
+		args = append(args, "daemon")
+	}
+	args = append(args, "--log-level=debug")
+	args = append(args, "--storage-driver="+getGraphDriver(config.StorageDriver))
+	for _, mirror := range config.RegistryMirrors {
+		args = append(args, "--registry-mirror="+mirror)
+	}
+	for _, r := range config.Runtimes {
+		args = append(args, "--add-runtime="+r.String())
+	}
+	if config.DefaultRuntime != "" {
+		args = append(args, "--default-runtime="+config.DefaultRuntime)
+	}
+	if config.PidFile != "" {
+		args = append(args, "--pidfile="+config.PidFile)
+	}
+	return args
+}
+
+// daemonPidFile returns the pid file of a daemon started with
+// the configuration, reading any pidfile from the daemon
+// configuration file when not configured.
+func daemonPidFile(config DaemonConfiguration, configFile string) string {
+	if config.PidFile != "" {
+		return config.PidFile
+	}
+	daemonConfig, err := readDaemonConfig(configFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warnf("Unable to read pidfile from daemon config: %v", err)
+		}
+		return DefaultDaemonPidFile
+	}
+	if pidFile, ok := daemonConfig["pidfile"].(string); ok && pidFile != "" {
+		return pidFile
+	}
+	return DefaultDaemonPidFile
+}
+
+// killDaemon kills the daemon process and removes its pid file.
+func killDaemon(p *os.Process, pidFile string) error {
+	if err := p.Kill(); err != nil {
+		return err
+	}
+	time.Sleep(500 * time.Millisecond)
+	return removePidFile(pidFile)
+}
+
+// removePidFile removes a stopped daemon's pid file so a new
+// daemon may be started, a missing file is not an error.
+func removePidFile(pidFile string) error {
+	if err := os.Remove(pidFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RegistryMirrors is a list of registry mirror URLs
+// which may be set multiple times as a flag.
+type RegistryMirrors []string
+
+func (rm *RegistryMirrors) String() string {
+	return strings.Join(*rm, ",")
+}
+
+// Set validates and adds a registry mirror URL
+func (rm *RegistryMirrors) Set(value string) error {
+	if err := validateRegistryMirror(value); err != nil {
+		return err
+	}
+	*rm = append(*rm, value)
+	return nil
+}
+
+// validateRegistryMirror checks that a registry mirror
+// is an http or https URL without a path.
+func validateRegistryMirror(mirror string) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return fmt.Errorf("invalid registry mirror %q: %v", mirror, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid registry mirror %q, scheme must be http or https", mirror)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid registry mirror %q, missing host", mirror)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid registry mirror %q, path not allowed", mirror)
+	}
+	return nil
+}
+
+// daemonWarningPatterns match daemon output lines which
+// may indicate a misconfigured daemon.
+var daemonWarningPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`level=(?:warn|warning|error|fatal)\b`),
+	regexp.MustCompile(`^(?:WARN|WARNING|ERRO|ERROR)\b`),
+	regexp.MustCompile(`(?i)\bdeprecated\b`),
+}
+
+// scanDaemonWarnings returns the lines of daemon output
+// which match any of the daemon warning patterns.
+func scanDaemonWarnings(r io.Reader) []string {
+	var warnings []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, pattern := range daemonWarningPatterns {
+			if pattern.MatchString(line) {
+				warnings = append(warnings, line)
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Debugf("Error scanning daemon output: %v", err)
+	}
+	return warnings
+}
+
+// checkDaemonWarnings logs the daemon warnings, returning
+// an error if any warnings exist and fail is set.
+func checkDaemonWarnings(warnings []string, fail bool) error {
+	for _, warning := range warnings {
+		logrus.Warnf("Daemon startup warning: %s", warning)
+	}
+	if fail && len(warnings) > 0 {
+		return fmt.Errorf("daemon startup had %d warnings:\n%s", len(warnings), strings.Join(warnings, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/golem/versionutil"
+)
+
+func TestDaemonWarnings(t *testing.T) {
+	clean := `time="2016-03-01T10:00:00Z" level=info msg="Graph migration to content-addressability took 0.00 seconds"
+time="2016-03-01T10:00:00Z" level=debug msg="Registering routers"
+time="2016-03-01T10:00:00Z" level=info msg="API listen on /var/run/docker.sock"
+`
+	warnings := scanDaemonWarnings(strings.NewReader(clean))
+	if len(warnings) != 0 {
+		t.Fatalf("Unexpected warnings: %q", warnings)
+	}
+	if err := checkDaemonWarnings(warnings, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	warned := `Warning: '-d' is deprecated, it will be removed soon. See usage.
+time="2016-03-01T10:00:00Z" level=error msg="'overlay' not found as a supported filesystem on this host."
+time="2016-03-01T10:00:00Z" level=warning msg="Your kernel does not support swap memory limit."
+time="2016-03-01T10:00:00Z" level=info msg="API listen on /var/run/docker.sock"
+`
+	warnings = scanDaemonWarnings(strings.NewReader(warned))
+	if len(warnings) != 3 {
+		t.Fatalf("Unexpected warnings %q, expected 3", warnings)
+	}
+	if err := checkDaemonWarnings(warnings, false); err != nil {
+		t.Fatalf("Unexpected error when not failing on warnings: %v", err)
+	}
+	if err := checkDaemonWarnings(warnings, true); err == nil {
+		t.Fatal("Expected error when failing on warnings")
+	}
+}
+
+func TestDaemonArgsRegistryMirrors(t *testing.T) {
+	cases := []struct {
+		mirrors  []string
+		expected []string
+	}{
+		{
+			expected: []string{"daemon", "--log-level=debug", "--storage-driver=overlay"},
+		},
+		{
+			mirrors:  []string{"https://mirror.example.com"},
+			expected: []string{"daemon", "--log-level=debug", "--storage-driver=overlay", "--registry-mirror=https://mirror.example.com"},
+		},
+		{
+			mirrors: []string{"https://mirror.example.com", "http://localhost:5000"},
+			expected: []string{
+				"daemon", "--log-level=debug", "--storage-driver=overlay",
+				"--registry-mirror=https://mirror.example.com",
+				"--registry-mirror=http://localhost:5000",
+			},
+		},
+	}
+	for _, c := range cases {
+		args := daemonArgs(DaemonConfiguration{RegistryMirrors: c.mirrors, StorageDriver: "overlay"}, versionutil.StaticVersion(1, 10, 3))
+		if strings.Join(args, " ") != strings.Join(c.expected, " ") {
+			t.Fatalf("Unexpected args for %v: %v, expected %v", c.mirrors, args, c.expected)
+		}
+	}
+
+	args := daemonArgs(DaemonConfiguration{StorageDriver: "aufs"}, versionutil.StaticVersion(1, 7, 1))
+	if args[0] != "--daemon" {
+		t.Fatalf("Unexpected daemon argument for 1.7: %v", args)
+	}
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	var mirrors RegistryMirrors
+	for _, valid := range []string{"https://mirror.example.com", "http://localhost:5000/"} {
+		if err := mirrors.Set(valid); err != nil {
+			t.Fatalf("Unexpected error for %q: %v", valid, err)
+		}
+	}
+	if len(mirrors) != 2 {
+		t.Fatalf("Unexpected mirrors: %v", mirrors)
+	}
+
+	for _, invalid := range []string{"mirror.example.com", "ftp://mirror.example.com", "https://", "https://mirror.example.com/v2/", "https://mirror.example.com?x=1"} {
+		if err := mirrors.Set(invalid); err == nil {
+			t.Fatalf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestDaemonCommandBinary(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-binary-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	binary := writeTempFile(t, td, "docker-1.10.3", "#!/bin/sh\necho 'Docker version 1.10.3, build 20f81dd'\n")
+	if err := os.Chmod(binary, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := daemonCommand(DaemonConfiguration{Binary: binary, StorageDriver: "vfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Path != binary {
+		t.Fatalf("Unexpected daemon binary %s, expected %s", cmd.Path, binary)
+	}
+	if len(cmd.Args) < 2 || cmd.Args[1] != "daemon" {
+		t.Fatalf("Unexpected daemon args %v", cmd.Args)
+	}
+
+	// Binary from environment when not configured
+	defer os.Setenv("GOLEM_DOCKER_BINARY", os.Getenv("GOLEM_DOCKER_BINARY"))
+	os.Setenv("GOLEM_DOCKER_BINARY", binary)
+	cmd, err = daemonCommand(DaemonConfiguration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Path != binary {
+		t.Fatalf("Unexpected daemon binary %s, expected %s", cmd.Path, binary)
+	}
+
+	if _, err := daemonCommand(DaemonConfiguration{Binary: filepath.Join(td, "missing")}); err == nil {
+		t.Fatal("Expected error with missing binary")
+	}
+}
+
+func TestDaemonArgsRuntimes(t *testing.T) {
+	config := DaemonConfiguration{
+		StorageDriver: "overlay",
+		Runtimes: []DaemonRuntime{
+			{Name: "patched-runc", Path: "/usr/local/bin/runc-patched"},
+		},
+		DefaultRuntime: "patched-runc",
+	}
+	args := daemonArgs(config, versionutil.StaticVersion(1, 12, 0))
+	expected := []string{"daemon", "--log-level=debug", "--storage-driver=overlay", "--add-runtime=patched-runc=/usr/local/bin/runc-patched", "--default-runtime=patched-runc"}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Unexpected args %v, expected %v", args, expected)
+	}
+
+	if err := checkDaemonVersion(config, versionutil.StaticVersion(1, 12, 0)); err != nil {
+		t.Fatalf("Unexpected error for 1.12: %v", err)
+	}
+	if err := checkDaemonVersion(config, versionutil.StaticVersion(1, 11, 2)); err == nil {
+		t.Fatal("Expected error using runtimes with 1.11")
+	}
+	if err := checkDaemonVersion(DaemonConfiguration{}, versionutil.StaticVersion(1, 11, 2)); err != nil {
+		t.Fatalf("Unexpected error without runtimes: %v", err)
+	}
+}
+
+func TestParseDaemonRuntime(t *testing.T) {
+	r, err := ParseDaemonRuntime("patched-runc=/usr/local/bin/runc-patched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "patched-runc" || r.Path != "/usr/local/bin/runc-patched" {
+		t.Fatalf("Unexpected runtime %#v", r)
+	}
+
+	for _, invalid := range []string{
+		"patched-runc",
+		"=/usr/local/bin/runc",
+		"bad name=/usr/local/bin/runc",
+		"-runc=/usr/local/bin/runc",
+		"patched-runc=bin/runc",
+	} {
+		if _, err := ParseDaemonRuntime(invalid); err == nil {
+			t.Fatalf("Expected error parsing %q", invalid)
+		}
+	}
+
+	runtimes := []DaemonRuntime{{Name: "patched-runc", Path: "/usr/local/bin/runc-patched"}}
+	if err := validateRuntimes(runtimes, "patched-runc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateRuntimes(runtimes, "runc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateRuntimes(runtimes, "missing"); err == nil {
+		t.Fatal("Expected error with unconfigured default runtime")
+	}
+	if err := validateRuntimes(append(runtimes, runtimes[0]), ""); err == nil {
+		t.Fatal("Expected error with duplicate runtime")
+	}
+	if err := validateRuntimes([]DaemonRuntime{{Name: "runc", Path: "/usr/bin/runc"}}, ""); err == nil {
+		t.Fatal("Expected error with reserved runtime name")
+	}
+}
+
+func TestDaemonCommandTracer(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-tracer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	binary := writeTempFile(t, td, "docker-1.10.3", "#!/bin/sh\necho 'Docker version 1.10.3, build 20f81dd'\n")
+	if err := os.Chmod(binary, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := daemonCommand(DaemonConfiguration{Binary: binary, Tracer: []string{"env", "TRACED=1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd.Args) < 4 || cmd.Args[0] != "env" || cmd.Args[1] != "TRACED=1" || cmd.Args[2] != binary || cmd.Args[3] != "daemon" {
+		t.Fatalf("Unexpected traced daemon args %v", cmd.Args)
+	}
+
+	if _, err := daemonCommand(DaemonConfiguration{Binary: binary, Tracer: []string{filepath.Join(td, "missing-strace")}}); err == nil {
+		t.Fatal("Expected error with missing tracer")
+	}
+}
+
+func TestDaemonPidFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-pidfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	missing := filepath.Join(td, "missing.json")
+	if pidFile := daemonPidFile(DaemonConfiguration{}, missing); pidFile != DefaultDaemonPidFile {
+		t.Fatalf("Unexpected default pid file %s", pidFile)
+	}
+
+	configFile := writeTempFile(t, td, "daemon.json", `{"pidfile": "/run/user/1000/docker.pid"}`)
+	if pidFile := daemonPidFile(DaemonConfiguration{}, configFile); pidFile != "/run/user/1000/docker.pid" {
+		t.Fatalf("Unexpected pid file from daemon config %s", pidFile)
+	}
+
+	config := DaemonConfiguration{PidFile: "/var/run/golem-docker.pid", StorageDriver: "vfs"}
+	if pidFile := daemonPidFile(config, configFile); pidFile != config.PidFile {
+		t.Fatalf("Unexpected configured pid file %s", pidFile)
+	}
+	args := daemonArgs(config, versionutil.StaticVersion(1, 12, 0))
+	if args[len(args)-1] != "--pidfile=/var/run/golem-docker.pid" {
+		t.Fatalf("Missing pidfile argument: %v", args)
+	}
+	if err := checkDaemonConfigConflicts(map[string]interface{}{"pidfile": "/run/docker.pid"}, config); err == nil {
+		t.Fatal("Expected conflict with pidfile in daemon config")
+	}
+}
+
+func TestKillDaemonRemovesPidFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-pidfile-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+
+	pidFile := writeTempFile(t, td, "docker.pid", "1234")
+	if err := killDaemon(cmd.Process, pidFile); err != nil {
+		t.Fatalf("Unexpected error killing daemon: %v", err)
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected pid file to be removed: %v", err)
+	}
+
+	// A missing pid file is not an error
+	if err := removePidFile(pidFile); err != nil {
+		t.Fatalf("Unexpected error removing missing pid file: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+)
+
+// eventStreamer is the subset of the docker client used
+// to stream daemon events.
+type eventStreamer interface {
+	Events(ctx context.Context, options types.EventsOptions) (io.ReadCloser, error)
+}
+
+// daemonEvent is an event from the daemon event stream. Daemons
+// before 1.10 only set the status, id and from fields.
+type daemonEvent struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	From   string `json:"from"`
+
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// String formats the event like the docker events command,
+// as the time, type, action and actor with its attributes.
+func (e daemonEvent) String() string {
+	t := time.Unix(e.Time, 0)
+	if e.TimeNano != 0 {
+		t = time.Unix(0, e.TimeNano)
+	}
+	ts := t.UTC().Format(time.RFC3339Nano)
+
+	if e.Type == "" {
+		if e.From != "" {
+			return fmt.Sprintf("%s %s %s (from=%s)", ts, e.Status, e.ID, e.From)
+		}
+		return fmt.Sprintf("%s %s %s", ts, e.Status, e.ID)
+	}
+
+	line := fmt.Sprintf("%s %s %s %s", ts, e.Type, e.Action, e.Actor.ID)
+	if len(e.Actor.Attributes) > 0 {
+		attrs := make([]string, 0, len(e.Actor.Attributes))
+		for k, v := range e.Actor.Attributes {
+			attrs = append(attrs, k+"="+v)
+		}
+		sort.Strings(attrs)
+		line = line + " (" + strings.Join(attrs, ", ") + ")"
+	}
+	return line
+}
+
+// captureDaemonEvents writes a line for each daemon event to w
+// until the returned function is called, which stops the event
+// stream and waits for the remaining events to be written.
+func captureDaemonEvents(ctx context.Context, cli eventStreamer, w io.Writer) (func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	body, err := cli.Events(ctx, types.EventsOptions{})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dec := json.NewDecoder(body)
+		for {
+			var e daemonEvent
+			if err := dec.Decode(&e); err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					logrus.Errorf("Error reading daemon events: %v", err)
+				}
+				return
+			}
+			fmt.Fprintln(w, e)
+		}
+	}()
+
+	return func() {
+		cancel()
+		body.Close()
+		<-done
+	}, nil
+}
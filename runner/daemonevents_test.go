@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+)
+
+type fakeEventStreamer struct {
+	r *io.PipeReader
+}
+
+func (f fakeEventStreamer) Events(ctx context.Context, options types.EventsOptions) (io.ReadCloser, error) {
+	return f.r, nil
+}
+
+func TestCaptureDaemonEvents(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "instance")
+	events, err := lr.RouteLogCapturer("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	stop, err := captureDaemonEvents(context.Background(), fakeEventStreamer{r: r}, events.Stdout())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := int64(1470052800000000000)
+	for i, action := range []string{"create", "start", "die", "destroy"} {
+		fmt.Fprintf(w, `{"status":%q,"id":"1a2b","from":"busybox","Type":"container","Action":%q,"Actor":{"ID":"1a2b","Attributes":{"name":"web","image":"busybox"}},"time":1470052800,"timeNano":%d}`+"\n", action, action, base+int64(i))
+	}
+	// Events from daemons before 1.10
+	fmt.Fprintln(w, `{"status":"pull","id":"busybox:latest","time":1470052801}`)
+
+	fp := filepath.Join(td, "instance", "events-stdout")
+	content := func() string {
+		b, _ := ioutil.ReadFile(fp)
+		return string(b)
+	}
+	waitForContent(t, content, "pull busybox:latest")
+	stop()
+
+	expected := "2016-08-01T12:00:00Z container create 1a2b (image=busybox, name=web)\n" +
+		"2016-08-01T12:00:00.000000001Z container start 1a2b (image=busybox, name=web)\n" +
+		"2016-08-01T12:00:00.000000002Z container die 1a2b (image=busybox, name=web)\n" +
+		"2016-08-01T12:00:00.000000003Z container destroy 1a2b (image=busybox, name=web)\n" +
+		"2016-08-01T12:00:01Z pull busybox:latest\n"
+	if out := content(); out != expected {
+		t.Fatalf("Unexpected events log\n%s\nexpected\n%s", out, expected)
+	}
+
+	// Events after stopping are not captured
+	if _, err := fmt.Fprintln(w, `{"status":"start","id":"late"}`); err == nil {
+		t.Fatal("Expected event stream to be closed after stopping")
+	}
+}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// daemonConfigFile is the configuration file read by the
+// daemon started in the test container.
+const daemonConfigFile = "/etc/docker/daemon.json"
+
+// DaemonConfigMerge determines how a suite daemon configuration
+// file is combined with a daemon configuration file already
+// present in the test image.
+type DaemonConfigMerge string
+
+const (
+	// DaemonConfigMerged deep merges the suite configuration
+	// into the existing configuration, with suite values taking
+	// precedence on conflicts
+	DaemonConfigMerged DaemonConfigMerge = "merge"
+
+	// DaemonConfigReplaced replaces the existing configuration
+	// with the suite configuration
+	DaemonConfigReplaced DaemonConfigMerge = "replace"
+)
+
+func (m *DaemonConfigMerge) String() string {
+	return string(*m)
+}
+
+// Set sets the merge behavior from a string, allowing the
+// behavior to be used as a flag value.
+func (m *DaemonConfigMerge) Set(s string) error {
+	switch merge := DaemonConfigMerge(s); merge {
+	case DaemonConfigMerged, DaemonConfigReplaced:
+		*m = merge
+		return nil
+	}
+	return fmt.Errorf("invalid daemon config merge %q, must be one of merge or replace", s)
+}
+
+// readDaemonConfig reads a daemon configuration file, which
+// must contain a JSON object.
+func readDaemonConfig(filename string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("invalid daemon config %s: %v", filename, err)
+	}
+	if config == nil {
+		return nil, fmt.Errorf("invalid daemon config %s: expected a JSON object", filename)
+	}
+	return config, nil
+}
+
+// mergeDaemonConfig deep merges the override into the base
+// configuration. Objects are merged key by key, any other
+// value in the override replaces the base value.
+func mergeDaemonConfig(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		baseObj, baseOK := merged[k].(map[string]interface{})
+		overrideObj, overrideOK := v.(map[string]interface{})
+		if baseOK && overrideOK {
+			merged[k] = mergeDaemonConfig(baseObj, overrideObj)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// daemonFlagKeys returns the configuration file keys of the
+// options set as flags when starting the daemon, which the
+// daemon refuses to start with when also in the file.
+func daemonFlagKeys(config DaemonConfiguration) []string {
+	keys := []string{"log-level", "storage-driver"}
+	if len(config.RegistryMirrors) > 0 {
+		keys = append(keys, "registry-mirrors")
+	}
+	if len(config.Runtimes) > 0 {
+		keys = append(keys, "runtimes")
+	}
+	if config.DefaultRuntime != "" {
+		keys = append(keys, "default-runtime")
+	}
+	if config.PidFile != "" {
+		keys = append(keys, "pidfile")
+	}
+	return keys
+}
+
+// checkDaemonConfigConflicts returns an error if the daemon
+// configuration sets options also set as daemon flags.
+func checkDaemonConfigConflicts(daemonConfig map[string]interface{}, config DaemonConfiguration) error {
+	var conflicts []string
+	for _, key := range daemonFlagKeys(config) {
+		if _, ok := daemonConfig[key]; ok {
+			conflicts = append(conflicts, key)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("daemon config sets %s, already set by golem as daemon flags", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// writeDaemonConfig writes the daemon configuration file for the
+// configured suite daemon configuration, merging with or replacing
+// the existing file at target. Nothing is written when the daemon
+// configuration has no configuration file.
+func writeDaemonConfig(target string, config DaemonConfiguration) error {
+	if config.ConfigFile == "" {
+		return nil
+	}
+	daemonConfig, err := readDaemonConfig(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if config.ConfigMerge != DaemonConfigReplaced {
+		existing, err := readDaemonConfig(target)
+		if err == nil {
+			daemonConfig = mergeDaemonConfig(existing, daemonConfig)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := checkDaemonConfigConflicts(daemonConfig, config); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(daemonConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, append(b, '\n'), 0644)
+}
+
+// validateSuiteDaemonConfig validates the daemon configuration file
+// of a suite, which must be in the suite directory and must not set
+// options set as daemon flags for the suite.
+func validateSuiteDaemonConfig(dir string, config suiteConfiguration, runtimes []DaemonRuntime) error {
+	if config.DaemonConfigMerge != "" {
+		var merge DaemonConfigMerge
+		if err := merge.Set(string(config.DaemonConfigMerge)); err != nil {
+			return err
+		}
+	}
+	if config.DaemonConfig == "" {
+		return nil
+	}
+	clean := filepath.Clean(config.DaemonConfig)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid daemon_config %q, must be a path in the suite directory", config.DaemonConfig)
+	}
+	daemonConfig, err := readDaemonConfig(filepath.Join(dir, clean))
+	if err != nil {
+		return err
+	}
+	return checkDaemonConfigConflicts(daemonConfig, DaemonConfiguration{
+		Runtimes:       runtimes,
+		DefaultRuntime: config.DefaultRuntime,
+	})
+}
@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteDaemonConfigMerge(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-daemon-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	suiteConfig := writeTempFile(t, td, "suite.json", `{"debug": true, "labels": ["suite"], "log-opts": {"max-size": "20m"}}`)
+	target := writeTempFile(t, td, "daemon.json", `{"debug": false, "labels": ["base"], "log-opts": {"max-size": "10m", "max-file": "3"}, "insecure-registries": ["localregistry:5000"]}`)
+
+	if err := writeDaemonConfig(target, DaemonConfiguration{ConfigFile: suiteConfig}); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := readDaemonConfig(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"debug":               true,
+		"labels":              []interface{}{"suite"},
+		"log-opts":            map[string]interface{}{"max-size": "20m", "max-file": "3"},
+		"insecure-registries": []interface{}{"localregistry:5000"},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Unexpected merged config %#v, expected %#v", merged, expected)
+	}
+}
+
+func TestWriteDaemonConfigReplace(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-daemon-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	suiteConfig := writeTempFile(t, td, "suite.json", `{"debug": true}`)
+	target := writeTempFile(t, td, "daemon.json", `{"insecure-registries": ["localregistry:5000"]}`)
+
+	config := DaemonConfiguration{ConfigFile: suiteConfig, ConfigMerge: DaemonConfigReplaced}
+	if err := writeDaemonConfig(target, config); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := readDaemonConfig(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replaced, map[string]interface{}{"debug": true}) {
+		t.Fatalf("Unexpected replaced config %#v", replaced)
+	}
+
+	// Missing target is created when merging
+	missing := filepath.Join(td, "etc", "docker", "daemon.json")
+	if err := writeDaemonConfig(missing, DaemonConfiguration{ConfigFile: suiteConfig}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDaemonConfig(missing); err != nil {
+		t.Fatal(err)
+	}
+
+	// No config file leaves the target untouched
+	if err := writeDaemonConfig(filepath.Join(td, "untouched.json"), DaemonConfiguration{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(td, "untouched.json")); !os.IsNotExist(err) {
+		t.Fatalf("Expected no daemon config written: %v", err)
+	}
+}
+
+func TestWriteDaemonConfigInvalid(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-daemon-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	target := filepath.Join(td, "daemon.json")
+	cases := []struct {
+		content string
+		config  DaemonConfiguration
+	}{
+		{`{"debug": true`, DaemonConfiguration{}},
+		{`["debug"]`, DaemonConfiguration{}},
+		{`null`, DaemonConfiguration{}},
+		{`{"storage-driver": "vfs"}`, DaemonConfiguration{}},
+		{`{"registry-mirrors": ["https://mirror.example.com"]}`, DaemonConfiguration{RegistryMirrors: []string{"https://mirror.example.com"}}},
+		{`{"default-runtime": "runc"}`, DaemonConfiguration{DefaultRuntime: "runc"}},
+	}
+	for _, c := range cases {
+		c.config.ConfigFile = writeTempFile(t, td, "suite.json", c.content)
+		if err := writeDaemonConfig(target, c.config); err == nil {
+			t.Fatalf("Expected error writing daemon config %s", c.content)
+		}
+	}
+
+	// Mirrors only conflict when set as flags
+	config := DaemonConfiguration{ConfigFile: writeTempFile(t, td, "suite.json", `{"registry-mirrors": ["https://mirror.example.com"]}`)}
+	if err := writeDaemonConfig(target, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestValidateSuiteDaemonConfig(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-daemon-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "daemon.json", `{"runtimes": {}}`)
+
+	if err := validateSuiteDaemonConfig(td, suiteConfiguration{DaemonConfig: "daemon.json"}, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runtimes := []DaemonRuntime{{Name: "custom", Path: "/usr/bin/custom"}}
+	if err := validateSuiteDaemonConfig(td, suiteConfiguration{DaemonConfig: "daemon.json"}, runtimes); err == nil {
+		t.Fatal("Expected error for runtimes set in config and flags")
+	}
+	for _, p := range []string{"../daemon.json", "/etc/docker/daemon.json", "missing.json"} {
+		if err := validateSuiteDaemonConfig(td, suiteConfiguration{DaemonConfig: p}, nil); err == nil {
+			t.Fatalf("Expected error for daemon config %s", p)
+		}
+	}
+	if err := validateSuiteDaemonConfig(td, suiteConfiguration{DaemonConfig: "daemon.json", DaemonConfigMerge: "append"}, nil); err == nil {
+		t.Fatal("Expected error for invalid merge")
+	}
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadScriptEnv returns the environment for a script, merging
+// values loaded from the script's env files with the inline
+// environment. Values from later env files take precedence,
+// with the inline environment taking precedence over all.
+func loadScriptEnv(script Script) ([]string, error) {
+	if len(script.EnvFile) == 0 {
+		return script.Env, nil
+	}
+	var env []string
+	for _, envFile := range script.EnvFile {
+		fileEnv, err := parseEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		env = mergeEnv(env, fileEnv)
+	}
+	return mergeEnv(env, script.Env), nil
+}
+
+// mergeEnv merges the environment values, with values in
+// the override taking precedence over values in env.
+func mergeEnv(env, override []string) []string {
+	merged := make([]string, 0, len(env)+len(override))
+	keys := map[string]int{}
+	for _, e := range append(append([]string{}, env...), override...) {
+		key := e
+		if idx := strings.Index(e, "="); idx >= 0 {
+			key = e[:idx]
+		}
+		if i, ok := keys[key]; ok {
+			merged[i] = e
+			continue
+		}
+		keys[key] = len(merged)
+		merged = append(merged, e)
+	}
+	return merged
+}
+
+// parseEnvFile reads a dotenv style file of KEY=VALUE lines.
+// Empty lines and lines starting with "#" are ignored. Values
+// may be surrounded by single or double quotes, unquoted values
+// may be followed by a " #" comment.
+func parseEnvFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening env file: %v", err)
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		idx := strings.Index(line, "=")
+		if idx < 1 {
+			return nil, fmt.Errorf("invalid line %d in env file %s: expected KEY=VALUE", lineNum, filename)
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			end := strings.IndexByte(value[1:], value[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote on line %d in env file %s", lineNum, filename)
+			}
+			value = value[1 : end+1]
+		} else if idx := strings.Index(value, " #"); idx >= 0 {
+			value = strings.TrimSpace(value[:idx])
+		}
+
+		env = append(env, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading env file %s: %v", filename, err)
+	}
+
+	return env, nil
+}
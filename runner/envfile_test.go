@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, dir, name, content string) string {
+	fp := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func checkEnv(t *testing.T, env, expected []string) {
+	if len(env) != len(expected) {
+		t.Fatalf("Unexpected env %q, expected %q", env, expected)
+	}
+	for i := range expected {
+		if env[i] != expected[i] {
+			t.Fatalf("Unexpected env value %q, expected %q", env[i], expected[i])
+		}
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	fp := writeTempFile(t, td, "test.env", `# Registry settings
+TEST_REGISTRY=localregistry
+
+TEST_USER="test user"
+export TEST_PASSWORD='pass#word'
+TEST_REPO=hello-world # repository to push
+TEST_EMPTY=
+`)
+
+	env, err := parseEnvFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEnv(t, env, []string{
+		"TEST_REGISTRY=localregistry",
+		"TEST_USER=test user",
+		"TEST_PASSWORD=pass#word",
+		"TEST_REPO=hello-world",
+		"TEST_EMPTY=",
+	})
+
+	invalid := writeTempFile(t, td, "invalid.env", "TEST_REGISTRY\n")
+	if _, err := parseEnvFile(invalid); err == nil {
+		t.Fatal("Expected error parsing invalid env file")
+	}
+}
+
+func TestLoadScriptEnv(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	suiteEnv := writeTempFile(t, td, "suite.env", "TEST_REGISTRY=suite\nTEST_USER=suite\n")
+	scriptEnv := writeTempFile(t, td, "script.env", "TEST_USER=script\n")
+
+	env, err := loadScriptEnv(Script{
+		Env:     []string{"TEST_REGISTRY=inline"},
+		EnvFile: []string{suiteEnv, scriptEnv},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEnv(t, env, []string{
+		"TEST_REGISTRY=inline",
+		"TEST_USER=script",
+	})
+
+	if _, err := loadScriptEnv(Script{EnvFile: []string{filepath.Join(td, "missing.env")}}); err == nil {
+		t.Fatal("Expected error loading missing env file")
+	}
+}
@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// DefaultEnvMask are the key patterns of the environment
+// values masked when no mask is configured.
+var DefaultEnvMask = []string{"*PASSWORD*", "*SECRET*", "*TOKEN*", "*CREDENTIAL*", "*_KEY"}
+
+// maskedValue replaces the values of masked keys
+const maskedValue = "****"
+
+// EnvMask is a list of environment key patterns which may be
+// used as a flag value, either comma separated or set multiple
+// times. Patterns use path.Match syntax and match keys without
+// regard to case.
+type EnvMask []string
+
+func (m *EnvMask) String() string {
+	return strings.Join(*m, ",")
+}
+
+// Set adds comma separated key patterns to the mask
+func (m *EnvMask) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid env mask pattern %q: %v", pattern, err)
+		}
+		*m = append(*m, pattern)
+	}
+	return nil
+}
+
+// EnvPrinter prints the environment of commands before they are
+// run, masking the values of keys matching the mask. A nil
+// printer prints nothing.
+type EnvPrinter struct {
+	mask []string
+}
+
+// NewEnvPrinter returns a printer masking keys matching any of
+// the patterns, using DefaultEnvMask when no patterns are given.
+func NewEnvPrinter(mask []string) *EnvPrinter {
+	if len(mask) == 0 {
+		mask = DefaultEnvMask
+	}
+	return &EnvPrinter{
+		mask: mask,
+	}
+}
+
+// masked returns whether the value of the key is masked
+func (p *EnvPrinter) masked(key string) bool {
+	key = strings.ToUpper(key)
+	for _, pattern := range p.mask {
+		if ok, _ := path.Match(strings.ToUpper(pattern), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maskEnv returns the environment with masked values replaced
+func (p *EnvPrinter) maskEnv(env []string) []string {
+	masked := make([]string, 0, len(env))
+	for _, e := range env {
+		if idx := strings.Index(e, "="); idx >= 0 && p.masked(e[:idx]) {
+			e = e[:idx+1] + maskedValue
+		}
+		masked = append(masked, e)
+	}
+	return masked
+}
+
+// Print writes the environment the command will run with,
+// the process environment when the command has none set.
+func (p *EnvPrinter) Print(w io.Writer, cmd *exec.Cmd) {
+	if p == nil {
+		return
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	fmt.Fprintf(w, "Environment for %s:\n", strings.Join(cmd.Args, " "))
+	for _, e := range p.maskEnv(mergeEnv(nil, env)) {
+		fmt.Fprintf(w, "    %s\n", e)
+	}
+}
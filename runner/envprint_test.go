@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnvPrinterMergeOrder(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-envprint-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	os.Setenv("GOLEM_TEST_ORDER", "process")
+	defer os.Unsetenv("GOLEM_TEST_ORDER")
+
+	envFile := writeTempFile(t, td, "test.env", "GOLEM_TEST_ORDER=file\nFROM_FILE=file\nFROM_SCRIPT=file\n")
+	script := TestScript{
+		Script: Script{
+			Command: []string{"true"},
+			Env:     []string{"FROM_SCRIPT=script", "FROM_WRAPPER=script"},
+			EnvFile: []string{envFile},
+		},
+		WrapperEnv: []string{"FROM_WRAPPER=wrapper"},
+	}
+	cmd, err := testCommand(script, []string{"FROM_INSTANCE=instance", "FROM_SCRIPT=instance", "FROM_FILE=instance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	NewEnvPrinter(nil).Print(buf, cmd)
+	out := buf.String()
+	if !strings.HasPrefix(out, "Environment for true:\n") {
+		t.Fatalf("Unexpected header in %q", out)
+	}
+
+	expected := []string{
+		"GOLEM_TEST_ORDER=file",
+		"FROM_FILE=file",
+		"FROM_SCRIPT=script",
+		"FROM_INSTANCE=instance",
+		"FROM_WRAPPER=wrapper",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, "    "+e+"\n") {
+			t.Fatalf("Missing %s in %q", e, out)
+		}
+	}
+	if strings.Count(out, "GOLEM_TEST_ORDER=") != 1 {
+		t.Fatalf("Expected single GOLEM_TEST_ORDER value in %q", out)
+	}
+}
+
+func TestEnvPrinterMask(t *testing.T) {
+	env := []string{
+		"REGISTRY_PASSWORD=hunter2",
+		"github_token=abc",
+		"AWS_ACCESS_KEY=AKIA",
+		"KEYBOARD=us",
+		"PATH=/bin",
+		"EMPTY",
+	}
+
+	masked := NewEnvPrinter(nil).maskEnv(env)
+	expected := []string{
+		"REGISTRY_PASSWORD=****",
+		"github_token=****",
+		"AWS_ACCESS_KEY=****",
+		"KEYBOARD=us",
+		"PATH=/bin",
+		"EMPTY",
+	}
+	for i := range expected {
+		if masked[i] != expected[i] {
+			t.Fatalf("Unexpected masked value %d: %q, expected %q", i, masked[i], expected[i])
+		}
+	}
+
+	var mask EnvMask
+	if err := mask.Set("PATH, keyboard"); err != nil {
+		t.Fatal(err)
+	}
+	masked = NewEnvPrinter(mask).maskEnv(env)
+	if masked[0] != "REGISTRY_PASSWORD=hunter2" || masked[3] != "KEYBOARD=****" || masked[4] != "PATH=****" {
+		t.Fatalf("Unexpected values with configured mask: %v", masked)
+	}
+
+	if err := mask.Set("[bad"); err == nil {
+		t.Fatal("Expected error for invalid pattern")
+	}
+}
+
+func TestRunTestsPrintEnv(t *testing.T) {
+	capturer := newBufferLogger()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{Script: Script{Command: []string{"true"}, Env: []string{"API_SECRET=s3cr3t"}}},
+			},
+		},
+		TestCapturer: capturer,
+		EnvPrinter:   NewEnvPrinter(nil),
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if out := capturer.stderr.String(); !strings.Contains(out, "    API_SECRET=****\n") || strings.Contains(out, "s3cr3t") {
+		t.Fatalf("Unexpected printed environment %q", out)
+	}
+	if out := capturer.stdout.String(); out != "" {
+		t.Fatalf("Unexpected stdout %q", out)
+	}
+}
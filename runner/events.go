@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an event recorded in the event log.
+type EventType string
+
+const (
+	// EventConfigResolved is recorded once the run configuration
+	// has been resolved from flags and configuration files.
+	EventConfigResolved EventType = "config-resolved"
+
+	// EventImagePulled is recorded after an image is pulled.
+	EventImagePulled EventType = "image-pulled"
+
+	// EventImageSaved is recorded after an image is saved
+	// into a base image, with the size of the saved image.
+	EventImageSaved EventType = "image-saved"
+
+	// EventBaseImageBuilt is recorded after a base image is built.
+	EventBaseImageBuilt EventType = "base-image-built"
+
+	// EventBuildStarted is recorded when an instance image build starts.
+	EventBuildStarted EventType = "build-started"
+
+	// EventBuildFinished is recorded when an instance image build
+	// ends, with the error when the build failed.
+	EventBuildFinished EventType = "build-finished"
+
+	// EventInstanceResult is recorded after an instance has run.
+	EventInstanceResult EventType = "instance-result"
+
+	// EventInstanceRepeatResult is recorded after an instance
+	// has been run repeatedly, with the counts of all runs.
+	EventInstanceRepeatResult EventType = "instance-repeat-result"
+
+	// EventRunSummary is recorded at the end of a run.
+	EventRunSummary EventType = "run-summary"
+
+	// EventRunFailed is recorded when the build or run fails
+	// with an error, including when any test fails.
+	EventRunFailed EventType = "run-failed"
+)
+
+// Event is a single entry in the event log. Only the fields
+// relevant to the event type are set.
+type Event struct {
+	Type      EventType       `json:"type"`
+	Time      time.Time       `json:"time"`
+	Instance  string          `json:"instance,omitempty"`
+	Image     string          `json:"image,omitempty"`
+	Instances []string        `json:"instances,omitempty"`
+	Elapsed   time.Duration   `json:"elapsed,omitempty"`
+	Bytes     int64           `json:"bytes,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Result    *InstanceResult `json:"result,omitempty"`
+	Summary   *RunSummary     `json:"summary,omitempty"`
+}
+
+// EventLog records run events as JSON lines. Each event is
+// written with a single write so a partially written log
+// remains parseable line by line. A nil EventLog discards
+// all events.
+type EventLog struct {
+	l      sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewEventLog creates an event log writing to the provided writer.
+func NewEventLog(w io.Writer) *EventLog {
+	return &EventLog{
+		w: w,
+	}
+}
+
+// OpenEventLog opens an event log file at the provided path,
+// creating the parent directory and appending to any existing
+// file so the events of resumed runs follow earlier runs.
+func OpenEventLog(filename string) (*EventLog, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{
+		w:      f,
+		closer: f,
+	}, nil
+}
+
+// Log writes an event to the log, setting the event
+// time if not already set.
+func (el *EventLog) Log(e Event) error {
+	if el == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	el.l.Lock()
+	defer el.l.Unlock()
+	_, err = el.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying event log file.
+func (el *EventLog) Close() error {
+	if el == nil || el.closer == nil {
+		return nil
+	}
+	return el.closer.Close()
+}
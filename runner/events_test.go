@@ -0,0 +1,213 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestEventLog(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	el := NewEventLog(buf)
+
+	events := []Event{
+		{Type: EventConfigResolved, Instances: []string{"suite-1", "suite-2"}},
+		{Type: EventBuildStarted, Instance: "suite-1", Image: "golem-suite-1:latest"},
+		{Type: EventImagePulled, Image: "busybox:latest", Elapsed: time.Second},
+		{Type: EventBuildFinished, Instance: "suite-1", Image: "golem-suite-1:latest", Elapsed: 2 * time.Second},
+		{Type: EventInstanceResult, Instance: "suite-1", Result: &InstanceResult{Name: "suite-1", ExitCode: 1}},
+		{Type: EventRunSummary, Summary: &RunSummary{Ran: 1, Failed: 1}},
+	}
+	for _, e := range events {
+		if err := el.Log(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanner := bufio.NewScanner(buf)
+	var i int
+	for ; scanner.Scan(); i++ {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Error decoding line %d: %v", i+1, err)
+		}
+		if i >= len(events) {
+			t.Fatalf("Unexpected event %#v", e)
+		}
+		if e.Type != events[i].Type {
+			t.Fatalf("Unexpected event type %q, expected %q", e.Type, events[i].Type)
+		}
+		if e.Time.IsZero() {
+			t.Fatalf("Missing time on event %d", i+1)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(events) {
+		t.Fatalf("Unexpected number of events %d, expected %d", i, len(events))
+	}
+}
+
+func TestNilEventLog(t *testing.T) {
+	var el *EventLog
+	if err := el.Log(Event{Type: EventRunSummary}); err != nil {
+		t.Fatal(err)
+	}
+	if err := el.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenEventLogAppends(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-events-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	filename := filepath.Join(td, "logs", "run.jsonl")
+	for _, et := range []EventType{EventRunSummary, EventConfigResolved} {
+		el, err := OpenEventLog(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := el.Log(Event{Type: et}); err != nil {
+			t.Fatal(err)
+		}
+		if err := el.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEventTypes(t, readEvents(t, bytes.NewBuffer(b)), EventRunSummary, EventConfigResolved)
+}
+
+// readEvents decodes the event log, checking each line
+// is independently parseable.
+func readEvents(t *testing.T, buf *bytes.Buffer) []Event {
+	var events []Event
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Error decoding line %d: %v", len(events)+1, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func checkEventTypes(t *testing.T, events []Event, expected ...EventType) {
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events %#v, expected types %v", events, expected)
+	}
+	for i, e := range events {
+		if e.Type != expected[i] {
+			t.Fatalf("Unexpected event %d type %q, expected %q", i+1, e.Type, expected[i])
+		}
+	}
+}
+
+func TestRunEventLog(t *testing.T) {
+	d := newRunDaemon(t)
+	cli, stop := d.client()
+	defer stop()
+
+	d.addImage("golem-pass:latest", "sha256:01", RunConfiguration{})
+	d.addImage("golem-fail:latest", "sha256:02", RunConfiguration{})
+	d.exitCodes["golem-0a1b2c-fail"] = 1
+
+	buf := bytes.NewBuffer(nil)
+	config := RunnerConfiguration{
+		RunID: "0a1b2c",
+		Suites: []SuiteConfiguration{
+			{
+				Name: "suite",
+				Instances: []InstanceConfiguration{
+					{Name: "pass"},
+					{Name: "fail"},
+				},
+			},
+		},
+		EventLog: NewEventLog(buf),
+	}
+	r := NewRunner(config, CacheConfiguration{}, false)
+	if err := r.Run(context.Background(), cli); err == nil {
+		t.Fatal("Expected test failure")
+	}
+
+	events := readEvents(t, buf)
+	checkEventTypes(t, events, EventInstanceResult, EventInstanceResult, EventRunSummary, EventRunFailed)
+	if r := events[0].Result; r == nil || r.Name != "pass" || !r.Passed {
+		t.Fatalf("Unexpected first result %#v", r)
+	}
+	if r := events[1].Result; r == nil || r.Name != "fail" || r.Passed || r.ExitCode != 1 {
+		t.Fatalf("Unexpected second result %#v", r)
+	}
+	if s := events[2].Summary; s == nil || s.Ran != 2 || s.Failed != 1 {
+		t.Fatalf("Unexpected summary %#v", s)
+	}
+	if !strings.Contains(events[3].Error, "1 of 2 tests failed") {
+		t.Fatalf("Unexpected run failure error %q", events[3].Error)
+	}
+}
+
+func TestBuildFailureEventLog(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-events-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	d := newRunDaemon(t)
+	cli, stop := d.client()
+	defer stop()
+
+	buf := bytes.NewBuffer(nil)
+	config := RunnerConfiguration{
+		RunID: "0a1b2c",
+		Suites: []SuiteConfiguration{
+			{
+				Name: "suite",
+				Instances: []InstanceConfiguration{
+					{Name: "missing", BaseImage: BaseImageConfiguration{Base: assertTagged("busybox:latest")}},
+				},
+			},
+		},
+		// The missing image is rebuilt and the build fails
+		ImageMismatch: MismatchRebuild,
+		EventLog:      NewEventLog(buf),
+	}
+	r := NewRunner(config, CacheConfiguration{ImageCache: NewImageCache(td)}, false)
+	if err := r.Run(context.Background(), cli); err == nil {
+		t.Fatal("Expected build failure")
+	}
+
+	events := readEvents(t, buf)
+	checkEventTypes(t, events, EventBuildStarted, EventBuildFinished, EventRunFailed)
+	if events[1].Error == "" {
+		t.Fatal("Missing error on failed build")
+	}
+	if events[2].Error != events[1].Error {
+		t.Fatalf("Unexpected run failure error %q, expected %q", events[2].Error, events[1].Error)
+	}
+	if len(d.createdContainers()) != 0 {
+		t.Fatalf("Unexpected containers created: %v", d.createdContainers())
+	}
+}
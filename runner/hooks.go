@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// RunHook is a script run on the host once per run, either
+// before any suite or after all suites have run. The script
+// is run from the directory of the configuration file which
+// declared it.
+type RunHook struct {
+	Script
+	Dir string
+}
+
+// RunHooks are the scripts run around all suites in a run.
+type RunHooks struct {
+	BeforeAll []RunHook
+	AfterAll  []RunHook
+}
+
+// newRunHooks creates the run hooks declared in a configuration
+// file in the given directory.
+func newRunHooks(dir string, conf suitesConfiguration) RunHooks {
+	toHooks := func(scripts []pretestConfiguration) []RunHook {
+		var hooks []RunHook
+		for _, script := range scripts {
+			hook := RunHook{
+				Script: Script{
+					// TODO: respect quoted values
+					Command: strings.Split(script.Command, " "),
+					Env:     script.Env,
+				},
+				Dir: dir,
+			}
+			if script.EnvFile != "" {
+				hook.EnvFile = []string{filepath.Join(dir, script.EnvFile)}
+			}
+			hooks = append(hooks, hook)
+		}
+		return hooks
+	}
+	return RunHooks{
+		BeforeAll: toHooks(conf.BeforeAll),
+		AfterAll:  toHooks(conf.AfterAll),
+	}
+}
+
+// runHook runs the hook command with the host environment
+// and the hook environment, capturing output to the capturer.
+func runHook(lc LogCapturer, hook RunHook) error {
+	cmd := exec.Command(hook.Command[0], hook.Command[1:]...)
+	cmd.Dir = hook.Dir
+	cmd.Stdout = lc.Stdout()
+	cmd.Stderr = lc.Stderr()
+	env, err := loadScriptEnv(hook.Script)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.Run()
+}
+
+// runWithHooks runs the before all hooks followed by fn. The
+// after all hooks are always run once the before all hooks have
+// started, even when a hook or fn fails. The first error is
+// returned.
+func runWithHooks(hooks RunHooks, lc LogCapturer, fn func() error) (err error) {
+	defer func() {
+		for _, hook := range hooks.AfterAll {
+			if hookErr := runHook(lc, hook); hookErr != nil {
+				hookErr = fmt.Errorf("after_all %s failed: %v", strings.Join(hook.Command, " "), hookErr)
+				if err == nil {
+					err = hookErr
+				} else {
+					logrus.Error(hookErr)
+				}
+			}
+		}
+	}()
+
+	for _, hook := range hooks.BeforeAll {
+		if err := runHook(lc, hook); err != nil {
+			return fmt.Errorf("before_all %s failed: %v", strings.Join(hook.Command, " "), err)
+		}
+	}
+
+	return fn()
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHooks(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "golem.conf", `[[before_all]]
+  command="/bin/sh ./hook.sh before"
+[[after_all]]
+  command="/bin/sh ./hook.sh after"
+  env=["HOOK_SUFFIX=-done"]
+
+[[suite]]
+  name="hooks"
+  [[suite.testrunner]]
+    command="true"
+`)
+	writeTempFile(t, td, "hook.sh", "echo \"$1$HOOK_SUFFIX\" >> hooks.log\n")
+
+	_, hooks, err := parseConfiguration([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks.BeforeAll) != 1 || len(hooks.AfterAll) != 1 {
+		t.Fatalf("Unexpected hooks %#v", hooks)
+	}
+
+	hookLog := filepath.Join(td, "hooks.log")
+	readLog := func() string {
+		b, err := ioutil.ReadFile(hookLog)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	for _, fnErr := range []error{nil, errors.New("suite failed")} {
+		if err := os.RemoveAll(hookLog); err != nil {
+			t.Fatal(err)
+		}
+
+		var suitesRun int
+		err := runWithHooks(hooks, nilLogger{}, func() error {
+			suitesRun++
+			if log := readLog(); log != "before\n" {
+				t.Fatalf("Unexpected hook log before suites %q", log)
+			}
+			return fnErr
+		})
+		if err != fnErr {
+			t.Fatalf("Unexpected error %v, expected %v", err, fnErr)
+		}
+		if suitesRun != 1 {
+			t.Fatalf("Unexpected suite runs %d", suitesRun)
+		}
+		if log := readLog(); log != "before\nafter-done\n" {
+			t.Fatalf("Unexpected hook log %q", log)
+		}
+	}
+}
+
+func TestRunHooksBeforeAllFailure(t *testing.T) {
+	lc := newBufferLogger()
+	hooks := RunHooks{
+		BeforeAll: []RunHook{{Script: Script{Command: []string{"false"}}}},
+		AfterAll:  []RunHook{{Script: Script{Command: []string{"echo", "after"}}}},
+	}
+
+	err := runWithHooks(hooks, lc, func() error {
+		t.Fatal("Suites run after before_all failure")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "before_all") {
+		t.Fatalf("Expected before_all error, got %v", err)
+	}
+	if lc.stdout.String() != "after\n" {
+		t.Fatalf("Expected after_all to run, output %q", lc.stdout.String())
+	}
+}
@@ -0,0 +1,417 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/engine-api/types/versions"
+	"golang.org/x/net/context"
+)
+
+// ImageFormat is the format images are exported in when
+// saved into base images.
+type ImageFormat string
+
+const (
+	// ImageFormatDocker exports images using the daemon's
+	// docker save tar format
+	ImageFormatDocker ImageFormat = "docker"
+
+	// ImageFormatOCI exports images as an OCI image layout
+	// tar converted from the docker save tar
+	ImageFormatOCI ImageFormat = "oci"
+)
+
+const (
+	// ociMinAPIVersion is the minimum daemon API version saving
+	// images with manifest.json, required to convert to OCI
+	ociMinAPIVersion = "1.22"
+
+	ociLayoutVersion     = "1.0.0"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+func (f *ImageFormat) String() string {
+	return string(*f)
+}
+
+// Set sets the image format from a string, allowing the
+// format to be used as a flag value.
+func (f *ImageFormat) Set(s string) error {
+	switch format := ImageFormat(s); format {
+	case ImageFormatDocker, ImageFormatOCI:
+		*f = format
+		return nil
+	}
+	return fmt.Errorf("invalid image format %q, must be one of docker or oci", s)
+}
+
+// imageFile returns the name of the file an image is saved
+// to in the images directory for the format.
+func (f ImageFormat) imageFile(imgID string) string {
+	if f == ImageFormatOCI {
+		return imgID + ".oci.tar"
+	}
+	return imgID + ".tar"
+}
+
+// checkImageFormatSupport returns an error if the daemon does
+// not support exporting images in the given format.
+func checkImageFormatSupport(ctx context.Context, cli serverVersioner, format ImageFormat) error {
+	if format != ImageFormatOCI {
+		return nil
+	}
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting server version: %v", err)
+	}
+	if versions.LessThan(v.APIVersion, ociMinAPIVersion) {
+		return fmt.Errorf("daemon API version %s does not support the oci image format, requires %s or later", v.APIVersion, ociMinAPIVersion)
+	}
+	return nil
+}
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// blobName returns the path of a blob in an OCI image layout
+func blobName(d digest.Digest) string {
+	return path.Join("blobs", d.Algorithm().String(), d.Hex())
+}
+
+// walkTar calls fn for each regular file in a tar
+func walkTar(r io.Reader, fn func(name string, hdr *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := fn(path.Clean(hdr.Name), hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func writeTarJSON(tw *tar.Writer, name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, name, int64(len(b)), bytes.NewReader(b))
+}
+
+// convertToOCI converts a docker save tar into an OCI image layout
+// tar. The docker tar is read twice, first to digest the config and
+// layer files and then to copy them into the layout as blobs.
+func convertToOCI(src io.ReadSeeker, w io.Writer) error {
+	var manifest []savedManifest
+	digests := map[string]ociDescriptor{}
+	err := walkTar(src, func(name string, hdr *tar.Header, r io.Reader) error {
+		if name == "manifest.json" {
+			if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+				return fmt.Errorf("error decoding manifest.json: %v", err)
+			}
+			return nil
+		}
+		d, err := digest.FromReader(r)
+		if err != nil {
+			return fmt.Errorf("error digesting %s: %v", name, err)
+		}
+		digests[name] = ociDescriptor{Digest: d, Size: hdr.Size}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return fmt.Errorf("no manifest.json in saved image tar")
+	}
+
+	descriptor := func(name, mediaType string) (ociDescriptor, error) {
+		desc, ok := digests[path.Clean(name)]
+		if !ok {
+			return ociDescriptor{}, fmt.Errorf("missing %s referenced by manifest.json", name)
+		}
+		desc.MediaType = mediaType
+		return desc, nil
+	}
+
+	blobs := map[string]digest.Digest{}
+	index := ociIndex{SchemaVersion: 2}
+	var manifests [][]byte
+	for _, m := range manifest {
+		om := ociManifest{
+			SchemaVersion: 2,
+			MediaType:     ociManifestMediaType,
+		}
+		if om.Config, err = descriptor(m.Config, ociConfigMediaType); err != nil {
+			return err
+		}
+		blobs[path.Clean(m.Config)] = om.Config.Digest
+		for _, l := range m.Layers {
+			desc, err := descriptor(l, ociLayerMediaType)
+			if err != nil {
+				return err
+			}
+			blobs[path.Clean(l)] = desc.Digest
+			om.Layers = append(om.Layers, desc)
+		}
+
+		b, err := json.Marshal(om)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, b)
+		desc := ociDescriptor{
+			MediaType: ociManifestMediaType,
+			Digest:    digest.FromBytes(b),
+			Size:      int64(len(b)),
+		}
+		if len(m.RepoTags) == 0 {
+			index.Manifests = append(index.Manifests, desc)
+		}
+		for _, t := range m.RepoTags {
+			desc.Annotations = map[string]string{ociRefNameAnnotation: t}
+			index.Manifests = append(index.Manifests, desc)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeTarJSON(tw, "oci-layout", ociLayout{ImageLayoutVersion: ociLayoutVersion}); err != nil {
+		return err
+	}
+	if err := writeTarJSON(tw, "index.json", index); err != nil {
+		return err
+	}
+	written := map[digest.Digest]struct{}{}
+	for _, b := range manifests {
+		d := digest.FromBytes(b)
+		if _, ok := written[d]; ok {
+			continue
+		}
+		written[d] = struct{}{}
+		if err := writeTarFile(tw, blobName(d), int64(len(b)), bytes.NewReader(b)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := src.Seek(0, 0); err != nil {
+		return fmt.Errorf("error seeking saved image tar: %v", err)
+	}
+	err = walkTar(src, func(name string, hdr *tar.Header, r io.Reader) error {
+		d, ok := blobs[name]
+		if !ok {
+			return nil
+		}
+		if _, ok := written[d]; ok {
+			return nil
+		}
+		written[d] = struct{}{}
+		return writeTarFile(tw, blobName(d), hdr.Size, r)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// convertFromOCI converts an OCI image layout tar into a tar which
+// may be loaded by the daemon. The layout is read twice, first for
+// the index and then to copy the blobs and read the manifests. Repo
+// tags are not included, images are tagged after loading.
+func convertFromOCI(src io.ReadSeeker, w io.Writer) error {
+	var (
+		layout ociLayout
+		index  ociIndex
+	)
+	err := walkTar(src, func(name string, hdr *tar.Header, r io.Reader) error {
+		switch name {
+		case "oci-layout":
+			if err := json.NewDecoder(r).Decode(&layout); err != nil {
+				return fmt.Errorf("error decoding oci-layout: %v", err)
+			}
+		case "index.json":
+			if err := json.NewDecoder(r).Decode(&index); err != nil {
+				return fmt.Errorf("error decoding index.json: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if layout.ImageLayoutVersion != ociLayoutVersion {
+		return fmt.Errorf("unsupported oci layout version %q", layout.ImageLayoutVersion)
+	}
+
+	manifestBlobs := map[string]struct{}{}
+	for _, desc := range index.Manifests {
+		if desc.MediaType != ociManifestMediaType {
+			return fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+		}
+		manifestBlobs[blobName(desc.Digest)] = struct{}{}
+	}
+
+	if _, err := src.Seek(0, 0); err != nil {
+		return fmt.Errorf("error seeking oci image tar: %v", err)
+	}
+	tw := tar.NewWriter(w)
+	manifests := map[string]ociManifest{}
+	err = walkTar(src, func(name string, hdr *tar.Header, r io.Reader) error {
+		if !strings.HasPrefix(name, "blobs/") {
+			return nil
+		}
+		if _, ok := manifestBlobs[name]; ok {
+			var m ociManifest
+			if err := json.NewDecoder(r).Decode(&m); err != nil {
+				return fmt.Errorf("error decoding manifest %s: %v", name, err)
+			}
+			manifests[name] = m
+			return nil
+		}
+		return writeTarFile(tw, name, hdr.Size, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	type loadManifest struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	var load []loadManifest
+	seen := map[string]struct{}{}
+	for _, desc := range index.Manifests {
+		name := blobName(desc.Digest)
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		m, ok := manifests[name]
+		if !ok {
+			return fmt.Errorf("missing manifest blob %s", desc.Digest)
+		}
+		lm := loadManifest{Config: blobName(m.Config.Digest)}
+		for _, l := range m.Layers {
+			lm.Layers = append(lm.Layers, blobName(l.Digest))
+		}
+		load = append(load, lm)
+	}
+	if err := writeTarJSON(tw, "manifest.json", load); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// exportOCI converts a saved docker image tar to an OCI image
+// layout tar, removing the docker tar once converted.
+func exportOCI(dockerTar, ociTar string) error {
+	src, err := os.Open(dockerTar)
+	if err != nil {
+		return fmt.Errorf("error opening saved image tar: %v", err)
+	}
+	defer os.Remove(dockerTar)
+	defer src.Close()
+
+	f, err := os.Create(ociTar)
+	if err != nil {
+		return fmt.Errorf("error creating oci image tar: %v", err)
+	}
+	defer f.Close()
+
+	if err := convertToOCI(src, f); err != nil {
+		return fmt.Errorf("error converting %s to oci: %v", dockerTar, err)
+	}
+	return nil
+}
+
+// exportImage saves an image to the images directory in the
+// given format.
+func exportImage(cli imageSaver, imagesDir, imgID string, format ImageFormat) error {
+	dockerTar := filepath.Join(imagesDir, imgID+".tar")
+	if err := saveImage(cli, dockerTar, imgID); err != nil {
+		return err
+	}
+	if format != ImageFormatOCI {
+		return nil
+	}
+	return exportOCI(dockerTar, filepath.Join(imagesDir, format.imageFile(imgID)))
+}
+
+// openImageTar opens the saved tar of an image in the images
+// directory, converting OCI image layout tars into a tar which
+// may be loaded by the daemon.
+func openImageTar(imageRoot, imageID string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(imageRoot, ImageFormatDocker.imageFile(imageID)))
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err = os.Open(filepath.Join(imageRoot, ImageFormatOCI.imageFile(imageID)))
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := convertFromOCI(f, pw)
+		f.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
@@ -0,0 +1,174 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"golang.org/x/net/context"
+)
+
+// fakeImageClient saves images from docker save tars and
+// records the tars loaded.
+type fakeImageClient struct {
+	saved  map[string][]byte
+	loaded [][]byte
+}
+
+func (c *fakeImageClient) ImageSave(ctx context.Context, imageIDs []string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(c.saved[imageIDs[0]])), nil
+}
+
+func (c *fakeImageClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	b, err := ioutil.ReadAll(input)
+	if err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	c.loaded = append(c.loaded, b)
+	return types.ImageLoadResponse{Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+}
+
+func buildTar(t *testing.T, files map[string][]byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		if err := writeTarFile(tw, name, int64(len(content)), bytes.NewReader(content)); err != nil {
+			t.Fatalf("Error writing tar file %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error closing tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readTar(t *testing.T, r io.Reader) map[string][]byte {
+	files := map[string][]byte{}
+	err := walkTar(r, func(name string, hdr *tar.Header, r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		files[name] = b
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error reading tar: %v", err)
+	}
+	return files
+}
+
+func TestImageFormatRoundTrip(t *testing.T) {
+	hex := "4ab4c602aa5eed5528a6620ff18a1dc4faef0e1ab3a5eddeddb410714478c67f"
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layers := [][]byte{[]byte("base layer"), []byte("top layer")}
+	manifest, err := json.Marshal([]savedManifest{{
+		Config:   hex + ".json",
+		RepoTags: []string{"busybox:latest"},
+		Layers:   []string{"l1/layer.tar", "l2/layer.tar"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &fakeImageClient{
+		saved: map[string][]byte{
+			"sha256:" + hex: buildTar(t, map[string][]byte{
+				"manifest.json": manifest,
+				hex + ".json":   config,
+				"l1/layer.tar":  layers[0],
+				"l2/layer.tar":  layers[1],
+				"l1/json":       []byte("{}"),
+				"repositories":  []byte("{}"),
+			}),
+		},
+	}
+
+	for _, format := range []ImageFormat{ImageFormatDocker, ImageFormatOCI} {
+		td, err := ioutil.TempDir("", "golem-image-format-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(td)
+
+		if err := exportImage(cli, td, "sha256:"+hex, format); err != nil {
+			t.Fatalf("Error exporting %s image: %v", format, err)
+		}
+		entries, err := ioutil.ReadDir(td)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != format.imageFile("sha256:"+hex) {
+			t.Fatalf("Unexpected images directory contents for %s: %v", format, entries)
+		}
+
+		if format == ImageFormatOCI {
+			f, err := os.Open(filepath.Join(td, entries[0].Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := readTar(t, f)
+			f.Close()
+			if _, ok := files["oci-layout"]; !ok {
+				t.Fatalf("Missing oci-layout in %v", files)
+			}
+			var index ociIndex
+			if err := json.Unmarshal(files["index.json"], &index); err != nil {
+				t.Fatalf("Error decoding index.json: %v", err)
+			}
+			if len(index.Manifests) != 1 || index.Manifests[0].Annotations[ociRefNameAnnotation] != "busybox:latest" {
+				t.Fatalf("Unexpected index: %#v", index)
+			}
+		}
+
+		cli.loaded = nil
+		if _, err := imageLoad(context.Background(), cli, td, "sha256:"+hex, ioutil.Discard, true); err != nil {
+			t.Fatalf("Error loading %s image: %v", format, err)
+		}
+		if len(cli.loaded) != 1 {
+			t.Fatalf("Expected 1 image load, got %d", len(cli.loaded))
+		}
+
+		files := readTar(t, bytes.NewReader(cli.loaded[0]))
+		var loaded []savedManifest
+		if err := json.Unmarshal(files["manifest.json"], &loaded); err != nil {
+			t.Fatalf("Error decoding loaded manifest.json: %v", err)
+		}
+		if len(loaded) != 1 || len(loaded[0].Layers) != len(layers) {
+			t.Fatalf("Unexpected loaded manifest for %s: %#v", format, loaded)
+		}
+		if !bytes.Equal(files[loaded[0].Config], config) {
+			t.Fatalf("Unexpected loaded config for %s: %q", format, files[loaded[0].Config])
+		}
+		for i, l := range loaded[0].Layers {
+			if !bytes.Equal(files[l], layers[i]) {
+				t.Fatalf("Unexpected loaded layer %d for %s: %q", i, format, files[l])
+			}
+		}
+	}
+}
+
+func TestConvertToOCIRequiresManifest(t *testing.T) {
+	legacy := buildTar(t, map[string][]byte{
+		"abc/layer.tar": []byte("layer"),
+		"repositories":  []byte("{}"),
+	})
+	if err := convertToOCI(bytes.NewReader(legacy), ioutil.Discard); err == nil {
+		t.Fatal("Expected error converting tar without manifest.json")
+	}
+}
+
+func TestCheckImageFormatSupport(t *testing.T) {
+	ctx := context.Background()
+	if err := checkImageFormatSupport(ctx, &fakeVersionNegotiator{apiVersion: "1.21"}, ImageFormatDocker); err != nil {
+		t.Fatalf("Unexpected error for docker format: %v", err)
+	}
+	if err := checkImageFormatSupport(ctx, &fakeVersionNegotiator{apiVersion: "1.21"}, ImageFormatOCI); err == nil {
+		t.Fatal("Expected error for oci format with API version 1.21")
+	}
+	if err := checkImageFormatSupport(ctx, &fakeVersionNegotiator{apiVersion: "1.24"}, ImageFormatOCI); err != nil {
+		t.Fatalf("Unexpected error for oci format with API version 1.24: %v", err)
+	}
+}
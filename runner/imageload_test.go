@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"golang.org/x/net/context"
+)
+
+// responseLoader responds to image loads with a fixed response
+type responseLoader struct {
+	body  string
+	json  bool
+	quiet bool
+}
+
+func (l *responseLoader) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	l.quiet = quiet
+	if _, err := ioutil.ReadAll(input); err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+	return types.ImageLoadResponse{
+		Body: ioutil.NopCloser(strings.NewReader(l.body)),
+		JSON: l.json,
+	}, nil
+}
+
+func TestImageLoadOutput(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-imageload-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	imageID := "sha256:3b8e8e4b1a1b"
+	writeTempFile(t, td, imageID+".tar", "image")
+
+	cases := []struct {
+		name       string
+		loader     *responseLoader
+		quiet      bool
+		expectedID string
+		expected   string
+	}{
+		{
+			name: "json",
+			loader: &responseLoader{
+				json: true,
+				body: `{"status":"Loading layer","progressDetail":{"current":512,"total":1024},"progress":"[=====>     ] 512 B/1.024 kB","id":"8ddc19f16526"}
+{"status":"Loading layer","progressDetail":{"current":1024,"total":1024},"id":"8ddc19f16526"}
+{"stream":"Loaded image ID: sha256:0f864637f229\n"}
+`,
+			},
+			expectedID: "sha256:0f864637f229",
+			expected:   "Loaded image ID: sha256:0f864637f229\n",
+		},
+		{
+			name:       "plain",
+			loader:     &responseLoader{body: "Loaded image ID: sha256:0f864637f229\n"},
+			quiet:      true,
+			expectedID: "sha256:0f864637f229",
+			expected:   "Loaded image ID: sha256:0f864637f229\n",
+		},
+		{
+			name:       "unreported",
+			loader:     &responseLoader{},
+			quiet:      true,
+			expectedID: imageID,
+		},
+	}
+
+	for _, c := range cases {
+		capturer := newBufferLogger()
+		loadedID, err := imageLoad(context.Background(), c.loader, td, imageID, capturer.Stdout(), c.quiet)
+		if err != nil {
+			t.Fatalf("%s: error loading image: %v", c.name, err)
+		}
+		if c.loader.quiet != c.quiet {
+			t.Fatalf("%s: unexpected quiet %t", c.name, c.loader.quiet)
+		}
+		if loadedID != c.expectedID {
+			t.Fatalf("%s: unexpected loaded image %s, expected %s", c.name, loadedID, c.expectedID)
+		}
+		if out := capturer.stdout.String(); out != c.expected {
+			t.Fatalf("%s: unexpected load output %q, expected %q", c.name, out, c.expected)
+		}
+	}
+
+	errLoader := &responseLoader{
+		json: true,
+		body: `{"errorDetail":{"message":"unexpected EOF"},"error":"unexpected EOF"}`,
+	}
+	if _, err := imageLoad(context.Background(), errLoader, td, imageID, ioutil.Discard, true); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+		t.Fatalf("Expected load error, got %v", err)
+	}
+}
+
+// fakeSyncClient is an image sync client with inspectable images
+// which are not listed, such as untagged images
+type fakeSyncClient struct {
+	responseLoader
+	listed    []types.Image
+	inspected map[string]types.ImageInspect
+	loads     int
+	tags      []string
+}
+
+func (c *fakeSyncClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.Image, error) {
+	return c.listed, nil
+}
+
+func (c *fakeSyncClient) ImageInspectWithRaw(ctx context.Context, imageID string, getSize bool) (types.ImageInspect, []byte, error) {
+	img, ok := c.inspected[imageID]
+	if !ok {
+		return types.ImageInspect{}, nil, errors.New("no such image")
+	}
+	return img, nil, nil
+}
+
+func (c *fakeSyncClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	c.loads++
+	return c.responseLoader.ImageLoad(ctx, input, quiet)
+}
+
+func (c *fakeSyncClient) ImageTag(ctx context.Context, imageID, ref string, options types.ImageTagOptions) error {
+	c.tags = append(c.tags, imageID+" "+ref)
+	return nil
+}
+
+func (c *fakeSyncClient) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDelete, error) {
+	return nil, nil
+}
+
+func TestSyncImagesPresentUntagged(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-imagesync-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	present := "sha256:3b8e8e4b1a1b"
+	missing := "sha256:0f864637f229"
+	writeTempFile(t, td, "images.json", `{"`+present+`":["golang:1.7","golang:latest"],"`+missing+`":["busybox:latest"]}`)
+	writeTempFile(t, td, missing+".tar", "image")
+
+	cli := &fakeSyncClient{
+		inspected: map[string]types.ImageInspect{
+			present: {ID: present, RepoTags: []string{"golang:1.7"}},
+		},
+	}
+	if err := syncImages(context.Background(), cli, td, ReferenceStrict, false, ioutil.Discard, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if cli.loads != 1 {
+		t.Fatalf("Expected only the missing image to be loaded, got %d loads", cli.loads)
+	}
+	sort.Strings(cli.tags)
+	expected := []string{
+		missing + " busybox:latest",
+		present + " golang:latest",
+	}
+	if !reflect.DeepEqual(cli.tags, expected) {
+		t.Fatalf("Unexpected tags %v, expected %v", cli.tags, expected)
+	}
+}
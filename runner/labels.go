@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// labelSpecial holds the characters escaped in label keys and
+// values, those treated specially by the dockramp lexer and by
+// its shell word processing of LABEL arguments.
+const labelSpecial = "<#'\" \\$\t"
+
+// validateLabels checks that suite labels have non-empty keys
+// which do not use the label namespaces reserved by golem, and
+// that keys and values can be written to a Dockerfile.
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if key == "" {
+			return fmt.Errorf("label key must not be empty")
+		}
+		if strings.HasPrefix(key, golemLabel) || strings.HasPrefix(key, "golem.") {
+			return fmt.Errorf("label %s uses a namespace reserved by golem", key)
+		}
+		if err := checkLabelWord(key); err != nil {
+			return fmt.Errorf("invalid label key %q: %v", key, err)
+		}
+		if err := checkLabelWord(value); err != nil {
+			return fmt.Errorf("invalid value for label %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// checkLabelWord returns an error for label keys and values
+// which cannot pass through the dockramp Dockerfile parser,
+// which does not support line breaks or non-ASCII characters.
+func checkLabelWord(s string) error {
+	for _, c := range s {
+		if c > '~' || (c < ' ' && c != '\t') {
+			return fmt.Errorf("unsupported character %q", c)
+		}
+	}
+	return nil
+}
+
+// quoteLabelWord escapes a label key or value as a single
+// dockramp LABEL argument.
+func quoteLabelWord(s string) string {
+	if s == "" {
+		return `""`
+	}
+	var b bytes.Buffer
+	for _, c := range s {
+		if strings.ContainsRune(labelSpecial, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// instanceLabels returns the labels for a test instance
+// container, the suite labels along with the golem labels
+// identifying the run.
+func instanceLabels(suiteLabels map[string]string, runID string) map[string]string {
+	labels := make(map[string]string, len(suiteLabels)+2)
+	for k, v := range suiteLabels {
+		labels[k] = v
+	}
+	labels[golemLabel] = "true"
+	labels[runLabel] = runID
+	return labels
+}
+
+// writeLabels writes a Dockerfile LABEL instruction for each
+// label in key order. Dockramp only accepts a single key and
+// value per instruction, without the "key=value" form.
+func writeLabels(w io.Writer, labels map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "LABEL %s %s\n", quoteLabelWord(k), quoteLabelWord(labels[k]))
+	}
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jlhawn/dockramp/build"
+)
+
+func TestSuiteLabels(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-labels-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "golem.conf", `[[suite]]
+  name="registry"
+  [suite.labels]
+    team="distribution"
+    "ticket.id"="DIST-123"
+  [[suite.testrunner]]
+    command="bats -t ."
+`)
+
+	suites, err := parseSuites([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"team": "distribution", "ticket.id": "DIST-123"}
+	labels := suites["registry"].config.Labels
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Unexpected labels %v, expected %v", labels, expected)
+	}
+
+	// Container spec labels include the golem labels
+	containerLabels := instanceLabels(labels, "run1")
+	if containerLabels["team"] != "distribution" || containerLabels[golemLabel] != "true" || containerLabels[runLabel] != "run1" {
+		t.Fatalf("Unexpected container labels %v", containerLabels)
+	}
+	if len(labels) != 2 {
+		t.Fatalf("Suite labels modified: %v", labels)
+	}
+
+	// Image spec labels
+	if built := buildLabels(t, labels); !reflect.DeepEqual(built, expected) {
+		t.Fatalf("Unexpected image labels %v, expected %v", built, expected)
+	}
+	var df bytes.Buffer
+	writeLabels(&df, nil)
+	if df.Len() != 0 {
+		t.Fatalf("Unexpected label instruction without labels %q", df.String())
+	}
+
+	// Labels round trip through results
+	b, err := json.Marshal(InstanceResult{Name: "registry", Suite: "registry", Labels: labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result InstanceResult
+	if err := json.Unmarshal(b, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Labels, expected) {
+		t.Fatalf("Unexpected result labels %v", result.Labels)
+	}
+}
+
+func TestSuiteLabelsReserved(t *testing.T) {
+	for _, key := range []string{"", golemLabel, "golem.run"} {
+		if err := validateLabels(map[string]string{key: "value"}); err == nil {
+			t.Fatalf("Expected error for label key %q", key)
+		}
+	}
+	if err := validateLabels(map[string]string{"com.example.team": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"line\nbreak", "caf\u00e9"} {
+		if err := validateLabels(map[string]string{"key": value}); err == nil {
+			t.Fatalf("Expected error for label value %q", value)
+		}
+	}
+}
+
+func TestWriteLabelsDockramp(t *testing.T) {
+	labels := map[string]string{
+		"quoted":           `say "hi" it's`,
+		"shell":            `$HOME ${PATH} \n \\`,
+		"spaces and\ttabs": "a  b\tc",
+		"comment#":         "<<EOF #1",
+		"empty":            "",
+		"key=value":        "a=b",
+	}
+	if built := buildLabels(t, labels); !reflect.DeepEqual(built, labels) {
+		t.Fatalf("Unexpected image labels %#v, expected %#v", built, labels)
+	}
+}
+
+// buildLabels runs the dockramp builder on a Dockerfile with
+// the labels written by writeLabels, returning the labels sent
+// to the daemon when creating the build container.
+func buildLabels(t *testing.T, labels map[string]string) map[string]string {
+	td, err := ioutil.TempDir("", "golem-labels-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	var df bytes.Buffer
+	fmt.Fprintln(&df, "FROM scratch")
+	writeLabels(&df, labels)
+	writeTempFile(t, td, "Dockerfile", df.String())
+
+	var created struct {
+		Labels map[string]string
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/containers/create") {
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Errorf("Error decoding container config: %v", err)
+			}
+		}
+		// Stop the build before anything is committed
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}))
+	defer server.Close()
+
+	builder, err := newOutputBuilder(ioutil.Discard, func() (*build.Builder, error) {
+		return build.NewBuilder(server.URL, nil, td, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Run(); err == nil || !strings.Contains(err.Error(), "unable to create container") {
+		t.Fatalf("Expected container creation error, got %v\n%s", err, df.String())
+	}
+	return created.Labels
+}
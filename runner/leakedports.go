@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+)
+
+// leakedPortChecker is the subset of the docker client used to
+// find and remove golem containers holding host ports.
+type leakedPortChecker interface {
+	containerRemover
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+}
+
+// portHolder is a running golem container publishing host ports
+type portHolder struct {
+	Container types.Container
+	Ports     []string
+}
+
+func (ph portHolder) String() string {
+	return fmt.Sprintf("%s %v holding %s", ph.Container.ID, ph.Container.Names, strings.Join(ph.Ports, ", "))
+}
+
+// findPortHolders returns the golem containers publishing host ports,
+// ports are formatted as ip:port/protocol.
+func findPortHolders(containers []types.Container) []portHolder {
+	var holders []portHolder
+	for _, c := range containers {
+		if !isGolemContainer(c) {
+			continue
+		}
+		var ports []string
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 {
+				continue
+			}
+			ip := p.IP
+			if ip == "" {
+				ip = "0.0.0.0"
+			}
+			ports = append(ports, fmt.Sprintf("%s:%d/%s", ip, p.PublicPort, p.Type))
+		}
+		if len(ports) > 0 {
+			sort.Strings(ports)
+			holders = append(holders, portHolder{Container: c, Ports: ports})
+		}
+	}
+	return holders
+}
+
+// checkLeakedPorts reports golem containers still running and holding
+// host ports after the run, which would fail later runs publishing the
+// same ports. The containers are removed when kill is set.
+func (r *runner) checkLeakedPorts(ctx context.Context, cli leakedPortChecker, kill bool) error {
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing containers: %v", err)
+	}
+	holders := findPortHolders(containers)
+	for _, holder := range holders {
+		if !kill {
+			logrus.Warnf("Leaked host ports: container %s", holder)
+			continue
+		}
+		logrus.Infof("Removing container %s", holder)
+		removeOptions := types.ContainerRemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		}
+		if err := removeContainer(ctx, cli, holder.Container.ID, r.config.StopTimeout, removeOptions); err != nil {
+			return fmt.Errorf("error removing container %s: %v", holder.Container.ID, err)
+		}
+	}
+	if len(holders) > 0 && !kill {
+		logrus.Warnf("%d golem containers hold host ports, remove them or rerun with -kill-leaked-ports", len(holders))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+)
+
+func TestFindPortHolders(t *testing.T) {
+	golemLabels := map[string]string{golemLabel: "true"}
+	f := &fakeOrphanRemover{
+		containers: []types.Container{
+			// Compose service left by a golem run
+			{ID: "c1", Names: []string{"/golem-registry"}, Image: "golem-registry:latest", Labels: golemLabels, Ports: []types.Port{
+				{PrivatePort: 5000, PublicPort: 5000, Type: "tcp", IP: "0.0.0.0"},
+				{PrivatePort: 443, PublicPort: 8443, Type: "tcp", IP: "127.0.0.1"},
+				{PrivatePort: 80, Type: "tcp"},
+			}},
+			// Golem container without published ports
+			{ID: "c2", Names: []string{"/golem-old"}, Image: "golem-old:latest", Labels: golemLabels, Ports: []types.Port{
+				{PrivatePort: 2375, Type: "tcp"},
+			}},
+			// Non-golem container holding a port
+			{ID: "c3", Names: []string{"/webapp"}, Image: "nginx:1.9", Ports: []types.Port{
+				{PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+			}},
+		},
+	}
+
+	holders := findPortHolders(f.containers)
+	if len(holders) != 1 || holders[0].Container.ID != "c1" {
+		t.Fatalf("Unexpected port holders %v, expected c1", holders)
+	}
+	expected := []string{"0.0.0.0:5000/tcp", "127.0.0.1:8443/tcp"}
+	if !reflect.DeepEqual(holders[0].Ports, expected) {
+		t.Fatalf("Unexpected ports %v, expected %v", holders[0].Ports, expected)
+	}
+
+	r := &runner{}
+	if err := r.checkLeakedPorts(context.Background(), f, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.removedContainers) != 0 {
+		t.Fatalf("Unexpected removed containers when reporting: %v", f.removedContainers)
+	}
+
+	if err := r.checkLeakedPorts(context.Background(), f, true); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.removedContainers, []string{"c1"}) {
+		t.Fatalf("Unexpected removed containers %v, expected [c1]", f.removedContainers)
+	}
+}
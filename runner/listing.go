@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// CustomImageListing describes a custom image selected
+// for a test instance.
+type CustomImageListing struct {
+	Target  string `json:"target"`
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// InstanceListing describes a resolved test instance.
+type InstanceListing struct {
+	Name         string               `json:"name"`
+	BaseImage    string               `json:"baseImage"`
+	CustomImages []CustomImageListing `json:"customImages,omitempty"`
+}
+
+// SuiteListing describes a resolved test suite and
+// the test instances it expands to.
+type SuiteListing struct {
+	Name      string            `json:"name"`
+	Path      string            `json:"path"`
+	Dind      bool              `json:"dind"`
+	Instances []InstanceListing `json:"instances"`
+}
+
+// ListSuites returns a listing of the suites and instances
+// of a runner configuration without building or running them.
+func ListSuites(config RunnerConfiguration) []SuiteListing {
+	listing := make([]SuiteListing, 0, len(config.Suites))
+	for _, suite := range config.Suites {
+		sl := SuiteListing{
+			Name:      suite.Name,
+			Path:      suite.Path,
+			Dind:      suite.DockerInDocker,
+			Instances: make([]InstanceListing, 0, len(suite.Instances)),
+		}
+		for _, instance := range suite.Instances {
+			il := InstanceListing{
+				Name: instance.Name,
+			}
+			if instance.BaseImage.Base != nil {
+				il.BaseImage = instance.BaseImage.Base.String()
+			}
+			for _, ci := range instance.BaseImage.CustomImages {
+				il.CustomImages = append(il.CustomImages, CustomImageListing{
+					Target:  ci.Target.String(),
+					Source:  ci.Source,
+					Version: ci.Version,
+				})
+			}
+			sl.Instances = append(sl.Instances, il)
+		}
+		listing = append(listing, sl)
+	}
+	return listing
+}
+
+// WriteSuiteListing writes the listing of the suites of
+// a runner configuration as indented JSON.
+func WriteSuiteListing(w io.Writer, config RunnerConfiguration) error {
+	b, err := json.MarshalIndent(ListSuites(config), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestListSuites(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-list-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "golem.conf", `[[suite]]
+  name="registry"
+  dind=true
+  [[suite.testrunner]]
+    command="bats -t ."
+  [[suite.customimage]]
+    tag="golem-registry:latest"
+    default="registry:2.2.1"
+`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fr := newFlagResolver(fs)
+	if err := fs.Parse([]string{"-i", "golem-registry:latest,registry:2.3.0", "-i", "golem-registry:latest,registry:2.4.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	suites, err := parseSuites([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config RunnerConfiguration
+	for _, suite := range suites {
+		sc, err := resolveSuite(newMultiResolver(fr, suite, globalDefault), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Suites = append(config.Suites, sc)
+	}
+
+	expected := []SuiteListing{
+		{
+			Name: "registry",
+			Path: td,
+			Dind: true,
+			Instances: []InstanceListing{
+				{
+					Name:      "registry-1",
+					BaseImage: "distribution/golem-runner:0.1-bats",
+					CustomImages: []CustomImageListing{
+						{Target: "golem-registry:latest", Source: "registry:2.3.0", Version: "2.3.0"},
+					},
+				},
+				{
+					Name:      "registry-2",
+					BaseImage: "distribution/golem-runner:0.1-bats",
+					CustomImages: []CustomImageListing{
+						{Target: "golem-registry:latest", Source: "registry:2.4.0", Version: "2.4.0"},
+					},
+				},
+			},
+		},
+	}
+
+	listing := ListSuites(config)
+	if !reflect.DeepEqual(listing, expected) {
+		t.Fatalf("Unexpected listing\n\tExpected: %#v\n\tActual: %#v", expected, listing)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSuiteListing(&buf, config); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []SuiteListing
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("Unexpected decoded listing\n\tExpected: %#v\n\tActual: %#v", expected, decoded)
+	}
+}
+
+func TestListSuitesSorted(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-list-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	expected := []string{"alpha", "beta", "delta", "gamma", "zeta"}
+	var conf bytes.Buffer
+	for _, name := range []string{"zeta", "gamma", "alpha", "delta", "beta"} {
+		fmt.Fprintf(&conf, "[[suite]]\n  name=%q\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n", name)
+	}
+	writeTempFile(t, td, "golem.conf", conf.String())
+
+	suites, err := parseSuites([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config RunnerConfiguration
+	for _, name := range suiteNames(suites) {
+		sc, err := resolveSuite(newMultiResolver(suites[name], globalDefault), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Suites = append(config.Suites, sc)
+	}
+
+	var names []string
+	for _, sl := range ListSuites(config) {
+		names = append(names, sl.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Unexpected suite order %v, expected %v", names, expected)
+	}
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// filterWriter pipes writes through a filter process before
+// writing them to the underlying writer. Once the filter
+// exits, writes go to the underlying writer unfiltered.
+type filterWriter struct {
+	name string
+	out  io.Writer
+
+	l      sync.Mutex
+	stdin  io.WriteCloser
+	raw    bool
+	closed bool
+	done   chan struct{}
+}
+
+// newFilterWriter starts the filter command with its output
+// going to out, running the filter with /bin/sh -c.
+func newFilterWriter(name, filter string, out io.Writer) (*filterWriter, error) {
+	cmd := exec.Command("/bin/sh", "-c", filter)
+	cmd.Stdout = out
+	// The pipe is owned here rather than through StdinPipe,
+	// which would be closed by Wait concurrently with Close.
+	stdinR, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = stdinR
+	err = cmd.Start()
+	stdinR.Close()
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+	fw := &filterWriter{
+		name:  name,
+		out:   out,
+		stdin: stdin,
+		done:  make(chan struct{}),
+	}
+	go func() {
+		err := cmd.Wait()
+		fw.l.Lock()
+		if !fw.closed {
+			logrus.Warnf("Log filter for %s exited (%v), capturing unfiltered output", name, err)
+			fw.raw = true
+		}
+		fw.l.Unlock()
+		close(fw.done)
+	}()
+	return fw, nil
+}
+
+func (fw *filterWriter) Write(b []byte) (int, error) {
+	fw.l.Lock()
+	defer fw.l.Unlock()
+	if fw.raw {
+		return fw.out.Write(b)
+	}
+	n, err := fw.stdin.Write(b)
+	if err != nil {
+		logrus.Warnf("Error writing to log filter for %s, capturing unfiltered output: %v", fw.name, err)
+		fw.raw = true
+		_, err = fw.out.Write(b[n:])
+		return len(b), err
+	}
+	return n, nil
+}
+
+// Close closes the input of the filter and waits for the
+// filter to write its remaining output. Later writes go to
+// the underlying writer unfiltered.
+func (fw *filterWriter) Close() error {
+	fw.l.Lock()
+	if fw.closed {
+		fw.l.Unlock()
+		return nil
+	}
+	fw.closed = true
+	var err error
+	select {
+	case <-fw.done:
+		// Filter has exited, nothing is left to flush
+		fw.stdin.Close()
+	default:
+		err = fw.stdin.Close()
+	}
+	fw.l.Unlock()
+	<-fw.done
+
+	fw.l.Lock()
+	fw.raw = true
+	fw.l.Unlock()
+	return err
+}
+
+// filterCapturer is a log capturer with stdout and stderr
+// piped through a filter command, such as a scrubber
+// removing secrets, before reaching the wrapped capturer.
+type filterCapturer struct {
+	LogCapturer
+	stdout *filterWriter
+	stderr *filterWriter
+}
+
+// newFilterCapturer wraps the capturer with the filter command,
+// returning the capturer unfiltered with a warning when the
+// filter cannot be started.
+func newFilterCapturer(name, filter string, capturer LogCapturer) LogCapturer {
+	stdout, err := newFilterWriter(name+"-stdout", filter, capturer.Stdout())
+	if err != nil {
+		logrus.Warnf("Error starting log filter for %s, capturing unfiltered output: %v", name, err)
+		return capturer
+	}
+	stderr, err := newFilterWriter(name+"-stderr", filter, capturer.Stderr())
+	if err != nil {
+		logrus.Warnf("Error starting log filter for %s, capturing unfiltered output: %v", name, err)
+		stdout.Close()
+		return capturer
+	}
+	return &filterCapturer{
+		LogCapturer: capturer,
+		stdout:      stdout,
+		stderr:      stderr,
+	}
+}
+
+func (fc *filterCapturer) Stdout() io.Writer {
+	return fc.stdout
+}
+
+func (fc *filterCapturer) Stderr() io.Writer {
+	return fc.stderr
+}
+
+// closeFilters waits for the filters to finish writing
+// without closing the wrapped capturer.
+func (fc *filterCapturer) closeFilters() error {
+	err := fc.stdout.Close()
+	if serr := fc.stderr.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (fc *filterCapturer) Close() error {
+	err := fc.closeFilters()
+	if cerr := fc.LogCapturer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogRouterFilter(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "registry-1")
+	lr.SetFilter("sed -e 's/password=[^ ]*/password=***/'")
+	test, err := lr.RouteLogCapturer("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := lr.RouteLogCapturer("test"); err != nil || again != test {
+		t.Fatalf("Expected the same filtered capturer for an existing stream, got %v", err)
+	}
+
+	assertWrite(t, test.Stdout(), "login user=golem password=secret ok")
+	assertWrite(t, test.Stderr(), "retrying with password=hunter2")
+	if err := lr.Flush(true); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"test-stdout": "login user=golem password=*** ok\n",
+		"test-stderr": "retrying with password=***\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(td, "registry-1", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Unexpected filtered %s %q, expected %q", name, b, expected)
+		}
+	}
+
+	// Streams are captured unfiltered after flushing
+	assertWrite(t, test.Stdout(), "password=late")
+	b, err := ioutil.ReadFile(filepath.Join(td, "registry-1", "test-stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte("password=late\n")) {
+		t.Fatalf("Expected unfiltered write after flush, got %q", b)
+	}
+}
+
+func TestLogFilterExited(t *testing.T) {
+	capturer := newBufferLogger()
+	filtered := newFilterCapturer("test", "exit 1", capturer)
+	fc, ok := filtered.(*filterCapturer)
+	if !ok {
+		t.Fatalf("Expected filter capturer, got %T", filtered)
+	}
+
+	select {
+	case <-fc.stdout.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for filter to exit")
+	}
+
+	assertWrite(t, filtered.Stdout(), "raw line")
+	if out := capturer.stdout.String(); out != "raw line\n" {
+		t.Fatalf("Expected unfiltered output after filter exited, got %q", out)
+	}
+	if err := filtered.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
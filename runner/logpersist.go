@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// LogPersistence determines when log streams created by
+// the log router are saved to the log directory.
+type LogPersistence string
+
+const (
+	// PersistAlways writes log streams to the log
+	// directory as they are captured
+	PersistAlways LogPersistence = "always"
+
+	// PersistOnFailure buffers log streams in memory
+	// and only writes them to the log directory when
+	// the router is flushed after a failure
+	PersistOnFailure LogPersistence = "on-failure"
+
+	// PersistNever does not write any log streams
+	PersistNever LogPersistence = "never"
+)
+
+func (p *LogPersistence) String() string {
+	return string(*p)
+}
+
+// Set sets the log persistence from a string, allowing
+// the persistence to be used as a flag value.
+func (p *LogPersistence) Set(s string) error {
+	switch persistence := LogPersistence(s); persistence {
+	case PersistAlways, PersistOnFailure, PersistNever:
+		*p = persistence
+		return nil
+	}
+	return fmt.Errorf("invalid log persistence %q, must be one of always, on-failure or never", s)
+}
+
+// LogStreams is a list of log stream names which may be
+// used as a flag value, either comma separated or set
+// multiple times.
+type LogStreams []string
+
+func (s *LogStreams) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set adds comma separated stream names to the list
+func (s *LogStreams) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*s = append(*s, name)
+		}
+	}
+	return nil
+}
+
+// lockedWriter serializes writes to a shared buffer
+type lockedWriter struct {
+	l *sync.Mutex
+	w io.Writer
+}
+
+func (lw lockedWriter) Write(b []byte) (int, error) {
+	lw.l.Lock()
+	defer lw.l.Unlock()
+	return lw.w.Write(b)
+}
+
+// bufferedLogCapturer holds a log stream in memory until
+// it is either flushed to files or discarded.
+type bufferedLogCapturer struct {
+	basename string
+	merged   bool
+
+	l      sync.Mutex
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+func newBufferedLogCapturer(basename string, merged bool) *bufferedLogCapturer {
+	return &bufferedLogCapturer{
+		basename: basename,
+		merged:   merged,
+	}
+}
+
+func (bl *bufferedLogCapturer) Stdout() io.Writer {
+	return lockedWriter{l: &bl.l, w: &bl.stdout}
+}
+
+// Stderr writes to the stdout buffer when merged, keeping
+// the order of writes to both.
+func (bl *bufferedLogCapturer) Stderr() io.Writer {
+	if bl.merged {
+		return bl.Stdout()
+	}
+	return lockedWriter{l: &bl.l, w: &bl.stderr}
+}
+
+// Close keeps the buffered output, the stream is only
+// released by flushing or discarding it.
+func (bl *bufferedLogCapturer) Close() error {
+	return nil
+}
+
+// flush writes the buffered output to files using the
+// same naming as the file log capturer and resets the
+// buffers.
+func (bl *bufferedLogCapturer) flush() error {
+	bl.l.Lock()
+	defer bl.l.Unlock()
+
+	newCapturer := NewFileLogCapturer
+	if bl.merged {
+		newCapturer = NewMergedFileLogCapturer
+	}
+	fl, err := newCapturer(bl.basename)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	if _, err := bl.stdout.WriteTo(fl.Stdout()); err != nil {
+		return err
+	}
+	if _, err := bl.stderr.WriteTo(fl.Stderr()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (bl *bufferedLogCapturer) discard() {
+	bl.l.Lock()
+	defer bl.l.Unlock()
+	bl.stdout.Reset()
+	bl.stderr.Reset()
+}
+
+// SetPersistence sets when log streams are written to the
+// log directory and which streams are written. An empty
+// list of streams persists all streams. Only streams
+// created after the call are affected.
+func (lr *LogRouter) SetPersistence(persistence LogPersistence, streams []string) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+	lr.persistence = persistence
+	lr.persistStreams = map[string]struct{}{}
+	for _, name := range streams {
+		lr.persistStreams[name] = struct{}{}
+	}
+}
+
+// persisted returns whether the named stream should be
+// written to the log directory.
+func (lr *LogRouter) persisted(name string) bool {
+	if lr.logDir == "" || lr.persistence == PersistNever {
+		return false
+	}
+	if len(lr.persistStreams) == 0 {
+		return true
+	}
+	_, ok := lr.persistStreams[name]
+	return ok
+}
+
+// Flush writes buffered log streams to the log directory
+// when failed is true and discards them otherwise. Only
+// streams buffered with PersistOnFailure are affected.
+// Log filters are closed first, streams are captured
+// unfiltered after flushing.
+func (lr *LogRouter) Flush(failed bool) error {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+
+	var errs []string
+	for name, fc := range lr.filtered {
+		if err := fc.closeFilters(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	for name, bl := range lr.buffered {
+		if !failed {
+			bl.discard()
+			continue
+		}
+		if err := bl.flush(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error flushing log streams: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
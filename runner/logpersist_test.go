@@ -0,0 +1,225 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeStreams(t *testing.T, lr *LogRouter, names ...string) {
+	for _, name := range names {
+		c, err := lr.RouteLogCapturer(name)
+		if err != nil {
+			t.Fatalf("Error creating stream %s: %v", name, err)
+		}
+		assertWrite(t, c.Stdout(), name+" out")
+		assertWrite(t, c.Stderr(), name+" err")
+		if err := c.Close(); err != nil {
+			t.Fatalf("Error closing stream %s: %v", name, err)
+		}
+	}
+}
+
+func logFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestLogPersistOnFailureSuccess(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "instance")
+	defer lr.Shutdown()
+	lr.SetPersistence(PersistOnFailure, []string{"daemon", "test"})
+
+	writeStreams(t, lr, "compose", "daemon", "test")
+
+	if err := lr.Flush(false); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+	if files := logFiles(t, td); len(files) != 0 {
+		t.Fatalf("Expected no log files on success, got %v", files)
+	}
+}
+
+func TestLogPersistOnFailureFailed(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "instance")
+	defer lr.Shutdown()
+	lr.SetPersistence(PersistOnFailure, []string{"daemon", "test"})
+
+	writeStreams(t, lr, "compose", "daemon", "test")
+
+	if files := logFiles(t, td); len(files) != 0 {
+		t.Fatalf("Expected no log files before flush, got %v", files)
+	}
+	if err := lr.Flush(true); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+
+	expected := []string{
+		"instance/daemon-stderr",
+		"instance/daemon-stdout",
+		"instance/test-stderr",
+		"instance/test-stdout",
+	}
+	files := logFiles(t, td)
+	if len(files) != len(expected) {
+		t.Fatalf("Unexpected log files %v, expected %v", files, expected)
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Fatalf("Unexpected log files %v, expected %v", files, expected)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(td, "instance", "test-stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "test out\n" {
+		t.Fatalf("Unexpected test stdout %q", b)
+	}
+}
+
+func TestLogPersistSelectedStreams(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "")
+	defer lr.Shutdown()
+	lr.SetPersistence(PersistAlways, []string{"test"})
+
+	writeStreams(t, lr, "scripts", "test")
+
+	files := logFiles(t, td)
+	if len(files) != 2 || files[0] != "test-stderr" || files[1] != "test-stdout" {
+		t.Fatalf("Unexpected log files %v", files)
+	}
+}
+
+func TestLogPersistenceSet(t *testing.T) {
+	var p LogPersistence
+	if err := p.Set("on-failure"); err != nil {
+		t.Fatal(err)
+	}
+	if p != PersistOnFailure {
+		t.Fatalf("Unexpected persistence %q", p)
+	}
+	if err := p.Set("sometimes"); err == nil {
+		t.Fatal("Expected error for invalid persistence")
+	}
+
+	var s LogStreams
+	for _, v := range []string{"daemon, test", "compose"} {
+		if err := s.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.String() != "daemon,test,compose" {
+		t.Fatalf("Unexpected streams %q", s.String())
+	}
+}
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (cb *closeBuffer) Close() error {
+	cb.closed = true
+	return nil
+}
+
+func TestMergedLogCapturer(t *testing.T) {
+	buf := &closeBuffer{}
+	c := NewMergedLogCapturer(buf)
+	assertWrite(t, c.Stdout(), "1 out")
+	assertWrite(t, c.Stderr(), "2 err")
+	assertWrite(t, c.Stdout(), "3 out")
+	assertWrite(t, c.Stderr(), "4 err")
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); out != "1 out\n2 err\n3 out\n4 err\n" {
+		t.Fatalf("Unexpected merged output %q", out)
+	}
+	if !buf.closed {
+		t.Fatal("Expected merged output to be closed")
+	}
+}
+
+func TestLogRouterMergedStreams(t *testing.T) {
+	for _, persistence := range []LogPersistence{PersistAlways, PersistOnFailure} {
+		td, err := ioutil.TempDir("", "golem-logs-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(td)
+
+		lr := NewLogRouter(td, "instance")
+		lr.SetPersistence(persistence, nil)
+		lr.SetMergedStreams([]string{"test"})
+
+		test, err := lr.RouteLogCapturer("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			assertWrite(t, test.Stdout(), fmt.Sprintf("ok %d", i))
+			assertWrite(t, test.Stderr(), fmt.Sprintf("warning %d", i))
+		}
+		writeStreams(t, lr, "daemon")
+		if err := lr.Flush(true); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{
+			"instance/daemon-stderr",
+			"instance/daemon-stdout",
+			"instance/test-output",
+		}
+		if files := logFiles(t, td); !reflect.DeepEqual(files, expected) {
+			t.Fatalf("Unexpected %s log files %v, expected %v", persistence, files, expected)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(td, "instance", "test-output"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "ok 0\nwarning 0\nok 1\nwarning 1\nok 2\nwarning 2\n" {
+			t.Fatalf("Unexpected %s merged output %q", persistence, b)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/Sirupsen/logrus"
@@ -83,13 +84,22 @@ func (lmw *logMultiWriter) RemoveWriter(w io.Writer) {
 
 }
 
+// DefaultMaxTaps is the default maximum number of simultaneous
+// taps on a single log stream.
+const DefaultMaxTaps = 32
+
+// ErrTooManyTaps is returned when a log stream already has the
+// maximum number of simultaneous taps.
+var ErrTooManyTaps = errors.New("too many taps on log stream")
+
 type logTapper struct {
 	stderr MultiWriter
 	stdout MultiWriter
 	closer io.Closer
 
-	l    sync.Mutex
-	taps map[*logTap]MultiWriter
+	l       sync.Mutex
+	taps    map[*logTap]MultiWriter
+	maxTaps int
 }
 
 type logTap struct {
@@ -99,12 +109,16 @@ type logTap struct {
 	tapper *logTapper
 }
 
-func newLogTapper(sink LogCapturer) *logTapper {
+// newLogTapper creates a log tapper writing to the given sink,
+// allowing at most maxTaps simultaneous taps. A maxTaps of zero
+// or less does not limit the number of taps.
+func newLogTapper(sink LogCapturer, maxTaps int) *logTapper {
 	return &logTapper{
-		stdout: NewLogMultiWriter(sink.Stdout()),
-		stderr: NewLogMultiWriter(sink.Stderr()),
-		closer: sink,
-		taps:   map[*logTap]MultiWriter{},
+		stdout:  NewLogMultiWriter(sink.Stdout()),
+		stderr:  NewLogMultiWriter(sink.Stderr()),
+		closer:  sink,
+		taps:    map[*logTap]MultiWriter{},
+		maxTaps: maxTaps,
 	}
 }
 
@@ -116,15 +130,28 @@ func (lr *logTapper) Stderr() io.Writer {
 	return lr.stderr
 }
 
-func (lr *logTapper) TapStdout() io.ReadCloser {
+func (lr *logTapper) TapStdout() (io.ReadCloser, error) {
 	return lr.addTap(lr.stdout)
 }
 
-func (lr *logTapper) TapStderr() io.ReadCloser {
+func (lr *logTapper) TapStderr() (io.ReadCloser, error) {
 	return lr.addTap(lr.stderr)
 }
 
-func (lr *logTapper) addTap(mw MultiWriter) io.ReadCloser {
+func (lr *logTapper) setMaxTaps(n int) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+	lr.maxTaps = n
+}
+
+func (lr *logTapper) addTap(mw MultiWriter) (io.ReadCloser, error) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+
+	if lr.maxTaps > 0 && len(lr.taps) >= lr.maxTaps {
+		return nil, ErrTooManyTaps
+	}
+
 	r, w := io.Pipe()
 	mw.AddWriter(w)
 	t := &logTap{
@@ -133,12 +160,9 @@ func (lr *logTapper) addTap(mw MultiWriter) io.ReadCloser {
 		tapper: lr,
 	}
 
-	lr.l.Lock()
-	defer lr.l.Unlock()
-
 	lr.taps[t] = mw
 
-	return t
+	return t, nil
 }
 
 func (lr *logTapper) removeTap(t *logTap) error {
@@ -203,11 +227,22 @@ func (nilLogger) Close() error {
 // LogRouter manages log streams as well as the
 // creation and routing of those streams.
 type LogRouter struct {
-	logDir string
+	logDir    string
+	namespace string
 
 	l          sync.Mutex
 	logStreams map[string]*logTapper
 	forwards   []LogForwarder
+	maxTaps    int
+
+	persistence    LogPersistence
+	persistStreams map[string]struct{}
+	buffered       map[string]*bufferedLogCapturer
+
+	filter   string
+	filtered map[string]*filterCapturer
+
+	mergedStreams map[string]struct{}
 
 	forwardChan chan LogForwarder
 	streamChan  chan string
@@ -217,57 +252,115 @@ type LogRouter struct {
 // NewLogRouter creates a new LogRouter with a directory
 // as a default log sink for all created log streams. If
 // the log directory is empty, log streams will not be
-// saved by the router.
-func NewLogRouter(logDirectory string) *LogRouter {
+// saved by the router. Streams are namespaced by the
+// provided namespace, such as the instance name, to keep
+// streams from different instances from colliding. Each
+// stream allows DefaultMaxTaps simultaneous taps and all
+// streams are persisted until SetPersistence is called.
+func NewLogRouter(logDirectory, namespace string) *LogRouter {
 	// Create channels
 	lr := &LogRouter{
 		logDir:     logDirectory,
+		namespace:  strings.Replace(namespace, "/", "_", -1),
 		logStreams: map[string]*logTapper{},
 		forwards:   []LogForwarder{},
+		maxTaps:    DefaultMaxTaps,
+		buffered:   map[string]*bufferedLogCapturer{},
+		filtered:   map[string]*filterCapturer{},
 
 		forwardChan: make(chan LogForwarder),
 		streamChan:  make(chan string),
 		closeChan:   make(chan struct{}),
 	}
-	go lr.route()
+	go lr.route(lr.forwardChan, lr.streamChan, lr.closeChan)
 	return lr
 }
 
+// streamName returns the namespaced name of a stream
+func (lr *LogRouter) streamName(name string) string {
+	if lr.namespace == "" {
+		return name
+	}
+	return lr.namespace + "/" + name
+}
+
+// SetMaxTaps sets the maximum number of simultaneous taps
+// allowed on each log stream, including existing streams.
+// A value of zero or less removes the limit. Existing taps
+// are not closed when lowering the limit.
+func (lr *LogRouter) SetMaxTaps(n int) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+	lr.maxTaps = n
+	for _, t := range lr.logStreams {
+		t.setMaxTaps(n)
+	}
+}
+
+// SetFilter sets a shell command the stdout and stderr of log
+// streams created afterwards are piped through before being
+// saved or forwarded, such as a sed expression scrubbing secrets.
+// Streams are captured unfiltered if the filter exits early.
+func (lr *LogRouter) SetFilter(filter string) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+	lr.filter = filter
+}
+
+// SetMergedStreams sets the log streams created afterwards whose
+// stdout and stderr are saved to a single "-output" file in the
+// order written, rather than to separate files.
+func (lr *LogRouter) SetMergedStreams(streams []string) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+	lr.mergedStreams = map[string]struct{}{}
+	for _, name := range streams {
+		lr.mergedStreams[name] = struct{}{}
+	}
+}
+
 func forwardStream(f LogForwarder, name string, t *logTapper) {
 	forwardName := name + "-stdout"
-	if err := f.StartForward(forwardName, t.TapStdout()); err != nil {
+	if tap, err := t.TapStdout(); err != nil {
+		logrus.Errorf("unable to tap %s: %v", forwardName, err)
+	} else if err := f.StartForward(forwardName, tap); err != nil {
 		logrus.Errorf("unable to start forwarder %s: %v", forwardName, err)
 	}
 	forwardName = name + "-stderr"
-	if err := f.StartForward(forwardName, t.TapStderr()); err != nil {
+	if tap, err := t.TapStderr(); err != nil {
+		logrus.Errorf("unable to tap %s: %v", forwardName, err)
+	} else if err := f.StartForward(forwardName, tap); err != nil {
 		logrus.Errorf("unable to start forwarder %s: %v", forwardName, err)
 	}
 	// TODO: Handle errors to ensure caller does not attempt to stop
 }
 
-func (lr *LogRouter) route() {
+// route routes streams to forwarders until closed. The channels
+// are passed in since Shutdown clears them on the router.
+func (lr *LogRouter) route(forwardChan <-chan LogForwarder, streamChan <-chan string, closeChan <-chan struct{}) {
 	defer logrus.Debugf("Log router completed")
 	for {
 		select {
-		case f := <-lr.forwardChan:
+		case f := <-forwardChan:
 			lr.l.Lock()
 			for name, t := range lr.logStreams {
-				forwardStream(f, name, t)
+				forwardStream(f, lr.streamName(name), t)
 			}
 			lr.forwards = append(lr.forwards, f)
 			lr.l.Unlock()
-		case name := <-lr.streamChan:
+		case name := <-streamChan:
 			lr.l.Lock()
 			t, ok := lr.logStreams[name]
 			if ok {
 				for _, f := range lr.forwards {
-					forwardStream(f, name, t)
+					forwardStream(f, lr.streamName(name), t)
 				}
 			}
 			lr.l.Unlock()
-		case <-lr.closeChan:
+		case <-closeChan:
 			lr.l.Lock()
 			for name := range lr.logStreams {
+				name = lr.streamName(name)
 				for _, f := range lr.forwards {
 					forwardName := name + "-stdout"
 					if err := f.StopForward(forwardName); err != nil {
@@ -298,6 +391,9 @@ func (lr *LogRouter) RouteLogCapturer(name string) (capturer LogCapturer, err er
 
 	tapped, ok := lr.logStreams[name]
 	if ok {
+		if fc, ok := lr.filtered[name]; ok {
+			return fc, nil
+		}
 		return tapped, nil
 	}
 
@@ -305,20 +401,39 @@ func (lr *LogRouter) RouteLogCapturer(name string) (capturer LogCapturer, err er
 		return nil, errors.New("cannot create log capturer on closed router")
 	}
 
-	if lr.logDir == "" {
+	basename := filepath.Join(lr.logDir, filepath.FromSlash(lr.streamName(name)))
+	_, merged := lr.mergedStreams[name]
+	switch {
+	case !lr.persisted(name):
 		capturer = nilLogger{}
-	} else {
-		basename := filepath.Join("/var/log/docker", name)
+	case lr.persistence == PersistOnFailure:
+		bl := newBufferedLogCapturer(basename, merged)
+		lr.buffered[name] = bl
+		capturer = bl
+	case merged:
+		capturer, err = NewMergedFileLogCapturer(basename)
+		if err != nil {
+			return
+		}
+	default:
 		capturer, err = NewFileLogCapturer(basename)
 		if err != nil {
 			return
 		}
 	}
 
-	tapped = newLogTapper(capturer)
+	tapped = newLogTapper(capturer, lr.maxTaps)
 
 	lr.logStreams[name] = tapped
 
+	if lr.filter != "" {
+		filtered := newFilterCapturer(lr.streamName(name), lr.filter, tapped)
+		if fc, ok := filtered.(*filterCapturer); ok {
+			lr.filtered[name] = fc
+		}
+		return filtered, nil
+	}
+
 	return tapped, nil
 }
 
@@ -342,8 +457,18 @@ func (lr *LogRouter) AddCapturer(name string, c LogCapturer) error {
 		return errors.New("log stream does not exist")
 	}
 
-	go copyTap(name, c.Stdout(), tapped.TapStdout())
-	go copyTap(name, c.Stderr(), tapped.TapStderr())
+	stdout, err := tapped.TapStdout()
+	if err != nil {
+		return err
+	}
+	stderr, err := tapped.TapStderr()
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+
+	go copyTap(name, c.Stdout(), stdout)
+	go copyTap(name, c.Stderr(), stderr)
 
 	return nil
 }
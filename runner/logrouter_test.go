@@ -3,6 +3,9 @@ package runner
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -82,11 +85,14 @@ func newBufferLogger() *bufferLogger {
 
 func TestLogTapper(t *testing.T) {
 	c := newBufferLogger()
-	tapped := newLogTapper(c)
+	tapped := newLogTapper(c, 0)
 
 	assertWrite(t, tapped.Stdout(), "First line")
 
-	r1 := tapped.TapStdout()
+	r1, err := tapped.TapStdout()
+	if err != nil {
+		t.Fatal(err)
+	}
 	b1 := bytes.NewBuffer(nil)
 	done1 := make(chan error)
 	go func() {
@@ -99,7 +105,10 @@ func TestLogTapper(t *testing.T) {
 
 	assertWrite(t, tapped.Stdout(), "Second line")
 
-	r2 := tapped.TapStdout()
+	r2, err := tapped.TapStdout()
+	if err != nil {
+		t.Fatal(err)
+	}
 	b2 := bytes.NewBuffer(nil)
 	done2 := make(chan error)
 	go func() {
@@ -140,3 +149,90 @@ Fourth line
 	checkBuffer(t, b2, expected2)
 
 }
+
+func TestLogTapperMaxTaps(t *testing.T) {
+	tapped := newLogTapper(newBufferLogger(), 2)
+	defer tapped.Close()
+
+	r1, err := tapped.TapStdout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tapped.TapStderr(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tapped.TapStdout(); err != ErrTooManyTaps {
+		t.Fatalf("Expected %v, got %v", ErrTooManyTaps, err)
+	}
+
+	if err := r1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tapped.TapStdout(); err != nil {
+		t.Fatalf("Expected tap after closing, got %v", err)
+	}
+	if _, err := tapped.TapStdout(); err != ErrTooManyTaps {
+		t.Fatalf("Expected %v, got %v", ErrTooManyTaps, err)
+	}
+}
+
+func TestLogRouterMaxTaps(t *testing.T) {
+	lr := NewLogRouter("", "")
+	defer lr.Shutdown()
+
+	if _, err := lr.RouteLogCapturer("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	lr.SetMaxTaps(1)
+
+	// A capturer needs both a stdout and stderr tap
+	if err := lr.AddCapturer("test", newBufferLogger()); err != ErrTooManyTaps {
+		t.Fatalf("Expected %v, got %v", ErrTooManyTaps, err)
+	}
+
+	lr.SetMaxTaps(2)
+
+	if err := lr.AddCapturer("test", newBufferLogger()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogRouterNamespace(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	routers := []*LogRouter{
+		NewLogRouter(td, "registry-1"),
+		NewLogRouter(td, "registry-2"),
+	}
+	for _, lr := range routers {
+		c, err := lr.RouteLogCapturer("daemon")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertWrite(t, c.Stdout(), "output from "+lr.namespace)
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		lr.Shutdown()
+	}
+
+	for _, instance := range []string{"registry-1", "registry-2"} {
+		b, err := ioutil.ReadFile(filepath.Join(td, instance, "daemon-stdout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "output from " + instance + "\n"; string(b) != expected {
+			t.Fatalf("Unexpected log content %q, expected %q", b, expected)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(td, "daemon-stdout")); err == nil {
+		t.Fatal("Unexpected log stream outside of instance directory")
+	}
+}
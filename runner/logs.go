@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -79,3 +80,46 @@ func (fl *fileLogger) Close() error {
 	}
 	return nil
 }
+
+// mergedLogger writes stdout and stderr to a single writer,
+// serializing writes so the output keeps the order in which
+// the writes were made.
+type mergedLogger struct {
+	l sync.Mutex
+	w io.WriteCloser
+}
+
+// NewMergedLogCapturer creates a log capturer writing both
+// stdout and stderr to w in the order they are written.
+func NewMergedLogCapturer(w io.WriteCloser) LogCapturer {
+	return &mergedLogger{
+		w: w,
+	}
+}
+
+// NewMergedFileLogCapturer uses a single file as a logging
+// backend for both stdout and stderr, with suffix "-output".
+func NewMergedFileLogCapturer(basename string) (LogCapturer, error) {
+	if err := os.MkdirAll(filepath.Dir(basename), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(basename + "-output")
+	if err != nil {
+		return nil, err
+	}
+	return NewMergedLogCapturer(f), nil
+}
+
+func (ml *mergedLogger) Stdout() io.Writer {
+	return lockedWriter{l: &ml.l, w: ml.w}
+}
+
+func (ml *mergedLogger) Stderr() io.Writer {
+	return lockedWriter{l: &ml.l, w: ml.w}
+}
+
+func (ml *mergedLogger) Close() error {
+	ml.l.Lock()
+	defer ml.l.Unlock()
+	return ml.w.Close()
+}
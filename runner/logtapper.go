@@ -66,9 +66,15 @@ func TapServer(l net.Listener, lr *LogRouter) {
 				var tap io.ReadCloser
 
 				if tm.Stdout {
-					tap = ts.TapStdout()
+					tap, err = ts.TapStdout()
 				} else {
-					tap = ts.TapStderr()
+					tap, err = ts.TapStderr()
+				}
+				if err != nil {
+					tm.Err.Send(errStreamMessage{Message: err.Error()})
+					// TODO: Check send error
+					tm.Err.Close()
+					continue
 				}
 
 				go func() {
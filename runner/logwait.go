@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// LogWait is the configuration for waiting until a line
+// matching a pattern is written to a log stream.
+type LogWait struct {
+	Stream  string        `json:"stream"`
+	Pattern string        `json:"pattern"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// LogWaiter waits for a line matching a pattern on
+// the stdout or stderr of a log stream. Only lines
+// written after the waiter is created are matched.
+type LogWaiter struct {
+	name    string
+	pattern *regexp.Regexp
+	taps    []io.ReadCloser
+
+	once    sync.Once
+	matched chan struct{}
+}
+
+// NewLogWaiter creates a log waiter for the named log stream,
+// tapping the stream immediately so that lines written before
+// Wait is called are not missed.
+func (lr *LogRouter) NewLogWaiter(name string, pattern *regexp.Regexp) (*LogWaiter, error) {
+	lr.l.Lock()
+	defer lr.l.Unlock()
+
+	tapped, ok := lr.logStreams[name]
+	if !ok {
+		return nil, fmt.Errorf("log stream %s does not exist", name)
+	}
+
+	stdout, err := tapped.TapStdout()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := tapped.TapStderr()
+	if err != nil {
+		stdout.Close()
+		return nil, err
+	}
+
+	lw := &LogWaiter{
+		name:    name,
+		pattern: pattern,
+		taps:    []io.ReadCloser{stdout, stderr},
+		matched: make(chan struct{}),
+	}
+	for _, tap := range lw.taps {
+		go lw.scan(tap)
+	}
+
+	return lw, nil
+}
+
+// scan reads lines until the tap is closed, reading continues
+// after a match to avoid blocking writers to the log stream.
+func (lw *LogWaiter) scan(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if lw.pattern.MatchString(scanner.Text()) {
+			lw.once.Do(func() {
+				close(lw.matched)
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Debugf("Error scanning log stream %s: %v", lw.name, err)
+	}
+}
+
+// Wait blocks until a matching line is written or the timeout
+// elapses, returning an error naming the pattern on timeout.
+// The log stream taps are closed when Wait returns.
+func (lw *LogWaiter) Wait(timeout time.Duration) error {
+	defer lw.close()
+
+	select {
+	case <-lw.matched:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s waiting for %q in log stream %s", timeout, lw.pattern, lw.name)
+	}
+}
+
+func (lw *LogWaiter) close() {
+	for _, tap := range lw.taps {
+		if err := tap.Close(); err != nil {
+			logrus.Debugf("Error closing tap on %s: %v", lw.name, err)
+		}
+	}
+}
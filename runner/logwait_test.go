@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogWaiter(t *testing.T) {
+	lr := NewLogRouter("", "")
+	defer lr.Shutdown()
+
+	lc, err := lr.RouteLogCapturer("compose")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines written before the waiter is created are not matched
+	assertWrite(t, lc.Stdout(), "registry_1 | listening on [::]:5000")
+
+	pattern := regexp.MustCompile(`listening on .*:5000`)
+	waiter, err := lr.NewLogWaiter("compose", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		assertWrite(t, lc.Stdout(), "registry_1 | starting")
+		assertWrite(t, lc.Stderr(), "registry_1 | listening on [::]:5000")
+		assertWrite(t, lc.Stdout(), "registry_1 | ready")
+	}()
+
+	if err := waiter.Wait(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	waiter, err = lr.NewLogWaiter("compose", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWrite(t, lc.Stdout(), "registry_1 | shutting down")
+	err = waiter.Wait(50 * time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected timeout waiting for pattern")
+	}
+	if !strings.Contains(err.Error(), pattern.String()) {
+		t.Fatalf("Expected error to name pattern: %v", err)
+	}
+
+	// Writes are not blocked after the waiter is done
+	assertWrite(t, lc.Stdout(), "registry_1 | stopped")
+
+	if _, err := lr.NewLogWaiter("missing", pattern); err == nil {
+		t.Fatal("Expected error waiting on missing stream")
+	}
+}
+
+func TestNewLogWait(t *testing.T) {
+	wait, err := newLogWait(waitForConfiguration{Pattern: "ready"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait.Stream != "compose" || wait.Timeout != defaultWaitTimeout {
+		t.Fatalf("Unexpected defaults %#v", wait)
+	}
+
+	wait, err = newLogWait(waitForConfiguration{Stream: "daemon", Pattern: "API listen", Timeout: "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait.Stream != "daemon" || wait.Timeout != 10*time.Second {
+		t.Fatalf("Unexpected wait %#v", wait)
+	}
+
+	for _, invalid := range []waitForConfiguration{
+		{},
+		{Pattern: "ready("},
+		{Pattern: "ready", Timeout: "soon"},
+	} {
+		if _, err := newLogWait(invalid); err == nil {
+			t.Fatalf("Expected error for %#v", invalid)
+		}
+	}
+}
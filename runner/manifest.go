@@ -0,0 +1,278 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/reference"
+)
+
+const (
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeImageIndex   = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// manifestCheckTimeout limits how long checking the platforms
+// of a base image in its registry may delay the build.
+const manifestCheckTimeout = 15 * time.Second
+
+// manifestInspector looks up the platforms an image has
+// manifests for in its registry. No platforms are returned
+// for images with a single manifest, whose platform is only
+// known once pulled.
+type manifestInspector interface {
+	ManifestPlatforms(ctx context.Context, ref reference.NamedTagged) ([]Platform, error)
+}
+
+// registryManifestInspector inspects manifests using the
+// registry API, authenticating anonymously when challenged.
+type registryManifestInspector struct {
+	client *http.Client
+	scheme string
+}
+
+func newRegistryManifestInspector() *registryManifestInspector {
+	return &registryManifestInspector{
+		client: http.DefaultClient,
+		scheme: "https",
+	}
+}
+
+// registryRepository returns the registry host and repository
+// of the named image, defaulting to Docker Hub.
+func registryRepository(named reference.Named) (string, string) {
+	name := named.Name()
+	if i := strings.Index(name, "/"); i > 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			if host == "docker.io" || host == "index.docker.io" {
+				name = name[i+1:]
+			} else {
+				return host, name[i+1:]
+			}
+		}
+	}
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return "registry-1.docker.io", name
+}
+
+func (ri *registryManifestInspector) ManifestPlatforms(ctx context.Context, ref reference.NamedTagged) ([]Platform, error) {
+	host, repo := registryRepository(ref)
+	u := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", ri.scheme, host, repo, ref.Tag())
+
+	resp, err := ri.getManifest(ctx, u, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		token, err := ri.token(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = ri.getManifest(ctx, u, token); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned status %d for %s", resp.StatusCode, ref)
+	}
+
+	switch strings.Split(resp.Header.Get("Content-Type"), ";")[0] {
+	case mediaTypeManifestList, mediaTypeImageIndex:
+	default:
+		return nil, nil
+	}
+	var list struct {
+		Manifests []struct {
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("error decoding manifest list of %s: %v", ref, err)
+	}
+	platforms := make([]Platform, 0, len(list.Manifests))
+	for _, m := range list.Manifests {
+		platforms = append(platforms, Platform{
+			OS:           m.Platform.OS,
+			Architecture: normalizeArch(m.Platform.Architecture),
+			Variant:      m.Platform.Variant,
+		})
+	}
+	return platforms, nil
+}
+
+func (ri *registryManifestInspector) getManifest(ctx context.Context, u, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", mediaTypeManifestList)
+	req.Header.Add("Accept", mediaTypeImageIndex)
+	req.Header.Add("Accept", mediaTypeManifest)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return ri.client.Do(req.WithContext(ctx))
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token gets an anonymous bearer token for the challenge
+func (ri *registryManifestInspector) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication %q", challenge)
+	}
+	params := map[string]string{}
+	for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid registry token realm %q", params["realm"])
+	}
+	q := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if v, ok := params[key]; ok {
+			q.Set(key, v)
+		}
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := ri.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token server returned status %d", resp.StatusCode)
+	}
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("error decoding token: %v", err)
+	}
+	if tr.Token == "" {
+		tr.Token = tr.AccessToken
+	}
+	return tr.Token, nil
+}
+
+// matchesPlatform returns whether the manifest platform can be
+// used for the platform, any variant matching an unset variant.
+func (p Platform) matchesPlatform(manifest Platform) bool {
+	if p.OS != manifest.OS || p.Architecture != manifest.Architecture {
+		return false
+	}
+	return p.Variant == "" || p.Variant == manifest.Variant
+}
+
+// checkBaseManifest returns an error if the base image is not
+// available locally and its registry has no manifest for the
+// platform. Images with a single manifest, untagged images and
+// registries which cannot be inspected are not checked.
+func checkBaseManifest(ctx context.Context, cli imageInspector, mi manifestInspector, base reference.Named, p Platform) error {
+	tagged, ok := base.(reference.NamedTagged)
+	if !ok || p.IsZero() || mi == nil {
+		return nil
+	}
+	if _, _, err := cli.ImageInspectWithRaw(ctx, base.String(), false); err == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, manifestCheckTimeout)
+	defer cancel()
+	platforms, err := mi.ManifestPlatforms(ctx, tagged)
+	if err != nil {
+		logrus.Debugf("Unable to check manifest platforms of %s: %v", base, err)
+		return nil
+	}
+	if len(platforms) == 0 {
+		return nil
+	}
+	available := make([]string, 0, len(platforms))
+	for _, manifest := range platforms {
+		if p.matchesPlatform(manifest) {
+			return nil
+		}
+		available = append(available, manifest.String())
+	}
+	return fmt.Errorf("base image %s has no manifest for platform %s, available for %s; set arch_baseimage for %s to a base image built for it", base, p, strings.Join(available, ", "), p.Architecture)
+}
+
+// selectBase returns the base image configured for the
+// architecture of the platform, or the default base.
+func (c BaseImageConfiguration) selectBase(p Platform) reference.Named {
+	if base, ok := c.ArchBase[p.Architecture]; ok {
+		return base
+	}
+	return c.Base
+}
+
+// buildPlatform returns the platform images are built for,
+// the configured platform or else the platform of the daemon.
+func (r *runner) buildPlatform(ctx context.Context, cli daemonInfoer, configured Platform) (Platform, error) {
+	if !configured.IsZero() {
+		return configured, nil
+	}
+	if r.daemonPlatform != nil {
+		return *r.daemonPlatform, nil
+	}
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return Platform{}, fmt.Errorf("error getting daemon info: %v", err)
+	}
+	p := Platform{
+		OS:           info.OSType,
+		Architecture: normalizeArch(info.Architecture),
+	}
+	r.daemonPlatform = &p
+	return p, nil
+}
+
+// newArchBase parses the base images by architecture,
+// normalizing architecture names.
+func newArchBase(images map[string]string) (map[string]reference.NamedTagged, error) {
+	if len(images) == 0 {
+		return nil, nil
+	}
+	archBase := make(map[string]reference.NamedTagged, len(images))
+	for arch, image := range images {
+		normalized := normalizeArch(strings.ToLower(arch))
+		if !platformComponent.MatchString(normalized) {
+			return nil, fmt.Errorf("invalid arch_baseimage architecture %q", arch)
+		}
+		if _, ok := archBase[normalized]; ok {
+			return nil, fmt.Errorf("duplicate arch_baseimage for architecture %s", normalized)
+		}
+		named, err := getNamedTagged(image)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arch_baseimage for %s: %v", arch, err)
+		}
+		archBase[normalized] = named
+	}
+	return archBase, nil
+}
@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/engine-api/types"
+)
+
+type fakeManifestInspector struct {
+	platforms []Platform
+	err       error
+	calls     int
+}
+
+func (f *fakeManifestInspector) ManifestPlatforms(ctx context.Context, ref reference.NamedTagged) ([]Platform, error) {
+	f.calls++
+	return f.platforms, f.err
+}
+
+func mustPlatform(s string) Platform {
+	p, err := ParsePlatform(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func TestCheckBaseManifest(t *testing.T) {
+	base := assertTagged("distribution/golem-runner:0.1-bats")
+	amd64Only := &fakeManifestInspector{platforms: []Platform{mustPlatform("linux/amd64"), mustPlatform("windows/amd64")}}
+	multiArch := &fakeManifestInspector{platforms: []Platform{mustPlatform("linux/amd64"), mustPlatform("linux/arm64/v8")}}
+	noImages := fakeImageInspector{}
+	ctx := context.Background()
+
+	err := checkBaseManifest(ctx, noImages, amd64Only, base, mustPlatform("linux/arm64"))
+	if err == nil {
+		t.Fatal("Expected error for base image without arm64 manifest")
+	}
+	for _, s := range []string{"distribution/golem-runner:0.1-bats", "platform linux/arm64", "linux/amd64, windows/amd64", "arch_baseimage for arm64"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("Expected error to contain %q: %v", s, err)
+		}
+	}
+
+	if err := checkBaseManifest(ctx, noImages, multiArch, base, mustPlatform("linux/arm64")); err != nil {
+		t.Fatalf("Unexpected error for matching manifest: %v", err)
+	}
+	if err := checkBaseManifest(ctx, noImages, multiArch, base, mustPlatform("linux/arm64/v7")); err == nil {
+		t.Fatal("Expected error for variant without manifest")
+	}
+
+	// Local images are checked once inspected, not in the registry
+	local := fakeImageInspector{base.String(): types.ImageInspect{ID: "sha256:base"}}
+	inspector := &fakeManifestInspector{}
+	if err := checkBaseManifest(ctx, local, inspector, base, mustPlatform("linux/arm64")); err != nil || inspector.calls > 0 {
+		t.Fatalf("Expected local image not to be checked, got %v after %d calls", err, inspector.calls)
+	}
+
+	// Single manifests and registry errors are left to the pull
+	for _, mi := range []*fakeManifestInspector{{}, {err: errors.New("connection refused")}} {
+		if err := checkBaseManifest(ctx, noImages, mi, base, mustPlatform("linux/arm64")); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := checkBaseManifest(ctx, noImages, amd64Only, base, Platform{}); err != nil {
+		t.Fatalf("Unexpected error without platform: %v", err)
+	}
+}
+
+func TestSelectArchBase(t *testing.T) {
+	archBase, err := newArchBase(map[string]string{"aarch64": "golem-runner:arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := BaseImageConfiguration{
+		Base:     assertTagged("golem-runner:latest"),
+		ArchBase: archBase,
+	}
+	if base := conf.selectBase(mustPlatform("linux/arm64")); base.String() != "golem-runner:arm64" {
+		t.Fatalf("Unexpected arm64 base %s", base)
+	}
+	if base := conf.selectBase(mustPlatform("linux/amd64")); base.String() != "golem-runner:latest" {
+		t.Fatalf("Unexpected amd64 base %s", base)
+	}
+
+	if _, err := newArchBase(map[string]string{"arm/v7": "golem-runner:arm"}); err == nil {
+		t.Fatal("Expected error for invalid architecture")
+	}
+	if _, err := newArchBase(map[string]string{"arm64": "golem-runner"}); err == nil {
+		t.Fatal("Expected error for untagged base image")
+	}
+
+	r := &runner{}
+	cli := &fakeDaemonInfoer{info: types.Info{OSType: "linux", Architecture: "aarch64"}}
+	for i := 0; i < 2; i++ {
+		p, err := r.buildPlatform(context.Background(), cli, Platform{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != "linux/arm64" {
+			t.Fatalf("Unexpected daemon platform %s", p)
+		}
+	}
+	if cli.calls != 1 {
+		t.Fatalf("Expected daemon platform to be cached, got %d info calls", cli.calls)
+	}
+	if p, _ := r.buildPlatform(context.Background(), cli, mustPlatform("linux/ppc64le")); p.String() != "linux/ppc64le" {
+		t.Fatalf("Expected configured platform, got %s", p)
+	}
+}
+
+func TestRegistryManifestPlatforms(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.URL.Query().Get("scope") != "repository:library/golem:pull" {
+				http.Error(w, "bad scope", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token":"anonymous"}`)
+		case "/v2/library/golem/manifests/list":
+			if r.Header.Get("Authorization") != "Bearer anonymous" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:library/golem:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeManifestList)
+			fmt.Fprint(w, `{"manifests":[{"platform":{"os":"linux","architecture":"amd64"}},{"platform":{"os":"linux","architecture":"arm","variant":"v7"}}]}`)
+		case "/v2/library/golem/manifests/single":
+			w.Header().Set("Content-Type", mediaTypeManifest)
+			fmt.Fprint(w, `{"schemaVersion":2}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ri := &registryManifestInspector{client: server.Client(), scheme: "http"}
+	host := strings.TrimPrefix(server.URL, "http://")
+	get := func(image string) ([]Platform, error) {
+		return ri.ManifestPlatforms(context.Background(), assertTagged(image))
+	}
+
+	platforms, err := get(host + "/library/golem:list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(platforms) != 2 || platforms[0].String() != "linux/amd64" || platforms[1].String() != "linux/arm/v7" {
+		t.Fatalf("Unexpected platforms %v", platforms)
+	}
+	if platforms, err := get(host + "/library/golem:single"); err != nil || len(platforms) != 0 {
+		t.Fatalf("Expected no platforms for single manifest, got %v, %v", platforms, err)
+	}
+	if _, err := get(host + "/library/golem:missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+
+	for image, expected := range map[string]string{
+		"golem:latest":                       "registry-1.docker.io library/golem",
+		"distribution/golem-runner:0.1":      "registry-1.docker.io distribution/golem-runner",
+		"docker.io/library/golem:latest":     "registry-1.docker.io library/golem",
+		"localhost/golem:latest":             "localhost golem",
+		"registry.example.com:5000/a/b:test": "registry.example.com:5000 a/b",
+	} {
+		host, repo := registryRepository(assertTagged(image))
+		if actual := host + " " + repo; actual != expected {
+			t.Fatalf("Unexpected registry repository %q for %s, expected %q", actual, image, expected)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package runner
 
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
 func expandCustomImageMatrix(images []CustomImage) [][]CustomImage {
 	imageMatrix := make([][]CustomImage, 0, len(images))
 	for _, img := range images {
@@ -50,3 +57,102 @@ func equalCustomImage(i1, i2 CustomImage) bool {
 
 	return true
 }
+
+// MatrixParam is a named suite parameter with the values
+// the test instances of the suite are expanded over.
+type MatrixParam struct {
+	Name   string
+	Values []string
+}
+
+// ParamValue is the value of a suite parameter for
+// a single test instance.
+type ParamValue struct {
+	Name  string
+	Value string
+}
+
+// paramNamePattern matches parameter names, which are set as
+// environment variables after converting with nameToEnv.
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// instanceNameInvalid matches characters not allowed in
+// instance names, which are used in image names.
+var instanceNameInvalid = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// newMatrixParams creates the suite parameters sorted by
+// name, returning an error for invalid names or values.
+func newMatrixParams(params map[string][]string) ([]MatrixParam, error) {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	matrix := make([]MatrixParam, 0, len(names))
+	for _, name := range names {
+		if !paramNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid param name %q, must start with a letter or underscore", name)
+		}
+		values := params[name]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("param %s has no values", name)
+		}
+		seen := map[string]struct{}{}
+		for _, value := range values {
+			if paramValueName(value) == "" {
+				return nil, fmt.Errorf("invalid value %q for param %s, must contain a letter or digit", value, name)
+			}
+			if _, ok := seen[value]; ok {
+				return nil, fmt.Errorf("duplicate value %q for param %s", value, name)
+			}
+			seen[value] = struct{}{}
+		}
+		matrix = append(matrix, MatrixParam{Name: name, Values: values})
+	}
+	return matrix, nil
+}
+
+// expandParamMatrix returns every combination of parameter
+// values, varying the last parameter fastest.
+func expandParamMatrix(params []MatrixParam) [][]ParamValue {
+	if len(params) == 0 {
+		return nil
+	}
+	matrix := [][]ParamValue{{}}
+	for _, param := range params {
+		expanded := make([][]ParamValue, 0, len(matrix)*len(param.Values))
+		for _, row := range matrix {
+			for _, value := range param.Values {
+				values := append(append([]ParamValue{}, row...), ParamValue{Name: param.Name, Value: value})
+				expanded = append(expanded, values)
+			}
+		}
+		matrix = expanded
+	}
+	return matrix
+}
+
+// paramValueName returns the value as used in instance names
+func paramValueName(value string) string {
+	return strings.Trim(instanceNameInvalid.ReplaceAllString(strings.ToLower(value), "-"), "-_.")
+}
+
+// paramInstanceName returns the instance name for the
+// parameter values, the values appended to the name.
+func paramInstanceName(name string, values []ParamValue) string {
+	for _, pv := range values {
+		name = name + "-" + paramValueName(pv.Value)
+	}
+	return name
+}
+
+// paramEnv returns the environment variables setting
+// each parameter to its value.
+func paramEnv(values []ParamValue) []string {
+	env := make([]string, 0, len(values))
+	for _, pv := range values {
+		env = append(env, nameToEnv(pv.Name)+"="+pv.Value)
+	}
+	return env
+}
@@ -1,6 +1,10 @@
 package runner
 
 import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/docker/distribution/reference"
@@ -61,3 +65,102 @@ func TestImageMatrixExpansion(t *testing.T) {
 		}
 	}
 }
+
+func TestParamMatrixExpansion(t *testing.T) {
+	params, err := newMatrixParams(map[string][]string{
+		"mode": {"fast", "slow", "Safe Mode"},
+		"db":   {"postgres", "mysql"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matrix := expandParamMatrix(params)
+	expected := [][]ParamValue{
+		{{"db", "postgres"}, {"mode", "fast"}},
+		{{"db", "postgres"}, {"mode", "slow"}},
+		{{"db", "postgres"}, {"mode", "Safe Mode"}},
+		{{"db", "mysql"}, {"mode", "fast"}},
+		{{"db", "mysql"}, {"mode", "slow"}},
+		{{"db", "mysql"}, {"mode", "Safe Mode"}},
+	}
+	if !reflect.DeepEqual(matrix, expected) {
+		t.Fatalf("Unexpected param matrix\n%v\nexpected\n%v", matrix, expected)
+	}
+
+	if name := paramInstanceName("suite", matrix[2]); name != "suite-postgres-safe-mode" {
+		t.Fatalf("Unexpected instance name %q", name)
+	}
+	if expandParamMatrix(nil) != nil {
+		t.Fatal("Expected no matrix without params")
+	}
+
+	for _, invalid := range []map[string][]string{
+		{"1db": {"postgres"}},
+		{"db": {}},
+		{"db": {"postgres", "postgres"}},
+		{"db": {"--"}},
+	} {
+		if _, err := newMatrixParams(invalid); err == nil {
+			t.Fatalf("Expected error for params %v", invalid)
+		}
+	}
+}
+
+func TestSuiteParams(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-params-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	resolve := func(config string) (SuiteConfiguration, error) {
+		writeTempFile(t, td, "golem.conf", config)
+		suites, err := parseSuites([]string{td})
+		if err != nil {
+			return SuiteConfiguration{}, err
+		}
+		suite := suites["params"]
+		return resolveConfigurationSuite(newMultiResolver(suite, globalDefault), suite, "")
+	}
+
+	sc, err := resolve(`[[suite]]
+  name="params"
+  allow_no_tests=true
+  [suite.params]
+    db=["postgres", "mysql"]
+    go-version=["1.6", "1.7", "tip"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, instance := range sc.Instances {
+		names = append(names, instance.Name)
+	}
+	expectedNames := []string{
+		"params-postgres-1.6", "params-postgres-1.7", "params-postgres-tip",
+		"params-mysql-1.6", "params-mysql-1.7", "params-mysql-tip",
+	}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("Unexpected instance names %v", names)
+	}
+	expectedEnv := []string{
+		"GOLEM_INSTANCE_NAME=params-mysql-1.7",
+		"GOLEM_INSTANCE_INDEX=5",
+		"DB=mysql",
+		"GO_VERSION=1.7",
+	}
+	if env := sc.Instances[4].InstanceEnv; !reflect.DeepEqual(env, expectedEnv) {
+		t.Fatalf("Unexpected instance env %v", env)
+	}
+
+	_, err = resolve(`[[suite]]
+  name="params"
+  allow_no_tests=true
+  [suite.params]
+    mode=["Fast", "fast!"]
+`)
+	if err == nil || !strings.Contains(err.Error(), "multiple instances named params-fast") {
+		t.Fatalf("Expected instance name conflict error, got %v", err)
+	}
+}
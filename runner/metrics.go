@@ -0,0 +1,232 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of the
+// duration histogram buckets.
+var durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+
+// durationHistogram counts durations into cumulative buckets.
+type durationHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *durationHistogram) observe(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, len(durationBuckets))
+	}
+	seconds := d.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Metrics collects counters and histograms of a run from the
+// recorded run events. A nil Metrics discards all events.
+type Metrics struct {
+	l sync.Mutex
+
+	imagesPulled  uint64
+	pullDurations durationHistogram
+	imagesSaved   uint64
+	savedBytes    int64
+
+	// buildDurations are keyed by build kind, base or instance
+	buildDurations map[string]*durationHistogram
+
+	instances map[InstanceStatus]uint64
+	summary   *RunSummary
+}
+
+// NewMetrics creates an empty metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buildDurations: map[string]*durationHistogram{},
+		instances:      map[InstanceStatus]uint64{},
+	}
+}
+
+// Observe updates the metrics from a run event.
+func (m *Metrics) Observe(e Event) {
+	if m == nil {
+		return
+	}
+	m.l.Lock()
+	defer m.l.Unlock()
+	switch e.Type {
+	case EventImagePulled:
+		m.imagesPulled++
+		m.pullDurations.observe(e.Elapsed)
+	case EventImageSaved:
+		m.imagesSaved++
+		m.savedBytes += e.Bytes
+	case EventBaseImageBuilt:
+		m.observeBuild("base", e.Elapsed)
+	case EventBuildFinished:
+		if e.Error != "" {
+			return
+		}
+		m.observeBuild("instance", e.Elapsed)
+	case EventInstanceResult:
+		if e.Result == nil {
+			return
+		}
+		status := e.Result.Status
+		if status == "" {
+			status = ExitStatus(e.Result.ExitCode)
+		}
+		m.instances[status]++
+	case EventRunSummary:
+		m.summary = e.Summary
+	}
+}
+
+func (m *Metrics) observeBuild(kind string, d time.Duration) {
+	h, ok := m.buildDurations[kind]
+	if !ok {
+		h = &durationHistogram{}
+		m.buildDurations[kind] = h
+	}
+	h.observe(d)
+}
+
+// metricsWriter writes metrics in the text exposition format,
+// keeping the first write error.
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (mw *metricsWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+func (mw *metricsWriter) header(name, typ, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (mw *metricsWriter) histogram(name, labels string, h *durationHistogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range durationBuckets {
+		var count uint64
+		if h.buckets != nil {
+			count = h.buckets[i]
+		}
+		mw.printf("%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(le), count)
+	}
+	mw.printf("%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	mw.printf("%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	mw.printf("%s_count%s %d\n", name, labels, h.count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteText writes the metrics in the Prometheus text
+// exposition format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+
+	mw.header("golem_images_pulled_total", "counter", "Number of images pulled.")
+	mw.printf("golem_images_pulled_total %d\n", m.imagesPulled)
+	mw.header("golem_image_pull_duration_seconds", "histogram", "Time taken to pull an image.")
+	mw.histogram("golem_image_pull_duration_seconds", "", &m.pullDurations)
+
+	mw.header("golem_images_saved_total", "counter", "Number of images saved into base images.")
+	mw.printf("golem_images_saved_total %d\n", m.imagesSaved)
+	mw.header("golem_image_saved_bytes_total", "counter", "Bytes of images saved into base images, loaded by each dind instance.")
+	mw.printf("golem_image_saved_bytes_total %d\n", m.savedBytes)
+
+	mw.header("golem_build_duration_seconds", "histogram", "Time taken to build base and instance images.")
+	kinds := make([]string, 0, len(m.buildDurations))
+	for kind := range m.buildDurations {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		mw.histogram("golem_build_duration_seconds", fmt.Sprintf("kind=%q", kind), m.buildDurations[kind])
+	}
+
+	mw.header("golem_instance_runs_total", "counter", "Number of instance runs by status.")
+	statuses := make([]string, 0, len(m.instances))
+	for status := range m.instances {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		mw.printf("golem_instance_runs_total{status=%q} %d\n", status, m.instances[InstanceStatus(status)])
+	}
+
+	if m.summary != nil {
+		mw.header("golem_run_instances", "gauge", "Number of instances in the run by result.")
+		mw.printf("golem_run_instances{result=\"ran\"} %d\n", m.summary.Ran)
+		mw.printf("golem_run_instances{result=\"failed\"} %d\n", m.summary.Failed)
+		mw.printf("golem_run_instances{result=\"skipped\"} %d\n", m.summary.Skipped)
+		mw.header("golem_run_duration_seconds", "gauge", "Time taken to run all instances.")
+		mw.printf("golem_run_duration_seconds %s\n", formatFloat(m.summary.Elapsed.Seconds()))
+	}
+
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// WriteFile writes the metrics to a file, replacing the file
+// atomically so collectors never read partial metrics.
+func (m *Metrics) WriteFile(filename string) error {
+	if m == nil {
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".metrics-")
+	if err != nil {
+		return err
+	}
+	if err := m.WriteText(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
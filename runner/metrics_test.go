@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMetrics(t *testing.T) {
+	m := NewMetrics()
+	r := &runner{config: RunnerConfiguration{Metrics: m}}
+
+	for _, e := range []Event{
+		{Type: EventImagePulled, Image: "busybox:latest", Elapsed: 3 * time.Second},
+		{Type: EventImagePulled, Image: "golang:1.7", Elapsed: 45 * time.Second},
+		{Type: EventImageSaved, Image: "sha256:3b8e8e4b1a1b", Bytes: 1024},
+		{Type: EventImageSaved, Image: "sha256:0f864637f229", Bytes: 2048},
+		{Type: EventBaseImageBuilt, Image: "sha256:9c2a3f4c6e51", Elapsed: 90 * time.Second},
+		{Type: EventBuildStarted, Instance: "suite-1"},
+		{Type: EventBuildFinished, Instance: "suite-1", Elapsed: 100 * time.Second},
+		{Type: EventBuildFinished, Instance: "suite-2", Elapsed: 2 * time.Second},
+		{Type: EventInstanceResult, Instance: "suite-1", Result: &InstanceResult{Name: "suite-1", Passed: true, Status: InstancePassed}},
+		{Type: EventInstanceResult, Instance: "suite-2", Result: &InstanceResult{Name: "suite-2", ExitCode: ExitTestFailed, Status: InstanceTestFailed}},
+		{Type: EventInstanceResult, Instance: "suite-3", Result: &InstanceResult{Name: "suite-3", Status: InstanceSkipped}},
+		{Type: EventRunSummary, Summary: &RunSummary{Ran: 2, Failed: 1, Skipped: 1, Elapsed: 150 * time.Second}},
+	} {
+		r.logEvent(e)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := m.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		"# TYPE golem_images_pulled_total counter\ngolem_images_pulled_total 2\n",
+		"golem_image_pull_duration_seconds_bucket{le=\"5\"} 1\n",
+		"golem_image_pull_duration_seconds_bucket{le=\"60\"} 2\n",
+		"golem_image_pull_duration_seconds_bucket{le=\"+Inf\"} 2\n",
+		"golem_image_pull_duration_seconds_sum 48\n",
+		"golem_image_pull_duration_seconds_count 2\n",
+		"golem_images_saved_total 2\n",
+		"golem_image_saved_bytes_total 3072\n",
+		"golem_build_duration_seconds_bucket{kind=\"base\",le=\"60\"} 0\n",
+		"golem_build_duration_seconds_bucket{kind=\"base\",le=\"120\"} 1\n",
+		"golem_build_duration_seconds_count{kind=\"base\"} 1\n",
+		"golem_build_duration_seconds_bucket{kind=\"instance\",le=\"5\"} 1\n",
+		"golem_build_duration_seconds_sum{kind=\"instance\"} 102\n",
+		"golem_build_duration_seconds_count{kind=\"instance\"} 2\n",
+		"golem_instance_runs_total{status=\"passed\"} 1\n",
+		"golem_instance_runs_total{status=\"skipped\"} 1\n",
+		"golem_instance_runs_total{status=\"test-failed\"} 1\n",
+		"golem_run_instances{result=\"ran\"} 2\n",
+		"golem_run_instances{result=\"failed\"} 1\n",
+		"golem_run_instances{result=\"skipped\"} 1\n",
+		"golem_run_duration_seconds 150\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("Missing %q in metrics:\n%s", expected, out)
+		}
+	}
+}
+
+func TestMetricsWriteFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-metrics-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	m := NewMetrics()
+	m.Observe(Event{Type: EventImagePulled, Elapsed: time.Second})
+	filename := filepath.Join(td, "golem.prom")
+	if err := m.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "golem_images_pulled_total 1\n") {
+		t.Fatalf("Unexpected metrics file:\n%s", b)
+	}
+	files, err := ioutil.ReadDir(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected only the metrics file, found %d files", len(files))
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.Observe(Event{Type: EventImagePulled})
+	if err := nilMetrics.WriteText(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount represents a host path mounted into
+// the test container.
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// ParseMount parses a mount specification in the form
+// host:container[:ro|rw]. A relative host path is resolved
+// against the provided directory.
+func ParseMount(spec, dir string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Mount{}, fmt.Errorf("invalid mount %q, expected host:container[:ro|rw]", spec)
+	}
+	if parts[0] == "" || parts[1] == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q, empty path", spec)
+	}
+
+	m := Mount{
+		Source: parts[0],
+		Target: parts[1],
+	}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return Mount{}, fmt.Errorf("invalid mount %q, mode must be ro or rw", spec)
+		}
+	}
+
+	if !filepath.IsAbs(m.Source) {
+		m.Source = filepath.Join(dir, m.Source)
+	}
+	if !filepath.IsAbs(m.Target) {
+		return Mount{}, fmt.Errorf("invalid mount %q, container path must be absolute", spec)
+	}
+	m.Target = filepath.Clean(m.Target)
+
+	return m, nil
+}
+
+// validateMounts checks that all mount sources exist and that
+// the targets do not conflict with each other or the paths
+// reserved by the runner.
+func validateMounts(mounts []Mount, dind bool) error {
+	reserved := map[string]string{
+		"/var/log/docker": "docker log volume",
+		"/runner":         "runner directory",
+	}
+	if dind {
+		reserved["/var/lib/docker"] = "docker graph volume"
+	}
+	targets := map[string]struct{}{}
+	for _, m := range mounts {
+		if _, err := os.Stat(m.Source); err != nil {
+			return fmt.Errorf("invalid mount source %s: %v", m.Source, err)
+		}
+		if name, ok := reserved[m.Target]; ok {
+			return fmt.Errorf("mount target %s conflicts with %s", m.Target, name)
+		}
+		if _, ok := targets[m.Target]; ok {
+			return fmt.Errorf("duplicate mount target %s", m.Target)
+		}
+		targets[m.Target] = struct{}{}
+	}
+	return nil
+}
+
+// Bind returns the mount as a bind specification
+// for the container host configuration.
+func (m Mount) Bind() string {
+	if m.ReadOnly {
+		return m.Source + ":" + m.Target + ":ro"
+	}
+	return m.Source + ":" + m.Target
+}
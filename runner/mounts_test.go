@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMount(t *testing.T) {
+	cases := []struct {
+		spec     string
+		expected Mount
+		bind     string
+	}{
+		{
+			spec:     "/srv/fixtures:/fixtures",
+			expected: Mount{Source: "/srv/fixtures", Target: "/fixtures"},
+			bind:     "/srv/fixtures:/fixtures",
+		},
+		{
+			spec:     "/srv/fixtures:/fixtures:ro",
+			expected: Mount{Source: "/srv/fixtures", Target: "/fixtures", ReadOnly: true},
+			bind:     "/srv/fixtures:/fixtures:ro",
+		},
+		{
+			spec:     "fixtures:/fixtures/:rw",
+			expected: Mount{Source: "/suite/fixtures", Target: "/fixtures"},
+			bind:     "/suite/fixtures:/fixtures",
+		},
+	}
+	for _, c := range cases {
+		m, err := ParseMount(c.spec, "/suite")
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", c.spec, err)
+		}
+		if m != c.expected {
+			t.Fatalf("Unexpected mount for %q: %#v, expected %#v", c.spec, m, c.expected)
+		}
+		if b := m.Bind(); b != c.bind {
+			t.Fatalf("Unexpected bind for %q: %s, expected %s", c.spec, b, c.bind)
+		}
+	}
+
+	for _, invalid := range []string{"/srv", ":/fixtures", "/srv:", "/srv:/fixtures:rx", "/srv:fixtures", "/srv:/a:ro:z"} {
+		if _, err := ParseMount(invalid, "/suite"); err == nil {
+			t.Fatalf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestValidateMounts(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-mounts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	valid := []Mount{
+		{Source: td, Target: "/fixtures", ReadOnly: true},
+		{Source: td, Target: "/registry"},
+	}
+	if err := validateMounts(valid, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Graph directory only reserved when running docker in docker
+	graph := []Mount{{Source: td, Target: "/var/lib/docker"}}
+	if err := validateMounts(graph, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	invalid := [][]Mount{
+		{{Source: filepath.Join(td, "missing"), Target: "/fixtures"}},
+		{{Source: td, Target: "/var/lib/docker"}},
+		{{Source: td, Target: "/var/log/docker"}},
+		{{Source: td, Target: "/fixtures"}, {Source: td, Target: "/fixtures"}},
+	}
+	for _, mounts := range invalid {
+		if err := validateMounts(mounts, true); err == nil {
+			t.Fatalf("Expected error validating %#v", mounts)
+		}
+	}
+}
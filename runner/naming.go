@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+// DefaultNameTemplate is the template of instance container
+// names when none is configured, including the run id so
+// concurrent runs on the same daemon do not collide.
+const DefaultNameTemplate = "golem-{{.RunID}}-{{.Instance}}"
+
+// nameData are the values available to container name templates.
+type nameData struct {
+	RunID    string
+	Suite    string
+	Instance string
+}
+
+// containerNameRegexp matches the container names accepted by
+// the docker daemon.
+var containerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// renderContainerName renders the container name template with the
+// run, suite and instance names. Values are used unchanged, returns
+// an error if the name is not a valid container name.
+func renderContainerName(text string, data nameData) (string, error) {
+	tmpl, err := template.New("name").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid name template %q: %v", text, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("invalid name template %q: %v", text, err)
+	}
+	name := buf.String()
+	if !containerNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid container name %q, must be letters, digits, underscores, periods and dashes", name)
+	}
+	return name, nil
+}
+
+// ValidateNameTemplate checks the container name template renders
+// a valid name which differs between instances.
+func ValidateNameTemplate(text string) error {
+	first, err := renderContainerName(text, nameData{RunID: "0123456789ab", Suite: "suite", Instance: "suite-1"})
+	if err != nil {
+		return err
+	}
+	second, err := renderContainerName(text, nameData{RunID: "0123456789ab", Suite: "suite", Instance: "suite-2"})
+	if err != nil {
+		return err
+	}
+	if first == second {
+		return errors.New("name template must include the instance")
+	}
+	return nil
+}
+
+// containerNames returns the container names of the configured
+// instances by instance name, returning an error when instances
+// would share a container name.
+func (r *runner) containerNames() (map[string]string, error) {
+	names := map[string]string{}
+	instances := map[string]string{}
+	for _, suite := range r.config.Suites {
+		for _, instance := range suite.Instances {
+			if _, ok := names[instance.Name]; ok {
+				return nil, fmt.Errorf("multiple instances named %s", instance.Name)
+			}
+			name, err := r.containerName(suite, instance)
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := instances[name]; ok {
+				return nil, fmt.Errorf("instances %s and %s have the same container name %s", other, instance.Name, name)
+			}
+			instances[name] = instance.Name
+			names[instance.Name] = name
+		}
+	}
+	return names, nil
+}
+
+// containerName returns the name of the container of an instance
+// from the configured name template.
+func (r *runner) containerName(suite SuiteConfiguration, instance InstanceConfiguration) (string, error) {
+	text := r.config.NameTemplate
+	if text == "" {
+		text = DefaultNameTemplate
+	}
+	return renderContainerName(text, nameData{
+		RunID:    r.config.RunID,
+		Suite:    suite.Name,
+		Instance: instance.Name,
+	})
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderContainerName(t *testing.T) {
+	for _, tc := range []struct {
+		template string
+		data     nameData
+		expected string
+	}{
+		{DefaultNameTemplate, nameData{RunID: "0a1b2c", Suite: "registry", Instance: "registry-2"}, "golem-0a1b2c-registry-2"},
+		{"golem-{{.Instance}}", nameData{RunID: "0a1b2c", Suite: "registry", Instance: "registry-2"}, "golem-registry-2"},
+		{"{{.Suite}}-{{.Instance}}", nameData{Suite: "My_Suite", Instance: "My_Suite.v1"}, "My_Suite-My_Suite.v1"},
+		{"golem-{{.Instance}}", nameData{Instance: strings.Repeat("x", 70)}, "golem-" + strings.Repeat("x", 70)},
+	} {
+		name, err := renderContainerName(tc.template, tc.data)
+		if err != nil {
+			t.Fatalf("Error rendering %q: %v", tc.template, err)
+		}
+		if name != tc.expected {
+			t.Fatalf("Unexpected name %s rendering %q, expected %s", name, tc.template, tc.expected)
+		}
+	}
+
+	for _, tc := range []struct {
+		template string
+		err      string
+	}{
+		{"golem-{{.Instance", "invalid name template"},
+		{"golem-{{.Unknown}}", "invalid name template"},
+		{"-golem-{{.Instance}}", "invalid container name"},
+		{"golem/{{.Instance}}", "invalid container name"},
+		{"golem {{.Instance}}", "invalid container name"},
+	} {
+		_, err := renderContainerName(tc.template, nameData{RunID: "0a1b2c", Suite: "registry", Instance: "registry-2"})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("Unexpected error rendering %q: %v, expected %q", tc.template, err, tc.err)
+		}
+	}
+}
+
+func TestValidateNameTemplate(t *testing.T) {
+	if err := ValidateNameTemplate(DefaultNameTemplate); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateNameTemplate("golem-{{.RunID}}-{{.Instance}}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateNameTemplate("golem-{{.Suite}}"); err == nil || !strings.Contains(err.Error(), "must include the instance") {
+		t.Fatalf("Unexpected error for template without instance: %v", err)
+	}
+}
+
+func TestContainerNamesConcurrentRuns(t *testing.T) {
+	suites := []SuiteConfiguration{
+		{Name: "registry", Instances: []InstanceConfiguration{{Name: "registry-1"}, {Name: "registry-2"}}},
+		{Name: "swarm", Instances: []InstanceConfiguration{{Name: "swarm"}}},
+	}
+	names := map[string]struct{}{}
+	for _, runID := range []string{"0a1b2c3d4e5f", "f5e4d3c2b1a0"} {
+		r := &runner{config: RunnerConfiguration{
+			RunID:  runID,
+			Suites: suites,
+		}}
+		for _, suite := range suites {
+			for _, instance := range suite.Instances {
+				name, err := r.containerName(suite, instance)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(name, runID) {
+					t.Fatalf("Expected name %s to contain run id %s", name, runID)
+				}
+				if _, ok := names[name]; ok {
+					t.Fatalf("Duplicate container name %s", name)
+				}
+				names[name] = struct{}{}
+			}
+		}
+	}
+	if len(names) != 6 {
+		t.Fatalf("Unexpected number of names %d, expected 6", len(names))
+	}
+}
+
+func TestContainerNamesCollide(t *testing.T) {
+	r := &runner{config: RunnerConfiguration{
+		RunID:        "0a1b2c3d4e5f",
+		NameTemplate: "golem-{{.Instance}}",
+		Suites: []SuiteConfiguration{
+			{Name: "registry", Instances: []InstanceConfiguration{{Name: "a_b"}, {Name: "a.b"}}},
+			{Name: "swarm", Instances: []InstanceConfiguration{{Name: "swarm"}}},
+		},
+	}}
+	names, err := r.containerNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names["a_b"] != "golem-a_b" || names["a.b"] != "golem-a.b" {
+		t.Fatalf("Unexpected container names %v", names)
+	}
+
+	// Template without the instance name
+	r.config.NameTemplate = "golem-{{.Suite}}"
+	if _, err := r.containerNames(); err == nil || !strings.Contains(err.Error(), "a_b and a.b have the same container name golem-registry") {
+		t.Fatalf("Expected error for colliding container names, got %v", err)
+	}
+}
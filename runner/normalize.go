@@ -0,0 +1,207 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/reference"
+)
+
+// ReferencePolicy determines how image references are
+// normalized before being used as tags and cache keys.
+type ReferencePolicy string
+
+const (
+	// ReferenceStrict uses references as written, so "redis"
+	// and "docker.io/library/redis" are different images.
+	ReferenceStrict ReferencePolicy = "strict"
+
+	// ReferenceDocker normalizes references as the docker
+	// client does, so "redis" becomes "docker.io/library/redis".
+	ReferenceDocker ReferencePolicy = "docker"
+)
+
+const (
+	defaultRegistry = "docker.io"
+	legacyRegistry  = "index.docker.io"
+	officialRepo    = "library/"
+)
+
+func (p *ReferencePolicy) String() string {
+	return string(*p)
+}
+
+// Set sets the reference policy from a string, allowing
+// the policy to be used as a flag value.
+func (p *ReferencePolicy) Set(s string) error {
+	switch policy := ReferencePolicy(s); policy {
+	case ReferenceStrict, ReferenceDocker:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("invalid reference policy %q, must be one of strict or docker", s)
+}
+
+// normalizeName returns the normalized repository name,
+// an empty policy is treated as strict.
+func (p ReferencePolicy) normalizeName(name string) string {
+	if p != ReferenceDocker {
+		return name
+	}
+	i := strings.IndexRune(name, '/')
+	if i == -1 || (!strings.ContainsAny(name[:i], ".:") && name[:i] != "localhost") {
+		name = defaultRegistry + "/" + name
+	} else if name[:i] == legacyRegistry {
+		name = defaultRegistry + name[i:]
+	}
+	if strings.HasPrefix(name, defaultRegistry+"/") && !strings.ContainsRune(name[len(defaultRegistry)+1:], '/') {
+		name = defaultRegistry + "/" + officialRepo + name[len(defaultRegistry)+1:]
+	}
+	return name
+}
+
+// normalizeNamed returns the normalized reference, keeping
+// any tag or digest of the reference.
+func (p ReferencePolicy) normalizeNamed(named reference.Named) (reference.Named, error) {
+	name := p.normalizeName(named.Name())
+	if name == named.Name() {
+		return named, nil
+	}
+	normalized, err := reference.WithName(name)
+	if err != nil {
+		return nil, err
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		return reference.WithTag(normalized, tagged.Tag())
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		return reference.WithDigest(normalized, digested.Digest())
+	}
+	return normalized, nil
+}
+
+// normalizeTagged returns the normalized tagged reference.
+func (p ReferencePolicy) normalizeTagged(tagged reference.NamedTagged) (reference.NamedTagged, error) {
+	named, err := p.normalizeNamed(tagged)
+	if err != nil {
+		return nil, err
+	}
+	return named.(reference.NamedTagged), nil
+}
+
+// normalizeString parses and normalizes a reference string,
+// such as a tag from an image's repo tags.
+func (p ReferencePolicy) normalizeString(s string) (string, error) {
+	if p != ReferenceDocker {
+		return s, nil
+	}
+	named, err := reference.ParseNamed(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid reference %q: %v", s, err)
+	}
+	normalized, err := p.normalizeNamed(named)
+	if err != nil {
+		return "", err
+	}
+	return normalized.String(), nil
+}
+
+// normalizeStrings normalizes a list of reference strings,
+// keeping any reference which fails to normalize.
+func (p ReferencePolicy) normalizeStrings(refs []string) []string {
+	if p != ReferenceDocker {
+		return refs
+	}
+	normalized := make([]string, len(refs))
+	for i, ref := range refs {
+		n, err := p.normalizeString(ref)
+		if err != nil {
+			logrus.Debugf("Not normalizing %s: %v", ref, err)
+			n = ref
+		}
+		normalized[i] = n
+	}
+	return normalized
+}
+
+// mustTagged normalizes a tagged reference which was already
+// validated when parsed, keeping it on the unexpected error of
+// exceeding the maximum name length once normalized.
+func (p ReferencePolicy) mustTagged(tagged reference.NamedTagged) reference.NamedTagged {
+	if tagged == nil {
+		return nil
+	}
+	normalized, err := p.normalizeTagged(tagged)
+	if err != nil {
+		logrus.Warnf("Not normalizing %s: %v", tagged, err)
+		return tagged
+	}
+	return normalized
+}
+
+// customImages returns the custom images with normalized targets
+// and sources.
+func (p ReferencePolicy) customImages(images []CustomImage) []CustomImage {
+	if p != ReferenceDocker {
+		return images
+	}
+	normalized := make([]CustomImage, len(images))
+	for i, ci := range images {
+		ci.Target = p.mustTagged(ci.Target)
+		if ci.Source != "" {
+			ci.Source = p.normalizeStrings([]string{ci.Source})[0]
+		}
+		normalized[i] = ci
+	}
+	return normalized
+}
+
+// archBase returns the architecture base images normalized.
+func (p ReferencePolicy) archBase(archBase map[string]reference.NamedTagged) map[string]reference.NamedTagged {
+	if p != ReferenceDocker || archBase == nil {
+		return archBase
+	}
+	normalized := make(map[string]reference.NamedTagged, len(archBase))
+	for arch, base := range archBase {
+		normalized[arch] = p.mustTagged(base)
+	}
+	return normalized
+}
+
+// resolver returns a resolver normalizing the image references
+// of the provided resolver.
+func (p ReferencePolicy) resolver(r resolver) resolver {
+	if p != ReferenceDocker {
+		return r
+	}
+	return normalizingResolver{resolver: r, policy: p}
+}
+
+// normalizingResolver normalizes the image references of
+// a resolver so images from flags and suites configured with
+// different forms of the same reference match.
+type normalizingResolver struct {
+	resolver
+	policy ReferencePolicy
+}
+
+func (nr normalizingResolver) BaseImage() reference.NamedTagged {
+	return nr.policy.mustTagged(nr.resolver.BaseImage())
+}
+
+func (nr normalizingResolver) Images() []reference.NamedTagged {
+	images := nr.resolver.Images()
+	if images == nil {
+		return nil
+	}
+	normalized := make([]reference.NamedTagged, len(images))
+	for i, img := range images {
+		normalized[i] = nr.policy.mustTagged(img)
+	}
+	return normalized
+}
+
+func (nr normalizingResolver) CustomImages() []CustomImage {
+	return nr.policy.customImages(nr.resolver.CustomImages())
+}
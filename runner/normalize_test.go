@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"golang.org/x/net/context"
+)
+
+func TestReferencePolicyNormalize(t *testing.T) {
+	cases := []struct {
+		ref    string
+		docker string
+	}{
+		{"redis:3", "docker.io/library/redis:3"},
+		{"docker.io/redis:3", "docker.io/library/redis:3"},
+		{"index.docker.io/library/redis:3", "docker.io/library/redis:3"},
+		{"docker.io/library/redis:3", "docker.io/library/redis:3"},
+		{"distribution/golem-runner:0.1-bats", "docker.io/distribution/golem-runner:0.1-bats"},
+		{"localhost/golem:latest", "localhost/golem:latest"},
+		{"localhost:5000/redis:3", "localhost:5000/redis:3"},
+		{"quay.io/coreos/etcd:v3.0.0", "quay.io/coreos/etcd:v3.0.0"},
+		{"redis@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", "docker.io/library/redis@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+	}
+	for _, c := range cases {
+		normalized, err := ReferenceDocker.normalizeString(c.ref)
+		if err != nil {
+			t.Fatalf("Error normalizing %s: %v", c.ref, err)
+		}
+		if normalized != c.docker {
+			t.Fatalf("Unexpected normalized reference for %s: %s, expected %s", c.ref, normalized, c.docker)
+		}
+		strict, err := ReferenceStrict.normalizeString(c.ref)
+		if err != nil || strict != c.ref {
+			t.Fatalf("Expected strict policy to keep %s, got %s: %v", c.ref, strict, err)
+		}
+	}
+
+	var p ReferencePolicy
+	if err := p.Set("docker"); err != nil || p != ReferenceDocker {
+		t.Fatalf("Unexpected policy %q: %v", p, err)
+	}
+	if err := p.Set("familiar"); err == nil {
+		t.Fatal("Expected error for invalid policy")
+	}
+}
+
+func TestReferencePolicyConfigAndSync(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-normalize-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	// The same logical images written in different forms
+	writeTempFile(t, td, "golem.conf", `[[suite]]
+  name="normalize"
+  dind=true
+  allow_no_tests=true
+  images=["redis:3", "docker.io/library/nginx:1.9"]
+`)
+	suites, err := parseSuites([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	suite := suites["normalize"]
+	resolver := newMultiResolver(ReferenceDocker.resolver(suite), ReferenceDocker.resolver(globalDefault))
+	sc, err := resolveConfigurationSuite(resolver, suite, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Instances) != 1 {
+		t.Fatalf("Unexpected instances %#v", sc.Instances)
+	}
+	base := sc.Instances[0].BaseImage
+	if base.Base.String() != "docker.io/distribution/golem-runner:0.1-bats" {
+		t.Fatalf("Unexpected base image %s", base.Base)
+	}
+	var configTags []string
+	for _, img := range base.ExtraImages {
+		configTags = append(configTags, img.String())
+	}
+	sort.Strings(configTags)
+	expected := []string{"docker.io/library/nginx:1.9", "docker.io/library/redis:3"}
+	if !reflect.DeepEqual(configTags, expected) {
+		t.Fatalf("Unexpected config images %v, expected %v", configTags, expected)
+	}
+
+	// Images saved with the normalized config tags are already tagged
+	// in familiar form in the daemon, sync must not retag or reload
+	imagesDir := td + "/images"
+	if err := os.Mkdir(imagesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTempFile(t, imagesDir, "images.json", `{"sha256:redis":["docker.io/library/redis:3"],"sha256:nginx":["nginx:1.9"]}`)
+	cli := &fakeSyncClient{
+		listed: []types.Image{
+			{ID: "sha256:redis", RepoTags: []string{"redis:3"}},
+			{ID: "sha256:nginx", RepoTags: []string{"docker.io/library/nginx:1.9"}},
+		},
+	}
+	if err := syncImages(context.Background(), cli, imagesDir, ReferenceDocker, true, ioutil.Discard, true); err != nil {
+		t.Fatal(err)
+	}
+	if cli.loads != 0 || len(cli.tags) != 0 {
+		t.Fatalf("Unexpected loads %d and tags %v for normalized sync", cli.loads, cli.tags)
+	}
+
+	var syncTags []string
+	for _, tags := range [][]string{{"redis:3"}, {"docker.io/library/nginx:1.9"}} {
+		syncTags = append(syncTags, ReferenceDocker.normalizeStrings(tags)...)
+	}
+	sort.Strings(syncTags)
+	if !reflect.DeepEqual(syncTags, configTags) {
+		t.Fatalf("Sync tags %v do not match config tags %v", syncTags, configTags)
+	}
+
+	// Strict policy treats the forms as different tags
+	if err := syncImages(context.Background(), cli, imagesDir, ReferenceStrict, false, ioutil.Discard, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(cli.tags) != 2 {
+		t.Fatalf("Expected strict sync to retag both images, got %v", cli.tags)
+	}
+}
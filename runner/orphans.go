@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+)
+
+// orphanRemover is the subset of the docker client used to
+// find and remove resources left behind by previous runs.
+type orphanRemover interface {
+	containerRemover
+	volumePruner
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+}
+
+// isGolemContainer returns whether a container was created by golem,
+// either labeled by golem or named and created from a golem test image
+// by a version of golem which did not label containers.
+func isGolemContainer(c types.Container) bool {
+	if c.Labels[golemLabel] == "true" {
+		return true
+	}
+	var named bool
+	for _, name := range c.Names {
+		if strings.HasPrefix(strings.TrimPrefix(name, "/"), "golem-") {
+			named = true
+			break
+		}
+	}
+	return named && strings.HasPrefix(path.Base(c.Image), "golem-")
+}
+
+// staleContainers returns the golem containers which are
+// not named by the current plan.
+func staleContainers(containers []types.Container, planned map[string]struct{}) []types.Container {
+	var stale []types.Container
+	for _, c := range containers {
+		if !isGolemContainer(c) {
+			continue
+		}
+		var isPlanned bool
+		for _, name := range c.Names {
+			if _, ok := planned[strings.TrimPrefix(name, "/")]; ok {
+				isPlanned = true
+				break
+			}
+		}
+		if !isPlanned {
+			stale = append(stale, c)
+		}
+	}
+	return stale
+}
+
+// staleVolumes returns the volumes labeled by golem
+// which are not named by the current plan.
+func staleVolumes(volumes []*types.Volume, planned map[string]struct{}) []*types.Volume {
+	var stale []*types.Volume
+	for _, vol := range selectGolemVolumes(volumes, "") {
+		if _, ok := planned[vol.Name]; !ok {
+			stale = append(stale, vol)
+		}
+	}
+	return stale
+}
+
+// plannedResources returns the names of the containers
+// and volumes used by the configured suites.
+func (r *runner) plannedResources() (containers, volumes map[string]struct{}, err error) {
+	names, err := r.containerNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	containers = map[string]struct{}{}
+	volumes = map[string]struct{}{}
+	for _, contName := range names {
+		containers[contName] = struct{}{}
+		volumes[contName+"-graph"] = struct{}{}
+	}
+	return
+}
+
+// removeOrphans removes containers and volumes left behind by
+// previous runs which are not part of the current plan. Resources
+// which were not created by golem are never removed.
+func (r *runner) removeOrphans(ctx context.Context, cli orphanRemover) error {
+	plannedContainers, plannedVolumes, err := r.plannedResources()
+	if err != nil {
+		return err
+	}
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("error listing containers: %v", err)
+	}
+	for _, c := range staleContainers(containers, plannedContainers) {
+		logrus.Infof("Removing orphaned container %s %v", c.ID, c.Names)
+		removeOptions := types.ContainerRemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		}
+		if err := removeContainer(ctx, cli, c.ID, r.config.StopTimeout, removeOptions); err != nil {
+			return fmt.Errorf("error removing orphaned container %s: %v", c.ID, err)
+		}
+	}
+
+	filter := filters.NewArgs()
+	filter.Add("label", golemLabel+"=true")
+	resp, err := cli.VolumeList(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("error listing volumes: %v", err)
+	}
+	for _, vol := range staleVolumes(resp.Volumes, plannedVolumes) {
+		logrus.Infof("Removing orphaned volume %s", vol.Name)
+		if err := cli.VolumeRemove(ctx, vol.Name); err != nil {
+			return fmt.Errorf("error removing orphaned volume %s: %v", vol.Name, err)
+		}
+	}
+
+	return nil
+}
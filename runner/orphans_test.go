@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+)
+
+type fakeOrphanRemover struct {
+	containers []types.Container
+	volumes    []*types.Volume
+
+	removedContainers []string
+	removedVolumes    []string
+}
+
+func (f *fakeOrphanRemover) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeOrphanRemover) ContainerStop(ctx context.Context, containerID string, timeout int) error {
+	return nil
+}
+
+func (f *fakeOrphanRemover) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	f.removedContainers = append(f.removedContainers, containerID)
+	return nil
+}
+
+func (f *fakeOrphanRemover) VolumeList(ctx context.Context, filter filters.Args) (types.VolumesListResponse, error) {
+	return types.VolumesListResponse{Volumes: f.volumes}, nil
+}
+
+func (f *fakeOrphanRemover) VolumeRemove(ctx context.Context, volumeID string) error {
+	f.removedVolumes = append(f.removedVolumes, volumeID)
+	return nil
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	f := &fakeOrphanRemover{
+		containers: []types.Container{
+			// Planned container is kept
+			{ID: "c1", Names: []string{"/golem-0a1b2c-registry"}, Image: "golem-registry:latest", Labels: map[string]string{golemLabel: "true"}},
+			// Labeled container from crashed run
+			{ID: "c2", Names: []string{"/golem-old"}, Image: "golem-old:latest", Labels: map[string]string{golemLabel: "true"}},
+			// Unlabeled container from golem image
+			{ID: "c3", Names: []string{"/golem-older"}, Image: "myns/golem-older:latest"},
+			// Non-golem containers
+			{ID: "c4", Names: []string{"/golem-webapp"}, Image: "nginx:1.9"},
+			{ID: "c5", Names: []string{"/registry"}, Image: "registry:2"},
+		},
+		volumes: []*types.Volume{
+			{Name: "golem-0a1b2c-registry-graph", Labels: map[string]string{golemLabel: "true"}},
+			{Name: "golem-old-graph", Labels: map[string]string{golemLabel: "true"}},
+			{Name: "golem-unlabeled-graph"},
+			{Name: "data"},
+		},
+	}
+
+	r := &runner{
+		config: RunnerConfiguration{
+			RunID: "0a1b2c",
+			Suites: []SuiteConfiguration{
+				{
+					Name:      "registry",
+					Instances: []InstanceConfiguration{{Name: "registry"}},
+				},
+			},
+		},
+	}
+	if err := r.removeOrphans(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedContainers := []string{"c2", "c3"}
+	if len(f.removedContainers) != len(expectedContainers) {
+		t.Fatalf("Unexpected removed containers %v, expected %v", f.removedContainers, expectedContainers)
+	}
+	for i := range expectedContainers {
+		if f.removedContainers[i] != expectedContainers[i] {
+			t.Fatalf("Unexpected removed container %s, expected %s", f.removedContainers[i], expectedContainers[i])
+		}
+	}
+
+	if len(f.removedVolumes) != 1 || f.removedVolumes[0] != "golem-old-graph" {
+		t.Fatalf("Unexpected removed volumes %v, expected [golem-old-graph]", f.removedVolumes)
+	}
+}
@@ -0,0 +1,229 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// resultParser parses test results from the output
+// of a test runner command.
+type resultParser func(io.Reader) ([]TestResult, error)
+
+// resultParsers are the supported test runner output formats
+var resultParsers = map[string]resultParser{
+	"tap":   parseTAP,
+	"go":    parseGoTest,
+	"junit": parseJUnit,
+}
+
+var (
+	tapLine       = regexp.MustCompile(`^(not )?ok\b(?:\s+[0-9]+)?(?:\s+-)?\s*(.*)$`)
+	tapPlan       = regexp.MustCompile(`^\s*[0-9]+\.\.[0-9]+`)
+	tapDirective  = regexp.MustCompile(`(?i)\s+#\s*(skip|todo)\b.*$`)
+	goTestOutcome = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+)`)
+	goTestEvent   = regexp.MustCompile(`^=== (?:RUN|CONT|PAUSE)\s+(\S+)`)
+	goTestSummary = regexp.MustCompile(`^(?:PASS|FAIL|ok\s|FAIL\s|exit status\s|\?\s)`)
+)
+
+// parseTAP parses results from Test Anything Protocol output.
+// Lines following a test line, such as diagnostics, are the
+// output of that test. Lines before the first test are not
+// attributed to any test.
+func parseTAP(r io.Reader) ([]TestResult, error) {
+	var (
+		results []TestResult
+		output  [][]string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		matches := tapLine.FindStringSubmatch(line)
+		if matches == nil {
+			if len(results) > 0 && !tapPlan.MatchString(line) {
+				output[len(results)-1] = append(output[len(results)-1], line)
+			}
+			continue
+		}
+		result := TestResult{
+			Name:   strings.TrimSpace(matches[2]),
+			Status: TestPassed,
+		}
+		if directive := tapDirective.FindStringSubmatch(result.Name); directive != nil {
+			result.Name = strings.TrimSpace(result.Name[:len(result.Name)-len(directive[0])])
+			result.Status = TestSkipped
+		} else if matches[1] != "" {
+			result.Status = TestFailed
+		}
+		results = append(results, result)
+		output = append(output, nil)
+	}
+
+	for i := range results {
+		results[i].Output = strings.Join(output[i], "\n")
+	}
+
+	return results, scanner.Err()
+}
+
+// parseGoTest parses results from verbose go test output.
+// Lines are the output of the most recently run test, or of
+// the test of the preceding result line, which go test uses
+// for logs of finished tests. Package summary lines and lines
+// before the first test are not attributed to any test.
+func parseGoTest(r io.Reader) ([]TestResult, error) {
+	var (
+		results []TestResult
+		current string
+		output  = map[string][]string{}
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if event := goTestEvent.FindStringSubmatch(line); event != nil {
+			current = event[1]
+			continue
+		}
+		if goTestSummary.MatchString(line) {
+			current = ""
+			continue
+		}
+		matches := goTestOutcome.FindStringSubmatch(line)
+		if matches == nil {
+			if current != "" {
+				output[current] = append(output[current], line)
+			}
+			continue
+		}
+		current = matches[2]
+		result := TestResult{
+			Name: matches[2],
+		}
+		switch matches[1] {
+		case "PASS":
+			result.Status = TestPassed
+		case "FAIL":
+			result.Status = TestFailed
+		case "SKIP":
+			result.Status = TestSkipped
+		}
+		results = append(results, result)
+	}
+
+	for i := range results {
+		results[i].Output = strings.Join(output[results[i].Name], "\n")
+	}
+
+	return results, scanner.Err()
+}
+
+// junitTestCase is a testcase element of a JUnit XML report
+type junitTestCase struct {
+	Name      string `xml:"name,attr"`
+	ClassName string `xml:"classname,attr"`
+	Failure   *struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"failure"`
+	Error *struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	} `xml:"error"`
+	Skipped   *struct{} `xml:"skipped"`
+	SystemOut string    `xml:"system-out"`
+}
+
+// parseJUnit parses results from a JUnit XML report. Test
+// cases are named by their class name and name, joined by a
+// dot. Failure and error messages followed by the test case
+// output are the output of the test.
+func parseJUnit(r io.Reader) ([]TestResult, error) {
+	var results []TestResult
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "testcase" {
+			continue
+		}
+		var tc junitTestCase
+		if err := d.DecodeElement(&tc, &start); err != nil {
+			return results, err
+		}
+
+		result := TestResult{
+			Name:   tc.Name,
+			Status: TestPassed,
+		}
+		if tc.ClassName != "" {
+			result.Name = tc.ClassName + "." + tc.Name
+		}
+		var output []string
+		switch {
+		case tc.Failure != nil:
+			result.Status = TestFailed
+			output = append(output, tc.Failure.Message, tc.Failure.Text)
+		case tc.Error != nil:
+			result.Status = TestFailed
+			output = append(output, tc.Error.Message, tc.Error.Text)
+		case tc.Skipped != nil:
+			result.Status = TestSkipped
+		}
+		output = append(output, tc.SystemOut)
+
+		var lines []string
+		for _, o := range output {
+			if o = strings.TrimSpace(o); o != "" {
+				lines = append(lines, o)
+			}
+		}
+		result.Output = strings.Join(lines, "\n")
+		results = append(results, result)
+	}
+}
+
+// resultWriter is a writer which parses test results
+// from the written output. Output is always consumed,
+// even after a parse error, so writes are never blocked
+// by the parser.
+type resultWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+
+	results []TestResult
+	err     error
+}
+
+func newResultWriter(parser resultParser) *resultWriter {
+	pr, pw := io.Pipe()
+	rw := &resultWriter{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(rw.done)
+		rw.results, rw.err = parser(pr)
+		io.Copy(ioutil.Discard, pr)
+	}()
+	return rw
+}
+
+func (rw *resultWriter) Write(b []byte) (int, error) {
+	return rw.pw.Write(b)
+}
+
+// Close closes the writer and returns the parsed results.
+func (rw *resultWriter) Close() ([]TestResult, error) {
+	rw.pw.Close()
+	<-rw.done
+	return rw.results, rw.err
+}
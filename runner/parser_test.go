@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkResults(t *testing.T, results, expected []TestResult) {
+	if len(results) != len(expected) {
+		t.Fatalf("Unexpected number of results %d, expected %d: %#v", len(results), len(expected), results)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Fatalf("Unexpected result %#v, expected %#v", results[i], expected[i])
+		}
+	}
+}
+
+func TestParseTAP(t *testing.T) {
+	output := `1..4
+ok 1 push image
+not ok 2 pull image
+# (in test file ./v1.bats, line 32)
+ok 3 - delete image # skip not supported
+ok 4
+`
+	results, err := parseTAP(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, []TestResult{
+		{Name: "push image", Status: TestPassed},
+		{Name: "pull image", Status: TestFailed, Output: "# (in test file ./v1.bats, line 32)"},
+		{Name: "delete image", Status: TestSkipped},
+		{Name: "", Status: TestPassed},
+	})
+}
+
+func TestParseGoTest(t *testing.T) {
+	output := `=== RUN   TestPush
+--- PASS: TestPush (0.10s)
+=== RUN   TestPull
+=== RUN   TestPull/v2
+    --- FAIL: TestPull/v2 (0.01s)
+--- FAIL: TestPull (0.01s)
+=== RUN   TestDelete
+--- SKIP: TestDelete (0.00s)
+	registry_test.go:10: not supported
+FAIL
+`
+	results, err := parseGoTest(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, []TestResult{
+		{Name: "TestPush", Status: TestPassed},
+		{Name: "TestPull/v2", Status: TestFailed},
+		{Name: "TestPull", Status: TestFailed},
+		{Name: "TestDelete", Status: TestSkipped, Output: "\tregistry_test.go:10: not supported"},
+	})
+}
+
+func TestParseTAPOutput(t *testing.T) {
+	output := `# registry setup
+1..3
+ok 1 push image
+not ok 2 pull image
+# (in test file ./v1.bats, line 32)
+#   'docker pull localregistry/hello-world' failed
+ok 3 delete image
+# teardown complete
+`
+	results, err := parseTAP(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, []TestResult{
+		{Name: "push image", Status: TestPassed},
+		{Name: "pull image", Status: TestFailed, Output: "# (in test file ./v1.bats, line 32)\n#   'docker pull localregistry/hello-world' failed"},
+		{Name: "delete image", Status: TestPassed, Output: "# teardown complete"},
+	})
+}
+
+func TestParseGoTestOutput(t *testing.T) {
+	output := `building test binary
+=== RUN   TestPush
+pushing image
+--- PASS: TestPush (0.10s)
+=== RUN   TestPull
+pulling image
+=== RUN   TestPull/v2
+pulling v2 image
+    --- FAIL: TestPull/v2 (0.01s)
+    	registry_test.go:20: manifest unknown
+--- FAIL: TestPull (0.01s)
+FAIL
+exit status 1
+FAIL	github.com/docker/registry-tests	0.120s
+`
+	results, err := parseGoTest(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, []TestResult{
+		{Name: "TestPush", Status: TestPassed, Output: "pushing image"},
+		{Name: "TestPull/v2", Status: TestFailed, Output: "pulling v2 image\n    \tregistry_test.go:20: manifest unknown"},
+		{Name: "TestPull", Status: TestFailed, Output: "pulling image"},
+	})
+}
+
+func TestParseJUnit(t *testing.T) {
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="registry" tests="4">
+    <testcase classname="registry" name="TestPush" time="0.10"></testcase>
+    <testcase classname="registry" name="TestPull" time="0.01">
+      <failure message="pull failed">registry_test.go:20: unexpected status 500</failure>
+    </testcase>
+    <testcase name="TestDelete">
+      <skipped message="not supported"></skipped>
+    </testcase>
+    <testcase classname="registry" name="TestCatalog">
+      <error message="panic">runtime error</error>
+      <system-out>listing catalog</system-out>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	results, err := parseJUnit(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, []TestResult{
+		{Name: "registry.TestPush", Status: TestPassed},
+		{Name: "registry.TestPull", Status: TestFailed, Output: "pull failed\nregistry_test.go:20: unexpected status 500"},
+		{Name: "TestDelete", Status: TestSkipped},
+		{Name: "registry.TestCatalog", Status: TestFailed, Output: "panic\nruntime error\nlisting catalog"},
+	})
+
+	if _, err := parseJUnit(strings.NewReader("<testsuite><testcase name=\"x\">")); err == nil {
+		t.Fatal("Expected error parsing truncated report")
+	}
+}
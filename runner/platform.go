@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/engine-api/types"
+)
+
+var platformComponent = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Platform represents the operating system, architecture
+// and optional variant of an image, such as "linux/arm64/v8".
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// ParsePlatform parses a platform string in the form
+// os/arch[/variant], an empty string returns an empty platform.
+func ParsePlatform(s string) (Platform, error) {
+	if s == "" {
+		return Platform{}, nil
+	}
+	parts := strings.Split(strings.ToLower(s), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+	for _, part := range parts {
+		if !platformComponent.MatchString(part) {
+			return Platform{}, fmt.Errorf("invalid platform %q, bad component %q", s, part)
+		}
+	}
+	p := Platform{
+		OS:           parts[0],
+		Architecture: normalizeArch(parts[1]),
+	}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	}
+	return arch
+}
+
+// IsZero returns whether the platform is unset
+func (p Platform) IsZero() bool {
+	return p.OS == "" && p.Architecture == ""
+}
+
+func (p Platform) String() string {
+	if p.IsZero() {
+		return ""
+	}
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Set sets the platform from a string, allowing
+// the platform to be used as a flag value.
+func (p *Platform) Set(s string) error {
+	parsed, err := ParsePlatform(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// checkImagePlatform returns an error if the inspected image does
+// not match the platform. The image variant is not available from
+// the inspect response and is not compared.
+func checkImagePlatform(info types.ImageInspect, p Platform) error {
+	if p.IsZero() {
+		return nil
+	}
+	if info.Os != p.OS || normalizeArch(info.Architecture) != p.Architecture {
+		return fmt.Errorf("image %s has platform %s/%s, expected %s", info.ID, info.Os, info.Architecture, p)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/docker/engine-api/types"
+)
+
+func TestParsePlatform(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected Platform
+	}{
+		{"", Platform{}},
+		{"linux/amd64", Platform{OS: "linux", Architecture: "amd64"}},
+		{"linux/arm64/v8", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{"Linux/x86_64", Platform{OS: "linux", Architecture: "amd64"}},
+		{"linux/aarch64", Platform{OS: "linux", Architecture: "arm64"}},
+	}
+	for _, c := range cases {
+		p, err := ParsePlatform(c.value)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", c.value, err)
+		}
+		if p != c.expected {
+			t.Fatalf("Unexpected platform for %q: %#v, expected %#v", c.value, p, c.expected)
+		}
+	}
+
+	for _, invalid := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/extra", "linux/arm 64"} {
+		if _, err := ParsePlatform(invalid); err == nil {
+			t.Fatalf("Expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestCheckImagePlatform(t *testing.T) {
+	info := types.ImageInspect{
+		ID:           "sha256:abc",
+		Os:           "linux",
+		Architecture: "amd64",
+	}
+	if err := checkImagePlatform(info, Platform{}); err != nil {
+		t.Fatalf("Unexpected error with no platform: %v", err)
+	}
+	if err := checkImagePlatform(info, Platform{OS: "linux", Architecture: "amd64"}); err != nil {
+		t.Fatalf("Unexpected error with matching platform: %v", err)
+	}
+	if err := checkImagePlatform(info, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}); err == nil {
+		t.Fatal("Expected error with mismatched platform")
+	}
+}
+
+func TestBaseImageDigestPlatform(t *testing.T) {
+	tags := []tag{{Tag: assertTagged("busybox:latest"), Image: "sha256:def"}}
+	envs := []string{"BUSYBOX_VERSION latest"}
+
+	none := baseImageDigest(baseImageInputs("sha256:abc", tags, envs, Platform{}, false))
+	amd64 := baseImageDigest(baseImageInputs("sha256:abc", tags, envs, Platform{OS: "linux", Architecture: "amd64"}, false))
+	arm64 := baseImageDigest(baseImageInputs("sha256:abc", tags, envs, Platform{OS: "linux", Architecture: "arm64"}, false))
+
+	if none == amd64 || none == arm64 || amd64 == arm64 {
+		t.Fatalf("Expected distinct digests: %s, %s, %s", none, amd64, arm64)
+	}
+	if again := baseImageDigest(baseImageInputs("sha256:abc", tags, envs, Platform{OS: "linux", Architecture: "arm64"}, false)); again != arm64 {
+		t.Fatalf("Digest not stable: %s != %s", again, arm64)
+	}
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// prePullClient is the client used to select the images
+// to pre-pull.
+type prePullClient interface {
+	imageInspector
+	daemonInfoer
+}
+
+// pullRef is an image reference to pull along with the
+// platform the image must match.
+type pullRef struct {
+	Image    string
+	Platform Platform
+}
+
+// prePullRefs returns the unique image references of the base
+// image configurations of the instances in suites not skipped,
+// in the order first referenced. Configurations with a base
+// image which will be reused are not pulled.
+func (r *runner) prePullRefs(ctx context.Context, cli prePullClient, skipped map[string]string) ([]pullRef, error) {
+	var refs []pullRef
+	seen := map[pullRef]struct{}{}
+	add := func(ref pullRef) {
+		if _, ok := seen[ref]; ok {
+			return
+		}
+		seen[ref] = struct{}{}
+		refs = append(refs, ref)
+	}
+	for _, suite := range r.config.Suites {
+		if _, ok := skipped[suite.Name]; ok {
+			continue
+		}
+		for _, instance := range suite.Instances {
+			conf := instance.BaseImage
+			if r.reusableBaseImage(ctx, cli, conf) != "" {
+				continue
+			}
+			platform, err := r.buildPlatform(ctx, cli, conf.Platform)
+			if err != nil {
+				return nil, err
+			}
+			add(pullRef{Image: conf.selectBase(platform).String(), Platform: platform})
+			for _, ref := range conf.ExtraImages {
+				add(pullRef{Image: ref.String(), Platform: conf.Platform})
+			}
+			for _, ci := range conf.CustomImages {
+				add(pullRef{Image: ci.Source, Platform: conf.Platform})
+			}
+		}
+	}
+	return refs, nil
+}
+
+// prePull ensures all images referenced by the base image
+// configurations are present before any are built, pulling
+// missing images concurrently.
+func (r *runner) prePull(ctx context.Context, cli DockerClient, skipped map[string]string) error {
+	refs, err := r.prePullRefs(ctx, cli, skipped)
+	if err != nil {
+		return err
+	}
+	prePullStart := time.Now()
+	err = pullAll(ctx, refs, r.config.PullConcurrency, func(ctx context.Context, ref pullRef) error {
+		_, err := r.ensureImage(ctx, cli, ref.Image, ref.Platform)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logFields := logrus.Fields{
+		timerKey: time.Since(prePullStart),
+		"images": len(refs),
+	}
+	logrus.WithFields(logFields).Info("image pre-pull complete")
+	return nil
+}
+
+// pullAll calls pull for every reference with at most
+// concurrency calls running at the same time. Remaining
+// references are not pulled after a pull fails, the errors
+// of all failed pulls are returned together.
+func pullAll(ctx context.Context, refs []pullRef, concurrency int, pull func(context.Context, pullRef) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		l      sync.Mutex
+		errs   []string
+		limits = make(chan struct{}, concurrency)
+	)
+	for _, ref := range refs {
+		limits <- struct{}{}
+		if ctx.Err() != nil {
+			<-limits
+			break
+		}
+		wg.Add(1)
+		go func(ref pullRef) {
+			defer wg.Done()
+			defer func() { <-limits }()
+			if err := pull(ctx, ref); err != nil {
+				l.Lock()
+				// Only report the first of the pulls cancelled
+				// after a failure
+				if len(errs) == 0 || ctx.Err() == nil {
+					errs = append(errs, fmt.Sprintf("%s: %v", ref.Image, err))
+				}
+				l.Unlock()
+				cancel()
+			}
+		}(ref)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error pulling images:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"golang.org/x/net/context"
+)
+
+type fakePrePullClient struct {
+	fakeImageInspector
+	fakeDaemonInfoer
+}
+
+func TestPrePullRefs(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	amd64 := Platform{OS: "linux", Architecture: "amd64"}
+	shared := BaseImageConfiguration{
+		Base:        assertTagged("golang:1.7"),
+		ExtraImages: []reference.NamedTagged{assertTagged("nginx:1.9"), assertTagged("redis:3")},
+		Platform:    amd64,
+	}
+	other := BaseImageConfiguration{
+		Base:        assertTagged("golang:1.7"),
+		ExtraImages: []reference.NamedTagged{assertTagged("nginx:1.9")},
+		CustomImages: []CustomImage{
+			{Source: "dockerd:1.12", Target: assertTagged("docker:latest"), Version: "1.12"},
+		},
+		Platform: amd64,
+	}
+	reused := BaseImageConfiguration{
+		Base:     assertTagged("busybox:latest"),
+		Platform: amd64,
+	}
+	r := &runner{
+		config: RunnerConfiguration{
+			Suites: []SuiteConfiguration{
+				{
+					Name: "first",
+					Instances: []InstanceConfiguration{
+						{Name: "first-1", BaseImage: shared},
+						{Name: "first-2", BaseImage: shared},
+						{Name: "first-3", BaseImage: reused},
+					},
+				},
+				{
+					Name:      "second",
+					Instances: []InstanceConfiguration{{Name: "second-1", BaseImage: other}},
+				},
+				{
+					Name: "skipped",
+					Instances: []InstanceConfiguration{{Name: "skipped-1", BaseImage: BaseImageConfiguration{
+						Base:     assertTagged("postgres:9"),
+						Platform: amd64,
+					}}},
+				},
+			},
+		},
+		cache: CacheConfiguration{ImageCache: NewImageCache(td)},
+	}
+	cli := fakePrePullClient{fakeImageInspector: fakeImageInspector{
+		"sha256:reused": {ID: "sha256:reused"},
+	}}
+	if err := r.cache.ImageCache.SaveConfigImage(baseConfigKey(reused, false), "sha256:reused"); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := r.prePullRefs(context.Background(), &cli, map[string]string{"skipped": "unmet requirement"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []pullRef{
+		{Image: "golang:1.7", Platform: amd64},
+		{Image: "nginx:1.9", Platform: amd64},
+		{Image: "redis:3", Platform: amd64},
+		{Image: "dockerd:1.12", Platform: amd64},
+	}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Fatalf("Unexpected pull references\n\tExpected: %v\n\tActual: %v", expected, refs)
+	}
+}
+
+func TestPullAllConcurrent(t *testing.T) {
+	refs := []pullRef{{Image: "golang:1.7"}, {Image: "nginx:1.9"}, {Image: "redis:3"}}
+
+	var (
+		l       sync.Mutex
+		pulled  = map[string]int{}
+		started = make(chan struct{}, len(refs))
+		release = make(chan struct{})
+	)
+	pull := func(ctx context.Context, ref pullRef) error {
+		l.Lock()
+		pulled[ref.Image]++
+		l.Unlock()
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- pullAll(context.Background(), refs, len(refs), pull)
+	}()
+	// Every pull blocks until released, so all must be
+	// started concurrently
+	for range refs {
+		<-started
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"golang:1.7": 1, "nginx:1.9": 1, "redis:3": 1}
+	if !reflect.DeepEqual(pulled, expected) {
+		t.Fatalf("Unexpected pulls %v, expected %v", pulled, expected)
+	}
+}
+
+func TestPullAllConcurrencyLimit(t *testing.T) {
+	refs := []pullRef{{Image: "a:1"}, {Image: "b:1"}, {Image: "c:1"}, {Image: "d:1"}, {Image: "e:1"}}
+
+	var (
+		l             sync.Mutex
+		running, peak int
+	)
+	pull := func(ctx context.Context, ref pullRef) error {
+		l.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		l.Unlock()
+		defer func() {
+			l.Lock()
+			running--
+			l.Unlock()
+		}()
+		return nil
+	}
+	if err := pullAll(context.Background(), refs, 2, pull); err != nil {
+		t.Fatal(err)
+	}
+	if peak > 2 {
+		t.Fatalf("Unexpected concurrent pulls %d, expected at most 2", peak)
+	}
+}
+
+func TestPullAllError(t *testing.T) {
+	refs := []pullRef{{Image: "golang:1.7"}, {Image: "missing:1"}, {Image: "redis:3"}}
+	var pulled []string
+	pull := func(ctx context.Context, ref pullRef) error {
+		pulled = append(pulled, ref.Image)
+		if ref.Image == "missing:1" {
+			return errors.New("not found")
+		}
+		return nil
+	}
+
+	err := pullAll(context.Background(), refs, 1, pull)
+	if err == nil {
+		t.Fatal("Expected pull error")
+	}
+	if !strings.Contains(err.Error(), "missing:1: not found") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(pulled, []string{"golang:1.7", "missing:1"}) {
+		t.Fatalf("Unexpected pulls after failure: %v", pulled)
+	}
+}
@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = time.Minute
+)
+
+// ReadinessProbe is a command run inside a compose service
+// container until it succeeds, before any tests are run.
+type ReadinessProbe struct {
+	Service  string        `json:"service"`
+	Command  []string      `json:"command"`
+	Interval time.Duration `json:"interval"`
+	Timeout  time.Duration `json:"timeout"`
+}
+
+// probeAttempt runs a single attempt of a readiness probe,
+// returning nil when the service is ready.
+type probeAttempt func(ctx context.Context) error
+
+// waitReady runs the probe attempt every interval until it
+// succeeds, returning the number of attempts made. An error
+// with the last failure is returned when the probe has not
+// succeeded within the timeout.
+func waitReady(ctx context.Context, probe ReadinessProbe, attempt probeAttempt) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, probe.Timeout)
+	defer cancel()
+	var attempts int
+	for {
+		attempts++
+		err := attempt(ctx)
+		if err == nil {
+			return attempts, nil
+		}
+		logrus.Debugf("Readiness probe of %s attempt %d failed: %v", probe.Service, attempts, err)
+		select {
+		case <-ctx.Done():
+			return attempts, fmt.Errorf("service %s not ready after %s and %d attempts: %v", probe.Service, probe.Timeout, attempts, err)
+		case <-time.After(probe.Interval):
+		}
+	}
+}
+
+// composeProbeAttempt returns a probe attempt running the probe
+// command in the service container with docker compose exec,
+// capturing output to the log capturer.
+func composeProbeAttempt(lc LogCapturer, composeFile string, probe ReadinessProbe) probeAttempt {
+	args := append([]string{"-f", composeFile, "exec", "-T", probe.Service}, probe.Command...)
+	return func(ctx context.Context) error {
+		tail := newTailWriter(composeErrorLines)
+		cmd := exec.CommandContext(ctx, "docker-compose", args...)
+		cmd.Env = os.Environ()
+		cmd.Stdout = tailCapturer{LogCapturer: lc, tail: tail}.Stdout()
+		cmd.Stderr = tailCapturer{LogCapturer: lc, tail: tail}.Stderr()
+		if err := cmd.Run(); err != nil {
+			if output := tail.Tail(); output != "" {
+				return fmt.Errorf("%v, output:\n%s", err, output)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+type probeConfiguration struct {
+	Service  string `toml:"service"`
+	Command  string `toml:"command"`
+	Interval string `toml:"interval"`
+	Timeout  string `toml:"timeout"`
+}
+
+// newReadinessProbe validates the probe configuration,
+// defaulting the interval and timeout.
+func newReadinessProbe(pc probeConfiguration) (ReadinessProbe, error) {
+	probe := ReadinessProbe{
+		Service:  pc.Service,
+		Interval: defaultProbeInterval,
+		Timeout:  defaultProbeTimeout,
+	}
+	if probe.Service == "" {
+		return ReadinessProbe{}, errors.New("probe service must not be empty")
+	}
+	if pc.Command == "" {
+		return ReadinessProbe{}, fmt.Errorf("probe command for %s must not be empty", pc.Service)
+	}
+	probe.Command = strings.Split(pc.Command, " ")
+	for _, d := range []struct {
+		name  string
+		value string
+		set   *time.Duration
+	}{
+		{"interval", pc.Interval, &probe.Interval},
+		{"timeout", pc.Timeout, &probe.Timeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return ReadinessProbe{}, fmt.Errorf("invalid probe %s %q: %v", d.name, d.value, err)
+		}
+		if duration <= 0 {
+			return ReadinessProbe{}, fmt.Errorf("invalid probe %s %q, must be positive", d.name, d.value)
+		}
+		*d.set = duration
+	}
+	return probe, nil
+}
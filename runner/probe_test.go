@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWaitReadyAfterAttempts(t *testing.T) {
+	probe := ReadinessProbe{
+		Service:  "db",
+		Interval: time.Millisecond,
+		Timeout:  time.Second,
+	}
+	var calls int
+	attempts, err := waitReady(context.Background(), probe, func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts != 3 || calls != 3 {
+		t.Fatalf("Unexpected attempts %d, calls %d, expected 3", attempts, calls)
+	}
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	probe := ReadinessProbe{
+		Service:  "db",
+		Interval: 10 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+	}
+	start := time.Now()
+	attempts, err := waitReady(context.Background(), probe, func(context.Context) error {
+		return errors.New("connection refused")
+	})
+	if err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if !strings.Contains(err.Error(), "service db not ready") || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if attempts < 2 {
+		t.Fatalf("Expected multiple attempts, got %d", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Timeout not respected, took %s", elapsed)
+	}
+}
+
+func TestNewReadinessProbe(t *testing.T) {
+	probe, err := newReadinessProbe(probeConfiguration{Service: "db", Command: "pg_isready -U postgres"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ReadinessProbe{
+		Service:  "db",
+		Command:  []string{"pg_isready", "-U", "postgres"},
+		Interval: defaultProbeInterval,
+		Timeout:  defaultProbeTimeout,
+	}
+	if !reflect.DeepEqual(probe, expected) {
+		t.Fatalf("Unexpected probe %#v, expected %#v", probe, expected)
+	}
+
+	probe, err = newReadinessProbe(probeConfiguration{Service: "db", Command: "true", Interval: "2s", Timeout: "30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.Interval != 2*time.Second || probe.Timeout != 30*time.Second {
+		t.Fatalf("Unexpected durations %s and %s", probe.Interval, probe.Timeout)
+	}
+
+	for _, invalid := range []probeConfiguration{
+		{Command: "true"},
+		{Service: "db"},
+		{Service: "db", Command: "true", Interval: "soon"},
+		{Service: "db", Command: "true", Timeout: "-1s"},
+	} {
+		if _, err := newReadinessProbe(invalid); err == nil {
+			t.Fatalf("Expected error for %#v", invalid)
+		}
+	}
+}
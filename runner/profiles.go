@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Profiles are named sets of flag defaults, such as for
+// smoke or nightly runs, keyed by profile and flag name.
+type Profiles map[string]map[string]interface{}
+
+type profilesConfiguration struct {
+	Profile Profiles `toml:"profile"`
+}
+
+// LoadProfiles loads the profiles from a profiles file with
+// a [profile.<name>] table for each profile.
+func LoadProfiles(path string) (Profiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var conf profilesConfiguration
+	if err := toml.Unmarshal(b, &conf); err != nil {
+		return nil, fmt.Errorf("error unmarshalling %s: %s", path, err)
+	}
+	return conf.Profile, nil
+}
+
+// applyProfile sets the flags of a profile which were not set
+// on the command line, so command line flags always take
+// precedence. Array values set a flag once for each element,
+// for flags which may be set multiple times.
+func applyProfile(fs *flag.FlagSet, values map[string]interface{}) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "profile" || name == "profiles" {
+			return fmt.Errorf("flag %s may not be set in a profile", name)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %s", name)
+		}
+		if set[name] {
+			continue
+		}
+		elems, ok := values[name].([]interface{})
+		if !ok {
+			elems = []interface{}{values[name]}
+		}
+		for _, elem := range elems {
+			value, err := profileValue(elem)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %v", name, err)
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %v", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// profileValue returns the flag value of a profile toml value.
+func profileValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int64:
+		return strconv.FormatInt(value, 10), nil
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", v)
+	}
+}
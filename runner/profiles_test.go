@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const testProfiles = `
+[profile.smoke]
+  i=["dockerd:latest,dockerdevelopers/docker:1.12.1", "compose:latest,docker/compose:1.8.0"]
+  pull-timeout="2m"
+  repeat=1
+  failfast=true
+
+[profile.nightly]
+  repeat=5
+  pull-timeout="10m"
+`
+
+type profileFlags struct {
+	fs          *flag.FlagSet
+	fr          *flagResolver
+	pullTimeout time.Duration
+	repeat      int
+	failFast    bool
+}
+
+func newProfileFlags() *profileFlags {
+	pf := &profileFlags{
+		fs: flag.NewFlagSet("test", flag.ContinueOnError),
+	}
+	pf.fr = newFlagResolver(pf.fs)
+	pf.fs.DurationVar(&pf.pullTimeout, "pull-timeout", 0, "")
+	pf.fs.IntVar(&pf.repeat, "repeat", 1, "")
+	pf.fs.BoolVar(&pf.failFast, "failfast", false, "")
+	return pf
+}
+
+func loadTestProfile(t *testing.T, name string) map[string]interface{} {
+	td, err := ioutil.TempDir("", "golem-profiles-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	profiles, err := LoadProfiles(writeTempFile(t, td, "profiles.toml", testProfiles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, ok := profiles[name]
+	if !ok {
+		t.Fatalf("Missing profile %s", name)
+	}
+	return values
+}
+
+func TestApplyProfile(t *testing.T) {
+	pf := newProfileFlags()
+	if err := pf.fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProfile(pf.fs, loadTestProfile(t, "smoke")); err != nil {
+		t.Fatal(err)
+	}
+
+	if pf.pullTimeout != 2*time.Minute {
+		t.Fatalf("Unexpected pull timeout %s", pf.pullTimeout)
+	}
+	if pf.repeat != 1 || !pf.failFast {
+		t.Fatalf("Unexpected repeat %d and failfast %t", pf.repeat, pf.failFast)
+	}
+	images := pf.fr.CustomImages()
+	if len(images) != 2 {
+		t.Fatalf("Unexpected custom images %#v", images)
+	}
+	if images[0].Source != "docker/compose:1.8.0" || images[0].Version != "1.8.0" {
+		t.Fatalf("Unexpected custom image %#v", images[0])
+	}
+	if images[1].Source != "dockerdevelopers/docker:1.12.1" || images[1].Version != "1.12.1" {
+		t.Fatalf("Unexpected custom image %#v", images[1])
+	}
+}
+
+func TestApplyProfileFlagsOverride(t *testing.T) {
+	pf := newProfileFlags()
+	if err := pf.fs.Parse([]string{"-pull-timeout", "30s", "-i", "dockerd:latest,dockerdevelopers/docker:master"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProfile(pf.fs, loadTestProfile(t, "smoke")); err != nil {
+		t.Fatal(err)
+	}
+
+	if pf.pullTimeout != 30*time.Second {
+		t.Fatalf("Expected command line pull timeout, got %s", pf.pullTimeout)
+	}
+	if !pf.failFast {
+		t.Fatal("Expected failfast from profile")
+	}
+	images := pf.fr.CustomImages()
+	if len(images) != 1 || images[0].Version != "master" {
+		t.Fatalf("Expected only command line custom image, got %#v", images)
+	}
+}
+
+func TestApplyProfileInvalid(t *testing.T) {
+	for _, values := range []map[string]interface{}{
+		{"unknown": "value"},
+		{"profile": "nightly"},
+		{"repeat": "many"},
+		{"repeat": map[string]interface{}{"n": int64(1)}},
+	} {
+		pf := newProfileFlags()
+		pf.fs.String("profile", "", "")
+		if err := pf.fs.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyProfile(pf.fs, values); err == nil {
+			t.Fatalf("Expected error applying %#v", values)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// configHashLabel is set on instance images to the hash of
+// the instance configuration the image was built from.
+const configHashLabel = "golem.config-hash"
+
+// MismatchPolicy is the action taken when an instance image
+// was not built from the resolved instance configuration.
+type MismatchPolicy string
+
+const (
+	// MismatchError fails the run
+	MismatchError MismatchPolicy = "error"
+
+	// MismatchRebuild rebuilds the instance image
+	// before running it
+	MismatchRebuild MismatchPolicy = "rebuild"
+)
+
+func (p *MismatchPolicy) String() string {
+	return string(*p)
+}
+
+// Set sets the mismatch policy from a string, allowing
+// the policy to be used as a flag value.
+func (p *MismatchPolicy) Set(s string) error {
+	switch policy := MismatchPolicy(s); policy {
+	case MismatchError, MismatchRebuild:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("invalid image mismatch policy %q, must be one of error or rebuild", s)
+}
+
+// instanceConfigHash returns the hash of the instance
+// configuration embedded in an instance image.
+func instanceConfigHash(rc RunConfiguration) (string, error) {
+	b, err := json.Marshal(rc)
+	if err != nil {
+		return "", fmt.Errorf("error encoding configuration: %s", err)
+	}
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:]), nil
+}
+
+// imageLabels returns the labels of an instance image, the
+// suite labels along with the configuration hash.
+func imageLabels(suiteLabels map[string]string, configHash string) map[string]string {
+	labels := make(map[string]string, len(suiteLabels)+1)
+	for k, v := range suiteLabels {
+		labels[k] = v
+	}
+	labels[configHashLabel] = configHash
+	return labels
+}
+
+// checkInstanceImage returns an error when the instance image
+// is missing or was not built from the instance configuration,
+// such as an image left by a run of another configuration.
+func checkInstanceImage(ctx context.Context, cli imageInspector, imageName string, rc RunConfiguration) error {
+	expected, err := instanceConfigHash(rc)
+	if err != nil {
+		return err
+	}
+	info, _, err := cli.ImageInspectWithRaw(ctx, imageName, false)
+	if err != nil {
+		return fmt.Errorf("error inspecting image %s: %v", imageName, err)
+	}
+	var actual string
+	if info.Config != nil {
+		actual = info.Config.Labels[configHashLabel]
+	}
+	if actual == "" {
+		return fmt.Errorf("image %s has no configuration hash, it was not built by this version of golem", imageName)
+	}
+	if actual != expected {
+		return fmt.Errorf("image %s was built from a different configuration, hash %s, expected %s", imageName, actual, expected)
+	}
+	return nil
+}
+
+// verifyInstanceImage checks that the instance image was built
+// from the instance configuration, calling rebuild on mismatch
+// when the mismatch policy is rebuild.
+func verifyInstanceImage(ctx context.Context, cli imageInspector, policy MismatchPolicy, imageName string, rc RunConfiguration, rebuild func() error) error {
+	err := checkInstanceImage(ctx, cli, imageName, rc)
+	if err == nil || policy != MismatchRebuild {
+		return err
+	}
+	logrus.Warnf("Rebuilding instance image: %v", err)
+	if err := rebuild(); err != nil {
+		return err
+	}
+	return checkInstanceImage(ctx, cli, imageName, rc)
+}
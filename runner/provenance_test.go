@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/container"
+	"golang.org/x/net/context"
+)
+
+func labeledImage(configHash string) types.ImageInspect {
+	return types.ImageInspect{
+		ID: "sha256:3b8e8e4b1a1b",
+		Config: &container.Config{
+			Labels: map[string]string{configHashLabel: configHash},
+		},
+	}
+}
+
+func TestCheckInstanceImage(t *testing.T) {
+	ctx := context.Background()
+	current := RunConfiguration{Setup: []Script{{Command: []string{"bats", "-t", "."}}}}
+	stale := RunConfiguration{Setup: []Script{{Command: []string{"go", "test"}}}}
+	hash, err := instanceConfigHash(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staleHash, err := instanceConfigHash(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == staleHash {
+		t.Fatal("Expected different hashes for different configurations")
+	}
+
+	images := fakeImageInspector{
+		"golem-current:latest":   labeledImage(hash),
+		"golem-stale:latest":     labeledImage(staleHash),
+		"golem-unlabeled:latest": {ID: "sha256:0f864637f229", Config: &container.Config{}},
+	}
+	if err := checkInstanceImage(ctx, images, "golem-current:latest", current); err != nil {
+		t.Fatalf("Unexpected error for matching image: %v", err)
+	}
+	for _, name := range []string{"golem-stale:latest", "golem-unlabeled:latest", "golem-missing:latest"} {
+		if err := checkInstanceImage(ctx, images, name, current); err == nil {
+			t.Fatalf("Expected error for %s", name)
+		}
+	}
+}
+
+func TestImageLabelsBuild(t *testing.T) {
+	rc := RunConfiguration{Setup: []Script{{Command: []string{"bats", "-t", "."}}}}
+	hash, err := instanceConfigHash(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := imageLabels(map[string]string{"team": "distribution"}, hash)
+	built := buildLabels(t, labels)
+	if built[configHashLabel] != hash || built["team"] != "distribution" {
+		t.Fatalf("Unexpected image labels %v, expected %v", built, labels)
+	}
+}
+
+func TestVerifyInstanceImageMismatch(t *testing.T) {
+	ctx := context.Background()
+	rc := RunConfiguration{Setup: []Script{{Command: []string{"bats", "-t", "."}}}}
+	hash, err := instanceConfigHash(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const imageName = "golem-suite:latest"
+
+	// Error policy fails without rebuilding
+	images := fakeImageInspector{imageName: labeledImage("sha256:stale")}
+	var rebuilds int
+	rebuild := func() error {
+		rebuilds++
+		images[imageName] = labeledImage(hash)
+		return nil
+	}
+	err = verifyInstanceImage(ctx, images, MismatchError, imageName, rc, rebuild)
+	if err == nil || !strings.Contains(err.Error(), "different configuration") {
+		t.Fatalf("Expected mismatch error, got %v", err)
+	}
+	if rebuilds != 0 {
+		t.Fatalf("Unexpected rebuild with error policy")
+	}
+
+	// Empty policy is treated as error
+	if err := verifyInstanceImage(ctx, images, "", imageName, rc, rebuild); err == nil {
+		t.Fatal("Expected mismatch error with empty policy")
+	}
+
+	// Rebuild policy rebuilds and verifies the rebuilt image
+	if err := verifyInstanceImage(ctx, images, MismatchRebuild, imageName, rc, rebuild); err != nil {
+		t.Fatalf("Unexpected error after rebuild: %v", err)
+	}
+	if rebuilds != 1 {
+		t.Fatalf("Expected 1 rebuild, got %d", rebuilds)
+	}
+
+	// Matching images are not rebuilt
+	if err := verifyInstanceImage(ctx, images, MismatchRebuild, imageName, rc, rebuild); err != nil {
+		t.Fatal(err)
+	}
+	if rebuilds != 1 {
+		t.Fatalf("Unexpected rebuild of matching image")
+	}
+
+	// Rebuild errors are returned
+	images[imageName] = labeledImage("sha256:stale")
+	rebuildErr := errors.New("build error")
+	if err := verifyInstanceImage(ctx, images, MismatchRebuild, imageName, rc, func() error { return rebuildErr }); err != rebuildErr {
+		t.Fatalf("Expected rebuild error, got %v", err)
+	}
+}
+
+func TestMismatchPolicySet(t *testing.T) {
+	var p MismatchPolicy
+	if err := p.Set("rebuild"); err != nil || p != MismatchRebuild {
+		t.Fatalf("Unexpected policy %q: %v", p, err)
+	}
+	if err := p.Set("ignore"); err == nil {
+		t.Fatal("Expected error for invalid policy")
+	}
+}
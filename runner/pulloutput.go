@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/term"
+	"github.com/docker/go-units"
+)
+
+// PullVerbosity determines how much image pull output
+// is displayed.
+type PullVerbosity string
+
+const (
+	// PullQuiet suppresses pull progress, displaying only a
+	// summary line once the image is pulled
+	PullQuiet PullVerbosity = "quiet"
+
+	// PullNormal displays the pull progress of each layer
+	PullNormal PullVerbosity = "normal"
+
+	// PullVerbose displays the pull progress of each layer
+	// followed by a summary line once the image is pulled
+	PullVerbose PullVerbosity = "verbose"
+)
+
+func (v *PullVerbosity) String() string {
+	return string(*v)
+}
+
+// Set sets the verbosity from a string, allowing the
+// verbosity to be used as a flag value.
+func (v *PullVerbosity) Set(s string) error {
+	switch verbosity := PullVerbosity(s); verbosity {
+	case PullQuiet, PullNormal, PullVerbose:
+		*v = verbosity
+		return nil
+	}
+	return fmt.Errorf("invalid pull verbosity %q, must be one of quiet, normal or verbose", s)
+}
+
+// summarized returns whether a summary line is displayed
+// after an image is pulled.
+func (v PullVerbosity) summarized() bool {
+	return v == PullQuiet || v == PullVerbose
+}
+
+// displayPull displays the pull output stream to w according to
+// the verbosity, returning any error reported in the stream.
+func displayPull(r io.Reader, w io.Writer, verbosity PullVerbosity) error {
+	if verbosity != PullQuiet {
+		outFd, isTerminalOut := term.GetFdInfo(w)
+		return jsonmessage.DisplayJSONMessagesStream(r, w, outFd, isTerminalOut, nil)
+	}
+
+	dec := json.NewDecoder(r)
+	for {
+		var jm jsonmessage.JSONMessage
+		if err := dec.Decode(&jm); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if jm.Error != nil {
+			return jm.Error
+		}
+		if jm.ErrorMessage != "" {
+			return fmt.Errorf("%s", jm.ErrorMessage)
+		}
+	}
+}
+
+// writePullSummary writes the single line summary of a pulled image
+func writePullSummary(w io.Writer, image string, size int64, elapsed time.Duration) {
+	fmt.Fprintf(w, "pulled %s (%s) in %s\n", image, units.HumanSize(float64(size)), elapsed)
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/engine-api/types"
+	"golang.org/x/net/context"
+)
+
+const layeredPullOutput = `{"status":"Pulling from library/busybox","id":"latest"}
+{"status":"Pulling fs layer","id":"8ddc19f16526"}
+{"status":"Downloading","progressDetail":{"current":32768,"total":667590},"progress":"[==>   ] 32.77 kB/667.6 kB","id":"8ddc19f16526"}
+{"status":"Download complete","id":"8ddc19f16526"}
+{"status":"Pull complete","id":"8ddc19f16526"}
+{"status":"Digest: sha256:a59906e33509d14c036c8678d687bd4eec81ed7c4b8ce907b888c607f6a1e0e6"}
+{"status":"Status: Downloaded newer image for busybox:latest"}
+`
+
+type outputPuller struct {
+	output string
+}
+
+func (p outputPuller) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(p.output)), nil
+}
+
+func TestPullQuiet(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := pullImage(context.Background(), outputPuller{layeredPullOutput}, "busybox:latest", time.Second, buf, PullQuiet); err != nil {
+		t.Fatal(err)
+	}
+	if !PullQuiet.summarized() {
+		t.Fatal("Expected summary when quiet")
+	}
+	writePullSummary(buf, "busybox:latest", 1113436, 1500*time.Millisecond)
+
+	expected := "pulled busybox:latest (1.113 MB) in 1.5s\n"
+	if out := buf.String(); out != expected {
+		t.Fatalf("Unexpected quiet pull output %q, expected %q", out, expected)
+	}
+
+	errOutput := `{"status":"Pulling fs layer","id":"8ddc19f16526"}
+{"errorDetail":{"message":"unauthorized"},"error":"unauthorized"}
+`
+	buf.Reset()
+	if err := pullImage(context.Background(), outputPuller{errOutput}, "busybox:latest", time.Second, buf, PullQuiet); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Fatalf("Expected unauthorized error, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Unexpected quiet pull output %q", buf.String())
+	}
+}
+
+func TestPullNormal(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := pullImage(context.Background(), outputPuller{layeredPullOutput}, "busybox:latest", time.Second, buf, PullNormal); err != nil {
+		t.Fatal(err)
+	}
+	if PullNormal.summarized() {
+		t.Fatal("Unexpected summary with normal verbosity")
+	}
+	if out := buf.String(); !strings.Contains(out, "8ddc19f16526: Pull complete") {
+		t.Fatalf("Missing layer progress in %q", out)
+	}
+
+	var v PullVerbosity
+	if err := v.Set("loud"); err == nil {
+		t.Fatal("Expected error for invalid verbosity")
+	}
+}
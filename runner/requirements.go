@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/golem/versionutil"
+)
+
+// SuiteRequirements are the daemon capabilities required to run
+// a suite. Suites with unmet requirements are skipped.
+type SuiteRequirements struct {
+	// DockerVersion is the version of the daemon golem runs
+	// against, an empty constraint accepts every version.
+	DockerVersion versionutil.VersionConstraint
+
+	// StorageDriver is the storage driver the suite must run
+	// with. For dind suites this is the driver of the daemon
+	// in the test container, otherwise the driver of the daemon
+	// golem runs against.
+	StorageDriver string
+
+	// OS is the operating system type of the daemon golem
+	// runs against, such as linux or windows.
+	OS string
+}
+
+func (sr SuiteRequirements) empty() bool {
+	return sr.DockerVersion.String() == "" && sr.StorageDriver == "" && sr.OS == ""
+}
+
+// newSuiteRequirements creates the requirements of a suite from
+// an inclusive range of docker versions, either end optional.
+func newSuiteRequirements(config requiresConfiguration) (SuiteRequirements, error) {
+	var clauses []string
+	if config.MinDockerVersion != "" {
+		clauses = append(clauses, ">="+config.MinDockerVersion)
+	}
+	if config.MaxDockerVersion != "" {
+		clauses = append(clauses, "<="+config.MaxDockerVersion)
+	}
+	constraint, err := versionutil.ParseVersionConstraint(strings.Join(clauses, ","))
+	if err != nil {
+		return SuiteRequirements{}, fmt.Errorf("invalid requires docker version: %v", err)
+	}
+	return SuiteRequirements{
+		DockerVersion: constraint,
+		StorageDriver: config.StorageDriver,
+		OS:            config.OS,
+	}, nil
+}
+
+// daemonInfoer is the subset of the docker client used to
+// inspect the capabilities of the daemon.
+type daemonInfoer interface {
+	Info(ctx context.Context) (types.Info, error)
+}
+
+// unmetRequirement returns why the suite requirements are not met
+// by the daemon, or an empty string when they are met.
+func unmetRequirement(suite SuiteConfiguration, info types.Info) (string, error) {
+	req := suite.Requires
+	if req.DockerVersion.String() != "" {
+		v, err := versionutil.ParseVersion(info.ServerVersion)
+		if err != nil {
+			return "", fmt.Errorf("error parsing daemon version %s: %v", info.ServerVersion, err)
+		}
+		if !req.DockerVersion.Matches(v) {
+			return fmt.Sprintf("requires docker %s, daemon is %s", req.DockerVersion, v), nil
+		}
+	}
+	if req.StorageDriver != "" {
+		driver := info.Driver
+		if suite.DockerInDocker {
+			driver = getGraphDriver(suite.StorageDriver)
+		}
+		if driver != req.StorageDriver {
+			return fmt.Sprintf("requires storage driver %s, running with %s", req.StorageDriver, driver), nil
+		}
+	}
+	if req.OS != "" && req.OS != info.OSType {
+		return fmt.Sprintf("requires os %s, daemon is %s", req.OS, info.OSType), nil
+	}
+	return "", nil
+}
+
+// skippedSuites returns the reason each suite with unmet
+// requirements is skipped by suite name. The daemon is
+// only inspected when a suite has requirements.
+func (r *runner) skippedSuites(ctx context.Context, cli daemonInfoer) (map[string]string, error) {
+	if r.skipped != nil {
+		return r.skipped, nil
+	}
+	skipped := map[string]string{}
+	var info *types.Info
+	for _, suite := range r.config.Suites {
+		if suite.Requires.empty() {
+			continue
+		}
+		if info == nil {
+			i, err := cli.Info(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error getting daemon info: %v", err)
+			}
+			info = &i
+		}
+		reason, err := unmetRequirement(suite, *info)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			skipped[suite.Name] = reason
+		}
+	}
+
+	names := make([]string, 0, len(skipped))
+	for name := range skipped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logrus.WithField("suite", name).Infof("skipping suite, %s", skipped[name])
+	}
+
+	r.skipped = skipped
+	return skipped, nil
+}
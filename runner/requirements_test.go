@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+)
+
+type fakeDaemonInfoer struct {
+	info  types.Info
+	calls int
+}
+
+func (f *fakeDaemonInfoer) Info(ctx context.Context) (types.Info, error) {
+	f.calls++
+	return f.info, nil
+}
+
+func TestSuiteRequirements(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-requires-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	resolve := func(config string) (SuiteConfiguration, error) {
+		writeTempFile(t, td, "golem.conf", config)
+		suites, err := parseSuites([]string{td})
+		if err != nil {
+			return SuiteConfiguration{}, err
+		}
+		suite := suites["requires"]
+		return resolveConfigurationSuite(newMultiResolver(suite, globalDefault), suite, "")
+	}
+
+	newer, err := resolve(`[[suite]]
+  name="requires"
+  allow_no_tests=true
+  [suite.requires]
+    min_docker_version="1.12.0"
+    os="linux"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newer.Requires.DockerVersion.String() != ">=1.12.0" || newer.Requires.OS != "linux" {
+		t.Fatalf("Unexpected requirements %#v", newer.Requires)
+	}
+
+	run := func(info types.Info, suites ...SuiteConfiguration) (map[string]string, int) {
+		r := &runner{config: RunnerConfiguration{Suites: suites}}
+		cli := &fakeDaemonInfoer{info: info}
+		skipped, err := r.skippedSuites(context.Background(), cli)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return skipped, cli.calls
+	}
+
+	older := types.Info{ServerVersion: "1.11.2", OSType: "linux", Driver: "aufs"}
+	skipped, _ := run(older, newer)
+	if reason := skipped["requires"]; !strings.Contains(reason, "requires docker >=1.12.0, daemon is 1.11.2") {
+		t.Fatalf("Expected suite skipped on older daemon, got %q", reason)
+	}
+
+	matching := types.Info{ServerVersion: "1.12.1", OSType: "linux", Driver: "aufs"}
+	if skipped, _ := run(matching, newer); len(skipped) > 0 {
+		t.Fatalf("Expected suite to run on matching daemon, skipped %v", skipped)
+	}
+	windows := types.Info{ServerVersion: "1.12.1", OSType: "windows"}
+	if skipped, _ := run(windows, newer); !strings.Contains(skipped["requires"], "requires os linux") {
+		t.Fatalf("Expected suite skipped on windows daemon, got %v", skipped)
+	}
+
+	// Suites without requirements never inspect the daemon
+	plain := SuiteConfiguration{Name: "plain"}
+	if skipped, calls := run(older, plain); len(skipped) > 0 || calls > 0 {
+		t.Fatalf("Unexpected skipped %v after %d info calls", skipped, calls)
+	}
+
+	// Dind suites require the driver of the daemon in the container
+	overlay2 := SuiteConfiguration{
+		Name:           "overlay2",
+		DockerInDocker: true,
+		StorageDriver:  "overlay2",
+		Requires:       SuiteRequirements{StorageDriver: "overlay2"},
+	}
+	if skipped, _ := run(older, overlay2); len(skipped) > 0 {
+		t.Fatalf("Expected dind suite with overlay2 to run, skipped %v", skipped)
+	}
+	overlay2.DockerInDocker = false
+	if skipped, _ := run(older, overlay2); !strings.Contains(skipped["overlay2"], "running with aufs") {
+		t.Fatalf("Expected suite skipped on aufs daemon, got %v", skipped)
+	}
+
+	if _, err := resolve("[[suite]]\n  name=\"requires\"\n  allow_no_tests=true\n  [suite.requires]\n    max_docker_version=\"latest\"\n"); err == nil {
+		t.Fatal("Expected error for invalid max_docker_version")
+	}
+}
@@ -0,0 +1,165 @@
+package runner
+
+import "time"
+
+// InstanceResult is the result of running a single
+// test instance container.
+type InstanceResult struct {
+	Name     string        `json:"name"`
+	Suite    string        `json:"suite"`
+	ExitCode int           `json:"exitCode"`
+	Passed   bool          `json:"passed"`
+	Elapsed  time.Duration `json:"elapsed"`
+
+	// Labels are the labels configured for the suite
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Run is the number of the run when the instance
+	// is run repeatedly.
+	Run int `json:"run,omitempty"`
+
+	// Status and Repeat are the aggregated result of
+	// an instance run repeatedly.
+	Status InstanceStatus `json:"status,omitempty"`
+	Repeat *RepeatResult  `json:"repeat,omitempty"`
+
+	// Reason is why an instance was skipped
+	Reason string `json:"reason,omitempty"`
+}
+
+// InstanceStatus is the status of an instance over
+// all of its runs.
+type InstanceStatus string
+
+const (
+	// InstancePassed is the status of an instance
+	// which passed every run.
+	InstancePassed InstanceStatus = "passed"
+
+	// InstanceFailed is the status of an instance
+	// which failed every run.
+	InstanceFailed InstanceStatus = "failed"
+
+	// InstanceFlaky is the status of an instance
+	// which both passed and failed runs.
+	InstanceFlaky InstanceStatus = "flaky"
+
+	// InstanceSetupFailed is the status of an instance
+	// run which failed during test setup.
+	InstanceSetupFailed InstanceStatus = "setup-failed"
+
+	// InstanceTestFailed is the status of an instance
+	// run in which the test commands failed.
+	InstanceTestFailed InstanceStatus = "test-failed"
+
+	// InstanceTeardownFailed is the status of an instance
+	// run with passing tests which failed during teardown.
+	InstanceTeardownFailed InstanceStatus = "teardown-failed"
+
+	// InstanceSkipped is the status of an instance not
+	// run because its suite requirements were not met.
+	InstanceSkipped InstanceStatus = "skipped"
+)
+
+// Exit codes of the runner process in the test container
+// identifying the stage which failed. Other non-zero exit
+// codes are unexpected failures.
+const (
+	ExitSetupFailed    = 3
+	ExitTestFailed     = 4
+	ExitTeardownFailed = 5
+)
+
+// ExitStatus returns the status of an instance run from
+// the exit code of the runner process.
+func ExitStatus(exitCode int) InstanceStatus {
+	switch exitCode {
+	case 0:
+		return InstancePassed
+	case ExitSetupFailed:
+		return InstanceSetupFailed
+	case ExitTestFailed:
+		return InstanceTestFailed
+	case ExitTeardownFailed:
+		return InstanceTeardownFailed
+	default:
+		return InstanceFailed
+	}
+}
+
+// RepeatResult counts the passed and failed runs of
+// an instance run repeatedly.
+type RepeatResult struct {
+	Runs   int `json:"runs"`
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+}
+
+// Add counts a run of the instance.
+func (rr *RepeatResult) Add(passed bool) {
+	rr.Runs++
+	if passed {
+		rr.Passed++
+	} else {
+		rr.Failed++
+	}
+}
+
+// Status returns the status of the instance over all
+// counted runs, an instance without runs has failed.
+func (rr RepeatResult) Status() InstanceStatus {
+	switch {
+	case rr.Runs > 0 && rr.Failed == 0:
+		return InstancePassed
+	case rr.Passed == 0:
+		return InstanceFailed
+	default:
+		return InstanceFlaky
+	}
+}
+
+// RunSummary is the aggregated result of running all
+// configured test instances.
+type RunSummary struct {
+	Ran     int           `json:"ran"`
+	Failed  int           `json:"failed"`
+	Skipped int           `json:"skipped,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// TestStatus is the status of a single parsed test.
+type TestStatus string
+
+const (
+	// TestPassed is the status of a passing test.
+	TestPassed TestStatus = "pass"
+
+	// TestFailed is the status of a failing test.
+	TestFailed TestStatus = "fail"
+
+	// TestSkipped is the status of a skipped test.
+	TestSkipped TestStatus = "skip"
+
+	// TestAllowedFailure is the status of a test runner
+	// command which is allowed to fail and exited non-zero.
+	TestAllowedFailure TestStatus = "allowed_failure"
+
+	// TestExpectedFailure is the status of a failing test
+	// matching an expected failure pattern of the suite.
+	TestExpectedFailure TestStatus = "xfail"
+
+	// TestUnexpectedPass is the status of a passing test
+	// matching an expected failure pattern of the suite.
+	TestUnexpectedPass TestStatus = "xpass"
+)
+
+// TestResult is the result of a single test parsed
+// from the output of a test runner command.
+type TestResult struct {
+	Name   string     `json:"name"`
+	Status TestStatus `json:"status"`
+
+	// Output is the output attributed to the test,
+	// such as log lines and failure diagnostics.
+	Output string `json:"output,omitempty"`
+}
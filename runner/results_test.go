@@ -0,0 +1,48 @@
+package runner
+
+import "testing"
+
+func TestRepeatResult(t *testing.T) {
+	cases := []struct {
+		runs     []bool
+		expected RepeatResult
+		status   InstanceStatus
+	}{
+		{[]bool{true, false, true}, RepeatResult{Runs: 3, Passed: 2, Failed: 1}, InstanceFlaky},
+		{[]bool{true, true, true}, RepeatResult{Runs: 3, Passed: 3}, InstancePassed},
+		{[]bool{false, false}, RepeatResult{Runs: 2, Failed: 2}, InstanceFailed},
+		{[]bool{true}, RepeatResult{Runs: 1, Passed: 1}, InstancePassed},
+		{nil, RepeatResult{}, InstanceFailed},
+	}
+	for _, c := range cases {
+		var rr RepeatResult
+		for _, passed := range c.runs {
+			rr.Add(passed)
+		}
+		if rr != c.expected {
+			t.Fatalf("Unexpected counts for %v: %#v, expected %#v", c.runs, rr, c.expected)
+		}
+		if status := rr.Status(); status != c.status {
+			t.Fatalf("Unexpected status for %v: %s, expected %s", c.runs, status, c.status)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	cases := []struct {
+		exitCode int
+		status   InstanceStatus
+	}{
+		{0, InstancePassed},
+		{1, InstanceFailed},
+		{ExitSetupFailed, InstanceSetupFailed},
+		{ExitTestFailed, InstanceTestFailed},
+		{ExitTeardownFailed, InstanceTeardownFailed},
+		{137, InstanceFailed},
+	}
+	for _, c := range cases {
+		if status := ExitStatus(c.exitCode); status != c.status {
+			t.Fatalf("Unexpected status for exit code %d: %s, expected %s", c.exitCode, status, c.status)
+		}
+	}
+}
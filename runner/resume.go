@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// resumeEntry is the recorded result of an instance
+type resumeEntry struct {
+	Key    string    `json:"key"`
+	Passed bool      `json:"passed"`
+	Time   time.Time `json:"time"`
+}
+
+// ResumeFile records the results of completed instances
+// so a later run may skip instances which already passed.
+// Results are keyed on the suite path, the instance name and
+// a cache key of the instance, a changed key invalidates the
+// result. Keying on the suite path keeps the results of
+// instances of the same name in other configurations apart.
+type ResumeFile struct {
+	l       sync.Mutex
+	path    string
+	entries map[string]resumeEntry
+}
+
+// OpenResumeFile opens the resume file at the given path,
+// loading any previously recorded results.
+func OpenResumeFile(path string) (*ResumeFile, error) {
+	rf := &ResumeFile{
+		path:    path,
+		entries: map[string]resumeEntry{},
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rf, nil
+		}
+		return nil, fmt.Errorf("error reading resume file: %v", err)
+	}
+	if err := json.Unmarshal(b, &rf.entries); err != nil {
+		return nil, fmt.Errorf("error decoding resume file %s: %v", path, err)
+	}
+	return rf, nil
+}
+
+// Passed returns whether the instance has a recorded
+// passing result with the same cache key.
+func (rf *ResumeFile) Passed(name, key string) bool {
+	if rf == nil {
+		return false
+	}
+	rf.l.Lock()
+	defer rf.l.Unlock()
+	e, ok := rf.entries[name]
+	return ok && e.Key == key && e.Passed
+}
+
+// Record records the result of an instance and writes
+// the resume file. A nil resume file does not record.
+func (rf *ResumeFile) Record(name, key string, passed bool) error {
+	if rf == nil {
+		return nil
+	}
+	rf.l.Lock()
+	defer rf.l.Unlock()
+	rf.entries[name] = resumeEntry{
+		Key:    key,
+		Passed: passed,
+		Time:   time.Now().UTC(),
+	}
+
+	b, err := json.MarshalIndent(rf.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file so an interrupted write
+	// does not corrupt previously recorded results
+	tmp := rf.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rf.path)
+}
+
+// resumeName returns the name of an instance in the resume file.
+func resumeName(suite SuiteConfiguration, instance InstanceConfiguration) string {
+	return suite.Path + ":" + instance.Name
+}
@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestResumeFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-resume-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	fp := filepath.Join(td, "cache", "resume.json")
+
+	rf, err := OpenResumeFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf.Passed("registry-1", "sha256:aaa") {
+		t.Fatal("Unexpected passed result in new resume file")
+	}
+	if err := rf.Record("registry-1", "sha256:aaa", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := rf.Record("registry-2", "sha256:bbb", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second run loads the recorded results
+	rf, err = OpenResumeFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rf.Passed("registry-1", "sha256:aaa") {
+		t.Fatal("Expected passed instance to be skipped")
+	}
+	if rf.Passed("registry-2", "sha256:bbb") {
+		t.Fatal("Expected failed instance to be re-run")
+	}
+	if rf.Passed("registry-1", "sha256:ccc") {
+		t.Fatal("Expected changed cache key to be re-run")
+	}
+	if rf.Passed("registry-3", "sha256:aaa") {
+		t.Fatal("Expected unrecorded instance to be run")
+	}
+
+	// Re-run failed instance now passing
+	if err := rf.Record("registry-2", "sha256:bbb", true); err != nil {
+		t.Fatal(err)
+	}
+	rf, err = OpenResumeFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rf.Passed("registry-2", "sha256:bbb") {
+		t.Fatal("Expected re-run instance to be recorded as passed")
+	}
+
+	var nilFile *ResumeFile
+	if err := nilFile.Record("registry-1", "sha256:aaa", true); err != nil {
+		t.Fatal(err)
+	}
+	if nilFile.Passed("registry-1", "sha256:aaa") {
+		t.Fatal("Unexpected passed result from nil resume file")
+	}
+}
+
+func TestRunResume(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-resume-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	fp := filepath.Join(td, "resume.json")
+
+	d := newRunDaemon(t)
+	cli, stop := d.client()
+	defer stop()
+
+	d.addImage("golem-pass:latest", "sha256:01", RunConfiguration{})
+	d.addImage("golem-fail:latest", "sha256:02", RunConfiguration{})
+	d.addImage("golem-changed:latest", "sha256:03", RunConfiguration{})
+	d.exitCodes["golem-0a1b2c-fail"] = 1
+
+	run := func(suitePath string) []string {
+		rf, err := OpenResumeFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := RunnerConfiguration{
+			RunID: "0a1b2c",
+			Suites: []SuiteConfiguration{
+				{
+					Name: "suite",
+					Path: suitePath,
+					Instances: []InstanceConfiguration{
+						{Name: "pass"},
+						{Name: "fail"},
+						{Name: "changed"},
+					},
+				},
+			},
+			ResumeFile: rf,
+			Resume:     true,
+		}
+		created := len(d.createdContainers())
+		if err := NewRunner(config, CacheConfiguration{}, false).Run(context.Background(), cli); err == nil {
+			t.Fatal("Expected test failure")
+		}
+		return d.createdContainers()[created:]
+	}
+
+	expected := []string{"golem-0a1b2c-pass", "golem-0a1b2c-fail", "golem-0a1b2c-changed"}
+	if ran := run("/suites/registry"); !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("Unexpected containers on first run %v, expected %v", ran, expected)
+	}
+
+	// Passed instances are skipped unless the image changed
+	d.addImage("golem-changed:latest", "sha256:04", RunConfiguration{})
+	expected = []string{"golem-0a1b2c-fail", "golem-0a1b2c-changed"}
+	if ran := run("/suites/registry"); !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("Unexpected containers on resumed run %v, expected %v", ran, expected)
+	}
+
+	// Instances of the same name in another suite are not skipped
+	expected = []string{"golem-0a1b2c-pass", "golem-0a1b2c-fail", "golem-0a1b2c-changed"}
+	if ran := run("/suites/other"); !reflect.DeepEqual(ran, expected) {
+		t.Fatalf("Unexpected containers for other suite %v, expected %v", ran, expected)
+	}
+}
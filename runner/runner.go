@@ -2,6 +2,7 @@
 package runner
 
 import (
+	"archive/tar"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +12,9 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -19,13 +22,12 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/digest"
 	"github.com/docker/distribution/reference"
-	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stdcopy"
-	"github.com/docker/docker/pkg/term"
 	"github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
 	"github.com/docker/engine-api/types/network"
+	"github.com/docker/golem/retryutil"
 	"github.com/termie/go-shutil"
 )
 
@@ -37,13 +39,24 @@ type BaseImageConfiguration struct {
 	Base         reference.Named
 	ExtraImages  []reference.NamedTagged
 	CustomImages []CustomImage
+
+	// Platform is the platform required for all images,
+	// an empty platform accepts the daemon default.
+	Platform Platform
+
+	// ArchBase are base images used instead of Base when
+	// building for the architecture, such as arm64.
+	ArchBase map[string]reference.NamedTagged
 }
 
 // Script is the configuration for running a command
-// including its environment.
+// including its environment. Environment files are
+// loaded when the command is run, with values from
+// Env taking precedence.
 type Script struct {
 	Command []string `json:"command"`
 	Env     []string `json:"env"`
+	EnvFile []string `json:"envFile,omitempty"`
 }
 
 // TestScript is a command configuration along with
@@ -51,6 +64,35 @@ type Script struct {
 type TestScript struct {
 	Script
 	Format string `json:"format"`
+
+	// Coverage is the path of a go coverage profile written
+	// by the command inside the test container. Relative
+	// paths are resolved from the runner directory.
+	Coverage string `json:"coverage,omitempty"`
+
+	// AllowFailure records a non-zero exit of the command
+	// as a result without failing the suite.
+	AllowFailure bool `json:"allowFailure,omitempty"`
+
+	// Wrapper is a command prepended to the test command,
+	// such as a sanitizer or tracing wrapper.
+	Wrapper []string `json:"wrapper,omitempty"`
+
+	// Ordered runs the command on its own after all previous
+	// commands have finished, even when running in parallel.
+	Ordered bool `json:"ordered,omitempty"`
+
+	// WrapperEnv are environment variables in the form
+	// KEY=value set for the wrapped command, taking
+	// precedence over the command environment.
+	WrapperEnv []string `json:"wrapperEnv,omitempty"`
+
+	// ResultsFile is the path of a report written by the
+	// command inside the test container, parsed in the
+	// results format after the command exits. Relative
+	// paths are resolved from the runner directory.
+	ResultsFile   string `json:"resultsFile,omitempty"`
+	ResultsFormat string `json:"resultsFormat,omitempty"`
 }
 
 // RunConfiguration is the all the command
@@ -59,6 +101,50 @@ type TestScript struct {
 type RunConfiguration struct {
 	Setup      []Script     `json:"setup"`
 	TestRunner []TestScript `json:"runner"`
+
+	// AllowNoTests allows running without any test
+	// runner commands, otherwise an error is returned.
+	AllowNoTests bool `json:"allowNoTests,omitempty"`
+
+	// RunAll runs every test runner command even after a
+	// command fails, rather than stopping at the first failure.
+	// The run fails if any command failed.
+	RunAll bool `json:"runAll,omitempty"`
+
+	// WaitFor are log patterns waited for after starting
+	// compose services, before any tests are run.
+	WaitFor []LogWait `json:"waitFor,omitempty"`
+
+	// Probes are readiness probes run in compose services
+	// after the log waits, before any tests are run.
+	Probes []ReadinessProbe `json:"probes,omitempty"`
+
+	// InstanceEnv are environment variables identifying the
+	// instance, set for every test runner command.
+	InstanceEnv []string `json:"instanceEnv,omitempty"`
+
+	// Parallel is the maximum number of test runner commands
+	// run concurrently, commands run one at a time below 2.
+	Parallel int `json:"parallel,omitempty"`
+
+	// MinTests is the minimum number of tests which must be
+	// run, counted from the parsed results of the test runner
+	// commands excluding skipped tests. Not checked when zero.
+	MinTests int `json:"minTests,omitempty"`
+
+	// XFail are patterns matching the names of tests expected
+	// to fail. Matching failures do not fail the run and
+	// matching passes are reported as unexpected passes.
+	XFail []string `json:"xfail,omitempty"`
+
+	// XFailStrict fails the run when a test expected to fail
+	// passes.
+	XFailStrict bool `json:"xfailStrict,omitempty"`
+
+	// ComposeRetries is the number of times a failed compose
+	// build or up is retried, with the compose services taken
+	// down between attempts.
+	ComposeRetries int `json:"composeRetries,omitempty"`
 }
 
 // InstanceConfiguration is the configuration
@@ -81,14 +167,59 @@ type SuiteConfiguration struct {
 
 	DockerInDocker bool
 
+	// Mounts are host paths mounted into every
+	// container in the test suite.
+	Mounts []Mount
+
+	// StorageDriver is the storage driver for the docker
+	// daemon run inside the test container, overriding
+	// DOCKER_GRAPHDRIVER from the environment.
+	StorageDriver string
+
+	// Runtimes are additional runtimes registered with the
+	// docker daemon run inside the test container.
+	Runtimes []DaemonRuntime
+
+	// DefaultRuntime is the default runtime of the docker
+	// daemon run inside the test container.
+	DefaultRuntime string
+
+	// DaemonConfig is the path of a daemon configuration file
+	// relative to the suite directory, combined with the daemon
+	// configuration of the test image using DaemonConfigMerge.
+	DaemonConfig      string
+	DaemonConfigMerge DaemonConfigMerge
+
+	// Labels are metadata applied to the test images and
+	// containers of the suite and included in results.
+	Labels map[string]string
+
+	// Unprivileged runs the instance containers without full
+	// privileges, only allowed without docker in docker.
+	Unprivileged bool
+
+	// CapAdd and CapDrop are kernel capabilities added to and
+	// removed from the instance containers.
+	CapAdd  []string
+	CapDrop []string
+
+	// SecurityOpt are security options of the instance
+	// containers, such as seccomp or apparmor profiles.
+	SecurityOpt []string
+
+	// Requires are the daemon capabilities required to run
+	// the suite, the suite is skipped when not met.
+	Requires SuiteRequirements
+
 	Instances []InstanceConfiguration
 }
 
 // TestRunner defines an interface for building
-// and running a test.
+// and running a test. The provided context may be
+// used to cancel the build or run.
 type TestRunner interface {
-	Build(DockerClient) error
-	Run(DockerClient) error
+	Build(context.Context, DockerClient) error
+	Run(context.Context, DockerClient) error
 }
 
 // RunnerConfiguration is the configuration for
@@ -99,6 +230,170 @@ type TestRunner interface {
 type RunnerConfiguration struct {
 	Suites []SuiteConfiguration
 
+	// RunID identifies the run on labels of created
+	// resources, a random id is used when empty.
+	RunID string
+
+	// Cleanup is the policy for removing the container and
+	// graph volume of each instance after it has run.
+	Cleanup CleanupPolicy
+
+	// RegistryMirrors are registry mirrors used by the
+	// docker daemon run inside Docker-in-Docker suites.
+	RegistryMirrors []string
+
+	// CoverageDir is the directory to copy coverage profiles
+	// from test containers to, coverage profiles from all
+	// instances are merged into coverage.out.
+	CoverageDir string
+
+	// LogPersistence determines when the log streams of
+	// each instance are written to the log directory.
+	LogPersistence LogPersistence
+
+	// LogStreams are the names of the log streams to write
+	// to the log directory, all streams when empty.
+	LogStreams []string
+
+	// MergeStreams are the names of the log streams saved with
+	// stdout and stderr merged into a single file in write order.
+	MergeStreams []string
+
+	// CombinedLog writes the lines of all log streams of each
+	// instance to a single combined.log in its log directory.
+	CombinedLog bool
+
+	// LogFilter is a shell command run in each instance container
+	// which instance log streams are piped through before being
+	// saved, such as a sed expression scrubbing secrets.
+	LogFilter string
+
+	// PrintEnv prints the environment of the setup and test
+	// commands to their log streams before they run, masking
+	// values of keys matching EnvMask.
+	PrintEnv bool
+
+	// EnvMask are the key patterns of environment values masked
+	// when printing, DefaultEnvMask when empty.
+	EnvMask []string
+
+	// StatusAddr is the address the runner in instance containers
+	// serves its status and log tails on, not served when empty.
+	StatusAddr string
+
+	// LoadProgress shows the progress of loading images in each
+	// instance in its load log stream.
+	LoadProgress bool
+
+	// DaemonEvents captures the events of the daemon in dind
+	// instances to the events log stream.
+	DaemonEvents bool
+
+	// DaemonWarnings checks the startup output of the daemon in
+	// dind instances for warnings, failing the instance setup on
+	// warnings when DaemonWarningsFatal is set.
+	DaemonWarnings      bool
+	DaemonWarningsFatal bool
+
+	// DockerBinary is the docker binary used to start the daemon
+	// in dind instances, the runner default is used when empty.
+	DockerBinary string
+
+	// DaemonPidFile is the pid file of the daemon in dind
+	// instances, the runner default is used when empty.
+	DaemonPidFile string
+
+	// DefaultCommand is the test command run in instances without
+	// test runner commands, none are run when empty.
+	DefaultCommand string
+
+	// DisableConsole stops the runner in each instance from
+	// dumping test output to the console.
+	DisableConsole bool
+
+	// MaxTaps is the maximum number of simultaneous taps per log
+	// stream in each instance. DefaultMaxTaps is used when zero,
+	// and a negative value removes the limit.
+	MaxTaps int
+
+	// Tracer is a command, such as strace, the daemon started in
+	// each instance is run under for debugging, with test runner
+	// commands also traced when TraceTests is set.
+	Tracer     []string
+	TraceTests bool
+
+	// ResumeFile records the results of each instance,
+	// when nil results are not recorded.
+	ResumeFile *ResumeFile
+
+	// Resume skips instances which have a passing result
+	// recorded in the resume file for the same image.
+	Resume bool
+
+	// PinDigests emits base image Dockerfiles using digest
+	// references for all images, recording the Dockerfile
+	// in the image at /golem/Dockerfile.
+	PinDigests bool
+
+	// InstanceConfigDir is a directory to save the instance.json
+	// of each instance to as <instance name>.json, in addition to
+	// the copy built into the test image. Not saved when empty.
+	InstanceConfigDir string
+
+	// SuiteSizeLimit limits the size of each suite directory
+	// copied into the test images, a zero value is not limited.
+	SuiteSizeLimit SuiteSizeLimit
+
+	// Seed is a random seed set as GOLEM_SEED in every test
+	// container so randomized fixtures are reproducible, not
+	// set when zero.
+	Seed int64
+
+	// Repeat runs each instance the given number of times to
+	// detect flaky instances, instances not passing every run
+	// are reported as flaky. Values below 2 run once.
+	Repeat int
+
+	// FailFast stops repeating an instance after its first
+	// failed run.
+	FailFast bool
+
+	// ImageFormat is the format images are exported in when
+	// saved into base images, the docker save format when empty.
+	ImageFormat ImageFormat
+
+	// RequireCache fails building a base image which is not
+	// in the image cache or not present locally instead of
+	// building it, guarding runs expected to use a warm cache.
+	RequireCache bool
+
+	// RefreshBase resolves the images of every base image
+	// configuration again, rather than reusing the image last
+	// built from an unchanged configuration, picking up new
+	// images behind the same references.
+	RefreshBase bool
+
+	// PrePull pulls the images of all base image
+	// configurations concurrently before building, rather
+	// than one at a time as each base image is built.
+	PrePull bool
+
+	// PullConcurrency is the maximum number of images pulled
+	// at the same time when pre-pulling, one when not set.
+	PullConcurrency int
+
+	// RemoveOrphans removes containers and volumes left by
+	// previous golem runs which are not part of this run.
+	// Must not be used while other golem runs are active
+	// on the same daemon.
+	RemoveOrphans bool
+
+	// CheckPorts reports golem containers still running and
+	// holding host ports after the run, removing them when
+	// KillLeakedPorts is set.
+	CheckPorts      bool
+	KillLeakedPorts bool
+
 	// ExecutableName represents the name of the executable used inside
 	// the runner image.
 	ExecutableName string
@@ -115,6 +410,73 @@ type RunnerConfiguration struct {
 	// ImageNamespace defines the base name of the test images
 	// which will be used to push/pull from the test image
 	ImageNamespace string
+
+	// StopTimeout is the grace period given to containers to
+	// exit after being sent a stop signal before they are killed.
+	// When zero, containers are removed without being stopped.
+	StopTimeout time.Duration
+
+	// PullTimeout is the maximum time to wait for a single
+	// image pull to complete. When zero, pulls do not time out.
+	PullTimeout time.Duration
+
+	// Shell replaces the command of instance containers with
+	// an interactive shell attached to the console, such as
+	// /bin/sh, for inspecting built images without running
+	// tests. Tests are run when empty.
+	Shell string
+
+	// BuildTimeout is the maximum time to wait for a single
+	// image build to complete. When zero, builds do not time out.
+	BuildTimeout time.Duration
+
+	// PullVerbosity determines how much image pull output is
+	// displayed, normal when empty.
+	PullVerbosity PullVerbosity
+
+	// PullCapturer captures image pull output, output is
+	// written to the console when nil.
+	PullCapturer LogCapturer
+
+	// BuildCapturer captures the progress of base image and test
+	// image builds, output is written to the console when nil.
+	BuildCapturer LogCapturer
+
+	// ReferencePolicy is how image references are normalized
+	// for tags and cache keys, treated as strict when empty.
+	ReferencePolicy ReferencePolicy
+
+	// NameTemplate is the template of instance container names,
+	// also used as the compose project name in the container.
+	// DefaultNameTemplate is used when empty.
+	NameTemplate string
+
+	// ImageMismatch is the action taken when an instance image
+	// was not built from the instance configuration, such as
+	// an image overwritten by another run. Treated as error
+	// when empty.
+	ImageMismatch MismatchPolicy
+
+	// EventLog records the events of the run, events are
+	// discarded when nil.
+	EventLog *EventLog
+
+	// Metrics collects metrics from the events of the run,
+	// events are not collected when nil.
+	Metrics *Metrics
+
+	// TempDirs registers temporary build directories so they
+	// may be removed when the run is interrupted, when nil
+	// directories are only removed by the build.
+	TempDirs *TempDirs
+
+	// Hooks are the scripts run on the host before and
+	// after all suites.
+	Hooks RunHooks
+
+	// HookCapturer captures the output of the run hooks,
+	// output is discarded when nil.
+	HookCapturer LogCapturer
 }
 
 // runner represents a golem run session including
@@ -124,16 +486,55 @@ type runner struct {
 	config RunnerConfiguration
 	cache  CacheConfiguration
 	debug  bool
+
+	// pullLock serializes pull output from concurrent pulls
+	pullLock sync.Mutex
+
+	// skipped are the reasons suites with unmet requirements
+	// are skipped by suite name, set on first use.
+	skipped map[string]string
+
+	// manifests checks base images have a manifest for the
+	// build platform before pulling them.
+	manifests manifestInspector
+
+	// daemonPlatform is the platform of the daemon, set on
+	// first use when no platform is configured.
+	daemonPlatform *Platform
 }
 
 // NewRunner creates a new runner from a runner
 // and cache configuration.
 func NewRunner(config RunnerConfiguration, cache CacheConfiguration, debug bool) TestRunner {
+	if config.RunID == "" {
+		config.RunID = NewRunID()
+	}
 	return &runner{
-		config: config,
-		cache:  cache,
-		debug:  debug,
+		config:    config,
+		cache:     cache,
+		debug:     debug,
+		manifests: newRegistryManifestInspector(),
+	}
+}
+
+// logEvent records an event to the configured event log
+func (r *runner) logEvent(e Event) {
+	if err := r.config.EventLog.Log(e); err != nil {
+		logrus.Errorf("Error writing to event log: %v", err)
+	}
+	r.config.Metrics.Observe(e)
+}
+
+// logFailure records a run failed event for a build or run
+// error, returning the error.
+func (r *runner) logFailure(err error) error {
+	if err != nil {
+		r.logEvent(Event{
+			Type:  EventRunFailed,
+			Error: err.Error(),
+		})
 	}
+	return err
 }
 
 func (r *runner) imageName(name string) string {
@@ -147,93 +548,234 @@ func (r *runner) imageName(name string) string {
 // Build builds all suite instance image configured for
 // the runner. The result of build will be locally built
 // and tagged images ready to push or run directory.
-func (r *runner) Build(cli DockerClient) error {
+func (r *runner) Build(ctx context.Context, cli DockerClient) error {
+	return r.logFailure(r.buildImages(ctx, cli))
+}
+
+// buildImages builds the images of all suite instances
+// which are not skipped.
+func (r *runner) buildImages(ctx context.Context, cli DockerClient) error {
 	buildStart := time.Now()
 
+	if err := checkImageFormatSupport(ctx, cli, r.config.ImageFormat); err != nil {
+		return err
+	}
+
+	skipped, err := r.skippedSuites(ctx, cli)
+	if err != nil {
+		return err
+	}
+
+	if r.config.PrePull {
+		if err := r.prePull(ctx, cli, skipped); err != nil {
+			return err
+		}
+	}
+
 	for _, suite := range r.config.Suites {
+		if _, ok := skipped[suite.Name]; ok {
+			continue
+		}
+		if err := checkSuiteSize(suite.Path, r.config.SuiteSizeLimit); err != nil {
+			return err
+		}
 		for _, instance := range suite.Instances {
-			imageName := r.imageName(instance.Name)
-			logrus.WithField("image", imageName).Info("building image")
-
-			baseImage, err := BuildBaseImage(cli, instance.BaseImage, r.cache)
-			if err != nil {
-				return fmt.Errorf("failure building base image: %v", err)
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("build aborted: %v", err)
 			}
-
-			// Create temp build directory
-			td, err := ioutil.TempDir("", "golem-")
-			if err != nil {
-				return fmt.Errorf("unable to create tempdir: %v", err)
+			if err := r.buildInstance(ctx, cli, suite, instance); err != nil {
+				return err
 			}
-			defer os.RemoveAll(td)
+		}
+	}
 
-			// Create Dockerfile in tempDir
-			df, err := os.OpenFile(filepath.Join(td, "Dockerfile"), os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				return fmt.Errorf("error creating dockerfile: %v", err)
-			}
-			defer df.Close()
+	logrus.WithField(timerKey, time.Since(buildStart)).Info("test image build complete")
+	return nil
+}
 
-			fmt.Fprintf(df, "FROM %s\n", baseImage)
+// buildInstance builds the test image of a suite instance from
+// its base image, the suite directory and instance configuration.
+// The image is labeled with the hash of the instance configuration.
+func (r *runner) buildInstance(ctx context.Context, cli DockerClient, suite SuiteConfiguration, instance InstanceConfiguration) error {
+	imageName := r.imageName(instance.Name)
+	logrus.WithField("image", imageName).Info("building image")
+	instanceStart := time.Now()
+	r.logEvent(Event{
+		Type:     EventBuildStarted,
+		Instance: instance.Name,
+		Image:    imageName,
+	})
+
+	err := r.buildInstanceImage(ctx, cli, suite, instance, imageName)
+
+	e := Event{
+		Type:     EventBuildFinished,
+		Instance: instance.Name,
+		Image:    imageName,
+		Elapsed:  time.Since(instanceStart),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	r.logEvent(e)
+	return err
+}
 
-			logrus.Debugf("Copying %s to %s", suite.Path, filepath.Join(td, "runner"))
-			if err := shutil.CopyTree(suite.Path, filepath.Join(td, "runner"), nil); err != nil {
-				return fmt.Errorf("error copying test directory: %v", err)
-			}
+// buildInstanceImage builds and tags the test image of a suite instance.
+func (r *runner) buildInstanceImage(ctx context.Context, cli DockerClient, suite SuiteConfiguration, instance InstanceConfiguration, imageName string) error {
+	configHash, err := instanceConfigHash(instance.RunConfiguration)
+	if err != nil {
+		return err
+	}
 
-			fmt.Fprintln(df, "COPY ./runner/ /runner")
+	baseImage, err := r.baseImage(ctx, cli, instance.BaseImage)
+	if err != nil {
+		return fmt.Errorf("failure building base image: %v", err)
+	}
 
-			logrus.Debugf("Run configuration: %#v", instance.RunConfiguration)
+	// Create temp build directory
+	td, err := r.config.TempDirs.Create("golem-")
+	if err != nil {
+		return fmt.Errorf("unable to create tempdir: %v", err)
+	}
+	defer r.config.TempDirs.Remove(td)
 
-			instanceF, err := os.Create(filepath.Join(td, "instance.json"))
-			if err != nil {
-				return fmt.Errorf("error creating instance json file: %s", err)
-			}
-			if err := json.NewEncoder(instanceF).Encode(instance.RunConfiguration); err != nil {
-				instanceF.Close()
-				return fmt.Errorf("error encoding configuration: %s", err)
-			}
-			instanceF.Close()
+	// Create Dockerfile in tempDir
+	df, err := os.OpenFile(filepath.Join(td, "Dockerfile"), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating dockerfile: %v", err)
+	}
+	defer df.Close()
 
-			fmt.Fprintln(df, "COPY ./instance.json /instance.json")
+	fmt.Fprintf(df, "FROM %s\n", baseImage)
+	writeLabels(df, imageLabels(suite.Labels, configHash))
 
-			if err := df.Close(); err != nil {
-				return fmt.Errorf("error closing dockerfile: %s", err)
-			}
+	logrus.Debugf("Copying %s to %s", suite.Path, filepath.Join(td, "runner"))
+	if err := shutil.CopyTree(suite.Path, filepath.Join(td, "runner"), nil); err != nil {
+		return fmt.Errorf("error copying test directory: %v", err)
+	}
 
-			builder, err := cli.NewBuilder(td, "", imageName)
-			if err != nil {
-				return fmt.Errorf("failed to create builder: %s", err)
-			}
+	fmt.Fprintln(df, "COPY ./runner/ /runner")
 
-			if err := builder.Run(); err != nil {
-				return fmt.Errorf("build error: %s", err)
-			}
+	logrus.Debugf("Run configuration: %#v", instance.RunConfiguration)
+
+	if err := writeInstanceConfig(filepath.Join(td, "instance.json"), instance.RunConfiguration); err != nil {
+		return err
+	}
+	if r.config.InstanceConfigDir != "" {
+		instanceFile := filepath.Join(r.config.InstanceConfigDir, instance.Name+".json")
+		if err := writeInstanceConfig(instanceFile, instance.RunConfiguration); err != nil {
+			return err
 		}
+		logrus.Debugf("Saved instance configuration to %s", instanceFile)
 	}
 
-	logrus.WithField(timerKey, time.Since(buildStart)).Info("test image build complete")
+	fmt.Fprintln(df, "COPY ./instance.json /instance.json")
+
+	if err := df.Close(); err != nil {
+		return fmt.Errorf("error closing dockerfile: %s", err)
+	}
+
+	builder, err := cli.NewBuilder(td, "", imageName, r.buildOutput())
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %s", err)
+	}
+
+	if err := buildImage(ctx, builder, r.config.BuildTimeout); err != nil {
+		return fmt.Errorf("build error: %s", err)
+	}
+	return nil
+}
+
+// writeInstanceConfig writes the run configuration of an
+// instance as JSON, creating the parent directory.
+func writeInstanceConfig(path string, rc RunConfiguration) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating instance json directory: %s", err)
+	}
+	instanceF, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating instance json file: %s", err)
+	}
+	defer instanceF.Close()
+	if err := json.NewEncoder(instanceF).Encode(rc); err != nil {
+		return fmt.Errorf("error encoding configuration: %s", err)
+	}
 	return nil
 }
 
 // Run starts the test instance containers as well as any
 // containers which will manage the tests and waits for
-// the results.
-func (r *runner) Run(cli DockerClient) error {
+// the results. The run hooks are run around all suites.
+func (r *runner) Run(ctx context.Context, cli DockerClient) error {
+	lc := r.config.HookCapturer
+	if lc == nil {
+		lc = nilLogger{}
+	}
+	return r.logFailure(runWithHooks(r.config.Hooks, lc, func() error {
+		runErr := r.runSuites(ctx, cli)
+		if r.config.CheckPorts || r.config.KillLeakedPorts {
+			// Use a new context to check after an aborted run
+			if err := r.checkLeakedPorts(context.Background(), cli, r.config.KillLeakedPorts); err != nil {
+				logrus.Errorf("Error checking leaked ports: %v", err)
+			}
+		}
+		return runErr
+	}))
+}
+
+// runSuites runs the test instances of all suites.
+func (r *runner) runSuites(ctx context.Context, cli DockerClient) error {
 	var (
-		failedTests int
-		runTests    int
-		runnerStart = time.Now()
-		ctx         = context.Background()
+		failedTests   int
+		runTests      int
+		skippedTests  int
+		coverageFiles []string
+		runnerStart   = time.Now()
 	)
 
+	contNames, err := r.containerNames()
+	if err != nil {
+		return err
+	}
+
+	if r.config.RemoveOrphans {
+		if err := r.removeOrphans(ctx, cli); err != nil {
+			return err
+		}
+	}
+
+	skipped, err := r.skippedSuites(ctx, cli)
+	if err != nil {
+		return err
+	}
+
 	// TODO: Run in parallel
 	// TODO: validate namespace when in parallel mode
 	for _, suite := range r.config.Suites {
+		if reason, ok := skipped[suite.Name]; ok {
+			for _, instance := range suite.Instances {
+				skippedTests++
+				r.logEvent(Event{
+					Type:     EventInstanceResult,
+					Instance: instance.Name,
+					Result: &InstanceResult{
+						Name:   instance.Name,
+						Suite:  suite.Name,
+						Labels: suite.Labels,
+						Status: InstanceSkipped,
+						Reason: reason,
+					},
+				})
+			}
+			continue
+		}
 		for _, instance := range suite.Instances {
-			// TODO: Add configuration for nocache
-			nocache := false
-			contName := "golem-" + instance.Name
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("run aborted: %v", err)
+			}
+			instanceStart := time.Now()
+			contName := contNames[instance.Name]
 			// TODO: Use image ID and not image name
 			imageName := r.imageName(instance.Name)
 
@@ -244,150 +786,584 @@ func (r *runner) Run(cli DockerClient) error {
 			}
 			logrus.WithFields(logFields).Info("running instance")
 
-			hc := &container.HostConfig{
-				Privileged:   true,
-				VolumeDriver: "local",
+			if err := verifyInstanceImage(ctx, cli, r.config.ImageMismatch, imageName, instance.RunConfiguration, func() error {
+				return r.buildInstance(ctx, cli, suite, instance)
+			}); err != nil {
+				return err
 			}
 
-			args := []string{}
-			if suite.DockerInDocker {
-				args = append(args, "-docker")
-			}
-			if r.debug {
-				args = append(args, "-debug")
-			}
-			// TODO: Add argument for instance name
-
-			config := &container.Config{
-				Image:      imageName,
-				Cmd:        append([]string{r.config.ExecutableName}, args...),
-				WorkingDir: "/runner",
-				Volumes: map[string]struct{}{
-					"/var/log/docker": {},
-				},
+			// The instance image id is the cache key, any change
+			// to the instance configuration changes the image
+			var resumeKey string
+			if r.config.ResumeFile != nil {
+				info, _, err := cli.ImageInspectWithRaw(ctx, imageName, false)
+				if err != nil {
+					return fmt.Errorf("error inspecting image %s: %v", imageName, err)
+				}
+				resumeKey = info.ID
+				if r.config.Resume && r.config.ResumeFile.Passed(resumeName(suite, instance), resumeKey) {
+					logrus.WithFields(logFields).Info("skipping instance, already passed")
+					continue
+				}
 			}
 
-			if suite.DockerInDocker {
-				config.Env = append(config.Env, "DOCKER_GRAPHDRIVER="+getGraphDriver())
-
-				// TODO: In parallel mode, do not use a cached volume
-				volumeName := contName + "-graph"
-				cont, err := cli.ContainerInspect(ctx, contName)
-				if err == nil {
-					removeOptions := types.ContainerRemoveOptions{
-						RemoveVolumes: true,
-					}
-					if err := cli.ContainerRemove(ctx, cont.ID, removeOptions); err != nil {
-						return fmt.Errorf("error removing existing container %s: %v", contName, err)
-					}
+			repeat := r.config.Repeat
+			if repeat < 1 {
+				repeat = 1
+			}
+			var counts RepeatResult
+			for run := 1; run <= repeat; run++ {
+				if err := ctx.Err(); err != nil {
+					return fmt.Errorf("run aborted: %v", err)
+				}
+				runStart := time.Now()
+				containerID, exitCode, err := r.runInstance(ctx, cli, suite, instance, contName)
+				if err != nil {
+					return err
+				}
+				passed := exitCode == 0
+				counts.Add(passed)
+				runStatus := ExitStatus(exitCode)
+				if !passed {
+					logrus.Errorf("Test failed with exit code %d: %s", exitCode, runStatus)
 				}
 
-				var createVolume bool
-				vol, err := cli.VolumeInspect(ctx, volumeName)
-				if err == nil {
-					if nocache {
-						if err := cli.VolumeRemove(ctx, vol.Name); err != nil {
-							return fmt.Errorf("error removing volume %s: %v", vol.Name, err)
-						}
-						createVolume = true
-					}
-				} else if client.IsErrVolumeNotFound(err) {
-					createVolume = true
-				} else {
-					return fmt.Errorf("error inspecting volume: %v", err)
+				if r.config.CoverageDir != "" && run == 1 {
+					coverageFiles = append(coverageFiles, r.collectCoverage(ctx, cli, containerID, instance)...)
 				}
 
-				if createVolume {
-					createOptions := types.VolumeCreateRequest{
-						Name:   volumeName,
-						Driver: "local",
-					}
-					vol, err = cli.VolumeCreate(ctx, createOptions)
-					if err != nil {
-						return fmt.Errorf("error creating volume: %v", err)
-					}
+				var volumeName string
+				if suite.DockerInDocker {
+					volumeName = contName + "-graph"
+				}
+				if err := cleanupInstance(ctx, cli, r.config.Cleanup, containerID, volumeName, r.config.StopTimeout, passed); err != nil {
+					logrus.Errorf("Error cleaning up instance %s: %v", instance.Name, err)
 				}
 
-				// TODO: Use volume name instead of mountpoint
-				logrus.Debugf("Mounting %s to %s", vol.Mountpoint, "/var/lib/docker")
-				hc.Binds = append(hc.Binds, fmt.Sprintf("%s:/var/lib/docker", vol.Mountpoint))
+				result := &InstanceResult{
+					Name:     instance.Name,
+					Suite:    suite.Name,
+					ExitCode: exitCode,
+					Passed:   passed,
+					Elapsed:  time.Since(runStart),
+					Labels:   suite.Labels,
+					Status:   runStatus,
+				}
+				if repeat > 1 {
+					result.Run = run
+				}
+				r.logEvent(Event{
+					Type:     EventInstanceResult,
+					Instance: instance.Name,
+					Result:   result,
+				})
+
+				if !passed && r.config.FailFast {
+					break
+				}
 			}
 
-			nc := &network.NetworkingConfig{}
-
-			container, err := cli.ContainerCreate(ctx, config, hc, nc, contName)
-			if err != nil {
-				return fmt.Errorf("error creating container: %s", err)
+			runTests = runTests + 1
+			status := counts.Status()
+			if status != InstancePassed {
+				failedTests = failedTests + 1
 			}
-
-			for _, warning := range container.Warnings {
-				logrus.Warnf("Container %q create warning: %v", contName, warning)
+			if repeat > 1 {
+				logrus.WithFields(logFields).WithFields(logrus.Fields{
+					"runs":   counts.Runs,
+					"passed": counts.Passed,
+					"failed": counts.Failed,
+				}).Infof("instance %s", status)
+				r.logEvent(Event{
+					Type:     EventInstanceRepeatResult,
+					Instance: instance.Name,
+					Result: &InstanceResult{
+						Name:    instance.Name,
+						Suite:   suite.Name,
+						Passed:  status == InstancePassed,
+						Elapsed: time.Since(instanceStart),
+						Labels:  suite.Labels,
+						Status:  status,
+						Repeat:  &counts,
+					},
+				})
 			}
 
-			if err := cli.ContainerStart(ctx, container.ID); err != nil {
-				return fmt.Errorf("error starting container: %s", err)
+			if err := r.config.ResumeFile.Record(resumeName(suite, instance), resumeKey, status == InstancePassed); err != nil {
+				logrus.Errorf("Error recording result for %s: %v", instance.Name, err)
 			}
+		}
+	}
+
+	if len(coverageFiles) > 0 {
+		merged := filepath.Join(r.config.CoverageDir, "coverage.out")
+		if err := mergeCoverageFiles(merged, coverageFiles); err != nil {
+			logrus.Errorf("Error merging coverage profiles: %v", err)
+		} else {
+			logrus.Infof("Merged %d coverage profiles to %s", len(coverageFiles), merged)
+		}
+	}
+
+	logFields := logrus.Fields{
+		timerKey:  time.Since(runnerStart),
+		"ran":     runTests,
+		"failed":  failedTests,
+		"skipped": skippedTests,
+	}
+	logrus.WithFields(logFields).Info("test runner complete")
+	r.logEvent(Event{
+		Type: EventRunSummary,
+		Summary: &RunSummary{
+			Ran:     runTests,
+			Failed:  failedTests,
+			Skipped: skippedTests,
+			Elapsed: time.Since(runnerStart),
+		},
+	})
 
-			attachOptions := types.ContainerAttachOptions{
-				Stream: true,
-				Stdout: true,
-				Stderr: true,
+	if failedTests > 0 {
+		return fmt.Errorf("test failure: %d of %d tests failed", failedTests, runTests)
+	}
+
+	return nil
+}
+
+// runInstance creates and runs the container of a test instance,
+// streaming its output, and returns the container id and exit code.
+func (r *runner) runInstance(ctx context.Context, cli DockerClient, suite SuiteConfiguration, instance InstanceConfiguration, contName string) (string, int, error) {
+	// TODO: Add configuration for nocache
+	nocache := false
+	// TODO: Use image ID and not image name
+	imageName := r.imageName(instance.Name)
+
+	hc := instanceHostConfig(suite)
+
+	args := r.instanceArgs(suite, instance)
+
+	config := &container.Config{
+		Image:      imageName,
+		Cmd:        r.containerCommand(args),
+		WorkingDir: "/runner",
+		Volumes: map[string]struct{}{
+			"/var/log/docker": {},
+		},
+		Labels: instanceLabels(suite.Labels, r.config.RunID),
+		// Compose projects are named after the container so
+		// compose resource names do not collide between runs
+		Env: []string{"COMPOSE_PROJECT_NAME=" + contName},
+	}
+	if r.config.Seed != 0 {
+		config.Env = append(config.Env, fmt.Sprintf("GOLEM_SEED=%d", r.config.Seed))
+	}
+	r.setShellConfig(config)
+
+	// Remove a container left by a previous run or repeat
+	cont, err := cli.ContainerInspect(ctx, contName)
+	if err == nil {
+		removeOptions := types.ContainerRemoveOptions{
+			RemoveVolumes: true,
+		}
+		if err := removeContainer(ctx, cli, cont.ID, r.config.StopTimeout, removeOptions); err != nil {
+			return "", 0, fmt.Errorf("error removing existing container %s: %v", contName, err)
+		}
+	}
+
+	if suite.DockerInDocker {
+		config.Env = append(config.Env, "DOCKER_GRAPHDRIVER="+getGraphDriver(suite.StorageDriver))
+
+		// TODO: In parallel mode, do not use a cached volume
+		volumeName := contName + "-graph"
+
+		var createVolume bool
+		vol, err := cli.VolumeInspect(ctx, volumeName)
+		if err == nil {
+			if nocache {
+				if err := cli.VolumeRemove(ctx, vol.Name); err != nil {
+					return "", 0, fmt.Errorf("error removing volume %s: %v", vol.Name, err)
+				}
+				createVolume = true
 			}
-			resp, err := cli.ContainerAttach(ctx, container.ID, attachOptions)
+		} else if client.IsErrVolumeNotFound(err) {
+			createVolume = true
+		} else {
+			return "", 0, fmt.Errorf("error inspecting volume: %v", err)
+		}
+
+		if createVolume {
+			createOptions := graphVolumeRequest(volumeName, r.config.RunID)
+			vol, err = cli.VolumeCreate(ctx, createOptions)
 			if err != nil {
-				return fmt.Errorf("Error attaching to container: %v", err)
+				return "", 0, fmt.Errorf("error creating volume: %v", err)
 			}
+		}
 
-			// TODO: Capture output for parallel mode
-			if _, err := stdcopy.StdCopy(os.Stdout, os.Stderr, resp.Reader); err != nil {
-				return fmt.Errorf("Error copying output stream: %v", err)
-			}
+		// TODO: Use volume name instead of mountpoint
+		logrus.Debugf("Mounting %s to %s", vol.Mountpoint, "/var/lib/docker")
+		hc.Binds = append(hc.Binds, fmt.Sprintf("%s:/var/lib/docker", vol.Mountpoint))
+	}
 
-			inspectedContainer, err := cli.ContainerInspect(ctx, container.ID)
-			if err != nil {
-				return fmt.Errorf("Error inspecting container: %v", err)
+	for _, m := range suite.Mounts {
+		logrus.Debugf("Mounting %s to %s", m.Source, m.Target)
+		hc.Binds = append(hc.Binds, m.Bind())
+	}
+
+	nc := &network.NetworkingConfig{}
+
+	container, err := cli.ContainerCreate(ctx, config, hc, nc, contName)
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating container: %s", err)
+	}
+
+	for _, warning := range container.Warnings {
+		logrus.Warnf("Container %q create warning: %v", contName, warning)
+	}
+
+	if err := cli.ContainerStart(ctx, container.ID); err != nil {
+		return "", 0, fmt.Errorf("error starting container: %s", err)
+	}
+
+	attachOptions := types.ContainerAttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	}
+	if r.config.Shell != "" {
+		attachOptions.Stdin = true
+	}
+	resp, err := cli.ContainerAttach(ctx, container.ID, attachOptions)
+	if err != nil {
+		return "", 0, fmt.Errorf("Error attaching to container: %v", err)
+	}
+
+	copyOutput := func() error {
+		_, err := stdcopy.StdCopy(os.Stdout, os.Stderr, resp.Reader)
+		return err
+	}
+	if r.config.Shell != "" {
+		copyOutput = func() error {
+			return attachShell(resp)
+		}
+	}
+
+	// TODO: Capture output for parallel mode
+	if err := copyOutput(); err != nil {
+		if ctx.Err() != nil {
+			// Context is done, use a new context to cleanup the container
+			removeOptions := types.ContainerRemoveOptions{
+				Force: true,
 			}
-			runTests = runTests + 1
-			if inspectedContainer.State.ExitCode > 0 {
-				logrus.Errorf("Test failed with exit code %d", inspectedContainer.State.ExitCode)
-				failedTests = failedTests + 1
+			if err := removeContainer(context.Background(), cli, container.ID, r.config.StopTimeout, removeOptions); err != nil {
+				logrus.Errorf("Error removing container %s: %v", contName, err)
 			}
+			return "", 0, fmt.Errorf("run aborted: %v", ctx.Err())
 		}
+		return "", 0, fmt.Errorf("Error copying output stream: %v", err)
 	}
 
-	logFields := logrus.Fields{
-		timerKey: time.Since(runnerStart),
-		"ran":    runTests,
-		"failed": failedTests,
+	inspectedContainer, err := cli.ContainerInspect(ctx, container.ID)
+	if err != nil {
+		return "", 0, fmt.Errorf("Error inspecting container: %v", err)
 	}
-	logrus.WithFields(logFields).Info("test runner complete")
 
-	if failedTests > 0 {
-		return fmt.Errorf("test failure: %d of %d tests failed", failedTests, runTests)
+	return container.ID, inspectedContainer.State.ExitCode, nil
+}
+
+// instanceArgs returns the arguments of the runner in the
+// container of a test instance.
+func (r *runner) instanceArgs(suite SuiteConfiguration, instance InstanceConfiguration) []string {
+	args := []string{}
+	if suite.DockerInDocker {
+		args = append(args, "-docker")
+		if r.config.DockerBinary != "" {
+			args = append(args, "-docker-binary="+r.config.DockerBinary)
+		}
+		if r.config.DaemonPidFile != "" {
+			args = append(args, "-daemon-pidfile="+r.config.DaemonPidFile)
+		}
+	}
+	if r.debug {
+		args = append(args, "-debug")
+	}
+	if r.config.StopTimeout > 0 {
+		args = append(args, "-stop-timeout="+r.config.StopTimeout.String())
+	}
+	if r.config.Cleanup != "" {
+		args = append(args, "-cleanup="+string(r.config.Cleanup))
+	}
+	for _, mirror := range r.config.RegistryMirrors {
+		args = append(args, "-registry-mirror="+mirror)
+	}
+	for _, rt := range suite.Runtimes {
+		args = append(args, "-runtime="+rt.String())
+	}
+	if suite.DefaultRuntime != "" {
+		args = append(args, "-default-runtime="+suite.DefaultRuntime)
+	}
+	if suite.DaemonConfig != "" {
+		args = append(args, "-daemon-config="+path.Join("/runner", filepath.ToSlash(suite.DaemonConfig)))
+		if suite.DaemonConfigMerge != "" {
+			args = append(args, "-daemon-config-merge="+string(suite.DaemonConfigMerge))
+		}
 	}
+	if r.config.LogPersistence != "" {
+		args = append(args, "-log-persist="+string(r.config.LogPersistence))
+	}
+	if len(r.config.LogStreams) > 0 {
+		args = append(args, "-log-streams="+strings.Join(r.config.LogStreams, ","))
+	}
+	if len(r.config.MergeStreams) > 0 {
+		args = append(args, "-merge-streams="+strings.Join(r.config.MergeStreams, ","))
+	}
+	if r.config.CombinedLog {
+		args = append(args, "-combined-log")
+	}
+	if r.config.LogFilter != "" {
+		args = append(args, "-log-filter="+r.config.LogFilter)
+	}
+	if r.config.PrintEnv {
+		args = append(args, "-print-env")
+		if len(r.config.EnvMask) > 0 {
+			args = append(args, "-env-mask="+strings.Join(r.config.EnvMask, ","))
+		}
+	}
+	if r.config.LoadProgress {
+		args = append(args, "-load-progress")
+	}
+	if r.config.DaemonEvents {
+		args = append(args, "-daemon-events")
+	}
+	if r.config.DaemonWarnings {
+		args = append(args, "-daemon-warnings")
+		if r.config.DaemonWarningsFatal {
+			args = append(args, "-daemon-warnings-fatal")
+		}
+	}
+	if r.config.ReferencePolicy == ReferenceDocker {
+		args = append(args, "-reference-policy="+string(ReferenceDocker))
+	}
+	if r.config.StatusAddr != "" {
+		args = append(args, "-status-addr="+r.config.StatusAddr)
+	}
+	if r.config.DisableConsole {
+		args = append(args, "-console=false")
+	}
+	if r.config.DefaultCommand != DefaultTestCommand {
+		args = append(args, "-command="+r.config.DefaultCommand)
+	}
+	if r.config.MaxTaps < 0 {
+		args = append(args, "-max-taps=0")
+	} else if r.config.MaxTaps > 0 && r.config.MaxTaps != DefaultMaxTaps {
+		args = append(args, "-max-taps="+strconv.Itoa(r.config.MaxTaps))
+	}
+	if len(r.config.Tracer) > 0 {
+		args = append(args, "-tracer="+strings.Join(r.config.Tracer, " "))
+		if r.config.TraceTests {
+			args = append(args, "-trace-tests")
+		}
+	}
+	args = append(args, "-instance="+instance.Name)
+	return args
+}
 
-	return nil
+// collectCoverage copies the coverage profiles of the instance
+// test runners to the coverage directory, returning the copied
+// files. Copy errors are logged and do not fail the run.
+func (r *runner) collectCoverage(ctx context.Context, cli coverageCopier, containerID string, instance InstanceConfiguration) []string {
+	var files []string
+	for i, script := range instance.TestRunner {
+		if script.Coverage == "" {
+			continue
+		}
+		if err := os.MkdirAll(r.config.CoverageDir, 0755); err != nil {
+			logrus.Errorf("Error creating coverage directory: %v", err)
+			return files
+		}
+		dst := filepath.Join(r.config.CoverageDir, fmt.Sprintf("%s-%d.cover", instance.Name, i+1))
+		if err := copyCoverage(ctx, cli, containerID, script.Coverage, dst); err != nil {
+			logrus.Errorf("Error collecting coverage for %s: %v", instance.Name, err)
+			continue
+		}
+		files = append(files, dst)
+	}
+	return files
+}
+
+// containerRemover is the subset of the docker client used
+// to stop and remove containers.
+type containerRemover interface {
+	ContainerStop(ctx context.Context, containerID string, timeout int) error
+	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
 }
 
-func getGraphDriver() string {
-	d := os.Getenv("DOCKER_GRAPHDRIVER")
-	switch d {
-	case "":
-		return "overlay"
-	default:
+// removeContainer removes a container, first stopping it to give the
+// container the provided timeout to gracefully exit. A zero timeout
+// removes the container without first stopping it.
+func removeContainer(ctx context.Context, cli containerRemover, containerID string, timeout time.Duration, options types.ContainerRemoveOptions) error {
+	if timeout > 0 {
+		if err := cli.ContainerStop(ctx, containerID, stopSeconds(timeout)); err != nil {
+			// Removal may still succeed if the container was not running
+			logrus.Debugf("Error stopping container %s: %v", containerID, err)
+		}
+	}
+	return cli.ContainerRemove(ctx, containerID, options)
+}
+
+// stopSeconds converts a stop timeout to the seconds expected by
+// the docker API, rounding up so short timeouts are not lost.
+func stopSeconds(timeout time.Duration) int {
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
+// getGraphDriver returns the graph driver to use for a docker
+// daemon. The override is used when set, falling back to the
+// DOCKER_GRAPHDRIVER environment variable and then overlay.
+func getGraphDriver(override string) string {
+	if override != "" {
+		return override
+	}
+	if d := os.Getenv("DOCKER_GRAPHDRIVER"); d != "" {
 		return d
 	}
+	return "overlay"
 }
 
 func registryAuthNotSupported() (string, error) {
 	return "", errors.New("Registry auth not supported, pull image and re-run golem")
 }
 
-func ensureImage(cli DockerClient, image string) (string, error) {
-	ctx := context.Background()
+// imagePuller is the subset of the docker client used to pull images.
+type imagePuller interface {
+	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
+}
+
+// pullImage pulls an image and displays the pull progress to w
+// according to the verbosity. The pull is cancelled if not
+// completed within the timeout, a zero timeout only cancels the
+// pull when the provided context is done.
+func pullImage(ctx context.Context, cli imagePuller, image string, timeout time.Duration, w io.Writer, verbosity PullVerbosity) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	pullOptions := types.ImagePullOptions{
+		PrivilegeFunc: registryAuthNotSupported,
+	}
+	resp, err := cli.ImagePull(ctx, image, pullOptions)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	if err := displayPull(resp, w, verbosity); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("pull cancelled: %v", ctx.Err())
+		}
+		return fmt.Errorf("error copying pull output: %v", err)
+	}
+
+	return nil
+}
+
+// pullOutput returns the writer image pull output is
+// displayed to, the console when no capturer is configured.
+func (r *runner) pullOutput() io.Writer {
+	if r.config.PullCapturer == nil {
+		return lockedWriter{l: &r.pullLock, w: os.Stdout}
+	}
+	return lockedWriter{l: &r.pullLock, w: r.config.PullCapturer.Stdout()}
+}
+
+// buildOutput returns the writer image build progress is
+// written to, the console when no capturer is configured.
+func (r *runner) buildOutput() io.Writer {
+	if r.config.BuildCapturer == nil {
+		return os.Stdout
+	}
+	return r.config.BuildCapturer.Stdout()
+}
+
+// imageBuilder is the subset of the docker builder used
+// to build images.
+type imageBuilder interface {
+	Run() error
+	ImageID() string
+	Detach()
+}
+
+// buildImage runs the builder, aborting the build if not completed
+// within the timeout. A zero timeout only aborts the build when
+// the provided context is done. The builder cannot be interrupted,
+// so an aborted build is abandoned, detached from its output, and
+// should have its build context removed.
+func buildImage(ctx context.Context, builder imageBuilder, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- builder.Run()
+	}()
+
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+		builder.Detach()
+		return fmt.Errorf("build cancelled: %v", ctx.Err())
+	}
+}
+
+// pullPolicy is the retry policy for pulling images, only
+// transient errors are retried.
+var pullPolicy = retryutil.Policy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Retryable:      retryablePullError,
+}
+
+// permanentPullErrors are lowercase messages of pull errors which
+// fail the same way when retried, such as for missing images or
+// missing credentials.
+var permanentPullErrors = []string{
+	"not found",
+	"manifest unknown",
+	"unauthorized",
+	"authentication required",
+	"access denied",
+	"registry auth not supported",
+	"invalid reference",
+}
+
+// retryablePullError returns whether a pull error may be
+// transient and the pull worth retrying.
+func retryablePullError(err error) bool {
+	if client.IsErrImageNotFound(err) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range permanentPullErrors {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *runner) ensureImage(ctx context.Context, cli DockerClient, image string, platform Platform) (string, error) {
 	info, _, err := cli.ImageInspectWithRaw(ctx, image, false)
 	if err == nil {
 		logrus.Debugf("Image found locally %s", image)
+		if err := checkImagePlatform(info, platform); err != nil {
+			logrus.Errorf("Local image %q does not match platform: %v", image, err)
+			return "", err
+		}
 		return info.ID, nil
 	}
 
@@ -409,22 +1385,16 @@ func ensureImage(cli DockerClient, image string) (string, error) {
 	}
 
 	pullStart := time.Now()
-	pullOptions := types.ImagePullOptions{
-		PrivilegeFunc: registryAuthNotSupported,
-	}
-	resp, err := cli.ImagePull(ctx, tagged.String(), pullOptions)
-	if err != nil {
+	if err := retryutil.Do(ctx, pullPolicy, func(ctx context.Context) error {
+		err := pullImage(ctx, cli, tagged.String(), r.config.PullTimeout, r.pullOutput(), r.config.PullVerbosity)
+		if err != nil {
+			logrus.Debugf("Pull attempt for %q failed: %v", tagged.String(), err)
+		}
+		return err
+	}); err != nil {
 		logrus.Errorf("Error pulling image %q: %v", tagged.String(), err)
 		return "", err
 	}
-	defer resp.Close()
-
-	outFd, isTerminalOut := term.GetFdInfo(os.Stdout)
-
-	if err = jsonmessage.DisplayJSONMessagesStream(resp, os.Stdout, outFd, isTerminalOut, nil); err != nil {
-		logrus.Errorf("Error copying pull output: %v", err)
-		return "", err
-	}
 	// TODO: Get pulled digest
 
 	logFields := logrus.Fields{
@@ -432,16 +1402,34 @@ func ensureImage(cli DockerClient, image string) (string, error) {
 		"image":  tagged.String(),
 	}
 	logrus.WithFields(logFields).Info("image pulled")
+	r.logEvent(Event{
+		Type:    EventImagePulled,
+		Image:   tagged.String(),
+		Elapsed: time.Since(pullStart),
+	})
 
 	info, _, err = cli.ImageInspectWithRaw(ctx, tagged.String(), false)
 	if err != nil {
 		return "", nil
 	}
+	if err := checkImagePlatform(info, platform); err != nil {
+		logrus.Errorf("Pulled image %q does not match platform: %v", tagged.String(), err)
+		return "", err
+	}
+	if r.config.PullVerbosity.summarized() {
+		writePullSummary(r.pullOutput(), tagged.String(), info.Size, time.Since(pullStart))
+	}
 
 	return info.ID, nil
 }
 
-func saveImage(cli DockerClient, filename, imgID string) error {
+// imageSaver is the subset of the docker client used
+// to save images
+type imageSaver interface {
+	ImageSave(ctx context.Context, imageIDs []string) (io.ReadCloser, error)
+}
+
+func saveImage(cli imageSaver, filename, imgID string) error {
 	ctx := context.Background()
 
 	// TODO: must not exist
@@ -462,9 +1450,76 @@ func saveImage(cli DockerClient, filename, imgID string) error {
 		return fmt.Errorf("error copying saved image response: %v", err)
 	}
 
+	if _, err := f.Seek(0, 0); err != nil {
+		return fmt.Errorf("error seeking saved image tar: %v", err)
+	}
+	if err := verifySavedImage(f, imgID); err != nil {
+		return fmt.Errorf("invalid saved image tar %s: %v", filename, err)
+	}
+
 	return nil
 }
 
+// savedManifest is an entry in the manifest.json file
+// of a saved image tar.
+type savedManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// verifySavedImage verifies that a saved image tar contains the
+// image with the provided ID. Images are saved by ID so the tar
+// has no repo tags, tags are applied from the saved tag map. Tars
+// from daemons without manifest.json are checked for the legacy
+// image directory.
+func verifySavedImage(r io.Reader, imgID string) error {
+	hex := imgID
+	if i := strings.Index(hex, ":"); i >= 0 {
+		hex = hex[i+1:]
+	}
+
+	var (
+		manifest    []savedManifest
+		hasManifest bool
+		hasLegacy   bool
+	)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %v", err)
+		}
+		switch path.Clean(hdr.Name) {
+		case "manifest.json":
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return fmt.Errorf("error decoding manifest.json: %v", err)
+			}
+			hasManifest = true
+		case hex:
+			hasLegacy = true
+		}
+	}
+
+	if !hasManifest {
+		if hasLegacy {
+			return nil
+		}
+		return fmt.Errorf("no manifest.json or image directory for %s", imgID)
+	}
+
+	for _, m := range manifest {
+		if strings.TrimSuffix(path.Base(m.Config), ".json") == hex {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image %s not found in manifest.json", imgID)
+}
+
 func saveTagMap(filename string, tags []tag) error {
 	m := map[string][]string{}
 	for _, t := range tags {
@@ -552,6 +1607,10 @@ type CustomImage struct {
 	Target      reference.NamedTagged
 	Version     string
 	DefaultOnly bool
+
+	// Env are additional environment variables in the form
+	// KEY=template, templated from the selected version.
+	Env []string
 }
 
 func (ci CustomImage) String() string {
@@ -571,9 +1630,23 @@ const (
 	// hashVersion is used to force build cache
 	// busting when the method to compute the
 	// hash changes
-	hashVersion = "1"
+	hashVersion = "2"
 )
 
+// instanceEnv returns the environment variables identifying
+// an instance by its name, its index within the suite starting
+// at 1, and the version of each custom image.
+func instanceEnv(name string, index int, customImages []CustomImage) []string {
+	env := []string{
+		"GOLEM_INSTANCE_NAME=" + name,
+		fmt.Sprintf("GOLEM_INSTANCE_INDEX=%d", index),
+	}
+	for _, ci := range customImages {
+		env = append(env, fmt.Sprintf("%s_VERSION=%s", nameToEnv(ci.Target.Name()), ci.Version))
+	}
+	return env
+}
+
 func nameToEnv(name string) string {
 	name = strings.Replace(name, ".", "_", -1)
 	name = strings.Replace(name, "-", "_", -1)
@@ -581,21 +1654,132 @@ func nameToEnv(name string) string {
 	return strings.ToUpper(name)
 }
 
+// baseImageDigest computes the build cache digest for a base image
+// from its build inputs, the base image id, the tagged images,
+// version environment variables and the platform.
+func baseImageDigest(inputs []string) digest.Digest {
+	return digest.FromBytes([]byte(strings.Join(inputs, "\n")))
+}
+
+// imageInspector is the subset of the docker client used to inspect images
+type imageInspector interface {
+	ImageInspectWithRaw(ctx context.Context, imageID string, getSize bool) (types.ImageInspect, []byte, error)
+}
+
+// digestReference returns the digest reference for the named
+// repository from the repo digests of an image.
+func digestReference(repoDigests []string, name string) (string, bool) {
+	named, err := reference.ParseNamed(name)
+	if err != nil {
+		return "", false
+	}
+	for _, rd := range repoDigests {
+		ref, err := reference.ParseNamed(rd)
+		if err != nil {
+			continue
+		}
+		if _, ok := ref.(reference.Canonical); ok && ref.Name() == named.Name() {
+			return ref.String(), true
+		}
+	}
+	return "", false
+}
+
+// pinnedReference returns the digest reference of the image with
+// the provided id, falling back to the id when the image has no
+// digest for the named repository, such as locally built images.
+func pinnedReference(ctx context.Context, cli imageInspector, name, id string) string {
+	info, _, err := cli.ImageInspectWithRaw(ctx, id, false)
+	if err != nil {
+		logrus.Warnf("Unable to inspect %s for digest, using image id: %v", name, err)
+		return id
+	}
+	if ref, ok := digestReference(info.RepoDigests, name); ok {
+		return ref
+	}
+	logrus.Warnf("No digest found for %s, using image id %s", name, id)
+	return id
+}
+
+// writeFrom writes the FROM instruction along with comments
+// recording the pinned references of the images in the image.
+func writeFrom(w io.Writer, from string, pinned []string) {
+	fmt.Fprintf(w, "FROM %s\n", from)
+	for _, p := range pinned {
+		fmt.Fprintf(w, "# image %s\n", p)
+	}
+}
+
+// cachedBaseImage returns the id of the image cached for the hash
+// when the image exists locally, saving the build inputs. On a
+// miss the changed build inputs are logged and an empty id is
+// returned, or an error when the cache is required.
+func (r *runner) cachedBaseImage(ctx context.Context, cli imageInspector, imageHash, inputsKey digest.Digest, inputs []string) (string, error) {
+	ic := r.cache.ImageCache
+	id, err := ic.GetImage(imageHash)
+	if err == nil {
+		logrus.Debugf("Found image in cache for %s: %s", imageHash, id)
+		info, _, err := cli.ImageInspectWithRaw(ctx, id, false)
+		if err == nil {
+			logrus.Debugf("Cached image found locally %s", info.ID)
+			if err := ic.SaveInputs(inputsKey, inputs); err != nil {
+				logrus.Errorf("Unable to save build inputs: %v", err)
+			}
+			return id, nil
+		}
+		logrus.Errorf("Unable to find cached image %s: %v", id, err)
+	} else {
+		logrus.Debugf("Building image, could not find in cache: %v", err)
+	}
+	logInputChanges(ic, inputsKey, inputs)
+
+	if r.config.RequireCache {
+		return "", fmt.Errorf("base image with cache key %s is not cached, cache is required", imageHash)
+	}
+	return "", nil
+}
+
 // BuildBaseImage builds a base image using the given configuration
 // and returns an image id for the given image
 func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfiguration) (string, error) {
-	ctx := context.Background()
+	r := &runner{
+		cache:     c,
+		manifests: newRegistryManifestInspector(),
+	}
+	return r.buildBaseImage(context.Background(), cli, conf)
+}
+
+func (r *runner) buildBaseImage(ctx context.Context, cli DockerClient, conf BaseImageConfiguration) (string, error) {
+	c := r.cache
 	tags := []tag{}
 	images := []string{}
 	envs := []string{}
 
-	baseImageID, err := ensureImage(cli, conf.Base.String())
+	platform, err := r.buildPlatform(ctx, cli, conf.Platform)
+	if err != nil {
+		return "", err
+	}
+	base := conf.selectBase(platform)
+	if err := checkBaseManifest(ctx, cli, r.manifests, base, platform); err != nil {
+		return "", err
+	}
+	// The base image is checked against the daemon platform
+	// when no platform is configured, catching base images
+	// built for a different architecture before running.
+	baseImageID, err := r.ensureImage(ctx, cli, base.String(), platform)
 	if err != nil {
 		return "", err
 	}
 
+	// Pinned references of all images when pinning digests
+	from := baseImageID
+	var pinned []string
+	if r.config.PinDigests {
+		from = pinnedReference(ctx, cli, base.Name(), baseImageID)
+	}
+
 	for _, ref := range conf.ExtraImages {
-		id, err := ensureImage(cli, ref.String())
+		id, err := r.ensureImage(ctx, cli, ref.String(), conf.Platform)
 		if err != nil {
 			return "", err
 		}
@@ -604,9 +1788,12 @@ func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfig
 			Image: id,
 		})
 		images = append(images, id)
+		if r.config.PinDigests {
+			pinned = append(pinned, fmt.Sprintf("%s %s", ref, pinnedReference(ctx, cli, ref.Name(), id)))
+		}
 	}
 	for _, ci := range conf.CustomImages {
-		id, err := ensureImage(cli, ci.Source)
+		id, err := r.ensureImage(ctx, cli, ci.Source, conf.Platform)
 		if err != nil {
 			return "", err
 		}
@@ -614,61 +1801,46 @@ func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfig
 			Tag:   ci.Target,
 			Image: id,
 		})
+		if r.config.PinDigests {
+			pinned = append(pinned, fmt.Sprintf("%s %s", ci.Target, pinnedReference(ctx, cli, ci.Source, id)))
+		}
 
 		envs = append(envs, fmt.Sprintf("%s_VERSION %s", nameToEnv(ci.Target.Name()), ci.Version))
 
-		images = append(images, id)
-	}
-
-	dgstr := digest.Canonical.New()
-	// Add runner options
-	fmt.Fprintf(dgstr.Hash(), "Version: %s\n\n", hashVersion)
-
-	fmt.Fprintf(dgstr.Hash(), "%s\n\n", baseImageID)
+		customEnv, err := renderCustomImageEnv(ci)
+		if err != nil {
+			return "", err
+		}
+		envs = append(envs, customEnv...)
 
-	imageTags := map[string]string{}
-	allTags := []string{}
-	for _, t := range tags {
-		imageTags[t.Tag.String()] = t.Image
-		allTags = append(allTags, t.Tag.String())
-	}
-	sort.Strings(allTags)
-	for _, t := range allTags {
-		fmt.Fprintf(dgstr.Hash(), "%s %s\n", t, imageTags[t])
+		images = append(images, id)
 	}
 
-	fmt.Fprintln(dgstr.Hash())
-
-	// Version environment variable
 	sort.Strings(envs)
-
-	fmt.Fprintln(dgstr.Hash())
-	fmt.Fprintln(dgstr.Hash(), strings.Join(envs, " "))
-
-	imageHash := dgstr.Digest()
+	inputs := baseImageInputs(baseImageID, tags, envs, conf.Platform, r.config.PinDigests)
+	imageHash := baseImageDigest(inputs)
+	inputsKey := baseImageInputsKey(conf)
 
 	// TODO: Use step by step image cache instead of single image cache
-	id, err := c.ImageCache.GetImage(imageHash)
-	if err == nil {
-		logrus.Debugf("Found image in cache for %s: %s", imageHash, id)
-		info, _, err := cli.ImageInspectWithRaw(ctx, id, false)
-		if err == nil {
-			logrus.Debugf("Cached image found locally %s", info.ID)
-			return id, nil
-		}
-		logrus.Errorf("Unable to find cached image %s: %v", id, err)
-	} else {
-		logrus.Debugf("Building image, could not find in cache: %v", err)
+	id, err := r.cachedBaseImage(ctx, cli, imageHash, inputsKey, inputs)
+	if err != nil {
+		return "", err
+	}
+	if id != "" {
+		return id, nil
 	}
 
 	buildStart := time.Now()
 
-	// Create temp build directory
-	td, err := ioutil.TempDir("", "golem-")
+	// Create temp build directory, saved image tars may be large
+	// so the directory is registered for removal on interrupt.
+	// The builder reads the build context from disk, so all
+	// images are saved to it before the build starts.
+	td, err := r.config.TempDirs.Create("golem-")
 	if err != nil {
 		return "", fmt.Errorf("unable to create tempdir: %s", err)
 	}
-	defer os.RemoveAll(td)
+	defer r.config.TempDirs.Remove(td)
 
 	// Create Dockerfile in tempDir
 	df, err := os.OpenFile(filepath.Join(td, "Dockerfile"), os.O_CREATE|os.O_WRONLY, 0644)
@@ -677,7 +1849,7 @@ func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfig
 	}
 	defer df.Close()
 
-	fmt.Fprintf(df, "FROM %s\n", baseImageID)
+	writeFrom(df, from, pinned)
 
 	imagesDir := filepath.Join(td, "images")
 	if err := os.Mkdir(imagesDir, 0755); err != nil {
@@ -687,10 +1859,20 @@ func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfig
 	saveStart := time.Now()
 	logrus.Debugf("Saving %d images", len(images))
 	for _, img := range images {
-		if err := saveImage(cli, filepath.Join(imagesDir, img+".tar"), img); err != nil {
+		imageStart := time.Now()
+		if err := exportImage(cli, imagesDir, img, r.config.ImageFormat); err != nil {
 			return "", fmt.Errorf("error saving image %s: %v", img, err)
 		}
-
+		var size int64
+		if fi, err := os.Stat(filepath.Join(imagesDir, r.config.ImageFormat.imageFile(img))); err == nil {
+			size = fi.Size()
+		}
+		r.logEvent(Event{
+			Type:    EventImageSaved,
+			Image:   img,
+			Elapsed: time.Since(imageStart),
+			Bytes:   size,
+		})
 	}
 	logFields := logrus.Fields{
 		timerKey: time.Since(saveStart),
@@ -708,14 +1890,19 @@ func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfig
 		fmt.Fprintf(df, "ENV %s\n", e)
 	}
 
+	// Record the pinned Dockerfile in the image
+	if r.config.PinDigests {
+		fmt.Fprintln(df, "COPY ./Dockerfile /golem/Dockerfile")
+	}
+
 	// Call build
-	builder, err := cli.NewBuilder(td, "", "")
+	builder, err := cli.NewBuilder(td, "", "", r.buildOutput())
 	if err != nil {
 		logrus.Errorf("Error creating builder: %v", err)
 		return "", err
 	}
 
-	if err := builder.Run(); err != nil {
+	if err := buildImage(ctx, builder, r.config.BuildTimeout); err != nil {
 		logrus.Errorf("Error building: %v", err)
 		return "", err
 	}
@@ -724,10 +1911,18 @@ func BuildBaseImage(cli DockerClient, conf BaseImageConfiguration, c CacheConfig
 
 	// Update index
 	imageID := builder.ImageID()
+	r.logEvent(Event{
+		Type:    EventBaseImageBuilt,
+		Image:   imageID,
+		Elapsed: time.Since(buildStart),
+	})
 
 	if err := c.ImageCache.SaveImage(imageHash, imageID); err != nil {
 		logrus.Errorf("Unable to save image by hash %s: %s", imageHash, imageID)
 	}
+	if err := c.ImageCache.SaveInputs(inputsKey, inputs); err != nil {
+		logrus.Errorf("Unable to save build inputs: %v", err)
+	}
 
 	return imageID, nil
 }
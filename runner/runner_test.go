@@ -0,0 +1,872 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/client"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/container"
+	"github.com/docker/golem/retryutil"
+	"github.com/docker/golem/versionutil"
+	"github.com/jlhawn/dockramp/build"
+)
+
+type removeCall struct {
+	method  string
+	id      string
+	timeout int
+}
+
+type fakeContainerRemover struct {
+	calls []removeCall
+}
+
+func (f *fakeContainerRemover) ContainerStop(ctx context.Context, containerID string, timeout int) error {
+	f.calls = append(f.calls, removeCall{method: "stop", id: containerID, timeout: timeout})
+	return nil
+}
+
+func (f *fakeContainerRemover) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	f.calls = append(f.calls, removeCall{method: "remove", id: containerID})
+	return nil
+}
+
+func TestRemoveContainerStopTimeout(t *testing.T) {
+	cases := []struct {
+		timeout  time.Duration
+		expected []removeCall
+	}{
+		{
+			timeout: 0,
+			expected: []removeCall{
+				{method: "remove", id: "c1"},
+			},
+		},
+		{
+			timeout: 10 * time.Second,
+			expected: []removeCall{
+				{method: "stop", id: "c1", timeout: 10},
+				{method: "remove", id: "c1"},
+			},
+		},
+		{
+			timeout: 1500 * time.Millisecond,
+			expected: []removeCall{
+				{method: "stop", id: "c1", timeout: 2},
+				{method: "remove", id: "c1"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		f := &fakeContainerRemover{}
+		if err := removeContainer(context.Background(), f, "c1", c.timeout, types.ContainerRemoveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if len(f.calls) != len(c.expected) {
+			t.Fatalf("Unexpected calls for timeout %s: %v, expected %v", c.timeout, f.calls, c.expected)
+		}
+		for i := range c.expected {
+			if f.calls[i] != c.expected[i] {
+				t.Fatalf("Unexpected call %d for timeout %s: %v, expected %v", i, c.timeout, f.calls[i], c.expected[i])
+			}
+		}
+	}
+}
+
+// blockingReader blocks reads until the context is done
+type blockingReader struct {
+	ctx context.Context
+}
+
+func (br blockingReader) Read(b []byte) (int, error) {
+	<-br.ctx.Done()
+	return 0, errors.New("connection closed")
+}
+
+type blockingPuller struct{}
+
+func (blockingPuller) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(blockingReader{ctx: ctx}), nil
+}
+
+type completePuller struct{}
+
+func (completePuller) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(`{"status":"Pull complete"}`)), nil
+}
+
+func TestPullTimeout(t *testing.T) {
+	errC := make(chan error, 1)
+	go func() {
+		errC <- pullImage(context.Background(), blockingPuller{}, "busybox:latest", 10*time.Millisecond, ioutil.Discard, PullNormal)
+	}()
+
+	select {
+	case err := <-errC:
+		if err == nil {
+			t.Fatal("Expected pull timeout error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pull not cancelled after timeout")
+	}
+
+	if err := pullImage(context.Background(), completePuller{}, "busybox:latest", time.Second, ioutil.Discard, PullNormal); err != nil {
+		t.Fatalf("Unexpected error pulling: %v", err)
+	}
+}
+
+func TestRetryablePullError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{errors.New("Error: image library/golem-missing:latest not found"), false},
+		{errors.New("manifest unknown: manifest unknown"), false},
+		{errors.New("unauthorized: authentication required"), false},
+		{errors.New("Error response from daemon: Get https://registry-1.docker.io/v2/: net/http: TLS handshake timeout"), true},
+		{errors.New("error copying pull output: unexpected EOF"), true},
+		{errors.New("pull cancelled: context deadline exceeded"), true},
+	} {
+		if retryable := retryablePullError(tc.err); retryable != tc.retryable {
+			t.Fatalf("Unexpected retryable %t for %q, expected %t", retryable, tc.err, tc.retryable)
+		}
+	}
+
+	policy := pullPolicy
+	policy.InitialBackoff = time.Millisecond
+	var attempts int
+	retryutil.Do(context.Background(), policy, func(context.Context) error {
+		attempts++
+		return errors.New("Error: image library/golem-missing:latest not found")
+	})
+	if attempts != 1 {
+		t.Fatalf("Unexpected attempts %d for missing image, expected 1", attempts)
+	}
+}
+
+type blockingBuilder struct {
+	release  chan struct{}
+	detached chan struct{}
+}
+
+func (b blockingBuilder) Run() error {
+	<-b.release
+	return errors.New("build interrupted")
+}
+
+func (blockingBuilder) ImageID() string {
+	return ""
+}
+
+func (b blockingBuilder) Detach() {
+	close(b.detached)
+}
+
+type completeBuilder struct{}
+
+func (completeBuilder) Run() error {
+	return nil
+}
+
+func (completeBuilder) ImageID() string {
+	return "sha256:abc"
+}
+
+func (completeBuilder) Detach() {}
+
+func TestBuildTimeout(t *testing.T) {
+	tempDirs := NewTempDirs("")
+	td, err := tempDirs.Create("golem-build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := blockingBuilder{release: make(chan struct{}), detached: make(chan struct{})}
+	defer close(builder.release)
+
+	errC := make(chan error, 1)
+	go func() {
+		defer tempDirs.Remove(td)
+		errC <- buildImage(context.Background(), builder, 10*time.Millisecond)
+	}()
+
+	select {
+	case err := <-errC:
+		if err == nil || !strings.Contains(err.Error(), "build cancelled") {
+			t.Fatalf("Expected build timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Build not aborted after timeout")
+	}
+	select {
+	case <-builder.detached:
+	default:
+		t.Fatal("Expected aborted build to be detached from its output")
+	}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(td); os.IsNotExist(err) {
+			break
+		} else if i == 100 {
+			t.Fatalf("Build context %s not removed after timeout", td)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := buildImage(context.Background(), completeBuilder{}, time.Second); err != nil {
+		t.Fatalf("Unexpected error building: %v", err)
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	config := RunnerConfiguration{
+		Suites: []SuiteConfiguration{
+			{
+				Name: "suite",
+				Instances: []InstanceConfiguration{
+					{Name: "suite"},
+				},
+			},
+		},
+	}
+	r := NewRunner(config, CacheConfiguration{}, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	// The client is not set and must not be used after the deadline
+	if err := r.Build(ctx, DockerClient{}); err == nil {
+		t.Fatal("Expected build to be aborted")
+	}
+	if err := r.Run(ctx, DockerClient{}); err == nil {
+		t.Fatal("Expected run to be aborted")
+	}
+}
+
+func imageTar(t *testing.T, files map[string]string) *bytes.Buffer {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for name, content := range files {
+		hdr := &tar.Header{
+			Name: name,
+			Mode: 0644,
+			Size: int64(len(content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestVerifySavedImage(t *testing.T) {
+	imgID := "sha256:2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749"
+	manifest := `[{"Config":"2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749.json","RepoTags":["busybox:latest"],"Layers":["abc/layer.tar"]}]`
+
+	good := imageTar(t, map[string]string{
+		"2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749.json": "{}",
+		"manifest.json": manifest,
+	})
+	if err := verifySavedImage(good, imgID); err != nil {
+		t.Fatalf("Unexpected error verifying good tar: %v", err)
+	}
+
+	mismatched := imageTar(t, map[string]string{
+		"manifest.json": `[{"Config":"47bcc53f74dc94b1920f0b34f6036096526296767650f223433fe65c35f149eb.json","RepoTags":null}]`,
+	})
+	if err := verifySavedImage(mismatched, imgID); err == nil {
+		t.Fatal("Expected error for mismatched image")
+	}
+
+	partial := imageTar(t, map[string]string{"abc/layer.tar": ""})
+	if err := verifySavedImage(partial, imgID); err == nil {
+		t.Fatal("Expected error for tar without manifest")
+	}
+
+	legacy := imageTar(t, map[string]string{
+		"2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749/json": "{}",
+		"2b8fd9751c4c0f5dd266fcae00707e67a2545ef34f9a29354585f93dac906749/":     "",
+	})
+	if err := verifySavedImage(legacy, imgID); err != nil {
+		t.Fatalf("Unexpected error verifying legacy tar: %v", err)
+	}
+}
+
+func TestGetGraphDriver(t *testing.T) {
+	defer os.Setenv("DOCKER_GRAPHDRIVER", os.Getenv("DOCKER_GRAPHDRIVER"))
+
+	os.Setenv("DOCKER_GRAPHDRIVER", "")
+	if d := getGraphDriver(""); d != "overlay" {
+		t.Fatalf("Unexpected default driver %q", d)
+	}
+	if d := getGraphDriver("vfs"); d != "vfs" {
+		t.Fatalf("Unexpected override driver %q", d)
+	}
+
+	os.Setenv("DOCKER_GRAPHDRIVER", "aufs")
+	if d := getGraphDriver(""); d != "aufs" {
+		t.Fatalf("Unexpected environment driver %q", d)
+	}
+	if d := getGraphDriver("overlay2"); d != "overlay2" {
+		t.Fatalf("Unexpected override driver %q with environment set", d)
+	}
+
+	args := daemonArgs(DaemonConfiguration{StorageDriver: "vfs"}, versionutil.StaticVersion(1, 10, 3))
+	if args[len(args)-1] != "--storage-driver=vfs" {
+		t.Fatalf("Unexpected daemon args %v", args)
+	}
+}
+
+type fakeImageInspector map[string]types.ImageInspect
+
+func (f fakeImageInspector) ImageInspectWithRaw(ctx context.Context, imageID string, getSize bool) (types.ImageInspect, []byte, error) {
+	info, ok := f[imageID]
+	if !ok {
+		return types.ImageInspect{}, nil, errors.New("image not found")
+	}
+	return info, nil, nil
+}
+
+func TestPinnedDockerfile(t *testing.T) {
+	baseDigest := "golang@sha256:ab51fdc9e1d24b8b0a4b3bfcca9bad9e5e8b1bdcf8c2ae21ac2f0b4d8e70d8f4"
+	registryDigest := "registry@sha256:0a0fd8e0d10ad3bbc2f1c5c5e1f6a83d78e5a7bd1fd3b3f6d5f2a5d4e0f38b25"
+	f := fakeImageInspector{
+		"sha256:base": {
+			ID:          "sha256:base",
+			RepoDigests: []string{"myregistry.com/golang@sha256:0000000000000000000000000000000000000000000000000000000000000000", baseDigest},
+		},
+		"sha256:registry": {
+			ID:          "sha256:registry",
+			RepoDigests: []string{registryDigest},
+		},
+		"sha256:local": {
+			ID: "sha256:local",
+		},
+	}
+
+	ctx := context.Background()
+	from := pinnedReference(ctx, f, "golang", "sha256:base")
+	if from != baseDigest {
+		t.Fatalf("Unexpected pinned base %s, expected %s", from, baseDigest)
+	}
+	pinned := []string{
+		"golem-registry:latest " + pinnedReference(ctx, f, "registry:2.2.1", "sha256:registry"),
+		"golem-local:latest " + pinnedReference(ctx, f, "local:latest", "sha256:local"),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeFrom(buf, from, pinned)
+	expected := "FROM " + baseDigest + "\n" +
+		"# image golem-registry:latest " + registryDigest + "\n" +
+		"# image golem-local:latest sha256:local\n"
+	if buf.String() != expected {
+		t.Fatalf("Unexpected Dockerfile:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	tags := []tag{{Tag: assertTagged("registry:2.2.1"), Image: "sha256:registry"}}
+	if baseImageDigest(baseImageInputs("sha256:base", tags, nil, Platform{}, false)) == baseImageDigest(baseImageInputs("sha256:base", tags, nil, Platform{}, true)) {
+		t.Fatal("Expected pinned digests to change the cache key")
+	}
+}
+
+type fakeServerVersioner string
+
+func (v fakeServerVersioner) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{Version: string(v)}, nil
+}
+
+type fakeVersionNegotiator struct {
+	apiVersion string
+	calls      int
+	version    string
+}
+
+func (n *fakeVersionNegotiator) ServerVersion(ctx context.Context) (types.Version, error) {
+	n.calls++
+	return types.Version{Version: "1.12.1", APIVersion: n.apiVersion}, nil
+}
+
+func (n *fakeVersionNegotiator) UpdateClientVersion(v string) {
+	n.version = v
+}
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	n := &fakeVersionNegotiator{apiVersion: "1.24"}
+	if err := negotiateAPIVersion(context.Background(), n); err != nil {
+		t.Fatalf("Unexpected error negotiating: %v", err)
+	}
+	if n.calls != 1 {
+		t.Fatalf("Expected server version to be requested once, got %d", n.calls)
+	}
+	if n.version != "1.24" {
+		t.Fatalf("Expected client version 1.24, got %q", n.version)
+	}
+
+	// Newer servers are used at the latest version of the client
+	n = &fakeVersionNegotiator{apiVersion: "1.30"}
+	if err := negotiateAPIVersion(context.Background(), n); err != nil {
+		t.Fatalf("Unexpected error negotiating: %v", err)
+	}
+	if n.version != maxAPIVersion {
+		t.Fatalf("Expected client version %s, got %q", maxAPIVersion, n.version)
+	}
+
+	n = &fakeVersionNegotiator{apiVersion: "1.22"}
+	if err := negotiateAPIVersion(context.Background(), n); err != nil {
+		t.Fatalf("Unexpected error negotiating: %v", err)
+	}
+	if n.version != "1.22" {
+		t.Fatalf("Expected client version 1.22, got %q", n.version)
+	}
+
+	n = &fakeVersionNegotiator{}
+	if err := negotiateAPIVersion(context.Background(), n); err == nil {
+		t.Fatal("Expected error when server reports no API version")
+	}
+	if n.version != "" {
+		t.Fatalf("Expected client version to be unchanged, got %q", n.version)
+	}
+}
+
+func TestNewAPIClientNegotiatesVersion(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"Version":"1.12.1","ApiVersion":"1.24"}`)
+	}))
+	defer server.Close()
+
+	host := "tcp://" + strings.TrimPrefix(server.URL, "http://")
+	cli, err := newAPIClient(host, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != "/version" {
+		t.Fatalf("Expected a single unversioned version request, got %v", paths)
+	}
+	if v := cli.ClientVersion(); v != "1.24" {
+		t.Fatalf("Expected negotiated version 1.24, got %q", v)
+	}
+
+	paths = nil
+	cli, err = newAPIClient(host, "1.22", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 0 {
+		t.Fatalf("Expected no negotiation with explicit version, got requests %v", paths)
+	}
+	if v := cli.ClientVersion(); v != "1.22" {
+		t.Fatalf("Expected explicit version 1.22, got %q", v)
+	}
+}
+
+func TestCheckServerVersion(t *testing.T) {
+	constraint, err := versionutil.ParseVersionConstraint(">=1.10.0,<1.13.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		version   string
+		expectErr bool
+	}{
+		{"1.9.1", true},
+		{"1.10.0", false},
+		{"1.12.1", false},
+		{"1.13.0", true},
+	}
+	for _, c := range cases {
+		err := checkServerVersion(context.Background(), fakeServerVersioner(c.version), constraint)
+		if c.expectErr {
+			if err == nil {
+				t.Fatalf("Expected error for server version %s", c.version)
+			}
+			if !strings.Contains(err.Error(), c.version) {
+				t.Fatalf("Expected error to name version %s: %v", c.version, err)
+			}
+		} else if err != nil {
+			t.Fatalf("Unexpected error for server version %s: %v", c.version, err)
+		}
+	}
+}
+
+func TestWriteInstanceConfig(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-instances-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	rc := RunConfiguration{
+		Setup: []Script{{Command: []string{"/bin/sh", "-c", "docker pull busybox"}, Env: []string{"SETUP=1"}}},
+		TestRunner: []TestScript{
+			{Script: Script{Command: []string{"bats", "-t", "."}, Env: []string{}}, Format: "tap"},
+		},
+		RunAll:      true,
+		InstanceEnv: []string{"GOLEM_INSTANCE_NAME=registry-2", "GOLEM_INSTANCE_INDEX=2"},
+	}
+
+	path := filepath.Join(td, "instances", "registry-2.json")
+	if err := writeInstanceConfig(path, rc); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected instance json to be saved: %v", err)
+	}
+	defer f.Close()
+	var saved RunConfiguration
+	if err := json.NewDecoder(f).Decode(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, rc) {
+		t.Fatalf("Unexpected saved configuration\n\tExpected: %#v\n\tActual: %#v", rc, saved)
+	}
+}
+
+type outputBuilder struct {
+	out io.Writer
+}
+
+func (b outputBuilder) Run() error {
+	fmt.Fprintln(b.out, "Step 1: FROM busybox:latest")
+	fmt.Fprintln(b.out, "Successfully built sha256:abc")
+	return nil
+}
+
+func (outputBuilder) ImageID() string {
+	return "sha256:abc"
+}
+
+func (outputBuilder) Detach() {}
+
+func TestBuildOutputCapture(t *testing.T) {
+	capturer := newBufferLogger()
+	r := &runner{config: RunnerConfiguration{BuildCapturer: capturer}}
+	if err := buildImage(context.Background(), outputBuilder{out: r.buildOutput()}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Step 1: FROM busybox:latest\nSuccessfully built sha256:abc\n"
+	if out := capturer.stdout.String(); out != expected {
+		t.Fatalf("Unexpected build output %q, expected %q", out, expected)
+	}
+}
+
+func TestBuilderOutput(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	writeTempFile(t, td, "Dockerfile", "FROM scratch\nLABEL team distribution\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}))
+	defer server.Close()
+
+	stdout := os.Stdout
+	capturer := newBufferLogger()
+	r := &runner{config: RunnerConfiguration{BuildCapturer: capturer}}
+	builder, err := newOutputBuilder(r.buildOutput(), func() (*build.Builder, error) {
+		return build.NewBuilder(server.URL, nil, td, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Stdout != stdout {
+		t.Fatal("Expected os.Stdout restored after creating builder")
+	}
+	if err := buildImage(context.Background(), builder, time.Second); err == nil {
+		t.Fatal("Expected build error creating container")
+	}
+	expected := "Step 0: FROM scratch\nStep 1: LABEL team distribution\n"
+	if out := capturer.stdout.String(); out != expected {
+		t.Fatalf("Unexpected build output %q, expected %q", out, expected)
+	}
+}
+
+func TestBuilderDetach(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	writeTempFile(t, td, "Dockerfile", "FROM scratch\n")
+
+	capturer := newBufferLogger()
+	builder, err := newOutputBuilder(capturer.Stdout(), func() (*build.Builder, error) {
+		return build.NewBuilder("http://127.0.0.1:0", nil, td, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Progress of an abandoned build written after detaching is discarded
+	fmt.Fprintln(builder.w, "Step 0: FROM scratch")
+	builder.Detach()
+	fmt.Fprintln(builder.w, "Step 1: abandoned")
+	builder.w.Close()
+	<-builder.done
+
+	if out := capturer.stdout.String(); strings.Contains(out, "abandoned") {
+		t.Fatalf("Unexpected output after detaching %q", out)
+	}
+}
+
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestInstanceArgsMaxTaps(t *testing.T) {
+	suite := SuiteConfiguration{Name: "registry"}
+	instance := InstanceConfiguration{Name: "registry"}
+	for _, tc := range []struct {
+		maxTaps  int
+		expected string
+	}{
+		{0, ""},
+		{DefaultMaxTaps, ""},
+		{8, "-max-taps=8"},
+		{-1, "-max-taps=0"},
+	} {
+		r := &runner{config: RunnerConfiguration{MaxTaps: tc.maxTaps}}
+		args := r.instanceArgs(suite, instance)
+		var actual string
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "-max-taps") {
+				actual = arg
+			}
+		}
+		if actual != tc.expected {
+			t.Fatalf("Unexpected max taps argument %q for %d, expected %q", actual, tc.maxTaps, tc.expected)
+		}
+	}
+}
+
+func TestInstanceArgsCommand(t *testing.T) {
+	suite := SuiteConfiguration{Name: "registry"}
+	instance := InstanceConfiguration{Name: "registry"}
+
+	r := &runner{config: RunnerConfiguration{DefaultCommand: DefaultTestCommand}}
+	for _, arg := range r.instanceArgs(suite, instance) {
+		if strings.HasPrefix(arg, "-command") {
+			t.Fatalf("Unexpected command argument %q for the default command", arg)
+		}
+	}
+
+	r.config.DefaultCommand = "bats -t ."
+	if args := r.instanceArgs(suite, instance); !containsArg(args, "-command=bats -t .") {
+		t.Fatalf("Expected -command argument in %v", args)
+	}
+
+	r.config.DefaultCommand = ""
+	if args := r.instanceArgs(suite, instance); !containsArg(args, "-command=") {
+		t.Fatalf("Expected empty -command argument in %v", args)
+	}
+}
+
+func TestInstanceArgsConsole(t *testing.T) {
+	suite := SuiteConfiguration{Name: "registry"}
+	instance := InstanceConfiguration{Name: "registry"}
+
+	r := &runner{}
+	if args := r.instanceArgs(suite, instance); containsArg(args, "-console=false") {
+		t.Fatalf("Unexpected console argument in %v", args)
+	}
+
+	r.config.DisableConsole = true
+	if args := r.instanceArgs(suite, instance); !containsArg(args, "-console=false") {
+		t.Fatalf("Expected -console=false in %v", args)
+	}
+}
+
+func TestInstanceArgsDaemon(t *testing.T) {
+	suite := SuiteConfiguration{Name: "registry", DockerInDocker: true}
+	instance := InstanceConfiguration{Name: "registry"}
+
+	r := &runner{}
+	for _, arg := range r.instanceArgs(suite, instance) {
+		if strings.HasPrefix(arg, "-docker-binary") || strings.HasPrefix(arg, "-daemon-pidfile") {
+			t.Fatalf("Unexpected daemon argument %q", arg)
+		}
+	}
+
+	r.config.DockerBinary = "/usr/local/bin/dockerd"
+	r.config.DaemonPidFile = "/run/golem/docker.pid"
+	args := r.instanceArgs(suite, instance)
+	if !containsArg(args, "-docker-binary=/usr/local/bin/dockerd") {
+		t.Fatalf("Expected -docker-binary in %v", args)
+	}
+	if !containsArg(args, "-daemon-pidfile=/run/golem/docker.pid") {
+		t.Fatalf("Expected -daemon-pidfile in %v", args)
+	}
+
+	suite.DockerInDocker = false
+	args = r.instanceArgs(suite, instance)
+	if containsArg(args, "-docker-binary=/usr/local/bin/dockerd") || containsArg(args, "-daemon-pidfile=/run/golem/docker.pid") {
+		t.Fatalf("Unexpected daemon arguments without dind in %v", args)
+	}
+}
+
+func TestInstanceArgsDaemonWarnings(t *testing.T) {
+	suite := SuiteConfiguration{Name: "registry", DockerInDocker: true}
+	instance := InstanceConfiguration{Name: "registry"}
+
+	r := &runner{}
+	args := r.instanceArgs(suite, instance)
+	if containsArg(args, "-daemon-warnings") || containsArg(args, "-daemon-warnings-fatal") {
+		t.Fatalf("Unexpected daemon warning args %v", args)
+	}
+
+	r.config.DaemonWarnings = true
+	args = r.instanceArgs(suite, instance)
+	if !containsArg(args, "-daemon-warnings") || containsArg(args, "-daemon-warnings-fatal") {
+		t.Fatalf("Expected -daemon-warnings only in %v", args)
+	}
+
+	r.config.DaemonWarningsFatal = true
+	args = r.instanceArgs(suite, instance)
+	if !containsArg(args, "-daemon-warnings") || !containsArg(args, "-daemon-warnings-fatal") {
+		t.Fatalf("Expected -daemon-warnings and -daemon-warnings-fatal in %v", args)
+	}
+	if args[len(args)-1] != "-instance=registry" {
+		t.Fatalf("Expected instance as last argument in %v", args)
+	}
+}
+
+// runDaemon is a fake docker server which runs instance containers,
+// each exiting with the exit code configured for its container name.
+type runDaemon struct {
+	t         *testing.T
+	l         sync.Mutex
+	images    map[string]types.ImageInspect
+	exitCodes map[string]int
+	names     map[string]string
+	created   []string
+}
+
+func newRunDaemon(t *testing.T) *runDaemon {
+	return &runDaemon{
+		t:         t,
+		images:    map[string]types.ImageInspect{},
+		exitCodes: map[string]int{},
+		names:     map[string]string{},
+	}
+}
+
+// addImage adds an instance image built from the run configuration.
+func (d *runDaemon) addImage(name, id string, rc RunConfiguration) {
+	hash, err := instanceConfigHash(rc)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	d.images[name] = types.ImageInspect{
+		ID: id,
+		Config: &container.Config{
+			Labels: map[string]string{configHashLabel: hash},
+		},
+	}
+}
+
+// createdContainers returns the names of the created containers in order.
+func (d *runDaemon) createdContainers() []string {
+	d.l.Lock()
+	defer d.l.Unlock()
+	return append([]string(nil), d.created...)
+}
+
+func (d *runDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.l.Lock()
+	defer d.l.Unlock()
+
+	p := r.URL.Path
+	switch {
+	case r.Method == "GET" && strings.HasPrefix(p, "/images/") && strings.HasSuffix(p, "/json"):
+		info, ok := d.images[strings.TrimSuffix(strings.TrimPrefix(p, "/images/"), "/json")]
+		if !ok {
+			http.Error(w, "no such image", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	case r.Method == "POST" && p == "/containers/create":
+		name := r.URL.Query().Get("name")
+		id := fmt.Sprintf("%064d", len(d.created)+1)
+		d.names[id] = name
+		d.created = append(d.created, name)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(types.ContainerCreateResponse{ID: id})
+	case r.Method == "GET" && strings.HasPrefix(p, "/containers/") && strings.HasSuffix(p, "/json"):
+		id := strings.TrimSuffix(strings.TrimPrefix(p, "/containers/"), "/json")
+		name, ok := d.names[id]
+		if !ok {
+			http.Error(w, "no such container", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    id,
+				State: &types.ContainerState{ExitCode: d.exitCodes[name]},
+			},
+		})
+	case r.Method == "POST" && strings.HasSuffix(p, "/start"):
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" && strings.HasSuffix(p, "/attach"):
+		// Attach hijacks the connection, close it for no output
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			d.t.Errorf("Error hijacking attach: %v", err)
+			return
+		}
+		fmt.Fprint(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		conn.Close()
+	case r.Method == "DELETE" && strings.HasPrefix(p, "/containers/"):
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// client starts the fake server and returns a client for it
+// with a function to stop the server.
+func (d *runDaemon) client() (DockerClient, func()) {
+	server := httptest.NewServer(d)
+	apiClient, err := client.NewClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), "", nil, nil)
+	if err != nil {
+		server.Close()
+		d.t.Fatal(err)
+	}
+	return DockerClient{Client: apiClient}, server.Close
+}
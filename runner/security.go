@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/engine-api/types/container"
+)
+
+// capabilityPattern matches a kernel capability name, with
+// or without the CAP_ prefix.
+var capabilityPattern = regexp.MustCompile(`^(?i)(CAP_)?[A-Z]+(_[A-Z]+)*$`)
+
+// securityOptKeys are the security options supported by the
+// daemon, each set as key=value except no-new-privileges.
+var securityOptKeys = map[string]struct{}{
+	"apparmor":          {},
+	"label":             {},
+	"no-new-privileges": {},
+	"seccomp":           {},
+}
+
+// validateCapabilities returns an error if any of the capabilities
+// is not a capability name or ALL.
+func validateCapabilities(caps []string) error {
+	for _, c := range caps {
+		if !capabilityPattern.MatchString(c) {
+			return fmt.Errorf("invalid capability %q, must be a capability name such as NET_ADMIN or ALL", c)
+		}
+	}
+	return nil
+}
+
+// validateSecurityOpts returns an error if any of the security
+// options is not a supported key=value option.
+func validateSecurityOpts(opts []string) error {
+	for _, opt := range opts {
+		key := opt
+		var value string
+		if idx := strings.IndexAny(opt, "=:"); idx >= 0 {
+			key, value = opt[:idx], opt[idx+1:]
+		}
+		if _, ok := securityOptKeys[key]; !ok {
+			return fmt.Errorf("invalid security option %q, must be one of apparmor, label, no-new-privileges or seccomp", opt)
+		}
+		if value == "" && key != "no-new-privileges" {
+			return fmt.Errorf("invalid security option %q, %s requires a value", opt, key)
+		}
+	}
+	return nil
+}
+
+// instanceHostConfig returns the host configuration of the
+// instance containers of a suite.
+func instanceHostConfig(suite SuiteConfiguration) *container.HostConfig {
+	return &container.HostConfig{
+		Privileged:   !suite.Unprivileged,
+		CapAdd:       suite.CapAdd,
+		CapDrop:      suite.CapDrop,
+		SecurityOpt:  suite.SecurityOpt,
+		VolumeDriver: "local",
+	}
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSecurity(t *testing.T) {
+	if err := validateCapabilities([]string{"NET_ADMIN", "cap_sys_ptrace", "ALL"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, c := range []string{"", "NET-ADMIN", "CAP_", "1NET"} {
+		if err := validateCapabilities([]string{c}); err == nil {
+			t.Fatalf("Expected error for capability %q", c)
+		}
+	}
+
+	if err := validateSecurityOpts([]string{"seccomp=unconfined", "apparmor:golem", "label=disable", "no-new-privileges"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, opt := range []string{"seccomp", "seccomp=", "selinux=disable", "unconfined"} {
+		if err := validateSecurityOpts([]string{opt}); err == nil {
+			t.Fatalf("Expected error for security option %q", opt)
+		}
+	}
+}
+
+func TestSuiteSecurityOptions(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-security-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	resolve := func(config string) (SuiteConfiguration, error) {
+		writeTempFile(t, td, "golem.conf", config)
+		suites, err := parseSuites([]string{td})
+		if err != nil {
+			return SuiteConfiguration{}, err
+		}
+		suite := suites["security"]
+		return resolveConfigurationSuite(newMultiResolver(suite, globalDefault), suite, "")
+	}
+
+	// Default is privileged
+	sc, err := resolve("[[suite]]\n  name=\"security\"\n  allow_no_tests=true\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc := instanceHostConfig(sc); !hc.Privileged || len(hc.CapAdd) > 0 {
+		t.Fatalf("Unexpected default host config %#v", hc)
+	}
+
+	sc, err = resolve(`[[suite]]
+  name="security"
+  allow_no_tests=true
+  privileged=false
+  cap_add=["NET_ADMIN"]
+  cap_drop=["MKNOD"]
+  security_opt=["seccomp=unconfined"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := instanceHostConfig(sc)
+	if hc.Privileged {
+		t.Fatal("Expected unprivileged instance container")
+	}
+	if !reflect.DeepEqual([]string(hc.CapAdd), []string{"NET_ADMIN"}) || !reflect.DeepEqual([]string(hc.CapDrop), []string{"MKNOD"}) {
+		t.Fatalf("Unexpected capabilities add %v drop %v", hc.CapAdd, hc.CapDrop)
+	}
+	if !reflect.DeepEqual(hc.SecurityOpt, []string{"seccomp=unconfined"}) {
+		t.Fatalf("Unexpected security options %v", hc.SecurityOpt)
+	}
+
+	_, err = resolve("[[suite]]\n  name=\"security\"\n  allow_no_tests=true\n  dind=true\n  privileged=false\n")
+	if err == nil || !strings.Contains(err.Error(), "requires privileged") {
+		t.Fatalf("Expected error disabling privileged with dind, got %v", err)
+	}
+
+	if _, err := resolve("[[suite]]\n  name=\"security\"\n  allow_no_tests=true\n  cap_add=[\"NET ADMIN\"]\n"); err == nil {
+		t.Fatal("Expected error for invalid capability")
+	}
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/term"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/container"
+)
+
+// containerCommand returns the command of an instance container,
+// the golem executable with the runner arguments unless a shell
+// is configured to replace it.
+func (r *runner) containerCommand(args []string) []string {
+	if r.config.Shell != "" {
+		return []string{r.config.Shell}
+	}
+	return append([]string{r.config.ExecutableName}, args...)
+}
+
+// setShellConfig configures the container for an interactive
+// shell when a shell is configured.
+func (r *runner) setShellConfig(config *container.Config) {
+	if r.config.Shell == "" {
+		return
+	}
+	config.OpenStdin = true
+	config.StdinOnce = true
+	config.AttachStdin = true
+	config.Tty = true
+}
+
+// attachShell connects the console to an attached shell container,
+// returning once the shell output is closed. The console is put in
+// raw mode while attached when it is a terminal.
+func attachShell(resp types.HijackedResponse) error {
+	inFd, isTerminalIn := term.GetFdInfo(os.Stdin)
+	if isTerminalIn {
+		state, err := term.SetRawTerminal(inFd)
+		if err != nil {
+			return err
+		}
+		defer term.RestoreTerminal(inFd, state)
+	}
+
+	go func() {
+		if _, err := io.Copy(resp.Conn, os.Stdin); err != nil {
+			logrus.Debugf("Error copying shell input: %v", err)
+		}
+		resp.CloseWrite()
+	}()
+
+	// A tty container has a single raw output stream
+	_, err := io.Copy(os.Stdout, resp.Reader)
+	return err
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/engine-api/types/container"
+)
+
+func TestContainerCommandShell(t *testing.T) {
+	args := []string{"-docker", "-instance=suite-1"}
+
+	r := &runner{config: RunnerConfiguration{ExecutableName: "/usr/bin/golem_runner"}}
+	if cmd := r.containerCommand(args); !reflect.DeepEqual(cmd, []string{"/usr/bin/golem_runner", "-docker", "-instance=suite-1"}) {
+		t.Fatalf("Unexpected default command %v", cmd)
+	}
+	config := &container.Config{}
+	r.setShellConfig(config)
+	if config.Tty || config.OpenStdin {
+		t.Fatalf("Unexpected interactive config without shell: %#v", config)
+	}
+
+	r.config.Shell = "/bin/sh"
+	if cmd := r.containerCommand(args); !reflect.DeepEqual(cmd, []string{"/bin/sh"}) {
+		t.Fatalf("Unexpected shell command %v", cmd)
+	}
+	r.setShellConfig(config)
+	if !config.Tty || !config.OpenStdin || !config.AttachStdin {
+		t.Fatalf("Expected interactive config with shell: %#v", config)
+	}
+}
@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// snippetParam matches a ${name} parameter in a command
+// snippet, or an escaped $$.
+var snippetParam = regexp.MustCompile(`\$(?:\$|\{([A-Za-z_][A-Za-z0-9_]*)\})`)
+
+// expandSnippet returns the command of the named snippet with
+// each ${name} parameter replaced by its argument. Every
+// parameter must be given an argument and every argument must
+// be used by the snippet.
+func expandSnippet(snippets map[string]string, name string, args map[string]string) (string, error) {
+	snippet, ok := snippets[name]
+	if !ok {
+		return "", fmt.Errorf("undefined command snippet %q", name)
+	}
+	used := map[string]struct{}{}
+	var missing []string
+	command := snippetParam.ReplaceAllStringFunc(snippet, func(m string) string {
+		if m == "$$" {
+			return "$"
+		}
+		param := snippetParam.FindStringSubmatch(m)[1]
+		value, ok := args[param]
+		if !ok {
+			missing = append(missing, param)
+			return m
+		}
+		used[param] = struct{}{}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("command snippet %q requires args: %s", name, strings.Join(missing, ", "))
+	}
+	var unused []string
+	for arg := range args {
+		if _, ok := used[arg]; !ok {
+			unused = append(unused, arg)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return "", fmt.Errorf("command snippet %q does not use args: %s", name, strings.Join(unused, ", "))
+	}
+	return command, nil
+}
+
+// snippetCommand returns the command of an entry, expanded
+// from the snippet it uses if any.
+func snippetCommand(snippets map[string]string, command, use string, args map[string]string) (string, error) {
+	if use == "" {
+		if len(args) > 0 {
+			return "", fmt.Errorf("args set without use for command %q", command)
+		}
+		return command, nil
+	}
+	if command != "" {
+		return "", fmt.Errorf("command %q and use %q must not both be set", command, use)
+	}
+	return expandSnippet(snippets, use, args)
+}
+
+// expandSnippets replaces the commands of entries using a
+// command snippet with the expanded snippet.
+func expandSnippets(conf *suitesConfiguration) error {
+	expandPretest := func(scripts []pretestConfiguration) error {
+		for i, script := range scripts {
+			command, err := snippetCommand(conf.Commands, script.Command, script.Use, script.Args)
+			if err != nil {
+				return err
+			}
+			scripts[i].Command = command
+		}
+		return nil
+	}
+	if err := expandPretest(conf.BeforeAll); err != nil {
+		return err
+	}
+	if err := expandPretest(conf.AfterAll); err != nil {
+		return err
+	}
+	for _, suite := range conf.Suites {
+		if err := expandPretest(suite.Pretest); err != nil {
+			return err
+		}
+		for i, script := range suite.Runner {
+			command, err := snippetCommand(conf.Commands, script.Command, script.Use, script.Args)
+			if err != nil {
+				return err
+			}
+			suite.Runner[i].Command = command
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandSnippet(t *testing.T) {
+	snippets := map[string]string{
+		"bats":   "bats -t .",
+		"go":     "go test -v ${pkg} -run ${run}",
+		"escape": "sh -c echo $${HOME} ${dir}",
+	}
+	for _, tc := range []struct {
+		name     string
+		args     map[string]string
+		expected string
+	}{
+		{"bats", nil, "bats -t ."},
+		{"go", map[string]string{"pkg": "./registry", "run": "TestPush"}, "go test -v ./registry -run TestPush"},
+		{"escape", map[string]string{"dir": "/runner"}, "sh -c echo ${HOME} /runner"},
+	} {
+		command, err := expandSnippet(snippets, tc.name, tc.args)
+		if err != nil {
+			t.Fatalf("Error expanding %s: %v", tc.name, err)
+		}
+		if command != tc.expected {
+			t.Fatalf("Unexpected command %q expanding %s, expected %q", command, tc.name, tc.expected)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		args map[string]string
+		err  string
+	}{
+		{"missing", nil, `undefined command snippet "missing"`},
+		{"go", map[string]string{"pkg": "./registry"}, "requires args: run"},
+		{"bats", map[string]string{"dir": "."}, "does not use args: dir"},
+	} {
+		_, err := expandSnippet(snippets, tc.name, tc.args)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("Unexpected error expanding %s: %v, expected %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestSnippetConfiguration(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	writeTempFile(t, td, "golem.conf", `[commands]
+  certs="/bin/sh ./install_certs.sh ${host}"
+  bats="bats -t ."
+
+[[before_all]]
+  use="certs"
+  [before_all.args]
+    host="hostregistry"
+
+[[suite]]
+  name="snippets"
+  [[suite.pretest]]
+    use="certs"
+    [suite.pretest.args]
+      host="localregistry"
+  [[suite.testrunner]]
+    use="bats"
+    env=["TEST_REPO=hello-world"]
+  [[suite.testrunner]]
+    command="go test ./..."
+`)
+	suites, hooks, err := parseConfiguration([]string{td})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runConfig := suites["snippets"].RunConfiguration()
+	if expected := []string{"/bin/sh", "./install_certs.sh", "localregistry"}; !reflect.DeepEqual(runConfig.Setup[0].Command, expected) {
+		t.Fatalf("Unexpected pretest command %v, expected %v", runConfig.Setup[0].Command, expected)
+	}
+	if expected := []string{"bats", "-t", "."}; !reflect.DeepEqual(runConfig.TestRunner[0].Command, expected) {
+		t.Fatalf("Unexpected testrunner command %v, expected %v", runConfig.TestRunner[0].Command, expected)
+	}
+	if !reflect.DeepEqual(runConfig.TestRunner[0].Env, []string{"TEST_REPO=hello-world"}) {
+		t.Fatalf("Unexpected testrunner env %v", runConfig.TestRunner[0].Env)
+	}
+	if expected := []string{"go", "test", "./..."}; !reflect.DeepEqual(runConfig.TestRunner[1].Command, expected) {
+		t.Fatalf("Unexpected testrunner command %v, expected %v", runConfig.TestRunner[1].Command, expected)
+	}
+	if len(hooks.BeforeAll) != 1 || !reflect.DeepEqual(hooks.BeforeAll[0].Command, []string{"/bin/sh", "./install_certs.sh", "hostregistry"}) {
+		t.Fatalf("Unexpected before_all hooks %#v", hooks.BeforeAll)
+	}
+
+	for _, config := range []string{
+		"[[suite]]\n  [[suite.testrunner]]\n    use=\"undefined\"\n",
+		"[commands]\n  bats=\"bats -t .\"\n[[suite]]\n  [[suite.testrunner]]\n    use=\"bats\"\n    command=\"bats\"\n",
+		"[[suite]]\n  [[suite.testrunner]]\n    command=\"bats -t .\"\n    [suite.testrunner.args]\n      dir=\".\"\n",
+	} {
+		writeTempFile(t, td, "golem.conf", config)
+		if _, err := parseSuites([]string{td}); err == nil {
+			t.Fatalf("Expected error parsing %q", config)
+		}
+	}
+}
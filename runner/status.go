@@ -0,0 +1,235 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// RunPhase is the phase of a suite run
+type RunPhase string
+
+const (
+	// PhaseStarting is the phase before setup begins
+	PhaseStarting RunPhase = "starting"
+
+	// PhaseSetup is the phase running setup scripts, starting
+	// the daemon and starting compose services
+	PhaseSetup RunPhase = "setup"
+
+	// PhaseTest is the phase running the test runner commands
+	PhaseTest RunPhase = "test"
+
+	// PhaseTeardown is the phase stopping compose services
+	// and the daemon
+	PhaseTeardown RunPhase = "teardown"
+
+	// PhaseDone is the phase after teardown
+	PhaseDone RunPhase = "done"
+)
+
+// RunStatus is the reported status of a suite run
+type RunStatus struct {
+	Phase        RunPhase    `json:"phase"`
+	Step         string      `json:"step,omitempty"`
+	Elapsed      string      `json:"elapsed"`
+	PhaseElapsed string      `json:"phaseElapsed"`
+	Results      int         `json:"results"`
+	Failed       int         `json:"failed"`
+	LastResult   *TestResult `json:"lastResult,omitempty"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// StatusTracker tracks the status of a suite run for live
+// inspection. A nil tracker tracks nothing.
+type StatusTracker struct {
+	l          sync.Mutex
+	now        func() time.Time
+	start      time.Time
+	phaseStart time.Time
+	phase      RunPhase
+	step       string
+	results    int
+	failed     int
+	last       *TestResult
+	err        error
+}
+
+// NewStatusTracker creates a status tracker starting now
+func NewStatusTracker() *StatusTracker {
+	st := &StatusTracker{
+		now:   time.Now,
+		phase: PhaseStarting,
+	}
+	st.start = st.now()
+	st.phaseStart = st.start
+	return st
+}
+
+// SetPhase sets the current phase and the step within the phase
+func (st *StatusTracker) SetPhase(phase RunPhase, step string) {
+	if st == nil {
+		return
+	}
+	st.l.Lock()
+	defer st.l.Unlock()
+	if phase != st.phase {
+		st.phaseStart = st.now()
+	}
+	st.phase = phase
+	st.step = step
+}
+
+// AddResults records the results of a test runner command
+func (st *StatusTracker) AddResults(results []TestResult) {
+	if st == nil || len(results) == 0 {
+		return
+	}
+	st.l.Lock()
+	defer st.l.Unlock()
+	for _, result := range results {
+		st.results++
+		if result.Status == TestFailed {
+			st.failed++
+		}
+	}
+	last := results[len(results)-1]
+	st.last = &last
+}
+
+// Finish marks the run as done with the error ending the run
+func (st *StatusTracker) Finish(err error) {
+	if st == nil {
+		return
+	}
+	st.SetPhase(PhaseDone, "")
+	st.l.Lock()
+	st.err = err
+	st.l.Unlock()
+}
+
+// Status returns the current status of the run
+func (st *StatusTracker) Status() RunStatus {
+	st.l.Lock()
+	defer st.l.Unlock()
+	now := st.now()
+	status := RunStatus{
+		Phase:        st.phase,
+		Step:         st.step,
+		Elapsed:      now.Sub(st.start).String(),
+		PhaseElapsed: now.Sub(st.phaseStart).String(),
+		Results:      st.results,
+		Failed:       st.failed,
+		LastResult:   st.last,
+	}
+	if st.err != nil {
+		status.Error = st.err.Error()
+	}
+	return status
+}
+
+// StatusTailLines is the number of lines of each log stream
+// kept for the status server.
+const StatusTailLines = 100
+
+// LogTails is a log forwarder keeping the last lines of each
+// stream of a log router.
+type LogTails struct {
+	lines      int
+	streamName func(string) string
+
+	l     sync.Mutex
+	tails map[string]*tailWriter
+}
+
+// StartForward starts keeping the tail of the named stream
+func (lt *LogTails) StartForward(name string, r io.ReadCloser) error {
+	tail := newTailWriter(lt.lines)
+	lt.l.Lock()
+	lt.tails[name] = tail
+	lt.l.Unlock()
+	go func() {
+		defer r.Close()
+		if _, err := io.Copy(tail, r); err != nil {
+			logrus.Debugf("Error copying %s for log tail: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+// StopForward does nothing, the tail of a stream is kept
+// after the stream is closed.
+func (lt *LogTails) StopForward(name string) error {
+	return nil
+}
+
+// Tail returns the last lines of the stdout and stderr of
+// the log stream, returning false if the stream is unknown.
+func (lt *LogTails) Tail(stream string) (stdout, stderr string, ok bool) {
+	name := lt.streamName(stream)
+	lt.l.Lock()
+	defer lt.l.Unlock()
+	outTail, outOK := lt.tails[name+"-stdout"]
+	errTail, errOK := lt.tails[name+"-stderr"]
+	if outOK {
+		stdout = outTail.Tail()
+	}
+	if errOK {
+		stderr = errTail.Tail()
+	}
+	return stdout, stderr, outOK || errOK
+}
+
+// AddLogTails adds a forwarder keeping the last lines of each
+// log stream of the router.
+func (lr *LogRouter) AddLogTails(lines int) (*LogTails, error) {
+	lt := &LogTails{
+		lines:      lines,
+		streamName: lr.streamName,
+		tails:      map[string]*tailWriter{},
+	}
+	if err := lr.AddForwarder(lt); err != nil {
+		return nil, err
+	}
+	return lt, nil
+}
+
+// NewStatusHandler returns an HTTP handler serving the run status
+// as JSON at /status and the tail of log streams at /logs/<stream>.
+// Stderr of a stream is served with ?stderr=1.
+func NewStatusHandler(st *StatusTracker, tails *LogTails) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(st.Status()); err != nil {
+			logrus.Debugf("Error writing status: %v", err)
+		}
+	})
+	mux.HandleFunc("/logs/", func(w http.ResponseWriter, r *http.Request) {
+		stream := strings.TrimPrefix(r.URL.Path, "/logs/")
+		if tails == nil || stream == "" {
+			http.NotFound(w, r)
+			return
+		}
+		stdout, stderr, ok := tails.Tail(stream)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown log stream %q", stream), http.StatusNotFound)
+			return
+		}
+		out := stdout
+		if r.URL.Query().Get("stderr") != "" {
+			out = stderr
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if out != "" {
+			fmt.Fprintln(w, out)
+		}
+	})
+	return mux
+}
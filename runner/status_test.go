@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getStatus(t *testing.T, server *httptest.Server) RunStatus {
+	resp, err := http.Get(server.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", resp.StatusCode)
+	}
+	var status RunStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestStatusPhases(t *testing.T) {
+	now := time.Date(2016, 8, 1, 12, 0, 0, 0, time.UTC)
+	st := NewStatusTracker()
+	st.now = func() time.Time { return now }
+	st.start = now
+	st.phaseStart = now
+
+	server := httptest.NewServer(NewStatusHandler(st, nil))
+	defer server.Close()
+
+	status := getStatus(t, server)
+	if status.Phase != PhaseStarting || status.Elapsed != "0s" || status.LastResult != nil {
+		t.Fatalf("Unexpected starting status %#v", status)
+	}
+
+	now = now.Add(time.Minute)
+	st.SetPhase(PhaseSetup, "compose up")
+	now = now.Add(10 * time.Second)
+	status = getStatus(t, server)
+	if status.Phase != PhaseSetup || status.Step != "compose up" {
+		t.Fatalf("Unexpected setup status %#v", status)
+	}
+	if status.Elapsed != "1m10s" || status.PhaseElapsed != "10s" {
+		t.Fatalf("Unexpected setup elapsed %q, phase elapsed %q", status.Elapsed, status.PhaseElapsed)
+	}
+
+	st.SetPhase(PhaseTest, "bats -t .")
+	st.AddResults([]TestResult{
+		{Name: "first", Status: TestPassed},
+		{Name: "second", Status: TestFailed},
+	})
+	status = getStatus(t, server)
+	if status.Phase != PhaseTest || status.Step != "bats -t ." || status.PhaseElapsed != "0s" {
+		t.Fatalf("Unexpected test status %#v", status)
+	}
+	if status.Results != 2 || status.Failed != 1 {
+		t.Fatalf("Unexpected result counts %d, %d failed", status.Results, status.Failed)
+	}
+	if status.LastResult == nil || status.LastResult.Name != "second" || status.LastResult.Status != TestFailed {
+		t.Fatalf("Unexpected last result %#v", status.LastResult)
+	}
+
+	st.SetPhase(PhaseTeardown, "")
+	st.Finish(nil)
+	status = getStatus(t, server)
+	if status.Phase != PhaseDone || status.Step != "" || status.Error != "" {
+		t.Fatalf("Unexpected done status %#v", status)
+	}
+	if status.LastResult == nil || status.LastResult.Name != "second" {
+		t.Fatalf("Expected last result to be kept when done, got %#v", status.LastResult)
+	}
+
+	st.Finish(errors.New("run error: test failed"))
+	if status = getStatus(t, server); status.Error != "run error: test failed" {
+		t.Fatalf("Unexpected done error %q", status.Error)
+	}
+}
+
+func TestStatusRunTests(t *testing.T) {
+	st := NewStatusTracker()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{Script: Script{Command: []string{"true"}}},
+				{Script: Script{Command: []string{"false"}}, AllowFailure: true},
+			},
+		},
+		TestCapturer: newBufferLogger(),
+		Status:       st,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	status := st.Status()
+	if status.Phase != PhaseTest || status.Step != "false" {
+		t.Fatalf("Unexpected status after tests %#v", status)
+	}
+	if status.LastResult == nil || status.LastResult.Name != "false" || status.LastResult.Status != TestAllowedFailure {
+		t.Fatalf("Unexpected last result %#v", status.LastResult)
+	}
+
+	if err := sr.TearDown(); err != nil {
+		t.Fatal(err)
+	}
+	if status := st.Status(); status.Phase != PhaseTeardown {
+		t.Fatalf("Unexpected status after teardown %#v", status)
+	}
+}
+
+func TestStatusLogTails(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-logs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	lr := NewLogRouter(td, "registry-1")
+	test, err := lr.RouteLogCapturer("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tails, err := lr.AddLogTails(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Creating a stream waits for the router to finish
+	// forwarding the existing streams
+	if _, err := lr.RouteLogCapturer("barrier"); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewStatusHandler(NewStatusTracker(), tails))
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+
+	assertWrite(t, test.Stdout(), "one\ntwo\nthree")
+	assertWrite(t, test.Stderr(), "failed")
+	waitForContent(t, func() string {
+		_, out := get("/logs/test")
+		return out
+	}, "three")
+	if code, out := get("/logs/test"); code != http.StatusOK || out != "two\nthree\n" {
+		t.Fatalf("Unexpected stdout tail %d %q", code, out)
+	}
+	waitForContent(t, func() string {
+		_, out := get("/logs/test?stderr=1")
+		return out
+	}, "failed")
+
+	if code, out := get("/logs/unknown"); code != http.StatusNotFound || !strings.Contains(out, "unknown") {
+		t.Fatalf("Unexpected unknown stream response %d %q", code, out)
+	}
+}
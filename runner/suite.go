@@ -1,15 +1,22 @@
 package runner
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
@@ -17,11 +24,10 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/pkg/jsonmessage"
-	"github.com/docker/docker/pkg/term"
 	"github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/golem/clientutil"
-	"github.com/docker/golem/versionutil"
+	"github.com/docker/golem/retryutil"
 )
 
 const (
@@ -37,12 +43,60 @@ type SuiteRunnerConfiguration struct {
 	DockerLoadLogCapturer LogCapturer
 	DockerLogCapturer     LogCapturer
 
+	// LoadProgress shows image load progress in the load log
+	// stream, only the loaded images are shown when unset.
+	LoadProgress bool
+
 	ComposeFile     string
 	ComposeCapturer LogCapturer
 
 	RunConfiguration RunConfiguration
 	SetupLogCapturer LogCapturer
 	TestCapturer     LogCapturer
+
+	// StopTimeout is the grace period given to containers and
+	// compose services to exit before they are killed.
+	StopTimeout time.Duration
+
+	// Cleanup is the policy for removing compose containers
+	// and their volumes during teardown.
+	Cleanup CleanupPolicy
+
+	// Daemon is the configuration for the daemon started
+	// when running Docker-in-Docker.
+	Daemon DaemonConfiguration
+
+	// LogRouter is the router of the log streams used to
+	// wait for log patterns, required when waiting for logs.
+	LogRouter *LogRouter
+
+	// EnvPrinter prints the environment of the setup and test
+	// commands before they run, not printed when nil.
+	EnvPrinter *EnvPrinter
+
+	// DefaultCommand is the test command run when the run
+	// configuration has no test runner commands.
+	DefaultCommand []string
+
+	// Tracer is a command test runner commands are run under
+	// when TraceTests is set, with tracer output written to file
+	// descriptor 3 going to TraceCapturer.
+	Tracer        []string
+	TraceTests    bool
+	TraceCapturer LogCapturer
+
+	// ReferencePolicy is how the tags of synced images are
+	// normalized, matching the policy used to save the images.
+	ReferencePolicy ReferencePolicy
+
+	// Status tracks the phase and results of the run for the
+	// status server, nothing is tracked when nil.
+	Status *StatusTracker
+
+	// EventsCapturer captures the events of the daemon run
+	// inside the container from startup until teardown,
+	// events are not captured when nil.
+	EventsCapturer LogCapturer
 }
 
 // SuiteRunner is the runtime manager for the test
@@ -51,6 +105,10 @@ type SuiteRunner struct {
 	config SuiteRunnerConfiguration
 
 	daemonCloser func() error
+	stopEvents   func()
+
+	results []TestResult
+	passed  bool
 }
 
 // NewSuiteRunner creates a new SuiteRunner with the provided
@@ -69,7 +127,8 @@ func (sr *SuiteRunner) Setup() error {
 	setupStart := time.Now()
 	// Run all setup scripts
 	for _, setupScript := range sr.config.RunConfiguration.Setup {
-		if err := RunScript(sr.config.SetupLogCapturer, setupScript); err != nil {
+		sr.config.Status.SetPhase(PhaseSetup, strings.Join(setupScript.Command, " "))
+		if err := runScript(sr.config.SetupLogCapturer, setupScript, sr.config.EnvPrinter); err != nil {
 			return fmt.Errorf("error running setup script %s: %s", setupScript.Command[0], err)
 		}
 	}
@@ -77,29 +136,29 @@ func (sr *SuiteRunner) Setup() error {
 	// Start Docker-in-Docker daemon for tests, build compose images
 	if sr.config.DockerInDocker {
 		if sr.config.CleanDockerGraph {
-			// Check if empty
-			info, err := ioutil.ReadDir("/var/lib/docker")
-			if err != nil {
-				return fmt.Errorf("error reading /var/lib/docker: %v", err)
-			}
-
-			for _, fInfo := range info {
-				cleanFile := filepath.Join("/var/lib/docker", fInfo.Name())
-				if err := os.RemoveAll(cleanFile); err != nil {
-					return fmt.Errorf("error cleaning %s: %s", cleanFile, err)
-				}
+			if err := cleanDockerGraph("/var/lib/docker"); err != nil {
+				return err
 			}
 		}
 
+		sr.config.Status.SetPhase(PhaseSetup, "starting daemon")
 		dockerStart := time.Now()
 		logrus.Debugf("Starting daemon")
-		pc, k, err := StartDaemon(ctx, "docker", sr.config.DockerLogCapturer)
+		pc, k, err := StartDaemon(ctx, sr.config.Daemon, sr.config.DockerLogCapturer)
 		if err != nil {
 			return fmt.Errorf("error starting daemon: %s", err)
 		}
 		sr.daemonCloser = k
 		logrus.WithField(timerKey, time.Since(dockerStart)).Info("docker daemon startup complete")
 
+		if sr.config.EventsCapturer != nil {
+			stop, err := captureDaemonEvents(ctx, pc, sr.config.EventsCapturer.Stdout())
+			if err != nil {
+				return fmt.Errorf("error capturing daemon events: %v", err)
+			}
+			sr.stopEvents = stop
+		}
+
 		cleanupStart := time.Now()
 		// Remove all containers
 		containers, err := pc.ContainerList(ctx, types.ContainerListOptions{All: true})
@@ -112,43 +171,50 @@ func (sr *SuiteRunner) Setup() error {
 				RemoveVolumes: true,
 				Force:         true,
 			}
-			if err := pc.ContainerRemove(ctx, container.ID, removeOptions); err != nil {
+			if err := removeContainer(ctx, pc, container.ID, sr.config.StopTimeout, removeOptions); err != nil {
 				return fmt.Errorf("error removing container: %v", err)
 			}
 		}
 
-		if err := syncImages(ctx, pc, "/images", sr.config.CleanImageCache); err != nil {
+		sr.config.Status.SetPhase(PhaseSetup, "syncing images")
+		loadOutput := io.Writer(os.Stdout)
+		if sr.config.DockerLoadLogCapturer != nil {
+			loadOutput = sr.config.DockerLoadLogCapturer.Stdout()
+		}
+		if err := syncImages(ctx, pc, "/images", sr.config.ReferencePolicy, sr.config.CleanImageCache, loadOutput, !sr.config.LoadProgress); err != nil {
 			return fmt.Errorf("error syncing images: %v", err)
 		}
 		logrus.WithField(timerKey, time.Since(cleanupStart)).Info("image sync complete")
 
 		if sr.config.ComposeFile != "" {
 			logrus.Debugf("Build compose images")
+			sr.config.Status.SetPhase(PhaseSetup, "compose build")
 			buildStart := time.Now()
-			buildArgs := []string{"docker-compose", "-f", sr.config.ComposeFile, "build"}
+			buildArgs := []string{"build"}
 			if sr.config.CleanImageCache {
 				buildArgs = append(buildArgs, "--no-cache")
 			}
-			buildScript := Script{
-				Command: buildArgs,
-				Env:     os.Environ(),
-			}
-			if err := RunScript(sr.config.ComposeCapturer, buildScript); err != nil {
+			retries := sr.config.RunConfiguration.ComposeRetries
+			buildScript := composeScript(sr.config.ComposeFile, buildArgs...)
+			if err := runComposeRetry(ctx, sr.config.ComposeCapturer, sr.config.ComposeFile, retries, buildScript); err != nil {
 				return fmt.Errorf("error running docker compose build: %v", err)
 			}
 			logrus.WithField(timerKey, time.Since(buildStart)).Info("compose build complete")
 			logrus.Debugf("Starting compose containers")
+			sr.config.Status.SetPhase(PhaseSetup, "compose up")
 			upStart := time.Now()
-			upScript := Script{
-				Command: []string{"docker-compose", "-f", sr.config.ComposeFile, "up", "-d"},
-				Env:     os.Environ(),
-			}
-
-			if err := RunScript(sr.config.ComposeCapturer, upScript); err != nil {
+			upScript := composeScript(sr.config.ComposeFile, "up", "-d")
+			if err := runComposeRetry(ctx, sr.config.ComposeCapturer, sr.config.ComposeFile, retries, upScript); err != nil {
 				return fmt.Errorf("error running docker compose up: %v", err)
 			}
 			logrus.WithField(timerKey, time.Since(upStart)).Info("compose up complete")
 
+			// Tap log streams before starting the compose logs
+			waiters, err := sr.logWaiters()
+			if err != nil {
+				return err
+			}
+
 			go func() {
 				logrus.Debugf("Listening for logs")
 				logScript := Script{
@@ -158,26 +224,136 @@ func (sr *SuiteRunner) Setup() error {
 					logrus.Errorf("Error running docker compose logs: %v", err)
 				}
 			}()
+
+			for i, waiter := range waiters {
+				wait := sr.config.RunConfiguration.WaitFor[i]
+				sr.config.Status.SetPhase(PhaseSetup, fmt.Sprintf("waiting for %q in %s", wait.Pattern, wait.Stream))
+				waitStart := time.Now()
+				if err := waiter.Wait(wait.Timeout); err != nil {
+					for _, remaining := range waiters[i+1:] {
+						remaining.close()
+					}
+					return err
+				}
+				logrus.WithField(timerKey, time.Since(waitStart)).Infof("found %q in %s", wait.Pattern, wait.Stream)
+			}
+
+			for _, probe := range sr.config.RunConfiguration.Probes {
+				sr.config.Status.SetPhase(PhaseSetup, "probing "+probe.Service)
+				probeStart := time.Now()
+				attempts, err := waitReady(context.Background(), probe, composeProbeAttempt(sr.config.ComposeCapturer, sr.config.ComposeFile, probe))
+				if err != nil {
+					return err
+				}
+				logrus.WithField(timerKey, time.Since(probeStart)).Infof("%s ready after %d probe attempts", probe.Service, attempts)
+			}
 		}
 	}
 
+	if sr.config.ComposeFile == "" && len(sr.config.RunConfiguration.WaitFor) > 0 {
+		logrus.Warnf("No compose file, not waiting for log patterns")
+	}
+	if sr.config.ComposeFile == "" && len(sr.config.RunConfiguration.Probes) > 0 {
+		logrus.Warnf("No compose file, not running readiness probes")
+	}
+
 	logrus.WithField(timerKey, time.Since(setupStart)).Info("setup complete")
 
 	return nil
 }
 
+// logWaiters creates a log waiter for each configured log wait.
+func (sr *SuiteRunner) logWaiters() (waiters []*LogWaiter, err error) {
+	waits := sr.config.RunConfiguration.WaitFor
+	if len(waits) == 0 {
+		return nil, nil
+	}
+	if sr.config.LogRouter == nil {
+		return nil, errors.New("waiting for logs requires a log router")
+	}
+	defer func() {
+		if err != nil {
+			for _, waiter := range waiters {
+				waiter.close()
+			}
+			waiters = nil
+		}
+	}()
+	for _, wait := range waits {
+		pattern, err := regexp.Compile(wait.Pattern)
+		if err != nil {
+			return waiters, fmt.Errorf("invalid wait pattern %q: %v", wait.Pattern, err)
+		}
+		waiter, err := sr.config.LogRouter.NewLogWaiter(wait.Stream, pattern)
+		if err != nil {
+			return waiters, err
+		}
+		waiters = append(waiters, waiter)
+	}
+	return waiters, nil
+}
+
+// cleanDockerGraph ensures the docker graph directory exists and is empty.
+// A missing directory is created rather than treated as an error since
+// the directory may not exist until the first daemon start.
+func cleanDockerGraph(root string) error {
+	info, err := ioutil.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.Debugf("Creating missing graph directory %s", root)
+			if err := os.MkdirAll(root, 0700); err != nil {
+				return fmt.Errorf("error creating %s: %v", root, err)
+			}
+			return nil
+		}
+		return fmt.Errorf("error reading %s: %v", root, err)
+	}
+
+	if len(info) == 0 {
+		logrus.Debugf("Graph directory %s already empty", root)
+		return nil
+	}
+
+	for _, fInfo := range info {
+		cleanFile := filepath.Join(root, fInfo.Name())
+		if err := os.RemoveAll(cleanFile); err != nil {
+			return fmt.Errorf("error cleaning %s: %s", cleanFile, err)
+		}
+	}
+
+	return nil
+}
+
 // TearDown releases on test resources and stops any running containers
 // docker daemon.
 func (sr *SuiteRunner) TearDown() (err error) {
+	sr.config.Status.SetPhase(PhaseTeardown, "")
 	tearDownStart := time.Now()
 	if sr.config.DockerInDocker {
 		if sr.config.ComposeFile != "" {
+			stopArgs := []string{"docker-compose", "-f", sr.config.ComposeFile, "stop"}
+			if sr.config.StopTimeout > 0 {
+				stopArgs = append(stopArgs, "-t", strconv.Itoa(stopSeconds(sr.config.StopTimeout)))
+			}
 			stopScript := Script{
-				Command: []string{"docker-compose", "-f", sr.config.ComposeFile, "stop"},
+				Command: stopArgs,
 			}
-			if err := RunScript(sr.config.ComposeCapturer, stopScript); err != nil {
+			if err := runComposeScript(sr.config.ComposeCapturer, stopScript); err != nil {
 				logrus.Errorf("Error stopping docker compose: %v", err)
 			}
+
+			if sr.config.Cleanup.shouldRemove(sr.passed) {
+				rmScript := Script{
+					Command: []string{"docker-compose", "-f", sr.config.ComposeFile, "rm", "-f", "-v"},
+				}
+				if err := runComposeScript(sr.config.ComposeCapturer, rmScript); err != nil {
+					logrus.Errorf("Error removing docker compose containers: %v", err)
+				}
+			}
+		}
+
+		if sr.stopEvents != nil {
+			sr.stopEvents()
 		}
 
 		if err = sr.daemonCloser(); err != nil {
@@ -191,61 +367,421 @@ func (sr *SuiteRunner) TearDown() (err error) {
 }
 
 // RunTests runs the tests in order, capturing any output to
-// the test capturer.
-// TODO: Parse output and send to a test result manager.
+// the test capturer. Output from commands with a known format
+// is also parsed into test results while being captured.
+// The first failing command stops the run unless RunAll is
+// set, in which case every command is run and the failures
+// are returned together. Commands not marked as ordered are
+// run concurrently when the run configuration is parallel.
+// TODO: Send results to a test result manager.
 func (sr *SuiteRunner) RunTests() error {
+	sr.config.Status.SetPhase(PhaseTest, "")
 	runnerStart := time.Now()
-	for _, runner := range sr.config.RunConfiguration.TestRunner {
-		cmd := exec.Command(runner.Command[0], runner.Command[1:]...)
-		// TODO: Parse Stdout using sr.config.RunConfiguration.TestRunner.Format
-		cmd.Stdout = sr.config.TestCapturer.Stdout()
-		cmd.Stderr = sr.config.TestCapturer.Stderr()
-		cmd.Env = append(os.Environ(), runner.Env...)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("run error: %s", err)
+	runners := sr.testRunners()
+	if len(runners) == 0 {
+		if !sr.config.RunConfiguration.AllowNoTests {
+			return errors.New("no test runner commands configured")
+		}
+		logrus.Warnf("No test runner commands configured, no tests will run")
+	}
+	outcomes := make([]testOutcome, len(runners))
+	var failures []string
+	for _, batch := range testBatches(runners, sr.config.RunConfiguration.Parallel) {
+		sr.runTestBatch(runners, batch, outcomes)
+		for _, i := range batch {
+			runner, outcome := runners[i], outcomes[i]
+			if outcome.skipped {
+				continue
+			}
+			if outcome.err != nil {
+				return outcome.err
+			}
+			sr.results = append(sr.results, outcome.results...)
+			sr.config.Status.AddResults(outcome.results)
+
+			if outcome.runErr != nil {
+				if !runner.AllowFailure {
+					if !sr.config.RunConfiguration.RunAll {
+						return fmt.Errorf("run error: %s", outcome.runErr)
+					}
+					logrus.Errorf("Test runner %s failed: %v", strings.Join(runner.Command, " "), outcome.runErr)
+					failures = append(failures, fmt.Sprintf("%s: %s", strings.Join(runner.Command, " "), outcome.runErr))
+					continue
+				}
+				logrus.Warnf("Allowed failure of %s: %v", strings.Join(runner.Command, " "), outcome.runErr)
+				allowed := TestResult{
+					Name:   strings.Join(runner.Command, " "),
+					Status: TestAllowedFailure,
+				}
+				sr.results = append(sr.results, allowed)
+				sr.config.Status.AddResults([]TestResult{allowed})
+			}
 		}
 	}
 
 	logrus.WithField(timerKey, time.Since(runnerStart)).Info("suite runner complete")
+	if len(failures) > 0 {
+		return fmt.Errorf("run error: %d of %d test runner commands failed:\n%s", len(failures), len(runners), strings.Join(failures, "\n"))
+	}
+	if err := checkMinTests(sr.results, sr.config.RunConfiguration.MinTests); err != nil {
+		return err
+	}
+	if err := checkUnexpectedPasses(sr.results, sr.config.RunConfiguration.XFailStrict); err != nil {
+		return err
+	}
+	sr.passed = true
+
+	return nil
+}
+
+// checkMinTests returns an error if fewer than min tests were
+// run, not counting skipped tests or allowed command failures.
+func checkMinTests(results []TestResult, min int) error {
+	if min <= 0 {
+		return nil
+	}
+	var ran int
+	for _, result := range results {
+		switch result.Status {
+		case TestPassed, TestFailed, TestExpectedFailure, TestUnexpectedPass:
+			ran++
+		}
+	}
+	if ran < min {
+		return fmt.Errorf("run error: expected at least %d tests, %d ran", min, ran)
+	}
+	return nil
+}
+
+// checkUnexpectedPasses returns an error if any test expected
+// to fail passed when strict.
+func checkUnexpectedPasses(results []TestResult, strict bool) error {
+	if !strict {
+		return nil
+	}
+	if names := unexpectedPasses(results); len(names) > 0 {
+		return fmt.Errorf("run error: %d tests expected to fail passed: %s", len(names), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// testOutcome is the outcome of running a test runner command.
+// A command not started is skipped, err is an error creating the
+// command and runErr is the error from running it.
+type testOutcome struct {
+	results []TestResult
+	skipped bool
+	err     error
+	runErr  error
+}
+
+// failed returns whether the outcome stops the run when not
+// running all commands
+func (o testOutcome) failed(runner TestScript) bool {
+	return o.err != nil || (o.runErr != nil && !runner.AllowFailure)
+}
+
+// DefaultTestCommand is the test command run in test containers
+// for suites without test runner commands.
+const DefaultTestCommand = "bats"
+
+// testRunners returns the test runner commands to run, the
+// default command when none are configured.
+func (sr *SuiteRunner) testRunners() []TestScript {
+	runners := sr.config.RunConfiguration.TestRunner
+	if len(runners) > 0 || len(sr.config.DefaultCommand) == 0 {
+		return runners
+	}
+	logrus.Infof("No test runner commands configured, running %s", strings.Join(sr.config.DefaultCommand, " "))
+	return []TestScript{
+		{
+			Script: Script{
+				Command: sr.config.DefaultCommand,
+			},
+		},
+	}
+}
+
+// testBatches groups the test runner commands into batches of
+// indexes run one after another. Commands in a batch may run
+// concurrently, when parallel is above 1 consecutive commands
+// are batched unless ordered, ordered commands run alone.
+func testBatches(runners []TestScript, parallel int) [][]int {
+	var batches [][]int
+	var batch []int
+	for i, runner := range runners {
+		if parallel > 1 && !runner.Ordered {
+			batch = append(batch, i)
+			continue
+		}
+		if len(batch) > 0 {
+			batches = append(batches, batch)
+			batch = nil
+		}
+		batches = append(batches, []int{i})
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// runTestBatch runs the test runner commands in the batch, using
+// up to the configured parallel number of commands at a time. Once
+// a command fails, no further commands are started unless running
+// all commands.
+func (sr *SuiteRunner) runTestBatch(runners []TestScript, batch []int, outcomes []testOutcome) {
+	stdout, stderr := sr.config.TestCapturer.Stdout(), sr.config.TestCapturer.Stderr()
+	if len(batch) == 1 {
+		outcomes[batch[0]] = sr.runTestCommand(runners[batch[0]], stdout, stderr)
+		return
+	}
+
+	// Share a lock between streams so concurrent writes to the
+	// capturer do not race
+	l := &sync.Mutex{}
+	stdout, stderr = lockedWriter{l: l, w: stdout}, lockedWriter{l: l, w: stderr}
+
+	var (
+		wg      sync.WaitGroup
+		stopped int32
+	)
+	workers := make(chan struct{}, sr.config.RunConfiguration.Parallel)
+	for _, i := range batch {
+		workers <- struct{}{}
+		if atomic.LoadInt32(&stopped) != 0 {
+			<-workers
+			outcomes[i] = testOutcome{skipped: true}
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outcome := sr.runTestCommand(runners[i], stdout, stderr)
+			if outcome.failed(runners[i]) && !sr.config.RunConfiguration.RunAll {
+				atomic.StoreInt32(&stopped, 1)
+			}
+			outcomes[i] = outcome
+			<-workers
+		}(i)
+	}
+	wg.Wait()
+}
+
+// runTestCommand runs a test runner command writing its output
+// to the writers, parsing results from commands of known format.
+func (sr *SuiteRunner) runTestCommand(runner TestScript, stdout, stderr io.Writer) testOutcome {
+	cmd, err := testCommand(runner, sr.config.RunConfiguration.InstanceEnv)
+	if err != nil {
+		return testOutcome{err: err}
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	sr.config.EnvPrinter.Print(cmd.Stderr, cmd)
+	sr.config.Status.SetPhase(PhaseTest, strings.Join(runner.Command, " "))
+
+	closeTrace := func() {}
+	var traceDone <-chan struct{}
+	if sr.config.TraceTests && len(sr.config.Tracer) > 0 {
+		if cmd, err = traceCommand(sr.config.Tracer, cmd); err != nil {
+			return testOutcome{err: err}
+		}
+		traceOutput := stderr
+		if sr.config.TraceCapturer != nil {
+			traceOutput = sr.config.TraceCapturer.Stdout()
+		}
+		if closeTrace, traceDone, err = attachTraceOutput(cmd, traceOutput); err != nil {
+			return testOutcome{err: err}
+		}
+	}
+
+	var rw *resultWriter
+	if parser, ok := resultParsers[runner.Format]; ok {
+		rw = newResultWriter(parser)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, rw)
+	} else if runner.Format != "" {
+		logrus.Warnf("Unsupported test format %q, results will not be parsed", runner.Format)
+	}
+
+	if runner.ResultsFile != "" {
+		// Remove a report left by a previous run so a missing
+		// report is detected
+		if err := os.Remove(runner.ResultsFile); err != nil && !os.IsNotExist(err) {
+			return testOutcome{err: fmt.Errorf("error removing results file: %v", err)}
+		}
+	}
+
+	var outcome testOutcome
+	outcome.runErr = cmd.Start()
+	closeTrace()
+	if outcome.runErr == nil {
+		outcome.runErr = cmd.Wait()
+	}
+	if traceDone != nil {
+		<-traceDone
+	}
+
+	if rw != nil {
+		results, err := rw.Close()
+		if err != nil {
+			logrus.Errorf("Error parsing %s test output: %v", runner.Format, err)
+		}
+		outcome.results = results
+	}
+	if runner.ResultsFile != "" {
+		results, err := readResultsFile(runner.ResultsFile, runner.ResultsFormat)
+		if err != nil {
+			if outcome.runErr != nil {
+				err = fmt.Errorf("%v after %s", err, outcome.runErr)
+			}
+			return testOutcome{err: err}
+		}
+		outcome.results = append(outcome.results, results...)
+	}
+	if err := sr.applyXFail(&outcome); err != nil {
+		return testOutcome{err: err}
+	}
+	return outcome
+}
 
+// applyXFail marks the results of the outcome matching the
+// expected failure patterns. A command failing with only
+// expected test failures is not considered failed.
+func (sr *SuiteRunner) applyXFail(outcome *testOutcome) error {
+	xfail, err := newXFailMatcher(sr.config.RunConfiguration.XFail)
+	if err != nil {
+		return err
+	}
+	xfail.apply(outcome.results)
+	for _, name := range unexpectedPasses(outcome.results) {
+		logrus.Warnf("Test %s passed but is expected to fail", name)
+	}
+	if outcome.runErr != nil && onlyExpectedFailures(outcome.results) {
+		logrus.Infof("Ignoring command failure with only expected test failures: %v", outcome.runErr)
+		outcome.runErr = nil
+	}
 	return nil
 }
 
+// readResultsFile parses the results from a report written by
+// a test runner command. A missing report is an error as the
+// command did not run to completion.
+func readResultsFile(filename, format string) ([]TestResult, error) {
+	parser, ok := resultParsers[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported results format %q", format)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("results file %s was not written", filename)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	results, err := parser(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s results file %s: %v", format, filename, err)
+	}
+	return results, nil
+}
+
+// testCommand returns the command for a test runner, prefixed
+// by the wrapper command if set. The test runner environment
+// takes precedence over the instance environment and the
+// wrapper environment takes precedence over both.
+func testCommand(runner TestScript, instanceEnv []string) (*exec.Cmd, error) {
+	args := append(append([]string{}, runner.Wrapper...), runner.Command...)
+	if len(runner.Wrapper) > 0 {
+		if _, err := exec.LookPath(runner.Wrapper[0]); err != nil {
+			return nil, fmt.Errorf("invalid wrapper %s: %v", runner.Wrapper[0], err)
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	env, err := loadScriptEnv(runner.Script)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = mergeEnv(os.Environ(), mergeEnv(instanceEnv, mergeEnv(env, runner.WrapperEnv)))
+	return cmd, nil
+}
+
+// Results returns the test results parsed from the output
+// of the test runner commands.
+func (sr *SuiteRunner) Results() []TestResult {
+	return sr.results
+}
+
 // RunScript runs the script command attaching
 // results to stdout and stdout
 func RunScript(lc LogCapturer, script Script) error {
+	return runScript(lc, script, nil)
+}
+
+// runScript runs the script command, printing its environment
+// to the stderr of the log capturer first when the printer is
+// not nil.
+func runScript(lc LogCapturer, script Script, p *EnvPrinter) error {
 	cmd := exec.Command(script.Command[0], script.Command[1:]...)
 	cmd.Stdout = lc.Stdout()
 	cmd.Stderr = lc.Stderr()
-	cmd.Env = script.Env
+	env, err := loadScriptEnv(script)
+	if err != nil {
+		return err
+	}
+	cmd.Env = env
+	p.Print(cmd.Stderr, cmd)
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("could not start script: %s", err)
 	}
 	return cmd.Wait()
 }
 
-// StartDaemon starts a daemon using the provided binary returning
+// daemonReadyPolicy is the retry policy for
+// connecting to a newly started daemon.
+var daemonReadyPolicy = retryutil.Policy{
+	MaxAttempts:    11,
+	InitialBackoff: time.Second,
+}
+
+// StartDaemon starts a daemon using the provided configuration returning
 // a client to the binary, a close function, and error.
-func StartDaemon(ctx context.Context, binary string, lc LogCapturer) (DockerClient, func() error, error) {
-	// Get Docker version of process
-	previousVersion, err := versionutil.BinaryVersion(binary)
+func StartDaemon(ctx context.Context, config DaemonConfiguration, lc LogCapturer) (DockerClient, func() error, error) {
+	cmd, err := daemonCommand(config)
 	if err != nil {
-		return DockerClient{}, nil, fmt.Errorf("could not get binary version: %s", err)
+		return DockerClient{}, nil, err
 	}
-
-	logrus.Debugf("Starting daemon with %s", binary)
-	binaryArgs := []string{}
-	if previousVersion.LessThan(versionutil.StaticVersion(1, 8, 0)) {
-		binaryArgs = append(binaryArgs, "--daemon")
-	} else {
-		binaryArgs = append(binaryArgs, "daemon")
+	if err := writeDaemonConfig(daemonConfigFile, config); err != nil {
+		return DockerClient{}, nil, fmt.Errorf("error writing daemon config: %v", err)
 	}
-	binaryArgs = append(binaryArgs, "--log-level=debug")
-	binaryArgs = append(binaryArgs, "--storage-driver="+getGraphDriver())
-	cmd := exec.Command(binary, binaryArgs...)
+	pidFile := daemonPidFile(config, daemonConfigFile)
 	cmd.Stdout = lc.Stdout()
 	cmd.Stderr = lc.Stderr()
-	if err := cmd.Start(); err != nil {
+
+	// Capture startup output to check for warnings
+	var startupOutput *bytes.Buffer
+	var stderr MultiWriter
+	if config.CheckWarnings {
+		startupOutput = bytes.NewBuffer(nil)
+		stderr = NewLogMultiWriter(lc.Stderr())
+		stderr.AddWriter(startupOutput)
+		cmd.Stderr = stderr
+	}
+
+	closeTrace := func() {}
+	if len(config.Tracer) > 0 {
+		traceCapturer := config.TraceCapturer
+		if traceCapturer == nil {
+			traceCapturer = lc
+		}
+		if closeTrace, _, err = attachTraceOutput(cmd, traceCapturer.Stdout()); err != nil {
+			return DockerClient{}, nil, err
+		}
+	}
+
+	err = cmd.Start()
+	closeTrace()
+	if err != nil {
 		return DockerClient{}, nil, fmt.Errorf("could not start daemon: %s", err)
 	}
 
@@ -258,24 +794,29 @@ func StartDaemon(ctx context.Context, binary string, lc LogCapturer) (DockerClie
 	}
 
 	// Wait for it to start
-	for i := 0; ; i++ {
+	if err := retryutil.Do(ctx, daemonReadyPolicy, func(ctx context.Context) error {
 		v, err := cli.ServerVersion(ctx)
 		if err == nil {
 			logrus.Debugf("Established connection to daemon with version %s", v.Version)
-			break
-		}
-		if i >= 10 {
-			logrus.Fatalf("Failed to establish connection to daemon, check logs, quitting")
 		}
-		time.Sleep(time.Second)
+		return err
+	}); err != nil {
+		logrus.Fatalf("Failed to establish connection to daemon, check logs, quitting: %v", err)
 	}
 
 	kill := func() error {
-		if err := cmd.Process.Kill(); err != nil {
-			return err
+		return killDaemon(cmd.Process, pidFile)
+	}
+
+	if config.CheckWarnings {
+		stderr.RemoveWriter(startupOutput)
+		warnings := scanDaemonWarnings(startupOutput)
+		if err := checkDaemonWarnings(warnings, config.FailOnWarning); err != nil {
+			if killErr := kill(); killErr != nil {
+				logrus.Errorf("Error stopping daemon: %v", killErr)
+			}
+			return DockerClient{}, nil, err
 		}
-		time.Sleep(500 * time.Millisecond)
-		return os.RemoveAll("/var/run/docker.pid")
 	}
 
 	return DockerClient{Client: cli, options: &clientutil.ClientOptions{}}, kill, nil
@@ -318,7 +859,29 @@ func listDiff(l1, l2 []string) ([]string, []string) {
 	return removed, added
 }
 
-func syncImages(ctx context.Context, cli DockerClient, imageRoot string, clean bool) error {
+// imageTagger is the subset of the docker client used
+// to tag images
+type imageTagger interface {
+	ImageTag(ctx context.Context, imageID, ref string, options types.ImageTagOptions) error
+}
+
+// imageSyncer is the subset of the docker client used
+// to sync images with a saved image directory
+type imageSyncer interface {
+	imageInspector
+	imageLoader
+	imageTagger
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.Image, error)
+	ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDelete, error)
+}
+
+// syncImages loads and tags the images saved in the image root.
+// Images already present in the daemon, including ones not
+// listed such as untagged images, are only tagged with missing
+// tags rather than reloaded. Layers shared with present images
+// are not re-applied by the daemon on load. Tags are compared
+// normalized with the reference policy.
+func syncImages(ctx context.Context, cli imageSyncer, imageRoot string, policy ReferencePolicy, clean bool, out io.Writer, quiet bool) error {
 	logrus.Debugf("Syncing images from %s", imageRoot)
 	f, err := os.Open(filepath.Join(imageRoot, "images.json"))
 	if err != nil {
@@ -334,6 +897,8 @@ func syncImages(ctx context.Context, cli DockerClient, imageRoot string, clean b
 	allTags := map[string]struct{}{}
 	neededImages := map[string]struct{}{}
 	for imageID, tags := range m {
+		tags = policy.normalizeStrings(tags)
+		m[imageID] = tags
 		neededImages[imageID] = struct{}{}
 		for _, t := range tags {
 			allTags[t] = struct{}{}
@@ -350,7 +915,7 @@ func syncImages(ctx context.Context, cli DockerClient, imageRoot string, clean b
 		if ok {
 			delete(neededImages, img.ID)
 
-			repoTags := filterRepoTags(img.RepoTags)
+			repoTags := policy.normalizeStrings(filterRepoTags(img.RepoTags))
 			logrus.Debugf("Tags for %s: %#v", img.ID, repoTags)
 
 			// Sync tags for image ID
@@ -392,11 +957,13 @@ func syncImages(ctx context.Context, cli DockerClient, imageRoot string, clean b
 		if !ok {
 			return fmt.Errorf("missing image %s in tag map", imageID)
 		}
-		_, _, err := cli.ImageInspectWithRaw(ctx, imageID, false)
-		if err != nil {
-			if err := imageLoad(ctx, cli, imageRoot, imageID); err != nil {
-				return err
-			}
+		img, _, err := cli.ImageInspectWithRaw(ctx, imageID, false)
+		if err == nil {
+			// Only add tags missing from the present image
+			_, tags = listDiff(policy.normalizeStrings(filterRepoTags(img.RepoTags)), tags)
+			logrus.Debugf("Image %s already present, skipping load and adding tags %v", imageID, tags)
+		} else if _, err := imageLoad(ctx, cli, imageRoot, imageID, out, quiet); err != nil {
+			return err
 		}
 		for _, t := range tags {
 			if err := tagImage(ctx, cli, imageID, t); err != nil {
@@ -408,27 +975,79 @@ func syncImages(ctx context.Context, cli DockerClient, imageRoot string, clean b
 	return nil
 }
 
-func imageLoad(ctx context.Context, cli DockerClient, imageRoot, imageID string) error {
-	tf, err := os.Open(filepath.Join(imageRoot, imageID+".tar"))
+// imageLoader is the subset of the docker client used
+// to load images
+type imageLoader interface {
+	ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error)
+}
+
+// loadedImagePrefix prefixes the image ID in image load output
+const loadedImagePrefix = "Loaded image ID: "
+
+// imageLoad loads a saved image, writing the load output to out and
+// returning the loaded image ID. Load progress is omitted by the
+// daemon when quiet.
+func imageLoad(ctx context.Context, cli imageLoader, imageRoot, imageID string, out io.Writer, quiet bool) (string, error) {
+	tf, err := openImageTar(imageRoot, imageID)
 	if err != nil {
-		return fmt.Errorf("error opening image tar %s: %v", imageID, err)
+		return "", fmt.Errorf("error opening image tar %s: %v", imageID, err)
 	}
 	defer tf.Close()
 
-	resp, err := cli.ImageLoad(ctx, tf, true)
+	resp, err := cli.ImageLoad(ctx, tf, quiet)
 	if err != nil {
-		return fmt.Errorf("error loading image %s: %v", imageID, err)
+		return "", fmt.Errorf("error loading image %s: %v", imageID, err)
+	}
+	if resp.Body == nil {
+		return imageID, nil
 	}
 	defer resp.Body.Close()
 
-	outFd, isTerminalOut := term.GetFdInfo(os.Stdout)
+	loadedID, err := displayLoad(resp, out)
+	if err != nil {
+		return "", fmt.Errorf("error loading image %s: %v", imageID, err)
+	}
+	if loadedID == "" {
+		// Daemons before 1.23 do not report the loaded image
+		loadedID = imageID
+	}
+	logrus.Debugf("Loaded image %s", loadedID)
+	return loadedID, nil
+}
 
-	if resp.Body != nil && resp.JSON {
-		return jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stdout, outFd, isTerminalOut, nil)
+// displayLoad writes the image load response to out, decoding
+// JSON messages when the response is JSON and copying lines
+// otherwise. The loaded image ID is returned when reported.
+func displayLoad(resp types.ImageLoadResponse, out io.Writer) (string, error) {
+	var loadedID string
+	if resp.JSON {
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var jm jsonmessage.JSONMessage
+			if err := dec.Decode(&jm); err != nil {
+				if err == io.EOF {
+					return loadedID, nil
+				}
+				return "", err
+			}
+			if err := jm.Display(out, false); err != nil {
+				return "", err
+			}
+			if strings.HasPrefix(jm.Stream, loadedImagePrefix) {
+				loadedID = strings.TrimSpace(strings.TrimPrefix(jm.Stream, loadedImagePrefix))
+			}
+		}
 	}
 
-	_, err = io.Copy(os.Stdout, resp.Body)
-	return err
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(out, line)
+		if strings.HasPrefix(line, loadedImagePrefix) {
+			loadedID = strings.TrimSpace(strings.TrimPrefix(line, loadedImagePrefix))
+		}
+	}
+	return loadedID, scanner.Err()
 }
 
 func filterRepoTags(tags []string) []string {
@@ -441,7 +1060,7 @@ func filterRepoTags(tags []string) []string {
 	return filtered
 }
 
-func tagImage(ctx context.Context, cli DockerClient, img, tag string) error {
+func tagImage(ctx context.Context, cli imageTagger, img, tag string) error {
 	ref, err := reference.Parse(tag)
 	if err != nil {
 		return fmt.Errorf("invalid tag %s: %v", tag, err)
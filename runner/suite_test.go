@@ -0,0 +1,501 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunTestsCaptureAndParse(t *testing.T) {
+	output := "1..3\nok 1 first\nnot ok 2 second\nok 3 third\n"
+	capturer := newBufferLogger()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{
+					Script: Script{
+						Command: []string{"printf", output},
+					},
+					Format: "tap",
+				},
+			},
+		},
+		TestCapturer: capturer,
+	})
+
+	if err := sr.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if capturer.stdout.String() != output {
+		t.Fatalf("Unexpected captured output %q, expected %q", capturer.stdout.String(), output)
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "first", Status: TestPassed},
+		{Name: "second", Status: TestFailed},
+		{Name: "third", Status: TestPassed},
+	})
+}
+
+func TestCleanDockerGraph(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	// Missing directory is created
+	root := filepath.Join(td, "docker")
+	if err := cleanDockerGraph(root); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(root); err != nil {
+		t.Fatalf("Expected graph directory to be created: %v", err)
+	} else if !info.IsDir() {
+		t.Fatalf("Expected graph directory to be a directory")
+	}
+
+	// Empty directory is left as is
+	if err := cleanDockerGraph(root); err != nil {
+		t.Fatal(err)
+	}
+
+	// Populated directory is cleaned
+	if err := os.MkdirAll(filepath.Join(root, "image", "overlay"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTempFile(t, root, "linkgraph.db", "")
+	if err := cleanDockerGraph(root); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info) != 0 {
+		t.Fatalf("Expected empty graph directory, found %d entries", len(info))
+	}
+}
+
+func TestRunTestsNoRunners(t *testing.T) {
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		TestCapturer: newBufferLogger(),
+	})
+	if err := sr.RunTests(); err == nil {
+		t.Fatal("Expected error running without test runners")
+	}
+
+	sr = NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			AllowNoTests: true,
+		},
+		TestCapturer: newBufferLogger(),
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatalf("Unexpected error with no tests allowed: %v", err)
+	}
+}
+
+func TestRunTestsAllowFailure(t *testing.T) {
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{
+					Script: Script{
+						Command: []string{"false"},
+					},
+					AllowFailure: true,
+				},
+				{
+					Script: Script{
+						Command: []string{"true"},
+					},
+				},
+			},
+		},
+		TestCapturer: newBufferLogger(),
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatalf("Unexpected error with allowed failure: %v", err)
+	}
+	if !sr.passed {
+		t.Fatal("Expected suite to pass with allowed failure")
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "false", Status: TestAllowedFailure},
+	})
+
+	sr = NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{
+					Script: Script{
+						Command: []string{"false"},
+					},
+				},
+			},
+		},
+		TestCapturer: newBufferLogger(),
+	})
+	if err := sr.RunTests(); err == nil {
+		t.Fatal("Expected error from failing command")
+	}
+	if sr.passed {
+		t.Fatal("Expected suite to fail")
+	}
+}
+
+func TestRunTestsRunAll(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-runall-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	marker := filepath.Join(td, "ran")
+
+	testRunners := []TestScript{
+		{
+			Script: Script{
+				Command: []string{"printf", "1..1\nnot ok 1 first\n"},
+			},
+			Format: "tap",
+		},
+		{
+			Script: Script{
+				Command: []string{"false"},
+			},
+		},
+		{
+			Script: Script{
+				Command: []string{"printf", "1..1\nok 1 second\n"},
+			},
+			Format: "tap",
+		},
+		{
+			Script: Script{
+				Command: []string{"touch", marker},
+			},
+		},
+	}
+
+	// Fail fast stops at the first failing command
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: testRunners,
+		},
+		TestCapturer: newBufferLogger(),
+	})
+	if err := sr.RunTests(); err == nil {
+		t.Fatal("Expected error from failing command")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("Expected commands after failure not to run: %v", err)
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "first", Status: TestFailed},
+	})
+
+	// Run all runs every command and aggregates failures
+	sr = NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: testRunners,
+			RunAll:     true,
+		},
+		TestCapturer: newBufferLogger(),
+	})
+	err = sr.RunTests()
+	if err == nil {
+		t.Fatal("Expected error from failing command")
+	}
+	if !strings.Contains(err.Error(), "1 of 4") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if sr.passed {
+		t.Fatal("Expected suite to fail")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("Expected all commands to run: %v", err)
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "first", Status: TestFailed},
+		{Name: "second", Status: TestPassed},
+	})
+}
+
+func TestRunTestsInstanceEnv(t *testing.T) {
+	capturer := newBufferLogger()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{Script: Script{Command: []string{"/bin/sh", "-c", "echo $GOLEM_INSTANCE_NAME $GOLEM_INSTANCE_INDEX $GOLEM_REGISTRY_VERSION"}}},
+			},
+			InstanceEnv: []string{"GOLEM_INSTANCE_NAME=registry-2", "GOLEM_INSTANCE_INDEX=2", "GOLEM_REGISTRY_VERSION=2.4.0"},
+		},
+		TestCapturer: capturer,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if out := capturer.stdout.String(); out != "registry-2 2 2.4.0\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+}
+
+func TestRunTestsWrapper(t *testing.T) {
+	capturer := newBufferLogger()
+	script := TestScript{
+		Script: Script{
+			Command: []string{"/bin/sh", "-c", "echo $WRAPPED $GORACE"},
+			Env:     []string{"GORACE=halt_on_error=0"},
+		},
+		Wrapper:    []string{"env", "WRAPPED=yes"},
+		WrapperEnv: []string{"GORACE=halt_on_error=1"},
+	}
+
+	cmd, err := testCommand(script, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmd.Args) != 5 || cmd.Args[0] != "env" || cmd.Args[2] != "/bin/sh" {
+		t.Fatalf("Unexpected wrapped command %v", cmd.Args)
+	}
+
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{script},
+		},
+		TestCapturer: capturer,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if out := capturer.stdout.String(); out != "yes halt_on_error=1\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+
+	script.Wrapper = []string{"golem-missing-wrapper"}
+	if _, err := testCommand(script, nil); err == nil {
+		t.Fatal("Expected error with missing wrapper")
+	}
+}
+
+func TestTestBatches(t *testing.T) {
+	runners := []TestScript{{}, {}, {Ordered: true}, {}, {}}
+	cases := []struct {
+		parallel int
+		expected [][]int
+	}{
+		{0, [][]int{{0}, {1}, {2}, {3}, {4}}},
+		{1, [][]int{{0}, {1}, {2}, {3}, {4}}},
+		{2, [][]int{{0, 1}, {2}, {3, 4}}},
+	}
+	for _, c := range cases {
+		if batches := testBatches(runners, c.parallel); !reflect.DeepEqual(batches, c.expected) {
+			t.Fatalf("Unexpected batches with parallel %d: %v, expected %v", c.parallel, batches, c.expected)
+		}
+	}
+}
+
+func TestRunTestsParallel(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-parallel-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	// Each command waits for the other to start, only
+	// passing when both run at the same time
+	waitFor := func(self, other string) TestScript {
+		script := fmt.Sprintf("touch %s; for i in $(seq 500); do [ -f %s ] && exit 0; sleep 0.01; done; exit 1", filepath.Join(td, self), filepath.Join(td, other))
+		return TestScript{Script: Script{Command: []string{"/bin/sh", "-c", script}}}
+	}
+
+	capturer := newBufferLogger()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{waitFor("a", "b"), waitFor("b", "a")},
+			Parallel:   2,
+		},
+		TestCapturer: capturer,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatalf("Expected commands to run concurrently: %v", err)
+	}
+}
+
+func TestRunTestsParallelOrdered(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-parallel-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	a, b := filepath.Join(td, "a"), filepath.Join(td, "b")
+	capturer := newBufferLogger()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{Script: Script{Command: []string{"/bin/sh", "-c", "sleep 0.2; touch " + a}}},
+				{Script: Script{Command: []string{"/bin/sh", "-c", "test -f " + a + " && touch " + b}}, Ordered: true},
+				{Script: Script{Command: []string{"test", "-f", b}}},
+			},
+			Parallel: 2,
+		},
+		TestCapturer: capturer,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatalf("Expected ordered command to run after previous commands: %v", err)
+	}
+}
+
+func TestRunTestsMinTests(t *testing.T) {
+	output := "1..3\nok 1 first\nok 2 second # SKIP not supported\nok 3 third\n"
+	runMin := func(min int) (*SuiteRunner, error) {
+		sr := NewSuiteRunner(SuiteRunnerConfiguration{
+			RunConfiguration: RunConfiguration{
+				TestRunner: []TestScript{
+					{Script: Script{Command: []string{"printf", output}}, Format: "tap"},
+				},
+				MinTests: min,
+			},
+			TestCapturer: newBufferLogger(),
+		})
+		return sr, sr.RunTests()
+	}
+
+	if _, err := runMin(2); err != nil {
+		t.Fatalf("Unexpected error meeting minimum tests: %v", err)
+	}
+
+	sr, err := runMin(3)
+	if err == nil {
+		t.Fatal("Expected error with fewer tests than minimum")
+	}
+	if !strings.Contains(err.Error(), "expected at least 3 tests, 2 ran") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sr.passed {
+		t.Fatal("Expected suite not to pass")
+	}
+}
+
+func TestRunTestsDefaultCommand(t *testing.T) {
+	run := func(rc RunConfiguration) (string, error) {
+		capturer := newBufferLogger()
+		sr := NewSuiteRunner(SuiteRunnerConfiguration{
+			RunConfiguration: rc,
+			TestCapturer:     capturer,
+			DefaultCommand:   []string{"echo", "default"},
+		})
+		err := sr.RunTests()
+		return capturer.stdout.String(), err
+	}
+
+	out, err := run(RunConfiguration{})
+	if err != nil {
+		t.Fatalf("Unexpected error running default command: %v", err)
+	}
+	if out != "default\n" {
+		t.Fatalf("Unexpected default command output %q", out)
+	}
+
+	out, err = run(RunConfiguration{
+		TestRunner: []TestScript{
+			{Script: Script{Command: []string{"echo", "configured"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "configured\n" {
+		t.Fatalf("Unexpected output %q, expected only configured command", out)
+	}
+
+	out, err = run(RunConfiguration{AllowNoTests: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "default\n" {
+		t.Fatalf("Unexpected output %q, expected default command when running without tests is allowed", out)
+	}
+
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{TestCapturer: newBufferLogger()})
+	if err := sr.RunTests(); err == nil {
+		t.Fatal("Expected error without test runner or default command")
+	}
+
+	capturer := newBufferLogger()
+	sr = NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{AllowNoTests: true},
+		TestCapturer:     capturer,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatalf("Unexpected error running without tests: %v", err)
+	}
+	if out := capturer.stdout.String(); out != "" {
+		t.Fatalf("Unexpected output %q without default command", out)
+	}
+}
+
+func TestRunTestsResultsFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	report := `<testsuite><testcase classname="pkg" name="TestA"/><testcase classname="pkg" name="TestB"><failure message="boom"/></testcase></testsuite>`
+	resultsFile := filepath.Join(td, "junit.xml")
+	run := func(command string, stdoutFormat string) (*SuiteRunner, error) {
+		sr := NewSuiteRunner(SuiteRunnerConfiguration{
+			RunConfiguration: RunConfiguration{
+				TestRunner: []TestScript{
+					{
+						Script:        Script{Command: []string{"sh", "-c", command}},
+						Format:        stdoutFormat,
+						ResultsFile:   resultsFile,
+						ResultsFormat: "junit",
+					},
+				},
+				RunAll: true,
+			},
+			TestCapturer: newBufferLogger(),
+		})
+		return sr, sr.RunTests()
+	}
+
+	// Report written by the command is parsed
+	sr, err := run(fmt.Sprintf("printf '%s' > %s; exit 1", report, resultsFile), "")
+	if err == nil {
+		t.Fatal("Expected error from failing command")
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "pkg.TestA", Status: TestPassed},
+		{Name: "pkg.TestB", Status: TestFailed, Output: "boom"},
+	})
+
+	// Output is also parsed when given a format
+	sr, err = run(fmt.Sprintf("echo 'ok 1 stdout'; printf '%s' > %s", report, resultsFile), "tap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "stdout", Status: TestPassed},
+		{Name: "pkg.TestA", Status: TestPassed},
+		{Name: "pkg.TestB", Status: TestFailed, Output: "boom"},
+	})
+
+	// Report from a previous run is removed, a crashed command
+	// not writing the report is a suite error
+	_, err = run("exit 2", "")
+	if err == nil {
+		t.Fatal("Expected error for missing results file")
+	}
+	if !strings.Contains(err.Error(), "was not written") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	// DefaultMaxSuiteBytes is the default maximum total size
+	// of the files in a suite directory
+	DefaultMaxSuiteBytes = 1 << 30
+
+	// DefaultMaxSuiteFiles is the default maximum number of
+	// files in a suite directory
+	DefaultMaxSuiteFiles = 100000
+)
+
+// SuiteSizeLimit limits the size of a suite directory copied
+// into the test images. A zero value field is not limited.
+type SuiteSizeLimit struct {
+	MaxBytes int64
+	MaxFiles int
+}
+
+func (l SuiteSizeLimit) String() string {
+	files, bytes := "unlimited files", "unlimited size"
+	if l.MaxFiles > 0 {
+		files = fmt.Sprintf("%d files", l.MaxFiles)
+	}
+	if l.MaxBytes > 0 {
+		bytes = units.BytesSize(float64(l.MaxBytes))
+	}
+	return files + " and " + bytes
+}
+
+// ByteSize is a size in bytes which may be used as a flag
+// value, accepting human readable sizes such as "500m".
+type ByteSize int64
+
+func (s *ByteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+// Set sets the size from a human readable string
+func (s *ByteSize) Set(value string) error {
+	n, err := units.RAMInBytes(value)
+	if err != nil {
+		return err
+	}
+	*s = ByteSize(n)
+	return nil
+}
+
+var errSuiteTooLarge = errors.New("suite directory too large")
+
+// checkSuiteSize walks the suite directory, returning an error
+// as soon as the directory exceeds the limit. Symlinks are
+// counted but not followed, matching how the suite is copied.
+func checkSuiteSize(path string, limit SuiteSizeLimit) error {
+	if limit.MaxBytes <= 0 && limit.MaxFiles <= 0 {
+		return nil
+	}
+
+	var (
+		size  int64
+		files int
+	)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		files++
+		size += info.Size()
+		if (limit.MaxFiles > 0 && files > limit.MaxFiles) || (limit.MaxBytes > 0 && size > limit.MaxBytes) {
+			return errSuiteTooLarge
+		}
+		return nil
+	})
+	if err == errSuiteTooLarge {
+		return fmt.Errorf("suite directory %s is too large, found at least %d files totalling %s which exceeds the limit of %s: check the suite path or raise the limit with -max-suite-files and -max-suite-size", path, files, units.BytesSize(float64(size)), limit)
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSuiteSize(t *testing.T) {
+	td, err := ioutil.TempDir("", "golem-suite-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+
+	for i := 0; i < 4; i++ {
+		writeTempFile(t, td, fmt.Sprintf("test-%d.bats", i), strings.Repeat("x", 100))
+	}
+	if err := os.Mkdir(filepath.Join(td, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTempFile(t, filepath.Join(td, "lib"), "helpers.bash", strings.Repeat("x", 100))
+
+	// 5 files totalling 500 bytes
+	under := []SuiteSizeLimit{
+		{MaxBytes: 500, MaxFiles: 5},
+		{MaxBytes: 1000},
+		{MaxFiles: 10},
+		{},
+	}
+	for _, limit := range under {
+		if err := checkSuiteSize(td, limit); err != nil {
+			t.Fatalf("Unexpected error with limit %s: %v", limit, err)
+		}
+	}
+
+	over := []SuiteSizeLimit{
+		{MaxBytes: 499, MaxFiles: 5},
+		{MaxBytes: 500, MaxFiles: 4},
+		{MaxFiles: 1},
+	}
+	for _, limit := range over {
+		err := checkSuiteSize(td, limit)
+		if err == nil {
+			t.Fatalf("Expected error with limit %s", limit)
+		}
+		if !strings.Contains(err.Error(), td) || !strings.Contains(err.Error(), "-max-suite-size") {
+			t.Fatalf("Expected error to name the suite path and limit flags: %v", err)
+		}
+	}
+
+	if err := checkSuiteSize(filepath.Join(td, "missing"), SuiteSizeLimit{MaxFiles: 1}); err == nil {
+		t.Fatal("Expected error for missing suite directory")
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	var s ByteSize
+	if err := s.Set("500m"); err != nil {
+		t.Fatal(err)
+	}
+	if s != 500*1024*1024 {
+		t.Fatalf("Unexpected size %d", s)
+	}
+	if err := s.Set("lots"); err == nil {
+		t.Fatal("Expected error for invalid size")
+	}
+}
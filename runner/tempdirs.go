@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// TempDirs is a registry of temporary directories created
+// during a run, allowing them to be removed when the run is
+// interrupted before deferred removals are run. A nil
+// registry creates and removes directories without tracking.
+type TempDirs struct {
+	root string
+
+	l    sync.Mutex
+	dirs map[string]struct{}
+}
+
+// NewTempDirs creates an empty temporary directory registry
+// creating directories under root. An empty root uses the
+// default temporary directory, which respects TMPDIR.
+func NewTempDirs(root string) *TempDirs {
+	return &TempDirs{
+		root: root,
+		dirs: map[string]struct{}{},
+	}
+}
+
+// Root returns the directory temporary directories are
+// created in.
+func (td *TempDirs) Root() string {
+	if td == nil || td.root == "" {
+		return os.TempDir()
+	}
+	return td.root
+}
+
+// Create creates a new temporary directory with the
+// given prefix and registers it for removal.
+func (td *TempDirs) Create(prefix string) (string, error) {
+	dir, err := ioutil.TempDir(td.Root(), prefix)
+	if err != nil {
+		return "", err
+	}
+	if td != nil {
+		td.l.Lock()
+		td.dirs[dir] = struct{}{}
+		td.l.Unlock()
+	}
+	return dir, nil
+}
+
+// Remove removes the temporary directory and
+// unregisters it.
+func (td *TempDirs) Remove(dir string) error {
+	if td != nil {
+		td.l.Lock()
+		delete(td.dirs, dir)
+		td.l.Unlock()
+	}
+	return os.RemoveAll(dir)
+}
+
+// RemoveAll removes all registered temporary directories.
+func (td *TempDirs) RemoveAll() {
+	if td == nil {
+		return
+	}
+	td.l.Lock()
+	defer td.l.Unlock()
+	for dir := range td.dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			logrus.Errorf("Error removing temporary directory %s: %v", dir, err)
+		}
+		delete(td.dirs, dir)
+	}
+}
+
+// RemoveOnSignal removes all registered temporary directories
+// and exits when one of the signals is received. The exit status
+// is 128 plus the signal number, as for a process killed by it.
+func (td *TempDirs) RemoveOnSignal(signals ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	go func() {
+		s := <-c
+		logrus.Infof("Received %s, removing temporary directories", s)
+		td.RemoveAll()
+		os.Exit(signalExitStatus(s))
+	}()
+}
+
+// signalExitStatus returns the exit status of a process
+// terminated by the signal.
+func signalExitStatus(s os.Signal) int {
+	if sig, ok := s.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+// FatalHook returns a logrus hook which removes all registered
+// temporary directories when a fatal error is logged. Logging a
+// fatal error exits without running deferred removals.
+func (td *TempDirs) FatalHook() logrus.Hook {
+	return tempDirsHook{td}
+}
+
+type tempDirsHook struct {
+	td *TempDirs
+}
+
+func (h tempDirsHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.FatalLevel}
+}
+
+func (h tempDirsHook) Fire(*logrus.Entry) error {
+	h.td.RemoveAll()
+	return nil
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestTempDirs(t *testing.T) {
+	td := NewTempDirs("")
+
+	var dirs []string
+	for i := 0; i < 3; i++ {
+		dir, err := td.Create("golem-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTempFile(t, dir, "image.tar", "image content")
+		dirs = append(dirs, dir)
+	}
+
+	// Removed directories are unregistered
+	if err := td.Remove(dirs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(td.dirs) != 2 {
+		t.Fatalf("Unexpected registered directories %v", td.dirs)
+	}
+
+	td.RemoveAll()
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed: %v", dir, err)
+		}
+	}
+	if len(td.dirs) != 0 {
+		t.Fatalf("Unexpected registered directories after removal %v", td.dirs)
+	}
+
+	// Nil registry still creates and removes directories
+	var nilDirs *TempDirs
+	dir, err := nilDirs.Create("golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nilDirs.RemoveAll()
+	if err := nilDirs.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed: %v", dir, err)
+	}
+}
+
+func TestTempDirsRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "golem-root-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	td := NewTempDirs(root)
+	if td.Root() != root {
+		t.Fatalf("Unexpected root %s, expected %s", td.Root(), root)
+	}
+	dir, err := td.Create("golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer td.Remove(dir)
+	if filepath.Dir(dir) != root {
+		t.Fatalf("Expected %s to be created in %s", dir, root)
+	}
+
+	// Empty root uses TMPDIR
+	tmpdir := filepath.Join(root, "tmpdir")
+	if err := os.Mkdir(tmpdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldTmpdir, set := os.LookupEnv("TMPDIR")
+	os.Setenv("TMPDIR", tmpdir)
+	defer func() {
+		if set {
+			os.Setenv("TMPDIR", oldTmpdir)
+		} else {
+			os.Unsetenv("TMPDIR")
+		}
+	}()
+
+	for _, td := range []*TempDirs{NewTempDirs(""), nil} {
+		dir, err := td.Create("golem-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(dir) != tmpdir {
+			t.Fatalf("Expected %s to be created in %s", dir, tmpdir)
+		}
+		if err := td.Remove(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTempDirsFatalHook(t *testing.T) {
+	td := NewTempDirs("")
+	dir, err := td.Create("golem-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hook := td.FatalHook()
+	if levels := hook.Levels(); len(levels) != 1 || levels[0] != logrus.FatalLevel {
+		t.Fatalf("Unexpected hook levels %v", levels)
+	}
+	if err := hook.Fire(logrus.NewEntry(logrus.New())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be removed: %v", dir, err)
+	}
+}
+
+func TestSignalExitStatus(t *testing.T) {
+	if status := signalExitStatus(os.Interrupt); status != 130 {
+		t.Fatalf("Unexpected interrupt exit status %d, expected 130", status)
+	}
+	if status := signalExitStatus(syscall.SIGTERM); status != 143 {
+		t.Fatalf("Unexpected terminate exit status %d, expected 143", status)
+	}
+}
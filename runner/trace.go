@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Tracer is a command prefixed to traced commands, such as
+// strace or ltrace, which may be used as a flag value.
+type Tracer []string
+
+func (t *Tracer) String() string {
+	return strings.Join(*t, " ")
+}
+
+// Set sets the tracer command from a space separated string
+func (t *Tracer) Set(value string) error {
+	*t = strings.Fields(value)
+	return nil
+}
+
+// traceCommand returns the command wrapped by the tracer,
+// returning an error if the tracer cannot be found. The
+// command is returned unchanged when there is no tracer.
+func traceCommand(tracer []string, cmd *exec.Cmd) (*exec.Cmd, error) {
+	if len(tracer) == 0 {
+		return cmd, nil
+	}
+	if _, err := exec.LookPath(tracer[0]); err != nil {
+		return nil, fmt.Errorf("invalid tracer %s: %v", tracer[0], err)
+	}
+	args := append(append(append([]string{}, tracer[1:]...), cmd.Path), cmd.Args[1:]...)
+	traced := exec.Command(tracer[0], args...)
+	traced.Env = cmd.Env
+	traced.Dir = cmd.Dir
+	traced.Stdin = cmd.Stdin
+	traced.Stdout = cmd.Stdout
+	traced.Stderr = cmd.Stderr
+	return traced, nil
+}
+
+// attachTraceOutput routes output the command writes to file
+// descriptor 3 to w, allowing tracers to separate their output
+// from the traced command with options such as strace's
+// "-o /dev/fd/3". The returned function must be called once the
+// command is started, even if starting failed. The returned
+// channel is closed once all trace output has been copied.
+func attachTraceOutput(cmd *exec.Cmd, w io.Writer) (func(), <-chan struct{}, error) {
+	if len(cmd.ExtraFiles) > 0 {
+		return nil, nil, errors.New("trace output requires no extra files")
+	}
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer r.Close()
+		if _, err := io.Copy(w, r); err != nil {
+			logrus.Errorf("Error copying trace output: %v", err)
+		}
+	}()
+	return func() {
+		pw.Close()
+	}, done, nil
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunTestsTraceOutput(t *testing.T) {
+	capturer := newBufferLogger()
+	traceCapturer := newBufferLogger()
+	sr := NewSuiteRunner(SuiteRunnerConfiguration{
+		RunConfiguration: RunConfiguration{
+			TestRunner: []TestScript{
+				{Script: Script{Command: []string{"echo", "test output"}}},
+			},
+		},
+		TestCapturer:  capturer,
+		Tracer:        []string{"sh", "-c", `echo "traced $0" >&3; exec "$0" "$@"`},
+		TraceTests:    true,
+		TraceCapturer: traceCapturer,
+	})
+	if err := sr.RunTests(); err != nil {
+		t.Fatal(err)
+	}
+	if out := capturer.stdout.String(); out != "test output\n" {
+		t.Fatalf("Unexpected test output %q", out)
+	}
+	if out := traceCapturer.stdout.String(); !strings.HasPrefix(out, "traced ") || !strings.Contains(out, "echo") {
+		t.Fatalf("Unexpected trace output %q", out)
+	}
+}
@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+)
+
+const (
+	// golemLabel is set on all resources created by golem
+	golemLabel = "com.docker.golem"
+
+	// runLabel is set to the id of the run which
+	// created the resource
+	runLabel = "golem.run"
+)
+
+// NewRunID returns a random identifier for a run
+func NewRunID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		logrus.Panicf("Error reading random bytes: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// graphVolumeRequest returns the create request for the docker graph
+// volume of a test container, labeled as owned by golem and the run.
+func graphVolumeRequest(name, runID string) types.VolumeCreateRequest {
+	return types.VolumeCreateRequest{
+		Name:   name,
+		Driver: "local",
+		Labels: map[string]string{
+			golemLabel: "true",
+			runLabel:   runID,
+		},
+	}
+}
+
+// selectGolemVolumes returns the volumes labeled as created by golem,
+// limited to the provided run id when not empty.
+func selectGolemVolumes(volumes []*types.Volume, runID string) []*types.Volume {
+	var selected []*types.Volume
+	for _, vol := range volumes {
+		if vol == nil || vol.Labels[golemLabel] != "true" {
+			continue
+		}
+		if runID != "" && vol.Labels[runLabel] != runID {
+			continue
+		}
+		selected = append(selected, vol)
+	}
+	return selected
+}
+
+// volumePruner is the subset of the docker client
+// used to list and remove volumes.
+type volumePruner interface {
+	VolumeList(ctx context.Context, filter filters.Args) (types.VolumesListResponse, error)
+	VolumeRemove(ctx context.Context, volumeID string) error
+}
+
+// PruneVolumes removes volumes created by golem, limited to the volumes
+// of the provided run id when not empty. Volumes without the golem label
+// are never removed. Returns the names of the removed volumes.
+func PruneVolumes(ctx context.Context, cli DockerClient, runID string) ([]string, error) {
+	return pruneVolumes(ctx, cli, runID)
+}
+
+func pruneVolumes(ctx context.Context, cli volumePruner, runID string) ([]string, error) {
+	filter := filters.NewArgs()
+	filter.Add("label", golemLabel+"=true")
+	resp, err := cli.VolumeList(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumes: %v", err)
+	}
+	for _, warning := range resp.Warnings {
+		logrus.Warnf("Volume list warning: %s", warning)
+	}
+
+	// Older daemons ignore the label filter, always select by label
+	var removed []string
+	for _, vol := range selectGolemVolumes(resp.Volumes, runID) {
+		if err := cli.VolumeRemove(ctx, vol.Name); err != nil {
+			logrus.Errorf("Error removing volume %s: %v", vol.Name, err)
+			continue
+		}
+		logrus.Debugf("Removed volume %s", vol.Name)
+		removed = append(removed, vol.Name)
+	}
+
+	return removed, nil
+}
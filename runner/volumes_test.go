@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+)
+
+func TestGraphVolumeLabels(t *testing.T) {
+	req := graphVolumeRequest("golem-registry-graph", "abc123")
+	if req.Name != "golem-registry-graph" {
+		t.Fatalf("Unexpected volume name %q", req.Name)
+	}
+	if req.Labels[golemLabel] != "true" {
+		t.Fatalf("Missing golem label: %v", req.Labels)
+	}
+	if req.Labels[runLabel] != "abc123" {
+		t.Fatalf("Missing run label: %v", req.Labels)
+	}
+}
+
+type fakeVolumePruner struct {
+	volumes []*types.Volume
+	removed []string
+}
+
+func (f *fakeVolumePruner) VolumeList(ctx context.Context, filter filters.Args) (types.VolumesListResponse, error) {
+	// Behave as an older daemon ignoring the label filter
+	return types.VolumesListResponse{Volumes: f.volumes}, nil
+}
+
+func (f *fakeVolumePruner) VolumeRemove(ctx context.Context, volumeID string) error {
+	f.removed = append(f.removed, volumeID)
+	return nil
+}
+
+func TestPruneVolumes(t *testing.T) {
+	volumes := []*types.Volume{
+		{Name: "golem-a-graph", Labels: map[string]string{golemLabel: "true", runLabel: "run1"}},
+		{Name: "golem-b-graph", Labels: map[string]string{golemLabel: "true", runLabel: "run2"}},
+		{Name: "golem-unlabeled-graph"},
+		{Name: "other", Labels: map[string]string{"com.example": "true"}},
+	}
+
+	cases := []struct {
+		runID    string
+		expected []string
+	}{
+		{"", []string{"golem-a-graph", "golem-b-graph"}},
+		{"run2", []string{"golem-b-graph"}},
+		{"run3", nil},
+	}
+	for _, c := range cases {
+		f := &fakeVolumePruner{volumes: volumes}
+		removed, err := pruneVolumes(context.Background(), f, c.runID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removed) != len(c.expected) || len(f.removed) != len(c.expected) {
+			t.Fatalf("Unexpected removed volumes for run %q: %v, expected %v", c.runID, f.removed, c.expected)
+		}
+		for i := range c.expected {
+			if removed[i] != c.expected[i] || f.removed[i] != c.expected[i] {
+				t.Fatalf("Unexpected removed volume %d for run %q: %s, expected %s", i, c.runID, f.removed[i], c.expected[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// xfailMatcher matches test names against the expected
+// failure patterns of a suite. Each pattern is a regular
+// expression matching the whole test name.
+type xfailMatcher []*regexp.Regexp
+
+// newXFailMatcher compiles the expected failure patterns,
+// returning an error for an empty or invalid pattern.
+func newXFailMatcher(patterns []string) (xfailMatcher, error) {
+	m := make(xfailMatcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return nil, fmt.Errorf("xfail pattern must not be empty")
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid xfail pattern %q: %v", pattern, err)
+		}
+		m = append(m, re)
+	}
+	return m, nil
+}
+
+func (m xfailMatcher) match(name string) bool {
+	for _, re := range m {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply marks failing tests matching an expected failure
+// pattern as expected failures and passing tests as
+// unexpected passes. Results are updated in place.
+func (m xfailMatcher) apply(results []TestResult) {
+	if len(m) == 0 {
+		return
+	}
+	for i := range results {
+		if !m.match(results[i].Name) {
+			continue
+		}
+		switch results[i].Status {
+		case TestFailed:
+			results[i].Status = TestExpectedFailure
+		case TestPassed:
+			results[i].Status = TestUnexpectedPass
+		}
+	}
+}
+
+// onlyExpectedFailures returns whether the results have at
+// least one expected failure and no other failing tests,
+// meaning a failing command only failed as expected.
+func onlyExpectedFailures(results []TestResult) bool {
+	var expected bool
+	for _, result := range results {
+		switch result.Status {
+		case TestFailed:
+			return false
+		case TestExpectedFailure:
+			expected = true
+		}
+	}
+	return expected
+}
+
+// unexpectedPasses returns the names of the tests which
+// passed while expected to fail.
+func unexpectedPasses(results []TestResult) []string {
+	var names []string
+	for _, result := range results {
+		if result.Status == TestUnexpectedPass {
+			names = append(names, result.Name)
+		}
+	}
+	return names
+}
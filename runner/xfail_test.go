@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXFailMatcher(t *testing.T) {
+	m, err := newXFailMatcher([]string{"TestBroken", "TestNetwork/.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []TestResult{
+		{Name: "TestBroken", Status: TestFailed},
+		{Name: "TestNetwork/bridge", Status: TestPassed},
+		{Name: "TestNetwork/host", Status: TestSkipped},
+		{Name: "TestBrokenToo", Status: TestFailed},
+		{Name: "TestOther", Status: TestPassed},
+	}
+	m.apply(results)
+	checkResults(t, results, []TestResult{
+		{Name: "TestBroken", Status: TestExpectedFailure},
+		{Name: "TestNetwork/bridge", Status: TestUnexpectedPass},
+		{Name: "TestNetwork/host", Status: TestSkipped},
+		{Name: "TestBrokenToo", Status: TestFailed},
+		{Name: "TestOther", Status: TestPassed},
+	})
+
+	for _, pattern := range []string{"", "Test("} {
+		if _, err := newXFailMatcher([]string{pattern}); err == nil {
+			t.Fatalf("Expected error for xfail pattern %q", pattern)
+		}
+	}
+}
+
+func TestRunTestsXFail(t *testing.T) {
+	run := func(output string, strict bool) (*SuiteRunner, error) {
+		sr := NewSuiteRunner(SuiteRunnerConfiguration{
+			RunConfiguration: RunConfiguration{
+				TestRunner: []TestScript{
+					{
+						Script: Script{
+							Command: []string{"sh", "-c", `printf "$0"; exit 1`, output},
+						},
+						Format: "tap",
+					},
+				},
+				XFail:       []string{"second"},
+				XFailStrict: strict,
+			},
+			TestCapturer: newBufferLogger(),
+		})
+		return sr, sr.RunTests()
+	}
+
+	// Failing test matching is an expected failure
+	sr, err := run("1..2\nok 1 first\nnot ok 2 second\n", false)
+	if err != nil {
+		t.Fatalf("Unexpected error with only expected failures: %v", err)
+	}
+	if !sr.passed {
+		t.Fatal("Expected suite to pass")
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "first", Status: TestPassed},
+		{Name: "second", Status: TestExpectedFailure},
+	})
+
+	// Other failures still fail the suite
+	if _, err := run("1..2\nnot ok 1 first\nnot ok 2 second\n", false); err == nil {
+		t.Fatal("Expected error with unexpected failure")
+	}
+
+	// Passing test matching is an unexpected pass
+	sr, err = run("1..2\nok 1 first\nok 2 second\n", false)
+	if err == nil {
+		t.Fatal("Expected error from failing command without expected failures")
+	}
+	checkResults(t, sr.Results(), []TestResult{
+		{Name: "first", Status: TestPassed},
+		{Name: "second", Status: TestUnexpectedPass},
+	})
+}
+
+func TestRunTestsXFailStrict(t *testing.T) {
+	run := func(strict bool) (*SuiteRunner, error) {
+		sr := NewSuiteRunner(SuiteRunnerConfiguration{
+			RunConfiguration: RunConfiguration{
+				TestRunner: []TestScript{
+					{Script: Script{Command: []string{"printf", "1..2\nok 1 first\nok 2 second\n"}}, Format: "tap"},
+				},
+				XFail:       []string{"second"},
+				XFailStrict: strict,
+			},
+			TestCapturer: newBufferLogger(),
+		})
+		return sr, sr.RunTests()
+	}
+
+	sr, err := run(false)
+	if err != nil {
+		t.Fatalf("Unexpected error with unexpected pass: %v", err)
+	}
+	if !sr.passed {
+		t.Fatal("Expected suite to pass when not strict")
+	}
+
+	sr, err = run(true)
+	if err == nil {
+		t.Fatal("Expected error with unexpected pass when strict")
+	}
+	if !strings.Contains(err.Error(), "1 tests expected to fail passed: second") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sr.passed {
+		t.Fatal("Expected suite not to pass when strict")
+	}
+}
@@ -0,0 +1,112 @@
+package versionutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// versionOperators are the supported comparison operators,
+// longer operators are listed first to match before their
+// prefixes.
+var versionOperators = []string{">=", "<=", ">", "<", "="}
+
+type versionClause struct {
+	op      string
+	version Version
+}
+
+func (c versionClause) matches(v Version) bool {
+	switch c.op {
+	case ">=":
+		return !v.LessThan(c.version)
+	case ">":
+		return c.version.LessThan(v)
+	case "<=":
+		return !c.version.LessThan(v)
+	case "<":
+		return v.LessThan(c.version)
+	default:
+		return !v.LessThan(c.version) && !c.version.LessThan(v)
+	}
+}
+
+func (c versionClause) String() string {
+	return c.op + c.version.String()
+}
+
+// VersionConstraint is a set of version comparisons which
+// must all be satisfied, such as ">=1.10.0,<1.13.0". An
+// empty constraint is satisfied by every version.
+type VersionConstraint struct {
+	clauses []versionClause
+}
+
+// ParseVersionConstraint parses a comma separated list of
+// comparisons of an operator (>=, >, <=, < or =) and a
+// version. A version without an operator must match exactly.
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	var vc VersionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op := "="
+		for _, o := range versionOperators {
+			if strings.HasPrefix(part, o) {
+				op = o
+				part = strings.TrimSpace(part[len(o):])
+				break
+			}
+		}
+		v, err := ParseVersion(part)
+		if err != nil {
+			return VersionConstraint{}, fmt.Errorf("invalid version %q in constraint: %v", part, err)
+		}
+		vc.clauses = append(vc.clauses, versionClause{op: op, version: v})
+	}
+	return vc, nil
+}
+
+// AtLeast returns a constraint requiring a minimum version.
+func AtLeast(v Version) VersionConstraint {
+	return VersionConstraint{
+		clauses: []versionClause{{op: ">=", version: v}},
+	}
+}
+
+// And returns a constraint requiring both constraints.
+func (vc VersionConstraint) And(other VersionConstraint) VersionConstraint {
+	clauses := make([]versionClause, 0, len(vc.clauses)+len(other.clauses))
+	clauses = append(clauses, vc.clauses...)
+	clauses = append(clauses, other.clauses...)
+	return VersionConstraint{clauses: clauses}
+}
+
+// Matches returns whether the version satisfies the constraint.
+func (vc VersionConstraint) Matches(v Version) bool {
+	for _, c := range vc.clauses {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (vc VersionConstraint) String() string {
+	clauses := make([]string, len(vc.clauses))
+	for i, c := range vc.clauses {
+		clauses[i] = c.String()
+	}
+	return strings.Join(clauses, ",")
+}
+
+// Set parses the constraint, allowing it to be used as a flag value
+func (vc *VersionConstraint) Set(s string) error {
+	parsed, err := ParseVersionConstraint(s)
+	if err != nil {
+		return err
+	}
+	*vc = parsed
+	return nil
+}
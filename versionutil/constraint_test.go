@@ -0,0 +1,47 @@
+package versionutil
+
+import "testing"
+
+func TestVersionConstraint(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{">=1.10.0,<1.13.0", "1.9.1", false},
+		{">=1.10.0,<1.13.0", "1.10.0", true},
+		{">=1.10.0,<1.13.0", "1.12.3", true},
+		{">=1.10.0,<1.13.0", "1.13.0-rc1", true},
+		{">=1.10.0,<1.13.0", "1.13.0", false},
+		{">1.10.0", "1.10.0", false},
+		{"<=1.12.0", "1.12.0", true},
+		{"1.11.2", "1.11.2", true},
+		{"=1.11.2", "1.11.1", false},
+		{"", "0.1.0", true},
+	}
+	for _, c := range cases {
+		vc, err := ParseVersionConstraint(c.constraint)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", c.constraint, err)
+		}
+		v, err := ParseVersion(c.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vc.Matches(v) != c.expected {
+			t.Fatalf("Unexpected match of %s with %q, expected %t", c.version, c.constraint, c.expected)
+		}
+	}
+
+	if _, err := ParseVersionConstraint(">=latest"); err == nil {
+		t.Fatal("Expected error parsing invalid version")
+	}
+
+	vc := AtLeast(StaticVersion(1, 10, 0)).And(VersionConstraint{})
+	if err := vc.Set("<1.13.0"); err != nil {
+		t.Fatal(err)
+	}
+	if vc.String() != "<1.13.0" {
+		t.Fatalf("Unexpected constraint %s", vc)
+	}
+}
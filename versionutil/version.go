@@ -100,7 +100,38 @@ func (v Version) LessThan(v2 Version) bool {
 	return v.Commit < v2.Commit
 }
 
-var versionOutput = regexp.MustCompile(`Docker version ([a-z0-9-.]+), build ([a-f0-9]+(?:-dirty)?)`)
+var (
+	// versionOutput matches the version line printed by docker,
+	// dockerd and related binaries, such as
+	// "Docker version 1.12.1, build 23cf638". Any fields after
+	// the build, such as build dates, are ignored.
+	versionOutput = regexp.MustCompile(`^(?:\S+) version v?([0-9][^\s,]*)(?:, build ([^\s,]+))?`)
+
+	// buildCommit matches the commit at the start of a build field
+	buildCommit = regexp.MustCompile(`^[0-9a-f]+(?:-dirty)?`)
+)
+
+// ParseBinaryVersion parses the output of a Docker binary run with
+// "--version", using the first line containing a version. The
+// commit is taken from the build field when it starts with a commit,
+// builds such as "49bf474-unsupported" use the leading commit.
+func ParseBinaryVersion(output string) (Version, error) {
+	for _, line := range strings.Split(output, "\n") {
+		matches := versionOutput.FindStringSubmatch(strings.TrimSpace(line))
+		if len(matches) != 3 {
+			continue
+		}
+		v, err := ParseVersion(matches[1])
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid version %q: %v", matches[1], err)
+		}
+		if commit := buildCommit.FindString(matches[2]); commit != "" {
+			v.Commit = commit
+		}
+		return v, nil
+	}
+	return Version{}, fmt.Errorf("unexpected response from version: %s", strings.TrimSpace(output))
+}
 
 // BinaryVersion gets the Docker version for the provided Docker binary
 func BinaryVersion(executable string) (Version, error) {
@@ -110,17 +141,7 @@ func BinaryVersion(executable string) (Version, error) {
 		return Version{}, err
 	}
 
-	matches := versionOutput.FindStringSubmatch(strings.TrimSpace(string(out)))
-	if len(matches) != 3 {
-		return Version{}, fmt.Errorf("unexpected response from version: %s", string(out))
-	}
-	v, err := ParseVersion(matches[1])
-	if err != nil {
-		return Version{}, err
-	}
-	v.Commit = matches[2]
-
-	return v, nil
+	return ParseBinaryVersion(string(out))
 }
 
 // StaticVersion returns a version object for the given
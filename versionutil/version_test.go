@@ -111,3 +111,74 @@ func TestOrdering(t *testing.T) {
 		}
 	}
 }
+
+func TestParseBinaryVersion(t *testing.T) {
+	cases := []struct {
+		Output   string
+		Expected Version
+	}{
+		{
+			// docker client
+			Output: "Docker version 1.12.1, build 23cf638\n",
+			Expected: Version{
+				Name:          "1.12.1",
+				VersionNumber: [3]int{1, 12, 1},
+				Commit:        "23cf638",
+			},
+		},
+		{
+			// dockerd release
+			Output: "Docker version 17.03.1-ce, build c6d412e\n",
+			Expected: Version{
+				Name:          "17.03.1-ce",
+				VersionNumber: [3]int{17, 3, 1},
+				Tag:           "ce",
+				Commit:        "c6d412e",
+			},
+		},
+		{
+			// dockerd development build with extra fields
+			Output: "Docker version 1.13.0-dev, build 49bf474-unsupported, experimental\n",
+			Expected: Version{
+				Name:          "1.13.0-dev",
+				VersionNumber: [3]int{1, 13, 0},
+				Tag:           "dev",
+				Commit:        "49bf474",
+			},
+		},
+		{
+			// docker-load build with a build date
+			Output: "docker-load version v0.1.0-dev, build 9a8b7c6-dirty, built 2016-08-18T05:21:00Z\n",
+			Expected: Version{
+				Name:          "0.1.0-dev",
+				VersionNumber: [3]int{0, 1, 0},
+				Tag:           "dev",
+				Commit:        "9a8b7c6-dirty",
+			},
+		},
+		{
+			// distribution packaged build with warnings
+			Output: "WARNING: Error loading config file: .docker/config.json\nDocker version 1.6.2, build 7c8fca2/1.6.2\n",
+			Expected: Version{
+				Name:          "1.6.2",
+				VersionNumber: [3]int{1, 6, 2},
+				Commit:        "7c8fca2",
+			},
+		},
+	}
+	for _, tc := range cases {
+		v, err := ParseBinaryVersion(tc.Output)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", tc.Output, err)
+		}
+		if v != tc.Expected {
+			t.Errorf("Mismatched version value for %q\n\tActual: %#v\n\tExpected: %#v", tc.Output, v, tc.Expected)
+		}
+	}
+
+	for _, output := range []string{"", "flag provided but not defined: -version\n", "Docker version unknown, build 23cf638\n"} {
+		if _, err := ParseBinaryVersion(output); err == nil {
+			t.Errorf("Expected error parsing %q", output)
+		}
+	}
+}